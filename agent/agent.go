@@ -42,6 +42,7 @@ import (
 	"github.com/hashicorp/consul/agent/proxycfg"
 	"github.com/hashicorp/consul/agent/rpcclient/health"
 	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
 	"github.com/hashicorp/consul/agent/systemd"
 	"github.com/hashicorp/consul/agent/token"
 	"github.com/hashicorp/consul/agent/xds"
@@ -421,17 +422,28 @@ func New(bd BaseDeps) (*Agent, error) {
 		return nil, err
 	}
 
+	materializerDeps := health.MaterializerDeps{
+		Conn:       conn,
+		ConnPool:   bd.GRPCConnPool,
+		Datacenter: bd.RuntimeConfig.Datacenter,
+		Logger:     bd.Logger.Named("rpcclient.health"),
+	}
+	if a.config.StreamingShareSubscriptions {
+		materializerDeps.Client = submatview.NewSharedStreamClient(
+			health.NewPoolStreamClient(bd.GRPCConnPool, bd.RuntimeConfig.Datacenter),
+			a.config.StreamingShareMaxReplay)
+	}
+
 	a.rpcClientHealth = &health.Client{
-		Cache:     bd.Cache,
-		NetRPC:    &a,
-		CacheName: cachetype.HealthServicesName,
-		ViewStore: bd.ViewStore,
-		MaterializerDeps: health.MaterializerDeps{
-			Conn:   conn,
-			Logger: bd.Logger.Named("rpcclient.health"),
-		},
-		UseStreamingBackend: a.config.UseStreamingBackend,
-		QueryOptionDefaults: config.ApplyDefaultQueryOptions(a.config),
+		Cache:                     bd.Cache,
+		NetRPC:                    &a,
+		CacheName:                 cachetype.HealthServicesName,
+		ViewStore:                 bd.ViewStore,
+		MaterializerDeps:          materializerDeps,
+		UseStreamingBackend:       a.config.UseStreamingBackend,
+		QueryOptionDefaults:       config.ApplyDefaultQueryOptions(a.config),
+		StreamingFailureThreshold: a.config.StreamingFailureThreshold,
+		StreamingRetryInterval:    a.config.StreamingRetryInterval,
 	}
 
 	a.serviceManager = NewServiceManager(&a)
@@ -160,6 +160,9 @@ type ResultMeta struct {
 	//
 	// For simple cache types, Age is the time since the result being returned was
 	// fetched from the servers.
+	//
+	// For streaming types, Age is the time since events from the servers were
+	// last applied to the materialized view which produced the result.
 	Age time.Duration
 
 	// Index is the internal ModifyIndex for the cache entry. Not all types
@@ -167,6 +170,21 @@ type ResultMeta struct {
 	// already but this allows generic code to reason about whether cache values
 	// have changed.
 	Index uint64
+
+	// Hash is a hash of the content of the result. It is only set by types
+	// which are able to compute it, and may be used to detect when a result with
+	// a new Index has the same content as the previous result.
+	Hash uint64
+
+	// ServerID is the ID of the server which served the result. It is only set
+	// by streaming types, where it identifies the server of the subscription
+	// which produced the snapshot and the events applied to the result.
+	ServerID string
+
+	// EventsApplied is the number of events which were applied to produce the
+	// result, including the events of the snapshot. It is only set by
+	// streaming types, and may be used to find services which change often.
+	EventsApplied uint64
 }
 
 // Options are options for the Cache.
@@ -229,6 +247,12 @@ type RegisterOptions struct {
 	// background refreshing will cease.
 	LastGetTTL time.Duration
 
+	// LastGetTTLJitter is the fraction of LastGetTTL that is randomly added to
+	// the expiry of each entry. Entries populated at the same time would
+	// otherwise all expire together and be re-fetched in a burst. Zero
+	// disables jitter and values greater than 1 are capped at 1.
+	LastGetTTLJitter float64
+
 	// Refresh configures whether the data is actively refreshed or if
 	// the data is only refreshed on an explicit Get. The default (false)
 	// is to only request data on explicit Get.
@@ -271,12 +295,27 @@ func (c *Cache) RegisterType(n string, typ Type) {
 	if opts.LastGetTTL == 0 {
 		opts.LastGetTTL = 72 * time.Hour // reasonable default is days
 	}
+	switch {
+	case opts.LastGetTTLJitter < 0:
+		opts.LastGetTTLJitter = 0
+	case opts.LastGetTTLJitter > 1:
+		opts.LastGetTTLJitter = 1
+	}
 
 	c.typesLock.Lock()
 	defer c.typesLock.Unlock()
 	c.types[n] = typeEntry{Name: n, Type: typ, Opts: &opts}
 }
 
+// lastGetTTL returns the LastGetTTL extended by a random amount of up to
+// LastGetTTLJitter of its value.
+func (o *RegisterOptions) lastGetTTL() time.Duration {
+	if o.LastGetTTLJitter <= 0 {
+		return o.LastGetTTL
+	}
+	return o.LastGetTTL + lib.RandomStagger(time.Duration(float64(o.LastGetTTL)*o.LastGetTTLJitter))
+}
+
 // ReloadOptions updates the cache with the new options
 // return true if Cache is updated, false if already up to date
 func (c *Cache) ReloadOptions(options Options) bool {
@@ -437,7 +476,7 @@ RETRY_GET:
 		// TTL heap, we should touch it every time around here since this caller at
 		// least still cares about the value!
 		c.entriesLock.Lock()
-		c.entriesExpiryHeap.Update(entry.Expiry.Index(), r.TypeEntry.Opts.LastGetTTL)
+		c.entriesExpiryHeap.Update(entry.Expiry.Index(), r.TypeEntry.Opts.lastGetTTL())
 		c.entriesLock.Unlock()
 	}
 
@@ -766,7 +805,7 @@ func (c *Cache) fetch(key string, r getOptions, allowNew bool, attempt uint, ign
 		// initial expiry information and insert. If we're already in
 		// the heap we do nothing since we're reusing the same entry.
 		if newEntry.Expiry == nil || newEntry.Expiry.Index() == ttlcache.NotIndexed {
-			newEntry.Expiry = c.entriesExpiryHeap.Add(key, tEntry.Opts.LastGetTTL)
+			newEntry.Expiry = c.entriesExpiryHeap.Add(key, tEntry.Opts.lastGetTTL())
 		}
 
 		c.entries[key] = newEntry
@@ -813,6 +813,93 @@ func TestCacheGet_expire(t *testing.T) {
 	typ.AssertExpectations(t)
 }
 
+func TestCacheRegisterType_LastGetTTLJitter(t *testing.T) {
+	typ := &MockType{}
+	typ.On("RegisterOptions").Return(RegisterOptions{
+		LastGetTTL:       time.Minute,
+		LastGetTTLJitter: 0.25,
+	})
+	c := New(Options{})
+	c.RegisterType("t", typ)
+
+	opts := c.types["t"].Opts
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 20; i++ {
+		ttl := opts.lastGetTTL()
+		require.GreaterOrEqual(t, int64(ttl), int64(time.Minute))
+		require.Less(t, int64(ttl), int64(time.Minute+15*time.Second))
+		seen[ttl] = struct{}{}
+	}
+	require.Greater(t, len(seen), 1, "expected the expiry to vary between entries")
+
+	typ = &MockType{}
+	typ.On("RegisterOptions").Return(RegisterOptions{
+		LastGetTTL:       time.Minute,
+		LastGetTTLJitter: 3,
+	})
+	c.RegisterType("capped", typ)
+	require.Equal(t, float64(1), c.types["capped"].Opts.LastGetTTLJitter)
+
+	typ = &MockType{}
+	typ.On("RegisterOptions").Return(RegisterOptions{LastGetTTL: time.Minute})
+	c.RegisterType("none", typ)
+	require.Equal(t, time.Minute, c.types["none"].Opts.lastGetTTL())
+}
+
+// Test that entries with a jittered TTL expire within the jitter window.
+func TestCacheGet_expireLastGetTTLJitter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("too slow for testing.Short")
+	}
+
+	t.Parallel()
+
+	const ttl = 200 * time.Millisecond
+	typ := &MockType{}
+	typ.On("RegisterOptions").Return(RegisterOptions{
+		LastGetTTL:       ttl,
+		LastGetTTLJitter: 1,
+	})
+	c := New(Options{})
+	defer c.Close()
+	c.RegisterType("t", typ)
+
+	var lock sync.Mutex
+	expired := make(map[string]time.Duration)
+	start := time.Now()
+	typ.On("Fetch", mock.Anything, mock.Anything).
+		Return(func(o FetchOptions, r Request) FetchResult {
+			key := r.CacheInfo().Key
+			state := &testCloser{closeFn: func() {
+				lock.Lock()
+				defer lock.Unlock()
+				expired[key] = time.Since(start)
+			}}
+			return FetchResult{Value: key, State: state}
+		}, func(o FetchOptions, r Request) error {
+			return nil
+		})
+
+	const entries = 5
+	for i := 0; i < entries; i++ {
+		req := TestRequest(t, RequestInfo{Key: fmt.Sprintf("key-%d", i)})
+		_, _, err := c.Get(context.Background(), "t", req)
+		require.NoError(t, err)
+	}
+	populated := time.Since(start)
+
+	// The entries expire between ttl and twice the ttl after they were added,
+	// so all of them must have expired once twice the ttl has passed.
+	time.Sleep(2*ttl + populated + 100*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, expired, entries, "all the entries should have expired")
+	for key, after := range expired {
+		require.GreaterOrEqual(t, int64(after), int64(ttl), key)
+	}
+}
+
 // Test that entries expire for background refresh types that cancel fetch on
 // eviction. This is really a special case of the test below where the close
 // behavior of the type forces the timing that causes the race but it's worth
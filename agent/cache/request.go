@@ -43,6 +43,14 @@ type RequestInfo struct {
 	// default value (zero) is acceptable.
 	MinIndex uint64
 
+	// IndexFloor is the lowest index of a result which satisfies the query. It
+	// may be used by callers to read the result of a write they just
+	// performed. Unlike MinIndex, a result with an index equal to IndexFloor
+	// is returned, and the query waits for the index to reach IndexFloor even
+	// when the result has not changed. It is only supported by streaming
+	// cache types, and is ignored by Cache.
+	IndexFloor uint64
+
 	// Timeout is the timeout for waiting on a blocking query. When the
 	// timeout is reached, the last known value is returned (or maybe nil
 	// if there was no prior value). This "last known value" behavior matches
@@ -55,6 +63,13 @@ type RequestInfo struct {
 	// true.
 	MaxAge time.Duration
 
+	// MaxStaleDuration if set limits how long a result may continue to be
+	// served after the source of the result has been disconnected from the
+	// servers. Past that window an error is returned instead of the stale
+	// result. It is only supported by streaming cache types, and is ignored by
+	// Cache.
+	MaxStaleDuration time.Duration
+
 	// MustRevalidate forces a new lookup of the cache even if there is an
 	// existing one that has not expired. It is implied by HTTP requests with
 	// `Cache-Control: max-age=0` but we can't distinguish that case from the
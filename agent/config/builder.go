@@ -34,6 +34,7 @@ import (
 	"github.com/hashicorp/consul/agent/dns"
 	"github.com/hashicorp/consul/agent/rpc/middleware"
 	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
 	"github.com/hashicorp/consul/agent/token"
 	"github.com/hashicorp/consul/ipaddr"
 	"github.com/hashicorp/consul/lib"
@@ -1076,6 +1077,10 @@ func (b *builder) build() (rt RuntimeConfig, err error) {
 	}
 
 	rt.UseStreamingBackend = boolValWithDefault(c.UseStreamingBackend, true)
+	rt.StreamingShareSubscriptions = boolVal(c.Streaming.ShareSubscriptions)
+	rt.StreamingShareMaxReplay = intValWithDefault(c.Streaming.ShareMaxReplay, submatview.DefaultSharedStreamMaxReplay)
+	rt.StreamingFailureThreshold = intVal(c.Streaming.FailureThreshold)
+	rt.StreamingRetryInterval = b.durationVal("streaming.retry_interval", c.Streaming.RetryInterval)
 
 	if c.RaftBoltDBConfig != nil {
 		rt.RaftBoltDBConfig = *c.RaftBoltDBConfig
@@ -1087,6 +1092,15 @@ func (b *builder) build() (rt RuntimeConfig, err error) {
 	if rt.Cache.EntryFetchRate <= 0 {
 		return RuntimeConfig{}, fmt.Errorf("cache.entry_fetch_rate must be strictly positive, was: %v", rt.Cache.EntryFetchRate)
 	}
+	if rt.StreamingShareMaxReplay <= 0 {
+		return RuntimeConfig{}, fmt.Errorf("streaming.share_max_replay must be strictly positive, was: %v", rt.StreamingShareMaxReplay)
+	}
+	if rt.StreamingFailureThreshold < 0 {
+		return RuntimeConfig{}, fmt.Errorf("streaming.failure_threshold cannot be negative, was: %v", rt.StreamingFailureThreshold)
+	}
+	if rt.StreamingFailureThreshold > 0 && rt.StreamingRetryInterval <= 0 {
+		return RuntimeConfig{}, fmt.Errorf("streaming.retry_interval must be strictly positive, was: %v", rt.StreamingRetryInterval)
+	}
 
 	if rt.UIConfig.MetricsProvider == "prometheus" {
 		// Handle defaulting for the built-in version of prometheus.
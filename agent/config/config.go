@@ -122,6 +122,22 @@ type Cache struct {
 	EntryFetchRate *float64 `mapstructure:"entry_fetch_rate"`
 }
 
+// Streaming configuration for the streaming backend of client agents.
+type Streaming struct {
+	// ShareSubscriptions allows materialized views which subscribe to the same
+	// events to share a single subscription stream.
+	ShareSubscriptions *bool `mapstructure:"share_subscriptions"`
+	// ShareMaxReplay is the maximum number of events kept by a shared stream for
+	// views which subscribe after the stream was started.
+	ShareMaxReplay *int `mapstructure:"share_max_replay"`
+	// FailureThreshold is the number of consecutive streaming failures after
+	// which requests fall back to the cache or RPC backend.
+	FailureThreshold *int `mapstructure:"failure_threshold"`
+	// RetryInterval is how long requests use the fallback before streaming is
+	// tried again.
+	RetryInterval *string `mapstructure:"retry_interval"`
+}
+
 // Config defines the format of a configuration file in either JSON or
 // HCL format.
 //
@@ -254,6 +270,8 @@ type Config struct {
 	// any other endpoints which support streaming.
 	UseStreamingBackend *bool `mapstructure:"use_streaming_backend"`
 
+	Streaming Streaming `mapstructure:"streaming"`
+
 	// This isn't used by Consul but we've documented a feature where users
 	// can deploy their snapshot agent configs alongside their Consul configs
 	// so we have a placeholder here so it can be parsed but this doesn't
@@ -54,6 +54,10 @@ func DefaultSource() Source {
 		segment_limit = 64
 
 		server = false
+		streaming = {
+			failure_threshold = 3
+			retry_interval = "5m"
+		}
 		syslog_facility = "LOCAL0"
 
 		tls = {
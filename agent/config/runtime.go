@@ -910,6 +910,34 @@ type RuntimeConfig struct {
 	// in the client agent for endpoints which support streaming.
 	UseStreamingBackend bool
 
+	// StreamingShareSubscriptions allows the materialized views of the
+	// streaming backend which subscribe to the same events to share a single
+	// subscription stream. Defaults to false.
+	//
+	// hcl: streaming { share_subscriptions = (true|false) }
+	StreamingShareSubscriptions bool
+
+	// StreamingShareMaxReplay is the maximum number of events kept by a shared
+	// subscription stream for views which subscribe after the stream was
+	// started. Once a stream has received more events, later views start a new
+	// stream. It is only used when StreamingShareSubscriptions is enabled.
+	//
+	// hcl: streaming { share_max_replay = int }
+	StreamingShareMaxReplay int
+
+	// StreamingFailureThreshold is the number of consecutive failures of the
+	// streaming backend after which requests fall back to the cache or RPC
+	// backend. A value of 0 disables the fallback. Defaults to 3.
+	//
+	// hcl: streaming { failure_threshold = int }
+	StreamingFailureThreshold int
+
+	// StreamingRetryInterval is how long requests use the fallback before the
+	// streaming backend is tried again. Defaults to 5m.
+	//
+	// hcl: streaming { retry_interval = "duration" }
+	StreamingRetryInterval time.Duration
+
 	// RaftProtocol sets the Raft protocol version to use on this server.
 	// Defaults to 3.
 	//
@@ -3189,6 +3189,49 @@ func TestLoad_IntegrationWithFlags(t *testing.T) {
 			rt.SkipLeaveOnInt = true
 		},
 	})
+	run(t, testCase{
+		desc: "streaming.share_max_replay must be strictly positive",
+		args: []string{
+			`-data-dir=` + dataDir,
+		},
+		json: []string{`{
+			  "streaming": { "share_subscriptions": true, "share_max_replay": 0 }
+			}`},
+		hcl: []string{`
+			  streaming { share_subscriptions = true share_max_replay = 0 }
+			`},
+		expectedErr: "streaming.share_max_replay must be strictly positive, was: 0",
+	})
+	run(t, testCase{
+		desc: "streaming.failure_threshold cannot be negative",
+		args: []string{
+			`-data-dir=` + dataDir,
+		},
+		json: []string{`{
+			  "streaming": { "failure_threshold": -1 }
+			}`},
+		hcl: []string{`
+			  streaming { failure_threshold = -1 }
+			`},
+		expectedErr: "streaming.failure_threshold cannot be negative, was: -1",
+	})
+	run(t, testCase{
+		desc: "streaming.failure_threshold = 0 disables the fallback",
+		args: []string{
+			`-data-dir=` + dataDir,
+		},
+		json: []string{`{
+			  "streaming": { "failure_threshold": 0, "retry_interval": "0s" }
+			}`},
+		hcl: []string{`
+			  streaming { failure_threshold = 0 retry_interval = "0s" }
+			`},
+		expected: func(rt *RuntimeConfig) {
+			rt.DataDir = dataDir
+			rt.StreamingFailureThreshold = 0
+			rt.StreamingRetryInterval = 0
+		},
+	})
 	run(t, testCase{
 		desc: "auto_encrypt.allow_tls errors in client mode",
 		args: []string{
@@ -6403,6 +6446,10 @@ func TestLoad_FullConfig(t *testing.T) {
 		},
 		RaftBoltDBConfig:                 consul.RaftBoltDBConfig{NoFreelistSync: true},
 		AutoReloadConfigCoalesceInterval: 1 * time.Second,
+		StreamingShareSubscriptions:      true,
+		StreamingShareMaxReplay:          1378,
+		StreamingFailureThreshold:        7,
+		StreamingRetryInterval:           47 * time.Second,
 	}
 	entFullRuntimeConfig(expected)
 
@@ -92,6 +92,12 @@ cache = {
     entry_fetch_rate = 0.334
 },
 use_streaming_backend = true
+streaming {
+    share_subscriptions = true
+    share_max_replay = 1378
+    failure_threshold = 7
+    retry_interval = "47s"
+}
 ca_file = "erA7T0PM"
 ca_path = "mQEN1Mfp"
 cert_file = "7s4QAzDk"
@@ -37,6 +37,14 @@ var ClientCounters = []prometheus.CounterDefinition{
 		Name: []string{"client", "rpc", "failed"},
 		Help: "Increments whenever a Consul agent in client mode makes an RPC request to a Consul server and fails.",
 	},
+	{
+		Name: []string{"client", "rpc", "retry", "exceeded"},
+		Help: "Increments whenever a Consul agent in client mode does not retry a failed RPC request because the retry limit was reached.",
+	},
+	{
+		Name: []string{"client", "rpc", "invalid_server_port"},
+		Help: "Increments whenever a Consul agent in client mode ignores a Consul server because the server advertised an invalid RPC port.",
+	},
 }
 
 const (
@@ -70,6 +78,10 @@ type Client struct {
 	// from an agent.
 	rpcLimiter atomic.Value
 
+	// rpcRetryLimiter is used to rate limit the total number of RPC retries
+	// across all requests.
+	rpcRetryLimiter *rate.Limiter
+
 	// eventCh is used to receive events from the serf cluster in the datacenter
 	eventCh chan serf.Event
 
@@ -109,6 +121,7 @@ func NewClient(config *Config, deps Deps) (*Client, error) {
 		logger:          deps.Logger.NamedIntercept(logging.ConsulClient),
 		shutdownCh:      make(chan struct{}),
 		tlsConfigurator: deps.TLSConfigurator,
+		rpcRetryLimiter: rate.NewLimiter(config.RPCRetryRateLimit, config.RPCRetryMaxBurst),
 	}
 
 	c.rpcLimiter.Store(rate.NewLimiter(config.RPCRateLimit, config.RPCMaxBurst))
@@ -140,7 +153,7 @@ func NewClient(config *Config, deps Deps) (*Client, error) {
 		return nil, fmt.Errorf("Failed to start lan serf: %v", err)
 	}
 
-	if err := deps.Router.AddArea(types.AreaLAN, c.serf, c.connPool); err != nil {
+	if err := deps.Router.AddArea(types.AreaLAN, c.serf, deps.serverPinger()); err != nil {
 		c.Shutdown()
 		return nil, fmt.Errorf("Failed to add LAN area to the RPC router: %w", err)
 	}
@@ -305,6 +318,16 @@ TRY:
 		return rpcErr
 	}
 
+	if !c.rpcRetryLimiter.Allow() {
+		metrics.IncrCounter([]string{"client", "rpc", "retry", "exceeded"}, 1)
+		c.logger.Warn("RPC failed to server, retry limit exceeded",
+			"method", method,
+			"server", server.Addr,
+			"error", rpcErr,
+		)
+		return rpcErr
+	}
+
 	c.logger.Warn("Retrying RPC to server",
 		"method", method,
 		"server", server.Addr,
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/serf/serf"
 
@@ -120,6 +121,9 @@ func (c *Client) nodeJoin(me serf.MemberEvent) {
 			)
 			continue
 		}
+		if !c.validServerPort(parts) {
+			continue
+		}
 		c.logger.Info("adding server", "server", parts)
 		c.router.AddServer(types.AreaLAN, parts)
 
@@ -144,11 +148,31 @@ func (c *Client) nodeUpdate(me serf.MemberEvent) {
 			)
 			continue
 		}
+		if !c.validServerPort(parts) {
+			// The server may have been added with a valid port before.
+			c.router.RemoveServer(types.AreaLAN, parts)
+			continue
+		}
 		c.logger.Info("updating server", "server", parts.String())
 		c.router.AddServer(types.AreaLAN, parts)
 	}
 }
 
+// validServerPort returns false if the RPC port advertised by the server is not
+// a valid port, so that the server is not added to the router. RPCs are sent to
+// the other servers instead of dialing an address which can not be reached.
+func (c *Client) validServerPort(parts *metadata.Server) bool {
+	if parts.Port > 0 && parts.Port <= 65535 {
+		return true
+	}
+	metrics.IncrCounter([]string{"client", "rpc", "invalid_server_port"}, 1)
+	c.logger.Warn("ignoring server with an invalid RPC port",
+		"server", parts.Name,
+		"port", parts.Port,
+	)
+	return false
+}
+
 // nodeFail is used to handle fail events on the serf cluster
 func (c *Client) nodeFail(me serf.MemberEvent) {
 	for _, m := range me.Members {
@@ -204,6 +204,71 @@ func TestClient_JoinLAN_Invalid(t *testing.T) {
 	}
 }
 
+func TestClient_nodeJoin_InvalidServerPort(t *testing.T) {
+	t.Parallel()
+	dir, conf := testClientConfig(t)
+	defer os.RemoveAll(dir)
+
+	deps := newDefaultDeps(t, conf)
+	var warnings syncBuffer
+	deps.Logger.(hclog.InterceptLogger).RegisterSink(hclog.NewSinkAdapter(&hclog.LoggerOptions{
+		Level:  hclog.Warn,
+		Output: &warnings,
+	}))
+
+	c1, err := NewClient(conf, deps)
+	require.NoError(t, err)
+	defer c1.Shutdown()
+
+	member := func(name, port string) serf.Member {
+		return serf.Member{
+			Name: name,
+			Addr: net.IP([]byte{127, 0, 0, 1}),
+			Tags: map[string]string{
+				"role":  "consul",
+				"id":    name,
+				"dc":    conf.Datacenter,
+				"port":  port,
+				"build": "1.11.0",
+				"vsn":   "2",
+			},
+			Status: serf.StatusAlive,
+		}
+	}
+
+	c1.nodeJoin(serf.MemberEvent{Members: []serf.Member{
+		member("negative", "-1"),
+		member("too-large", "70000"),
+		member("valid", "8300"),
+	}})
+	require.Equal(t, 1, c1.router.GetLANManager().NumServers())
+	require.Equal(t, "valid", c1.router.FindLANServer().Name)
+	require.Contains(t, warnings.String(), "ignoring server with an invalid RPC port: server=negative port=-1")
+	require.Contains(t, warnings.String(), "ignoring server with an invalid RPC port: server=too-large port=70000")
+
+	// A server which advertises an invalid port after it was added is removed.
+	c1.nodeUpdate(serf.MemberEvent{Members: []serf.Member{member("valid", "0")}})
+	require.Equal(t, 0, c1.router.GetLANManager().NumServers())
+}
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
 func TestClient_JoinWAN_Invalid(t *testing.T) {
 	t.Parallel()
 	dir1, s1 := testServer(t)
@@ -552,6 +617,53 @@ func newDefaultDeps(t *testing.T, c *Config) Deps {
 	}
 }
 
+func TestClient_RPC_RetryRateLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("too slow for testing.Short")
+	}
+
+	t.Parallel()
+
+	dir1, s1 := testServer(t)
+	defer os.RemoveAll(dir1)
+	defer s1.Shutdown()
+
+	dir2, c1 := testClientWithConfig(t, func(c *Config) {
+		c.Datacenter = "dc1"
+		c.NodeName = uniqueNodeName(t.Name())
+		c.RPCHoldTimeout = 2 * time.Second
+		c.RPCRetryRateLimit = rate.Limit(0.001)
+		c.RPCRetryMaxBurst = 3
+	})
+	defer os.RemoveAll(dir2)
+	defer c1.Shutdown()
+
+	joinLAN(t, c1, s1)
+	retry.Run(t, func(r *retry.R) {
+		var out struct{}
+		if err := c1.RPC("Status.Ping", struct{}{}, &out); err != nil {
+			r.Fatalf("err: %v", err)
+		}
+	})
+
+	failer := &leaderFailer{}
+	if err := s1.RegisterEndpoint("Fail", failer); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Every call fails, but at most three failures are retried across all the
+	// calls. Once the retries are exhausted each call makes a single attempt.
+	for i := 0; i < 10; i++ {
+		var out struct{}
+		if err := c1.RPC("Fail.Always", struct{}{}, &out); !structs.IsErrNoLeader(err) {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if got, want := failer.totalCalls, 13; got > want {
+		t.Fatalf("got %d want <= %d", got, want)
+	}
+}
+
 func TestClient_RPC_RateLimit(t *testing.T) {
 	if testing.Short() {
 		t.Skip("too slow for testing.Short")
@@ -343,6 +343,15 @@ type Config struct {
 	RPCRateLimit rate.Limit
 	RPCMaxBurst  int
 
+	// RPCRetryRateLimit and RPCRetryMaxBurst limit how frequently a client
+	// retries failed RPC calls, across all calls. When the limit is reached the
+	// error of the failed call is returned without retrying, so that retries
+	// from many calls do not overwhelm servers which are recovering from an
+	// outage. As with RPCRateLimit, RPCRetryMaxBurst is ignored when
+	// RPCRetryRateLimit == Inf.
+	RPCRetryRateLimit rate.Limit
+	RPCRetryMaxBurst  int
+
 	// RPCMaxConnsPerClient is the limit of how many concurrent connections are
 	// allowed from a single source IP.
 	RPCMaxConnsPerClient int
@@ -489,6 +498,9 @@ func DefaultConfig() *Config {
 		RPCRateLimit: rate.Inf,
 		RPCMaxBurst:  1000,
 
+		RPCRetryRateLimit: rate.Inf,
+		RPCRetryMaxBurst:  100,
+
 		// TODO (slackpad) - Until #3744 is done, we need to keep these
 		// in sync with agent/config/default.go.
 		AutopilotConfig: &structs.AutopilotConfig{
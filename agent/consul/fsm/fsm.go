@@ -227,6 +227,8 @@ func (c *FSM) Restore(old io.ReadCloser) error {
 		c.deps.Publisher.RefreshTopic(state.EventTopicServiceHealth)
 		c.deps.Publisher.RefreshTopic(state.EventTopicServiceHealthConnect)
 		c.deps.Publisher.RefreshTopic(state.EventTopicCARoots)
+		c.deps.Publisher.RefreshTopic(state.EventTopicGatewayServices)
+		c.deps.Publisher.RefreshTopic(state.EventTopicConfigEntries)
 	}
 	c.stateLock.Unlock()
 
@@ -295,4 +297,18 @@ func (c *FSM) registerStreamSnapshotHandlers() {
 	if err != nil {
 		panic(fmt.Errorf("fatal error encountered registering streaming snapshot handlers: %w", err))
 	}
+
+	err = c.deps.Publisher.RegisterHandler(state.EventTopicGatewayServices, func(req stream.SubscribeRequest, buf stream.SnapshotAppender) (uint64, error) {
+		return c.State().GatewayServicesSnapshot(req, buf)
+	})
+	if err != nil {
+		panic(fmt.Errorf("fatal error encountered registering streaming snapshot handlers: %w", err))
+	}
+
+	err = c.deps.Publisher.RegisterHandler(state.EventTopicConfigEntries, func(req stream.SubscribeRequest, buf stream.SnapshotAppender) (uint64, error) {
+		return c.State().ConfigEntrySnapshot(req, buf)
+	})
+	if err != nil {
+		panic(fmt.Errorf("fatal error encountered registering streaming snapshot handlers: %w", err))
+	}
 }
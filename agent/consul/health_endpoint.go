@@ -227,7 +227,10 @@ func (h *Health) ServiceNodes(args *structs.ServiceSpecificRequest, reply *struc
 		}
 	}
 
-	filter, err := bexpr.CreateFilter(args.Filter, nil, reply.Nodes)
+	if err := args.ViewOptions.HealthAggregation.Validate(); err != nil {
+		return err
+	}
+	filter, err := structs.NewCheckServiceNodeFilter(args.Filter, args.ViewOptions.HealthAggregation)
 	if err != nil {
 		return err
 	}
@@ -249,11 +252,10 @@ func (h *Health) ServiceNodes(args *structs.ServiceSpecificRequest, reply *struc
 				thisReply.Nodes = nodeMetaFilter(args.NodeMetaFilters, thisReply.Nodes)
 			}
 
-			raw, err := filter.Execute(thisReply.Nodes)
+			thisReply.Nodes, err = filter.Execute(thisReply.Nodes)
 			if err != nil {
 				return err
 			}
-			thisReply.Nodes = raw.(structs.CheckServiceNodes)
 
 			// Note: we filter the results with ACLs *after* applying the user-supplied
 			// bexpr filter, to ensure QueryMeta.ResultsFilteredByACLs does not include
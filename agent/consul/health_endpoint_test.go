@@ -1751,6 +1751,40 @@ func TestHealth_RPC_Filter(t *testing.T) {
 		require.Len(t, out.Nodes, 1)
 	})
 
+	t.Run("ServiceNodes aggregated Status", func(t *testing.T) {
+		args := structs.ServiceSpecificRequest{
+			Datacenter:   "dc1",
+			ServiceName:  "warning",
+			QueryOptions: structs.QueryOptions{Filter: "Status == warning"},
+		}
+
+		out := new(structs.IndexedCheckServiceNodes)
+		require.NoError(t, msgpackrpc.CallWithCodec(codec, "Health.ServiceNodes", &args, out))
+		require.Len(t, out.Nodes, 1)
+
+		args.Filter = "Status == passing"
+		out = new(structs.IndexedCheckServiceNodes)
+		require.NoError(t, msgpackrpc.CallWithCodec(codec, "Health.ServiceNodes", &args, out))
+		require.Len(t, out.Nodes, 0)
+
+		args.ViewOptions.HealthAggregation = structs.HealthAggregationWarningAsPassing
+		out = new(structs.IndexedCheckServiceNodes)
+		require.NoError(t, msgpackrpc.CallWithCodec(codec, "Health.ServiceNodes", &args, out))
+		require.Len(t, out.Nodes, 1)
+
+		args.ServiceName = "critical"
+		args.Filter = "Status != critical"
+		out = new(structs.IndexedCheckServiceNodes)
+		require.NoError(t, msgpackrpc.CallWithCodec(codec, "Health.ServiceNodes", &args, out))
+		require.Len(t, out.Nodes, 0)
+
+		args.ViewOptions.HealthAggregation = "warning-is-fine"
+		out = new(structs.IndexedCheckServiceNodes)
+		err := msgpackrpc.CallWithCodec(codec, "Health.ServiceNodes", &args, out)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `unknown health aggregation "warning-is-fine"`)
+	})
+
 	t.Run("ChecksInState", func(t *testing.T) {
 		args := structs.ChecksInStateRequest{
 			Datacenter:   "dc1",
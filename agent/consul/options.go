@@ -29,6 +29,17 @@ type Deps struct {
 	EnterpriseDeps
 }
 
+// serverPinger returns the router.Pinger used to check the health of servers.
+// The gRPC connection pool is used when it implements router.Pinger, so that
+// servers are checked with the gRPC health service. Otherwise the servers are
+// pinged with the RPC connection pool.
+func (d Deps) serverPinger() router.Pinger {
+	if p, ok := d.GRPCConnPool.(router.Pinger); ok {
+		return p
+	}
+	return d.ConnPool
+}
+
 type GRPCClientConner interface {
 	ClientConn(datacenter string) (*grpc.ClientConn, error)
 	ClientConnLeader() (*grpc.ClientConn, error)
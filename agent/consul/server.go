@@ -31,6 +31,8 @@ import (
 	"github.com/hashicorp/serf/serf"
 	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/hashicorp/consul-net-rpc/net/rpc"
 
@@ -257,6 +259,11 @@ type Server struct {
 	grpcHandler connHandler
 	rpcServer   *rpc.Server
 
+	// grpcHealth is the gRPC health service of the server. It reports that
+	// the server is not serving once the server starts to leave or shut down,
+	// so that clients stop using it.
+	grpcHealth *health.Server
+
 	// insecureRPCServer is a RPC server that is configure with
 	// IncomingInsecureRPCConfig to allow clients to call AutoEncrypt.Sign
 	// to request client certificates. At this point a client doesn't have
@@ -595,7 +602,7 @@ func NewServer(config *Config, flat Deps, publicGRPCServer *grpc.Server) (*Serve
 		return nil, fmt.Errorf("Failed to start LAN Serf: %v", err)
 	}
 
-	if err := s.router.AddArea(types.AreaLAN, s.serfLAN, s.connPool); err != nil {
+	if err := s.router.AddArea(types.AreaLAN, s.serfLAN, flat.serverPinger()); err != nil {
 		s.Shutdown()
 		return nil, fmt.Errorf("Failed to add LAN serf route: %w", err)
 	}
@@ -606,7 +613,7 @@ func NewServer(config *Config, flat Deps, publicGRPCServer *grpc.Server) (*Serve
 
 	// Add a "static route" to the WAN Serf and hook it up to Serf events.
 	if s.serfWAN != nil {
-		if err := s.router.AddArea(types.AreaWAN, s.serfWAN, s.connPool); err != nil {
+		if err := s.router.AddArea(types.AreaWAN, s.serfWAN, flat.serverPinger()); err != nil {
 			s.Shutdown()
 			return nil, fmt.Errorf("Failed to add WAN serf route: %v", err)
 		}
@@ -700,11 +707,16 @@ func NewServer(config *Config, flat Deps, publicGRPCServer *grpc.Server) (*Serve
 }
 
 func newGRPCHandlerFromConfig(deps Deps, config *Config, s *Server) connHandler {
+	s.grpcHealth = health.NewServer()
 	register := func(srv *grpc.Server) {
+		grpc_health_v1.RegisterHealthServer(srv, s.grpcHealth)
 		if config.RPCConfig.EnableStreaming {
-			pbsubscribe.RegisterStateChangeSubscriptionServer(srv, subscribe.NewServer(
+			subSrv := subscribe.NewServer(
 				&subscribeBackend{srv: s, connPool: deps.GRPCConnPool},
-				deps.Logger.Named("grpc-api.subscription")))
+				deps.Logger.Named("grpc-api.subscription"))
+			subSrv.ServerID = string(config.NodeID)
+			subSrv.IsLeader = s.IsLeader
+			pbsubscribe.RegisterStateChangeSubscriptionServer(srv, subSrv)
 		}
 		s.registerEnterpriseGRPCServices(deps, srv)
 	}
@@ -1037,6 +1049,10 @@ func (s *Server) Shutdown() error {
 		s.Listener.Close()
 	}
 
+	if s.grpcHealth != nil {
+		// Shutdown sets the status to NOT_SERVING, and ignores later updates.
+		s.grpcHealth.Shutdown()
+	}
 	if s.grpcHandler != nil {
 		if err := s.grpcHandler.Shutdown(); err != nil {
 			s.logger.Warn("failed to stop gRPC server", "error", err)
@@ -1079,6 +1095,10 @@ func (s *Server) attemptLeadershipTransfer() (success bool) {
 func (s *Server) Leave() error {
 	s.logger.Info("server starting leave")
 
+	if s.grpcHealth != nil {
+		s.grpcHealth.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+
 	// Check the number of known peers
 	numPeers, err := s.autopilot.NumVoters()
 	if err != nil {
@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"context"
 	"crypto/x509"
 	"fmt"
 	"net"
@@ -22,6 +23,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/hashicorp/consul/agent/connect"
 	"github.com/hashicorp/consul/agent/metadata"
@@ -1113,9 +1115,11 @@ func TestServer_Leave(t *testing.T) {
 	default:
 		t.Fatal("no leader")
 	}
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, grpcHealthStatus(t, nonleader))
 	if err := nonleader.Leave(); err != nil {
 		t.Fatal("leave failed: ", err)
 	}
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, grpcHealthStatus(t, nonleader))
 
 	// Should lose a peer
 	retry.Run(t, func(r *retry.R) {
@@ -1124,6 +1128,32 @@ func TestServer_Leave(t *testing.T) {
 	})
 }
 
+func TestServer_Shutdown_GRPCHealth(t *testing.T) {
+	if testing.Short() {
+		t.Skip("too slow for testing.Short")
+	}
+
+	t.Parallel()
+	dir1, s1 := testServer(t)
+	defer os.RemoveAll(dir1)
+	defer s1.Shutdown()
+
+	testrpc.WaitForLeader(t, s1.RPC, "dc1")
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, grpcHealthStatus(t, s1))
+
+	require.NoError(t, s1.Shutdown())
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, grpcHealthStatus(t, s1))
+}
+
+// grpcHealthStatus returns the status reported by the gRPC health service of
+// the server.
+func grpcHealthStatus(t *testing.T, s *Server) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := s.grpcHealth.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	return resp.Status
+}
+
 func TestServer_RPC(t *testing.T) {
 	t.Parallel()
 	dir1, s1 := testServer(t)
@@ -2,6 +2,7 @@ package state
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	memdb "github.com/hashicorp/go-memdb"
@@ -9,6 +10,7 @@ import (
 	"github.com/hashicorp/consul/acl"
 	"github.com/hashicorp/consul/agent/consul/stream"
 	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/lib"
 	"github.com/hashicorp/consul/proto/pbsubscribe"
 )
 
@@ -78,12 +80,21 @@ func (e EventPayloadCheckServiceNode) Subject() stream.Subject {
 
 // serviceHealthSnapshot returns a stream.SnapshotFunc that provides a snapshot
 // of stream.Events that describe the current state of a service health query.
+// A request for stream.SubjectWildcard on the ServiceHealth topic returns the
+// instances of every service.
 func (s *Store) ServiceHealthSnapshot(req stream.SubscribeRequest, buf stream.SnapshotAppender) (index uint64, err error) {
 	tx := s.db.ReadTxn()
 	defer tx.Abort()
 
 	connect := req.Topic == EventTopicServiceHealthConnect
 
+	if req.Subject == stream.SubjectWildcard {
+		if connect {
+			return 0, fmt.Errorf("topic %v does not support subject %v", req.Topic, req.Subject)
+		}
+		return serviceHealthSnapshotWildcardTxn(tx, req, buf)
+	}
+
 	subject, ok := req.Subject.(EventSubjectService)
 	if !ok {
 		return 0, fmt.Errorf("expected SubscribeRequest.Subject to be a: state.EventSubjectService, was a: %T", req.Subject)
@@ -122,6 +133,44 @@ func (s *Store) ServiceHealthSnapshot(req stream.SubscribeRequest, buf stream.Sn
 	return idx, err
 }
 
+// serviceHealthSnapshotWildcardTxn appends the events for the instances of
+// every service, in every partition and namespace. The events are filtered by
+// the ACLs of each subscriber when they are sent.
+func serviceHealthSnapshotWildcardTxn(tx ReadTxn, req stream.SubscribeRequest, buf stream.SnapshotAppender) (uint64, error) {
+	entMeta := structs.WildcardEnterpriseMetaInPartition(structs.WildcardSpecifier)
+	idx, services, err := serviceListTxn(tx, nil, entMeta)
+	if err != nil {
+		return 0, err
+	}
+	idx = lib.MaxUint64(idx, catalogMaxIndex(tx, entMeta, true))
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].String() < services[j].String()
+	})
+
+	var nodes structs.CheckServiceNodes
+	for _, sn := range services {
+		serviceIdx, serviceNodes, err := checkServiceNodesTxn(tx, nil, sn.Name, false, &sn.EnterpriseMeta)
+		if err != nil {
+			return 0, err
+		}
+		idx = lib.MaxUint64(idx, serviceIdx)
+		nodes = append(nodes, serviceNodes...)
+	}
+
+	for i := range nodes {
+		buf.Append([]stream.Event{{
+			Index: idx,
+			Topic: req.Topic,
+			Payload: EventPayloadCheckServiceNode{
+				Op:    pbsubscribe.CatalogOp_Register,
+				Value: &nodes[i],
+			},
+		}})
+	}
+	return idx, nil
+}
+
 // TODO: this could use NodeServiceQuery
 type nodeServiceTuple struct {
 	Node      string
@@ -212,6 +212,41 @@ func TestServiceHealthSnapshot_ConnectTopic(t *testing.T) {
 	prototest.AssertDeepEqual(t, expected, buf.events, cmpEvents)
 }
 
+func TestServiceHealthSnapshot_Wildcard(t *testing.T) {
+	store := NewStateStore(nil)
+
+	counter := newIndexCounter()
+	err := store.EnsureRegistration(counter.Next(), testServiceRegistration(t, "web", regNode2))
+	require.NoError(t, err)
+	err = store.EnsureRegistration(counter.Next(), testServiceRegistration(t, "db"))
+	require.NoError(t, err)
+	err = store.EnsureRegistration(counter.Next(), testServiceRegistration(t, "web"))
+	require.NoError(t, err)
+
+	buf := &snapshotAppender{}
+	req := stream.SubscribeRequest{Topic: EventTopicServiceHealth, Subject: stream.SubjectWildcard}
+
+	idx, err := store.ServiceHealthSnapshot(req, buf)
+	require.NoError(t, err)
+	require.Equal(t, counter.Last(), idx)
+
+	var ids []string
+	for _, events := range buf.events {
+		require.Len(t, events, 1)
+		require.Equal(t, counter.Last(), events[0].Index)
+		require.Equal(t, EventTopicServiceHealth, events[0].Topic)
+		csn := getPayloadCheckServiceNode(events[0].Payload)
+		ids = append(ids, csn.Node.Node+"/"+csn.Service.ID)
+	}
+	require.Equal(t, []string{"node1/db", "node1/web", "node2/web"}, ids)
+
+	t.Run("connect topic", func(t *testing.T) {
+		req := stream.SubscribeRequest{Topic: EventTopicServiceHealthConnect, Subject: stream.SubjectWildcard}
+		_, err := store.ServiceHealthSnapshot(req, &snapshotAppender{})
+		require.Error(t, err)
+	})
+}
+
 type snapshotAppender struct {
 	events [][]stream.Event
 }
@@ -0,0 +1,101 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/acl"
+	"github.com/hashicorp/consul/agent/consul/stream"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// EventPayloadConfigEntry is used as the Payload for a stream.Event to
+// indicate changes to a config entry.
+//
+// The stream.Payload methods implemented by EventPayloadConfigEntry do not
+// mutate the payload, making it safe to use in an Event sent to
+// stream.EventPublisher.Publish.
+type EventPayloadConfigEntry struct {
+	Op    pbsubscribe.CatalogOp
+	Value structs.ConfigEntry
+}
+
+// HasReadPermission uses the CanRead of the config entry, the same as the
+// ConfigEntry.Get endpoint.
+func (e EventPayloadConfigEntry) HasReadPermission(authz acl.Authorizer) bool {
+	return e.Value.CanRead(authz) == nil
+}
+
+// Subject is the kind and the name of the config entry, so that subscribers
+// receive the events for one config entry.
+func (e EventPayloadConfigEntry) Subject() stream.Subject {
+	subject := EventSubjectService{
+		Key: pbsubscribe.ConfigEntryKey(e.Value.GetKind(), e.Value.GetName()),
+	}
+	if entMeta := e.Value.GetEnterpriseMeta(); entMeta != nil {
+		subject.EnterpriseMeta = *entMeta
+	}
+	return subject
+}
+
+// ConfigEntryEventsFromChanges returns the events on the ConfigEntries topic
+// for the changes to config entries.
+func ConfigEntryEventsFromChanges(_ ReadTxn, changes Changes) ([]stream.Event, error) {
+	var events []stream.Event
+	for _, change := range changes.Changes {
+		if change.Table != tableConfigEntries {
+			continue
+		}
+
+		if change.Deleted() {
+			entry := change.Before.(structs.ConfigEntry)
+			events = append(events, newConfigEntryEvent(changes.Index, pbsubscribe.CatalogOp_Deregister, entry))
+			continue
+		}
+		entry := change.After.(structs.ConfigEntry)
+		events = append(events, newConfigEntryEvent(changes.Index, pbsubscribe.CatalogOp_Register, entry))
+	}
+	return events, nil
+}
+
+func newConfigEntryEvent(idx uint64, op pbsubscribe.CatalogOp, entry structs.ConfigEntry) stream.Event {
+	return stream.Event{
+		Topic: EventTopicConfigEntries,
+		Index: idx,
+		Payload: EventPayloadConfigEntry{
+			Op:    op,
+			Value: entry,
+		},
+	}
+}
+
+// ConfigEntrySnapshot returns a snapshot of the config entry named by the
+// subject of req. The key of the subject is the kind and the name of the config
+// entry, as returned by pbsubscribe.ConfigEntryKey.
+func (s *Store) ConfigEntrySnapshot(req stream.SubscribeRequest, buf stream.SnapshotAppender) (uint64, error) {
+	subject, ok := req.Subject.(EventSubjectService)
+	if !ok {
+		return 0, fmt.Errorf("expected SubscribeRequest.Subject to be a: state.EventSubjectService, was a: %T", req.Subject)
+	}
+	parts := strings.SplitN(subject.Key, "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid config entry key %q, expected <kind>/<name>", subject.Key)
+	}
+	kind, name := parts[0], parts[1]
+	if _, err := structs.MakeConfigEntry(kind, name); err != nil {
+		return 0, err
+	}
+
+	tx := s.db.ReadTxn()
+	defer tx.Abort()
+
+	idx, entry, err := configEntryTxn(tx, nil, kind, name, &subject.EnterpriseMeta)
+	if err != nil {
+		return 0, err
+	}
+	if entry != nil {
+		buf.Append([]stream.Event{newConfigEntryEvent(idx, pbsubscribe.CatalogOp_Register, entry)})
+	}
+	return idx, nil
+}
@@ -0,0 +1,201 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/acl"
+	"github.com/hashicorp/consul/agent/consul/stream"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func testServiceDefaults(name, protocol string) *structs.ServiceConfigEntry {
+	return &structs.ServiceConfigEntry{
+		Kind:     structs.ServiceDefaults,
+		Name:     name,
+		Protocol: protocol,
+	}
+}
+
+type configEntryEvent struct {
+	Op       pbsubscribe.CatalogOp
+	Kind     string
+	Name     string
+	Protocol string
+}
+
+func configEntryEventsSummary(events []stream.Event) []configEntryEvent {
+	var result []configEntryEvent
+	for _, event := range events {
+		payload := event.Payload.(EventPayloadConfigEntry)
+		e := configEntryEvent{
+			Op:   payload.Op,
+			Kind: payload.Value.GetKind(),
+			Name: payload.Value.GetName(),
+		}
+		if sd, ok := payload.Value.(*structs.ServiceConfigEntry); ok {
+			e.Protocol = sd.Protocol
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func TestConfigEntryEventsFromChanges(t *testing.T) {
+	store := testStateStore(t)
+
+	require.NoError(t, store.EnsureConfigEntry(1, testServiceDefaults("web", "http")))
+
+	t.Run("config entry created", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, ensureConfigEntryTxn(tx, 2, testServiceDefaults("api", "grpc")))
+
+		events, err := ConfigEntryEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		for _, event := range events {
+			require.Equal(t, EventTopicConfigEntries, event.Topic)
+			require.Equal(t, uint64(2), event.Index)
+		}
+		require.Equal(t, []configEntryEvent{
+			{Op: pbsubscribe.CatalogOp_Register, Kind: structs.ServiceDefaults, Name: "api", Protocol: "grpc"},
+		}, configEntryEventsSummary(events))
+	})
+
+	t.Run("config entry updated", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, ensureConfigEntryTxn(tx, 2, testServiceDefaults("web", "http2")))
+
+		events, err := ConfigEntryEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		require.Equal(t, []configEntryEvent{
+			{Op: pbsubscribe.CatalogOp_Register, Kind: structs.ServiceDefaults, Name: "web", Protocol: "http2"},
+		}, configEntryEventsSummary(events))
+	})
+
+	t.Run("config entry deleted", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, deleteConfigEntryTxn(tx, 2, structs.ServiceDefaults, "web", nil))
+
+		events, err := ConfigEntryEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		require.Equal(t, []configEntryEvent{
+			{Op: pbsubscribe.CatalogOp_Deregister, Kind: structs.ServiceDefaults, Name: "web", Protocol: "http"},
+		}, configEntryEventsSummary(events))
+	})
+
+	t.Run("other tables are ignored", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, store.ensureNodeTxn(tx, 2, false, &structs.Node{Node: "node1", Address: "10.0.0.1"}))
+
+		events, err := ConfigEntryEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+}
+
+func TestConfigEntrySnapshot(t *testing.T) {
+	store := testStateStore(t)
+
+	req := stream.SubscribeRequest{
+		Topic:   EventTopicConfigEntries,
+		Subject: EventSubjectService{Key: pbsubscribe.ConfigEntryKey(structs.ServiceDefaults, "web")},
+	}
+
+	t.Run("no config entry", func(t *testing.T) {
+		buf := &snapshotAppender{}
+
+		idx, err := store.ConfigEntrySnapshot(req, buf)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), idx)
+		require.Empty(t, buf.events)
+	})
+
+	t.Run("with config entry", func(t *testing.T) {
+		buf := &snapshotAppender{}
+
+		require.NoError(t, store.EnsureConfigEntry(1, testServiceDefaults("web", "http")))
+		require.NoError(t, store.EnsureConfigEntry(2, testServiceDefaults("api", "grpc")))
+
+		idx, err := store.ConfigEntrySnapshot(req, buf)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), idx)
+
+		require.Len(t, buf.events, 1)
+		require.Len(t, buf.events[0], 1)
+		require.Equal(t, uint64(2), buf.events[0][0].Index)
+		require.Equal(t, []configEntryEvent{
+			{Op: pbsubscribe.CatalogOp_Register, Kind: structs.ServiceDefaults, Name: "web", Protocol: "http"},
+		}, configEntryEventsSummary(buf.events[0]))
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		for _, key := range []string{"web", "unknown-kind/web"} {
+			req := stream.SubscribeRequest{Subject: EventSubjectService{Key: key}}
+			_, err := store.ConfigEntrySnapshot(req, &snapshotAppender{})
+			require.Error(t, err, key)
+		}
+	})
+
+	t.Run("wrong subject", func(t *testing.T) {
+		_, err := store.ConfigEntrySnapshot(stream.SubscribeRequest{Subject: stream.SubjectNone}, &snapshotAppender{})
+		require.Error(t, err)
+	})
+}
+
+func TestEventPayloadConfigEntry_Subject(t *testing.T) {
+	payload := EventPayloadConfigEntry{
+		Op:    pbsubscribe.CatalogOp_Register,
+		Value: testServiceDefaults("Web", "http"),
+	}
+	req := EventSubjectService{Key: pbsubscribe.ConfigEntryKey(structs.ServiceDefaults, "web")}
+	require.Equal(t, req.String(), payload.Subject().String())
+}
+
+func TestEventPayloadConfigEntry_HasReadPermission(t *testing.T) {
+	authz := func(t *testing.T, rules string) acl.Authorizer {
+		policy, err := acl.NewPolicyFromSource(rules, acl.SyntaxCurrent, nil, nil)
+		require.NoError(t, err)
+
+		authz, err := acl.NewPolicyAuthorizerWithDefaults(acl.DenyAll(), []*acl.Policy{policy}, nil)
+		require.NoError(t, err)
+		return authz
+	}
+
+	serviceDefaults := EventPayloadConfigEntry{
+		Op:    pbsubscribe.CatalogOp_Register,
+		Value: testServiceDefaults("web", "http"),
+	}
+
+	t.Run("no service:read", func(t *testing.T) {
+		require.False(t, serviceDefaults.HasReadPermission(acl.DenyAll()))
+	})
+
+	t.Run("service:read on another service", func(t *testing.T) {
+		require.False(t, serviceDefaults.HasReadPermission(authz(t, `service "api" { policy = "read" }`)))
+	})
+
+	t.Run("service:read", func(t *testing.T) {
+		require.True(t, serviceDefaults.HasReadPermission(authz(t, `service "web" { policy = "read" }`)))
+	})
+
+	t.Run("proxy-defaults are readable by any token", func(t *testing.T) {
+		proxyDefaults := EventPayloadConfigEntry{
+			Op: pbsubscribe.CatalogOp_Register,
+			Value: &structs.ProxyConfigEntry{
+				Kind: structs.ProxyDefaults,
+				Name: structs.ProxyConfigGlobal,
+			},
+		}
+		require.True(t, proxyDefaults.HasReadPermission(acl.DenyAll()))
+	})
+}
@@ -0,0 +1,133 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/acl"
+	"github.com/hashicorp/consul/agent/consul/stream"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/lib"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// EventPayloadGatewayService is used as the Payload for a stream.Event to
+// indicate changes to the services linked to a gateway.
+//
+// The stream.Payload methods implemented by EventPayloadGatewayService do not
+// mutate the payload, making it safe to use in an Event sent to
+// stream.EventPublisher.Publish.
+type EventPayloadGatewayService struct {
+	Op    pbsubscribe.CatalogOp
+	Value *structs.GatewayService
+}
+
+// HasReadPermission requires service:read on both the gateway and the linked
+// service, the same as the Catalog.GatewayServices endpoint.
+func (e EventPayloadGatewayService) HasReadPermission(authz acl.Authorizer) bool {
+	var gatewayAuthzContext acl.AuthorizerContext
+	e.Value.Gateway.FillAuthzContext(&gatewayAuthzContext)
+	if authz.ServiceRead(e.Value.Gateway.Name, &gatewayAuthzContext) != acl.Allow {
+		return false
+	}
+
+	var serviceAuthzContext acl.AuthorizerContext
+	e.Value.Service.FillAuthzContext(&serviceAuthzContext)
+	return authz.ServiceRead(e.Value.Service.Name, &serviceAuthzContext) == acl.Allow
+}
+
+// Subject is the name of the gateway, so that subscribers receive the events for
+// the services linked to one gateway.
+func (e EventPayloadGatewayService) Subject() stream.Subject {
+	return EventSubjectService{
+		Key:            e.Value.Gateway.Name,
+		EnterpriseMeta: e.Value.Gateway.EnterpriseMeta,
+	}
+}
+
+// GatewayServicesEventsFromChanges returns the events on the GatewayServices
+// topic for the changes to the services linked to gateways. Mappings for the
+// wildcard service are not returned, because they are only used to link new
+// services to the gateway.
+func GatewayServicesEventsFromChanges(tx ReadTxn, changes Changes) ([]stream.Event, error) {
+	var events []stream.Event
+	for _, change := range changes.Changes {
+		if change.Table != tableGatewayServices {
+			continue
+		}
+
+		if change.Deleted() {
+			gs := change.Before.(*structs.GatewayService)
+			if gs.Service.Name == structs.WildcardSpecifier {
+				continue
+			}
+			events = append(events, newGatewayServiceEvent(changes.Index, pbsubscribe.CatalogOp_Deregister, gs))
+			continue
+		}
+
+		gs := change.After.(*structs.GatewayService)
+		if gs.Service.Name == structs.WildcardSpecifier {
+			continue
+		}
+		if change.Updated() && change.Before.(*structs.GatewayService).IsSame(gs) {
+			continue
+		}
+		op, err := gatewayServiceOp(tx, gs)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, newGatewayServiceEvent(changes.Index, op, gs))
+	}
+	return events, nil
+}
+
+// gatewayServiceOp returns the operation of the event for a service linked to a
+// gateway. A service linked from a wildcard with a different protocol is not
+// linked to the gateway, so it is deregistered.
+func gatewayServiceOp(tx ReadTxn, gs *structs.GatewayService) (pbsubscribe.CatalogOp, error) {
+	_, matches, err := checkProtocolMatch(tx, nil, gs)
+	if err != nil {
+		return 0, fmt.Errorf("failed checking protocol: %w", err)
+	}
+	if !matches {
+		return pbsubscribe.CatalogOp_Deregister, nil
+	}
+	return pbsubscribe.CatalogOp_Register, nil
+}
+
+func newGatewayServiceEvent(idx uint64, op pbsubscribe.CatalogOp, gs *structs.GatewayService) stream.Event {
+	return stream.Event{
+		Topic: EventTopicGatewayServices,
+		Index: idx,
+		Payload: EventPayloadGatewayService{
+			Op:    op,
+			Value: gs,
+		},
+	}
+}
+
+// GatewayServicesSnapshot returns a snapshot of the services linked to the
+// gateway named by the subject of req.
+func (s *Store) GatewayServicesSnapshot(req stream.SubscribeRequest, buf stream.SnapshotAppender) (uint64, error) {
+	subject, ok := req.Subject.(EventSubjectService)
+	if !ok {
+		return 0, fmt.Errorf("expected SubscribeRequest.Subject to be a: state.EventSubjectService, was a: %T", req.Subject)
+	}
+
+	tx := s.db.ReadTxn()
+	defer tx.Abort()
+
+	iter, err := tx.Get(tableGatewayServices, indexGateway, structs.NewServiceName(subject.Key, &subject.EnterpriseMeta))
+	if err != nil {
+		return 0, fmt.Errorf("failed gateway services lookup: %s", err)
+	}
+	maxIdx, services, err := s.collectGatewayServices(tx, nil, iter)
+	if err != nil {
+		return 0, err
+	}
+	idx := lib.MaxUint64(maxIdx, maxIndexTxn(tx, tableGatewayServices))
+
+	for _, gs := range services {
+		buf.Append([]stream.Event{newGatewayServiceEvent(idx, pbsubscribe.CatalogOp_Register, gs)})
+	}
+	return idx, nil
+}
@@ -0,0 +1,184 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/acl"
+	"github.com/hashicorp/consul/agent/consul/stream"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func testTerminatingGateway(services ...string) *structs.TerminatingGatewayConfigEntry {
+	entry := &structs.TerminatingGatewayConfigEntry{
+		Kind: structs.TerminatingGateway,
+		Name: "gateway",
+	}
+	for _, name := range services {
+		entry.Services = append(entry.Services, structs.LinkedService{Name: name})
+	}
+	return entry
+}
+
+type gatewayServiceEvent struct {
+	Op      pbsubscribe.CatalogOp
+	Gateway string
+	Service string
+}
+
+func gatewayServiceEventsSummary(events []stream.Event) []gatewayServiceEvent {
+	var result []gatewayServiceEvent
+	for _, event := range events {
+		payload := event.Payload.(EventPayloadGatewayService)
+		result = append(result, gatewayServiceEvent{
+			Op:      payload.Op,
+			Gateway: payload.Value.Gateway.Name,
+			Service: payload.Value.Service.Name,
+		})
+	}
+	return result
+}
+
+func TestGatewayServicesEventsFromChanges(t *testing.T) {
+	store := testStateStore(t)
+
+	require.NoError(t, store.EnsureConfigEntry(1, testTerminatingGateway("api")))
+
+	t.Run("linked services changed", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, ensureConfigEntryTxn(tx, 2, testTerminatingGateway("web")))
+
+		events, err := GatewayServicesEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		for _, event := range events {
+			require.Equal(t, EventTopicGatewayServices, event.Topic)
+			require.Equal(t, uint64(2), event.Index)
+		}
+		require.ElementsMatch(t, []gatewayServiceEvent{
+			{Op: pbsubscribe.CatalogOp_Deregister, Gateway: "gateway", Service: "api"},
+			{Op: pbsubscribe.CatalogOp_Register, Gateway: "gateway", Service: "web"},
+		}, gatewayServiceEventsSummary(events))
+	})
+
+	t.Run("gateway deleted", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, deleteConfigEntryTxn(tx, 2, structs.TerminatingGateway, "gateway", nil))
+
+		events, err := GatewayServicesEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		require.Equal(t, []gatewayServiceEvent{
+			{Op: pbsubscribe.CatalogOp_Deregister, Gateway: "gateway", Service: "api"},
+		}, gatewayServiceEventsSummary(events))
+	})
+
+	t.Run("wildcard is skipped", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, ensureConfigEntryTxn(tx, 2, testTerminatingGateway("api", structs.WildcardSpecifier)))
+
+		events, err := GatewayServicesEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("no change", func(t *testing.T) {
+		tx := store.db.WriteTxn(2)
+		defer tx.Abort()
+
+		require.NoError(t, ensureConfigEntryTxn(tx, 2, testTerminatingGateway("api")))
+
+		events, err := GatewayServicesEventsFromChanges(tx, Changes{Index: 2, Changes: tx.Changes()})
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+}
+
+func TestGatewayServicesSnapshot(t *testing.T) {
+	store := testStateStore(t)
+
+	req := stream.SubscribeRequest{
+		Topic:   EventTopicGatewayServices,
+		Subject: EventSubjectService{Key: "gateway"},
+	}
+
+	t.Run("no linked services", func(t *testing.T) {
+		buf := &snapshotAppender{}
+
+		idx, err := store.GatewayServicesSnapshot(req, buf)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), idx)
+		require.Empty(t, buf.events)
+	})
+
+	t.Run("with linked services", func(t *testing.T) {
+		buf := &snapshotAppender{}
+
+		require.NoError(t, store.EnsureConfigEntry(1, testTerminatingGateway("api", "web", structs.WildcardSpecifier)))
+
+		idx, err := store.GatewayServicesSnapshot(req, buf)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), idx)
+
+		var events []stream.Event
+		for _, e := range buf.events {
+			require.Len(t, e, 1)
+			require.Equal(t, uint64(1), e[0].Index)
+			events = append(events, e...)
+		}
+		require.ElementsMatch(t, []gatewayServiceEvent{
+			{Op: pbsubscribe.CatalogOp_Register, Gateway: "gateway", Service: "api"},
+			{Op: pbsubscribe.CatalogOp_Register, Gateway: "gateway", Service: "web"},
+		}, gatewayServiceEventsSummary(events))
+	})
+
+	t.Run("wrong subject", func(t *testing.T) {
+		_, err := store.GatewayServicesSnapshot(stream.SubscribeRequest{Subject: stream.SubjectNone}, &snapshotAppender{})
+		require.Error(t, err)
+	})
+}
+
+func TestEventPayloadGatewayService_HasReadPermission(t *testing.T) {
+	payload := EventPayloadGatewayService{
+		Op: pbsubscribe.CatalogOp_Register,
+		Value: &structs.GatewayService{
+			Gateway: structs.NewServiceName("gateway", nil),
+			Service: structs.NewServiceName("api", nil),
+		},
+	}
+
+	authz := func(t *testing.T, rules string) acl.Authorizer {
+		policy, err := acl.NewPolicyFromSource(rules, acl.SyntaxCurrent, nil, nil)
+		require.NoError(t, err)
+
+		authz, err := acl.NewPolicyAuthorizerWithDefaults(acl.DenyAll(), []*acl.Policy{policy}, nil)
+		require.NoError(t, err)
+		return authz
+	}
+
+	t.Run("no service:read", func(t *testing.T) {
+		require.False(t, payload.HasReadPermission(acl.DenyAll()))
+	})
+
+	t.Run("service:read on the gateway only", func(t *testing.T) {
+		require.False(t, payload.HasReadPermission(authz(t, `service "gateway" { policy = "read" }`)))
+	})
+
+	t.Run("service:read on the linked service only", func(t *testing.T) {
+		require.False(t, payload.HasReadPermission(authz(t, `service "api" { policy = "read" }`)))
+	})
+
+	t.Run("service:read on both", func(t *testing.T) {
+		rules := `
+			service "gateway" { policy = "read" }
+			service "api" { policy = "read" }
+		`
+		require.True(t, payload.HasReadPermission(authz(t, rules)))
+	})
+}
@@ -180,6 +180,8 @@ func (db *readDB) ReadTxn() AbortTxn {
 var (
 	EventTopicServiceHealth        = pbsubscribe.Topic_ServiceHealth
 	EventTopicServiceHealthConnect = pbsubscribe.Topic_ServiceHealthConnect
+	EventTopicGatewayServices      = pbsubscribe.Topic_GatewayServices
+	EventTopicConfigEntries        = pbsubscribe.Topic_ConfigEntries
 )
 
 func processDBChanges(tx ReadTxn, changes Changes) ([]stream.Event, error) {
@@ -188,6 +190,8 @@ func processDBChanges(tx ReadTxn, changes Changes) ([]stream.Event, error) {
 		aclChangeUnsubscribeEvent,
 		caRootsChangeEvents,
 		ServiceHealthEventsFromChanges,
+		GatewayServicesEventsFromChanges,
+		ConfigEntryEventsFromChanges,
 		// TODO: add other table handlers here.
 	}
 	for _, fn := range fns {
@@ -24,6 +24,12 @@ type Subject fmt.Stringer
 // used to notify subscribers when the global set CA root certificates changes.
 const SubjectNone stringer = "none"
 
+// SubjectWildcard is used to subscribe to the events of every subject of a
+// topic. Events are sent to the subscribers of SubjectWildcard in addition to
+// the subscribers of their own Subject, and the snapshot handler of the topic
+// must support it.
+const SubjectWildcard stringer = "*"
+
 type stringer string
 
 func (s stringer) String() string { return string(s) }
@@ -157,8 +157,9 @@ func (e *EventPublisher) Run(ctx context.Context) {
 	}
 }
 
-// publishEvent appends the events to any applicable topic buffers. It handles
-// any closeSubscriptionPayload events by closing associated subscriptions.
+// publishEvent appends the events to any applicable topic buffers, including
+// the SubjectWildcard buffer of their topic. It handles any
+// closeSubscriptionPayload events by closing associated subscriptions.
 func (e *EventPublisher) publishEvent(events []Event) {
 	groupedEvents := make(map[topicSubject][]Event)
 	for _, event := range events {
@@ -172,6 +173,12 @@ func (e *EventPublisher) publishEvent(events []Event) {
 			Subject: event.Payload.Subject().String(),
 		}
 		groupedEvents[groupKey] = append(groupedEvents[groupKey], event)
+
+		wildcardKey := topicSubject{
+			Topic:   event.Topic.String(),
+			Subject: SubjectWildcard.String(),
+		}
+		groupedEvents[wildcardKey] = append(groupedEvents[wildcardKey], event)
 	}
 
 	e.lock.Lock()
@@ -66,6 +66,52 @@ func TestEventPublisher_SubscribeWithIndex0(t *testing.T) {
 	assertNoResult(t, eventCh)
 }
 
+func TestEventPublisher_SubscribeWildcard(t *testing.T) {
+	req := &SubscribeRequest{
+		Topic:   testTopic,
+		Subject: SubjectWildcard,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	publisher := NewEventPublisher(0)
+	registerTestSnapshotHandlers(t, publisher)
+	go publisher.Run(ctx)
+
+	sub, err := publisher.Subscribe(req)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	eventCh := runSubscription(ctx, sub)
+
+	next := getNextEvent(t, eventCh)
+	require.Equal(t, testSnapshotEvent, next)
+
+	next = getNextEvent(t, eventCh)
+	require.True(t, next.IsEndOfSnapshot())
+
+	// Subscriber should see the events of every subject of the topic
+	for _, key := range []string{"sub-key", "other-key"} {
+		publisher.Publish([]Event{{
+			Topic:   testTopic,
+			Payload: simplePayload{key: key, value: "the-published-event-payload"},
+		}})
+
+		next = getNextEvent(t, eventCh)
+		expected := Event{
+			Topic:   testTopic,
+			Payload: simplePayload{key: key, value: "the-published-event-payload"},
+		}
+		require.Equal(t, expected, next)
+	}
+
+	// Subscriber should not see events for other topics
+	publisher.Publish([]Event{{
+		Topic:   intTopic(22),
+		Payload: simplePayload{key: "sub-key", value: "this-should-not-reach-the-subscriber"},
+	}})
+	assertNoResult(t, eventCh)
+}
+
 var testSnapshotEvent = Event{
 	Topic:   testTopic,
 	Payload: simplePayload{key: "sub-key", value: "snapshot-event-payload"},
@@ -0,0 +1,261 @@
+package private
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// ServerLoad is the load of a server which is ready to receive calls.
+type ServerLoad struct {
+	// Addr is the address of the server, prefixed with its datacenter.
+	Addr string
+	// InFlight is the number of unary calls and streams to the server which
+	// have been started by the pool and have not yet completed.
+	InFlight int
+}
+
+// ServerPicker selects the server used by each call on the connections to
+// servers in a ClientConnPool.
+type ServerPicker interface {
+	// Pick returns the index in servers of the server to use for the next
+	// call. servers is never empty.
+	Pick(servers []ServerLoad) int
+}
+
+// NewWeightedRandomPicker returns a ServerPicker which selects a random server,
+// weighted inversely to the number of calls in flight to each server, so that
+// servers with fewer streams are preferred.
+func NewWeightedRandomPicker() ServerPicker {
+	return newWeightedRandomPicker(time.Now().UnixNano())
+}
+
+func newWeightedRandomPicker(seed int64) *weightedRandomPicker {
+	return &weightedRandomPicker{rand: rand.New(rand.NewSource(seed))}
+}
+
+type weightedRandomPicker struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func (p *weightedRandomPicker) Pick(servers []ServerLoad) int {
+	weights := make([]float64, len(servers))
+	var total float64
+	for i, server := range servers {
+		weights[i] = 1 / float64(server.InFlight+1)
+		total += weights[i]
+	}
+
+	p.lock.Lock()
+	n := p.rand.Float64() * total
+	p.lock.Unlock()
+
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(servers) - 1
+}
+
+// serverPickerBalancerName is the name of the gRPC balancer used by the
+// connections to servers when ClientConnPoolConfig.ServerPicker is set.
+const serverPickerBalancerName = "consul_server_picker"
+
+// pickerRegistry stores the ServerPicker of each ClientConnPool by the
+// authority of its ServerLocator. This type exists because grpc requires that
+// balancers are registered globally, but each pool may use a different
+// ServerPicker.
+type pickerRegistry struct {
+	lock        sync.RWMutex
+	byAuthority map[string]*serverLoads
+}
+
+var pickers = &pickerRegistry{byAuthority: make(map[string]*serverLoads)}
+
+func (r *pickerRegistry) register(authority string, picker ServerPicker) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.byAuthority[authority] = &serverLoads{picker: picker, inFlight: make(map[string]int)}
+}
+
+// get returns the serverLoads for the authority. If no ServerPicker was
+// registered for the authority, the weighted random picker is used.
+func (r *pickerRegistry) get(authority string) *serverLoads {
+	r.lock.RLock()
+	loads, ok := r.byAuthority[authority]
+	r.lock.RUnlock()
+	if ok {
+		return loads
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if loads, ok := r.byAuthority[authority]; ok {
+		return loads
+	}
+	loads = &serverLoads{picker: NewWeightedRandomPicker(), inFlight: make(map[string]int)}
+	r.byAuthority[authority] = loads
+	return loads
+}
+
+func init() {
+	balancer.Register(serverPickerBuilder{})
+	balancer.Register(warmStandbyBuilder{})
+}
+
+// serverPickerBuilder builds the balancer for connections which use a
+// ServerPicker. The balancer connects to every server address given by the
+// resolver, and uses the ServerPicker to select the server for each call.
+type serverPickerBuilder struct{}
+
+func (serverPickerBuilder) Name() string {
+	return serverPickerBalancerName
+}
+
+func (serverPickerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	loads := pickers.get(opts.Target.Authority)
+	b := base.NewBalancerBuilder(serverPickerBalancerName, loads, base.Config{})
+	return b.Build(cc, opts)
+}
+
+// warmStandbyBalancerName is the name of the gRPC balancer used by the
+// connections to servers when ClientConnPoolConfig.WarmStandby is set.
+const warmStandbyBalancerName = "consul_warm_standby"
+
+// warmStandbyBuilder builds the balancer for connections with a warm standby.
+// The balancer connects to the first two server addresses given by the
+// resolver, and uses the ServerPicker to select between them. When one of the
+// servers fails, calls are sent to the other connection, which is already
+// established.
+type warmStandbyBuilder struct{}
+
+func (warmStandbyBuilder) Name() string {
+	return warmStandbyBalancerName
+}
+
+func (warmStandbyBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	loads := pickers.get(opts.Target.Authority)
+	b := base.NewBalancerBuilder(warmStandbyBalancerName, loads, base.Config{})
+	return warmStandbyBalancer{Balancer: b.Build(cc, opts)}
+}
+
+// warmStandbyBalancer limits the addresses passed to the base balancer to the
+// primary and the standby server.
+type warmStandbyBalancer struct {
+	balancer.Balancer
+}
+
+func (b warmStandbyBalancer) UpdateClientConnState(state balancer.ClientConnState) error {
+	if addrs := state.ResolverState.Addresses; len(addrs) > 2 {
+		state.ResolverState.Addresses = addrs[:2]
+	}
+	return b.Balancer.UpdateClientConnState(state)
+}
+
+// stickyPicker is a ServerPicker which keeps using the same server for as long
+// as it is ready, so that the other server remains a standby.
+type stickyPicker struct {
+	lock    sync.Mutex
+	current string
+}
+
+func (p *stickyPicker) Pick(servers []ServerLoad) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, server := range servers {
+		if server.Addr == p.current {
+			return i
+		}
+	}
+	p.current = servers[0].Addr
+	return 0
+}
+
+// serverLoads tracks the number of calls in flight to each server address, so
+// that the counts are preserved when the balancer builds a new picker.
+type serverLoads struct {
+	picker ServerPicker
+
+	lock     sync.Mutex
+	inFlight map[string]int
+}
+
+// Build implements base.PickerBuilder. It is called by the balancer each time
+// the set of ready connections changes.
+func (l *serverLoads) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &loadPicker{loads: l}
+	for sc, scInfo := range info.ReadySCs {
+		p.subConns = append(p.subConns, sc)
+		p.addrs = append(p.addrs, scInfo.Address.Addr)
+	}
+	sort.Sort(p)
+	return p
+}
+
+func (l *serverLoads) done(addr string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inFlight[addr]--
+	if l.inFlight[addr] <= 0 {
+		delete(l.inFlight, addr)
+	}
+}
+
+// loadPicker is a balancer.Picker which uses a ServerPicker to select one of
+// the ready connections, and counts the calls in flight on each of them.
+type loadPicker struct {
+	loads    *serverLoads
+	subConns []balancer.SubConn
+	addrs    []string
+}
+
+func (p *loadPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	if len(p.subConns) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+
+	p.loads.lock.Lock()
+	servers := make([]ServerLoad, len(p.addrs))
+	for i, addr := range p.addrs {
+		servers[i] = ServerLoad{Addr: addr, InFlight: p.loads.inFlight[addr]}
+	}
+	i := p.loads.picker.Pick(servers)
+	if i < 0 || i >= len(p.subConns) {
+		i = 0
+	}
+	addr := p.addrs[i]
+	p.loads.inFlight[addr]++
+	p.loads.lock.Unlock()
+
+	return balancer.PickResult{
+		SubConn: p.subConns[i],
+		Done: func(balancer.DoneInfo) {
+			p.loads.done(addr)
+		},
+	}, nil
+}
+
+// Len, Less, and Swap implement sort.Interface, so that the servers are passed
+// to the ServerPicker in a consistent order.
+func (p *loadPicker) Len() int {
+	return len(p.addrs)
+}
+
+func (p *loadPicker) Less(i, j int) bool {
+	return p.addrs[i] < p.addrs[j]
+}
+
+func (p *loadPicker) Swap(i, j int) {
+	p.addrs[i], p.addrs[j] = p.addrs[j], p.addrs[i]
+	p.subConns[i], p.subConns[j] = p.subConns[j], p.subConns[i]
+}
@@ -0,0 +1,108 @@
+package private
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestWeightedRandomPicker_PrefersLessLoadedServer(t *testing.T) {
+	picker := newWeightedRandomPicker(1)
+	servers := []ServerLoad{
+		{Addr: "dc1-10.0.0.1:8300", InFlight: 20},
+		{Addr: "dc1-10.0.0.2:8300", InFlight: 0},
+		{Addr: "dc1-10.0.0.3:8300", InFlight: 20},
+	}
+
+	counts := make([]int, len(servers))
+	for i := 0; i < 1000; i++ {
+		counts[picker.Pick(servers)]++
+	}
+	require.Greater(t, counts[1], 800, "counts: %v", counts)
+	require.NotZero(t, counts[0])
+	require.NotZero(t, counts[2])
+}
+
+func TestWeightedRandomPicker_EqualLoad(t *testing.T) {
+	picker := newWeightedRandomPicker(1)
+	servers := []ServerLoad{
+		{Addr: "dc1-10.0.0.1:8300", InFlight: 3},
+		{Addr: "dc1-10.0.0.2:8300", InFlight: 3},
+	}
+
+	counts := make([]int, len(servers))
+	for i := 0; i < 1000; i++ {
+		counts[picker.Pick(servers)]++
+	}
+	require.InDelta(t, 500, counts[0], 100, "counts: %v", counts)
+}
+
+func TestLoadPicker_CountsCallsInFlight(t *testing.T) {
+	first := &fixedPicker{}
+	loads := &serverLoads{picker: first, inFlight: make(map[string]int)}
+
+	scA, scB := &fakeSubConn{}, &fakeSubConn{}
+	picker := loads.Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			scB: {Address: resolver.Address{Addr: "dc1-10.0.0.2:8300"}},
+			scA: {Address: resolver.Address{Addr: "dc1-10.0.0.1:8300"}},
+		},
+	})
+
+	// Start calls to the first server, without completing them.
+	var pending []func(balancer.DoneInfo)
+	for i := 0; i < 20; i++ {
+		result, err := picker.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		require.Same(t, scA, result.SubConn)
+		pending = append(pending, result.Done)
+	}
+	require.Equal(t, []ServerLoad{
+		{Addr: "dc1-10.0.0.1:8300", InFlight: 19},
+		{Addr: "dc1-10.0.0.2:8300", InFlight: 0},
+	}, first.last)
+
+	// With skewed load, the weighted picker prefers the other server.
+	loads.picker = newWeightedRandomPicker(1)
+	var picksB int
+	for i := 0; i < 1000; i++ {
+		result, err := picker.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		if result.SubConn == scB {
+			picksB++
+		}
+		result.Done(balancer.DoneInfo{})
+	}
+	require.Greater(t, picksB, 800)
+
+	for _, done := range pending {
+		done(balancer.DoneInfo{})
+	}
+	require.Empty(t, loads.inFlight)
+}
+
+func TestLoadPicker_NoReadyServers(t *testing.T) {
+	loads := &serverLoads{picker: &fixedPicker{}, inFlight: make(map[string]int)}
+	picker := loads.Build(base.PickerBuildInfo{})
+
+	_, err := picker.Pick(balancer.PickInfo{})
+	require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+}
+
+// fixedPicker is a ServerPicker which always picks the first server, and
+// records the servers it was called with.
+type fixedPicker struct {
+	last []ServerLoad
+}
+
+func (p *fixedPicker) Pick(servers []ServerLoad) int {
+	p.last = servers
+	return 0
+}
+
+type fakeSubConn struct {
+	balancer.SubConn
+}
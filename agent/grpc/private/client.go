@@ -2,14 +2,25 @@ package private
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
+	"github.com/hashicorp/consul/agent/grpc/private/resolver"
 	"github.com/hashicorp/consul/agent/metadata"
 	"github.com/hashicorp/consul/agent/pool"
 	"github.com/hashicorp/consul/tlsutil"
@@ -20,6 +31,17 @@ type ClientConnPool struct {
 	dialer        dialer
 	servers       ServerLocator
 	gwResolverDep gatewayResolverDep
+	rpcPinger     Pinger
+	dialTimeout   time.Duration
+	callOpts      []grpc.CallOption
+	unaryInts     []grpc.UnaryClientInterceptor
+	streamInts    []grpc.StreamClientInterceptor
+	keepalive     keepalive.ClientParameters
+	balancerName  string
+	detector      *failureDetector
+	tracker       *connTracker
+	targets       bool
+	verify        bool
 	conns         map[string]*grpc.ClientConn
 	connsLock     sync.Mutex
 }
@@ -34,6 +56,29 @@ type ServerLocator interface {
 	Authority() string
 }
 
+// ServerDrainer is implemented by a ServerLocator which can stop sending new
+// calls to a server. It is used by ClientConnPool.DrainServer.
+type ServerDrainer interface {
+	// DrainServer excludes the server with the ID from the addresses used by
+	// the connections in the pool. It returns false if there is no server with
+	// the ID.
+	DrainServer(serverID string) bool
+}
+
+var _ ServerDrainer = (*resolver.ServerResolverBuilder)(nil)
+
+// ServerRecycler is implemented by a ServerLocator which can replace the
+// connections to a server with new connections. It is used to enforce
+// ClientConnPoolConfig.MaxConnAge.
+type ServerRecycler interface {
+	// RecycleServer replaces the connections to the server with the global
+	// address, once the calls in flight on them have finished. It returns false
+	// if there is no server with the global address.
+	RecycleServer(globalAddr string) bool
+}
+
+var _ ServerRecycler = (*resolver.ServerResolverBuilder)(nil)
+
 // gatewayResolverDep is just a holder for a function pointer that can be
 // updated lazily after the structs are instantiated (but before first use)
 // and all structs with a reference to this struct will see the same update.
@@ -44,10 +89,19 @@ type gatewayResolverDep struct {
 	GatewayResolver func(string) string
 }
 
+// Pinger checks the health of a server. It is implemented by pool.ConnPool.
+type Pinger interface {
+	Ping(dc, nodeName string, addr net.Addr) (bool, error)
+}
+
 // TLSWrapper wraps a non-TLS connection and returns a connection with TLS
 // enabled.
 type TLSWrapper func(dc string, conn net.Conn) (net.Conn, error)
 
+// ServerNameTLSWrapper is a variant of TLSWrapper which also takes the server
+// name used to verify the certificate of the server.
+type ServerNameTLSWrapper func(dc, serverName string, conn net.Conn) (net.Conn, error)
+
 // ALPNWrapper is a function that is used to wrap a non-TLS connection and
 // returns an appropriate TLS connection or error. This taks a datacenter and
 // node name as argument to configure the desired SNI value and the desired
@@ -72,6 +126,17 @@ type ClientConnPoolConfig struct {
 	// gateways).
 	ALPNWrapper ALPNWrapper
 
+	// ServerNameFunc returns the TLS server name of the servers in a
+	// datacenter, for deployments where the name can not be derived from the
+	// datacenter and the domain, such as clusters with a custom TLS domain per
+	// datacenter. When it is set, connections are wrapped by
+	// ServerNameTLSWrapper with the name it returns, instead of by TLSWrapper.
+	ServerNameFunc func(dc string) string
+
+	// ServerNameTLSWrapper wraps a socket in TLS using the server name
+	// returned by ServerNameFunc. It is only used when ServerNameFunc is set.
+	ServerNameTLSWrapper ServerNameTLSWrapper
+
 	// UseTLSForDC is a function to determine if dialing a given datacenter
 	// should use TLS.
 	UseTLSForDC func(dc string) bool
@@ -83,16 +148,174 @@ type ClientConnPoolConfig struct {
 	// DialingFromDatacenter is the datacenter of the consul agent using this
 	// pool.
 	DialingFromDatacenter string
+
+	// RPCPinger is used by Ping to check the health of servers which do not
+	// implement the gRPC health service.
+	RPCPinger Pinger
+
+	// DialTimeout is the maximum amount of time to wait for a connection to a
+	// server to be established. Defaults to pool.DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// MaxRecvMsgSize and MaxSendMsgSize limit the size in bytes of the messages
+	// received and sent by calls on the connections in the pool. Large limits
+	// allow the results for very large services to be received, but also allow a
+	// single message to allocate more memory. If the value is 0 the gRPC
+	// defaults are used (4MB to receive, and no limit to send).
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	// UnaryInterceptors and StreamInterceptors are applied, in order, to every
+	// call made on the connections in the pool. They are called after the
+	// request ID and the metadata returned by Metadata have been added to the
+	// outgoing metadata.
+	UnaryInterceptors  []grpc.UnaryClientInterceptor
+	StreamInterceptors []grpc.StreamClientInterceptor
+
+	// SlowCallThreshold enables logging of slow calls. A warning is logged to
+	// Logger for every unary call made on the connections in the pool which
+	// takes longer than SlowCallThreshold, with the method, the server, and the
+	// duration of the call. If SlowCallThreshold is 0, slow calls are not
+	// logged.
+	SlowCallThreshold time.Duration
+
+	// Logger is used to log slow calls. Defaults to hclog.NewNullLogger().
+	Logger hclog.Logger
+
+	// Metadata is called for every call made on the connections in the pool,
+	// and the metadata it returns is added to the outgoing metadata of the
+	// call. It may be used to propagate tracing headers or other values from
+	// the context of the caller to the servers.
+	Metadata MetadataExtractor
+
+	// KeepaliveTime is how long a connection may be idle before a keepalive
+	// ping is sent, so that intermediaries do not close connections which are
+	// idle between streaming updates. Defaults to 30 seconds. The servers do
+	// not accept pings more often than every 15 seconds.
+	KeepaliveTime time.Duration
+
+	// KeepaliveTimeout is how long to wait for the response to a keepalive ping
+	// before the connection is closed. Defaults to 10 seconds.
+	KeepaliveTimeout time.Duration
+
+	// ServerPicker selects the server used by each call on the connections to
+	// the servers in a datacenter. When it is set, the connections are
+	// connected to every server in the datacenter, instead of to a single
+	// server. If ServerPicker is nil, all calls use a single server, which is
+	// changed periodically by the rebalancer of the resolver.
+	ServerPicker ServerPicker
+
+	// WarmStandby keeps a second connection to another server in each
+	// datacenter, so that calls can fail over to it without waiting for a new
+	// connection to be established. Calls are sent to one of the two servers
+	// until it fails. When ServerPicker is also set, it selects between the
+	// two servers for each call.
+	WarmStandby bool
+
+	// FailureDetector enables the detection of servers which fail
+	// consistently. When a server is marked unhealthy it is excluded from the
+	// connections in the pool until it is probed successfully, if Servers
+	// implements ServerHealthSetter. The state of the detector is returned by
+	// Stats. If FailureDetector is nil, failures are not tracked.
+	FailureDetector *FailureDetectorConfig
+
+	// ResolverTargets allows ClientConnForTarget to dial targets which are
+	// resolved by a gRPC name resolver, instead of a concrete address. The
+	// calls on those connections are balanced across all the addresses
+	// returned by the resolver.
+	ResolverTargets bool
+
+	// VerifyHandshake makes ClientConn and ClientConnLeader wait until a
+	// connection to a server is established, for up to DialTimeout, before
+	// returning a new connection. Errors from the TLS handshake, such as an
+	// invalid certificate or a server name which does not match, are returned
+	// by ClientConn instead of by the first call made on the connection.
+	VerifyHandshake bool
+
+	// MaxConnAge is the maximum amount of time a connection to a server is
+	// used, regardless of whether it is idle. Once a connection is older than
+	// MaxConnAge, new calls are sent on a new connection to the same server,
+	// and the old connection is closed once the calls in flight on it have
+	// finished. This allows changes to DNS records and certificates to be
+	// picked up by long lived connections. MaxConnAge requires Servers to
+	// implement ServerRecycler. If MaxConnAge is 0, connections are not
+	// recycled.
+	MaxConnAge time.Duration
 }
 
+const (
+	defaultKeepaliveTime    = 30 * time.Second
+	defaultKeepaliveTimeout = 10 * time.Second
+)
+
 // NewClientConnPool create new GRPC client pool to connect to servers using
 // GRPC over RPC.
 func NewClientConnPool(cfg ClientConnPoolConfig) *ClientConnPool {
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = pool.DefaultDialTimeout
+	}
+	if cfg.KeepaliveTime == 0 {
+		cfg.KeepaliveTime = defaultKeepaliveTime
+	}
+	if cfg.KeepaliveTimeout == 0 {
+		cfg.KeepaliveTimeout = defaultKeepaliveTimeout
+	}
+	unaryInts := []grpc.UnaryClientInterceptor{requestIDUnaryInterceptor}
+	streamInts := []grpc.StreamClientInterceptor{requestIDStreamInterceptor}
+	if cfg.Metadata != nil {
+		unaryInts = append(unaryInts, metadataUnaryInterceptor(cfg.Metadata))
+		streamInts = append(streamInts, metadataStreamInterceptor(cfg.Metadata))
+	}
+	if cfg.SlowCallThreshold > 0 {
+		logger := cfg.Logger
+		if logger == nil {
+			logger = hclog.NewNullLogger()
+		}
+		unaryInts = append(unaryInts, slowCallUnaryInterceptor(logger, cfg.SlowCallThreshold))
+	}
 	c := &ClientConnPool{
-		servers: cfg.Servers,
-		conns:   make(map[string]*grpc.ClientConn),
+		servers:     cfg.Servers,
+		rpcPinger:   cfg.RPCPinger,
+		dialTimeout: cfg.DialTimeout,
+		conns:       make(map[string]*grpc.ClientConn),
+		unaryInts:   append(unaryInts, cfg.UnaryInterceptors...),
+		streamInts:  append(streamInts, cfg.StreamInterceptors...),
+		keepalive: keepalive.ClientParameters{
+			Time:    cfg.KeepaliveTime,
+			Timeout: cfg.KeepaliveTimeout,
+		},
+		balancerName: "pick_first",
+		targets:      cfg.ResolverTargets,
+		verify:       cfg.VerifyHandshake,
+	}
+	switch {
+	case cfg.WarmStandby:
+		picker := cfg.ServerPicker
+		if picker == nil {
+			picker = &stickyPicker{}
+		}
+		pickers.register(cfg.Servers.Authority(), picker)
+		c.balancerName = warmStandbyBalancerName
+	case cfg.ServerPicker != nil:
+		pickers.register(cfg.Servers.Authority(), cfg.ServerPicker)
+		c.balancerName = serverPickerBalancerName
+	}
+	if cfg.MaxRecvMsgSize > 0 {
+		c.callOpts = append(c.callOpts, grpc.MaxCallRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		c.callOpts = append(c.callOpts, grpc.MaxCallSendMsgSize(cfg.MaxSendMsgSize))
 	}
 	c.dialer = newDialer(cfg, &c.gwResolverDep)
+	if cfg.FailureDetector != nil {
+		c.detector = newFailureDetector(*cfg.FailureDetector, cfg.Servers, c.Ping)
+		c.dialer = c.detector.wrapDialer(c.dialer)
+	}
+	if recycler, ok := cfg.Servers.(ServerRecycler); ok && cfg.MaxConnAge > 0 {
+		c.dialer = maxAgeDialer(c.dialer, recycler, cfg.MaxConnAge)
+	}
+	c.tracker = newConnTracker(cfg.Servers)
+	c.dialer = c.tracker.wrapDialer(c.dialer)
 	return c
 }
 
@@ -122,16 +345,129 @@ func (c *ClientConnPool) dial(datacenter string, serverType string) (*grpc.Clien
 		return conn, nil
 	}
 
-	conn, err := grpc.Dial(
-		target,
+	balancerName := c.balancerName
+	if serverType == "leader" {
+		balancerName = "pick_first"
+	}
+	opts := c.dialOptions(c.dialer, balancerName)
+	if c.detector != nil {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(c.detector.unaryInterceptor(datacenter)))
+	}
+	if !c.verify {
+		conn, err := grpc.Dial(target, opts...)
+		if err != nil {
+			return nil, err
+		}
+		c.conns[target] = conn
+		return conn, nil
+	}
+
+	// Errors from the TLS handshake are not temporary, so the dial fails as
+	// soon as one is returned by the dialer, instead of retrying until the
+	// timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), c.dialTimeout)
+	defer cancel()
+	opts = append(opts,
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithReturnConnectionError())
+	conn, err := grpc.DialContext(ctx, target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the servers in datacenter %v: %w", datacenter, err)
+	}
+
+	c.conns[target] = conn
+	return conn, nil
+}
+
+// unixScheme is the prefix of addresses which refer to a unix socket.
+const unixScheme = "unix://"
+
+// ClientConnForAddr returns a grpc.ClientConn for the gRPC server listening at
+// addr. Only unix socket addresses, in the form unix:///path/to/socket, are
+// supported. These are used by components which run on the same host as the
+// agent. The connections are stored in the pool separately from the
+// connections to servers.
+func (c *ClientConnPool) ClientConnForAddr(addr string) (*grpc.ClientConn, error) {
+	if !strings.HasPrefix(addr, unixScheme) {
+		return nil, fmt.Errorf("unsupported address %q: only %v addresses are supported", addr, unixScheme)
+	}
+	path := strings.TrimPrefix(addr, unixScheme)
+
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		d := net.Dialer{Timeout: c.dialTimeout}
+		return d.DialContext(ctx, "unix", path)
+	}
+	conn, err := grpc.Dial("passthrough:///"+path, c.dialOptions(dialer, "pick_first")...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// ClientConnForTarget returns a grpc.ClientConn for target, which is resolved
+// by the gRPC name resolver registered for its scheme (ex: dns:///host:8502).
+// The calls on the connection are balanced across the addresses returned by
+// the resolver with the round_robin balancer. The addresses must be plain gRPC
+// endpoints, because the connections are not multiplexed over the RPC port of
+// the servers. ClientConnForTarget returns an error unless
+// ClientConnPoolConfig.ResolverTargets is set. The connections are stored in
+// the pool separately from the connections to servers.
+func (c *ClientConnPool) ClientConnForTarget(target string) (*grpc.ClientConn, error) {
+	if !c.targets {
+		return nil, fmt.Errorf("dialing resolver targets is not enabled")
+	}
+
+	key := "target:" + target
+
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	if conn, ok := c.conns[key]; ok {
+		return conn, nil
+	}
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		d := net.Dialer{Timeout: c.dialTimeout}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	conn, err := grpc.Dial(target, c.dialOptions(dialer, roundrobin.Name)...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conns[key] = conn
+	return conn, nil
+}
+
+func (c *ClientConnPool) dialOptions(dialer dialer, balancerName string) []grpc.DialOption {
+	return []grpc.DialOption{
 		// use WithInsecure mode here because we handle the TLS wrapping in the
 		// custom dialer based on logic around whether the server has TLS enabled.
 		grpc.WithInsecure(),
-		grpc.WithContextDialer(c.dialer),
+		grpc.WithContextDialer(dialer),
 		grpc.WithDisableRetry(),
+		// Bound each connection attempt so that a server which does not respond
+		// fails quickly and the next server can be tried.
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: c.dialTimeout,
+		}),
 		grpc.WithStatsHandler(newStatsHandler(defaultMetrics())),
+		grpc.WithDefaultCallOptions(c.callOpts...),
+		grpc.WithChainUnaryInterceptor(c.unaryInts...),
+		grpc.WithChainStreamInterceptor(c.streamInts...),
 		// nolint:staticcheck // there is no other supported alternative to WithBalancerName
-		grpc.WithBalancerName("pick_first"),
+		grpc.WithBalancerName(balancerName),
 		// Keep alive parameters are based on the same default ones we used for
 		// Yamux. These are somewhat arbitrary but we did observe in scale testing
 		// that the gRPC defaults (servers send keepalives only every 2 hours,
@@ -142,17 +478,132 @@ func (c *ClientConnPool) dial(datacenter string, serverType string) (*grpc.Clien
 		// earlier and so have a smaller chance of going unnoticed until there are
 		// actual updates to send out from the servers. The servers have a policy to
 		// not accept pings any faster than once every 15 seconds to protect against
-		// abuse.
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:    30 * time.Second,
-			Timeout: 10 * time.Second,
-		}))
+		// abuse. The defaults may be changed with ClientConnPoolConfig.KeepaliveTime
+		// and KeepaliveTimeout.
+		grpc.WithKeepaliveParams(c.keepalive),
+	}
+}
+
+// Warm creates the pooled connection for the datacenter of each of the servers,
+// and waits for the connections to be ready, so that the first call to each
+// datacenter does not have to wait for a connection to be established.
+// Datacenters are warmed in parallel. An error is returned for each datacenter
+// that could not be reached within the dial timeout, but those errors do not
+// stop the other connections from being warmed.
+func (c *ClientConnPool) Warm(servers []*metadata.Server) error {
+	dcs := make(map[string]struct{})
+	for _, server := range servers {
+		dcs[server.Datacenter] = struct{}{}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		errLock sync.Mutex
+		result  error
+	)
+	for dc := range dcs {
+		wg.Add(1)
+		go func(dc string) {
+			defer wg.Done()
+			if err := c.warm(dc); err != nil {
+				errLock.Lock()
+				result = multierror.Append(result, fmt.Errorf("datacenter %v: %w", dc, err))
+				errLock.Unlock()
+			}
+		}(dc)
+	}
+	wg.Wait()
+	return result
+}
+
+func (c *ClientConnPool) warm(dc string) error {
+	conn, err := c.ClientConn(dc)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	c.conns[target] = conn
-	return conn, nil
+	ctx, cancel := context.WithTimeout(context.Background(), c.dialTimeout)
+	defer cancel()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection not ready after %v: %v", c.dialTimeout, state)
+		}
+	}
+}
+
+// DrainServer stops sending new calls to the server with the ID, so that it can
+// be taken out of service without affecting the calls to the other servers.
+// Calls and streams which are in flight on the connection to the server are
+// allowed to finish, and then the connection is closed. The server remains
+// drained until it leaves the cluster. An error is returned if the
+// ServerLocator of the pool does not implement ServerDrainer, or if there is
+// no server with the ID.
+func (c *ClientConnPool) DrainServer(serverID string) error {
+	drainer, ok := c.servers.(ServerDrainer)
+	if !ok {
+		return fmt.Errorf("draining servers is not supported by %T", c.servers)
+	}
+	if !drainer.DrainServer(serverID) {
+		return fmt.Errorf("failed to find Consul server with ID %q", serverID)
+	}
+	return nil
+}
+
+// ResetServer closes the connections to the server with the ID, for targeted
+// recovery from a connection which is in a bad state. Unlike DrainServer, the
+// calls in flight on the connections fail, and the server is not excluded: the
+// connections are established again for the next calls, without affecting the
+// connections to the other servers. ResetServer returns false if there were no
+// open connections to the server.
+func (c *ClientConnPool) ResetServer(serverID string) bool {
+	return c.tracker.closeServer(serverID)
+}
+
+// Stats returns the state of the pool. It is intended to be used for
+// debugging.
+func (c *ClientConnPool) Stats() ClientConnPoolStats {
+	var stats ClientConnPoolStats
+	if c.detector != nil {
+		stats.Servers = c.detector.stats()
+	}
+	return stats
+}
+
+// Ping checks the health of the server at addr using the gRPC health checking
+// protocol, and returns true if the server reports that it is serving. Servers
+// which do not implement the health service are checked with the RPCPinger
+// instead. Ping implements the router.Pinger interface.
+func (c *ClientConnPool) Ping(dc, nodeName string, addr net.Addr) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.dialTimeout)
+	defer cancel()
+
+	// The connection is not stored in the pool, because connections in the pool
+	// are balanced between all the servers in a datacenter.
+	conn, err := grpc.DialContext(ctx,
+		resolver.DCPrefix(dc, addr.String()),
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(c.dialer),
+		grpc.WithDisableRetry(),
+		grpc.WithBlock())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	switch {
+	case status.Code(err) == codes.Unimplemented && c.rpcPinger != nil:
+		return c.rpcPinger.Ping(dc, nodeName, addr)
+	case err != nil:
+		return false, err
+	case resp.Status != grpc_health_v1.HealthCheckResponse_SERVING:
+		return false, fmt.Errorf("server is not serving: %v", resp.Status)
+	}
+	return true, nil
 }
 
 // newDialer returns a gRPC dialer function that conditionally wraps the connection
@@ -182,14 +633,21 @@ func newDialer(cfg ClientConnPoolConfig, gwResolverDep *gatewayResolverDep) func
 			return conn, err
 		}
 
-		d := net.Dialer{LocalAddr: cfg.SrcAddr, Timeout: pool.DefaultDialTimeout}
+		d := net.Dialer{LocalAddr: cfg.SrcAddr, Timeout: cfg.DialTimeout}
 		conn, err := d.DialContext(ctx, "tcp", server.Addr.String())
 		if err != nil {
 			return nil, err
 		}
 
 		if server.UseTLS && cfg.UseTLSForDC(server.Datacenter) {
-			if cfg.TLSWrapper == nil {
+			wrapper := cfg.TLSWrapper
+			if cfg.ServerNameFunc != nil && cfg.ServerNameTLSWrapper != nil {
+				serverName := cfg.ServerNameFunc(server.Datacenter)
+				wrapper = func(dc string, conn net.Conn) (net.Conn, error) {
+					return cfg.ServerNameTLSWrapper(dc, serverName, conn)
+				}
+			}
+			if wrapper == nil {
 				conn.Close()
 				return nil, fmt.Errorf("TLS enabled but got nil TLS wrapper")
 			}
@@ -201,12 +659,17 @@ func newDialer(cfg ClientConnPoolConfig, gwResolverDep *gatewayResolverDep) func
 			}
 
 			// Wrap the connection in a TLS client
-			tlsConn, err := cfg.TLSWrapper(server.Datacenter, conn)
+			tlsConn, err := wrapper(server.Datacenter, conn)
 			if err != nil {
 				conn.Close()
 				return nil, err
 			}
 			conn = tlsConn
+
+			if err := handshake(ctx, conn); err != nil {
+				conn.Close()
+				return nil, tlsHandshakeError{addr: server.Addr.String(), err: err}
+			}
 		}
 
 		_, err = conn.Write([]byte{byte(pool.RPCGRPC)})
@@ -218,3 +681,67 @@ func newDialer(cfg ClientConnPoolConfig, gwResolverDep *gatewayResolverDep) func
 		return conn, nil
 	}
 }
+
+// maxAgeDialer returns a dialer which recycles each connection returned by next
+// once it is older than maxAge, unless it was closed before then.
+func maxAgeDialer(next dialer, recycler ServerRecycler, maxAge time.Duration) dialer {
+	return func(ctx context.Context, globalAddr string) (net.Conn, error) {
+		conn, err := next(ctx, globalAddr)
+		if err != nil {
+			return nil, err
+		}
+		timer := time.AfterFunc(maxAge, func() {
+			recycler.RecycleServer(globalAddr)
+		})
+		return &maxAgeConn{Conn: conn, timer: timer}, nil
+	}
+}
+
+// maxAgeConn is a net.Conn which stops the timer which recycles the connection
+// when it is closed.
+type maxAgeConn struct {
+	net.Conn
+	timer *time.Timer
+}
+
+func (c *maxAgeConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// handshake runs the TLS handshake of conn, if it has not been run yet by the
+// TLSWrapper, so that handshake errors are returned by the dialer instead of
+// by the first write to the connection.
+func handshake(ctx context.Context, conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := tlsConn.SetDeadline(deadline); err != nil {
+			return err
+		}
+		defer tlsConn.SetDeadline(time.Time{})
+	}
+	return tlsConn.Handshake()
+}
+
+// tlsHandshakeError is returned by the dialer when the TLS handshake with a
+// server fails. It is not temporary, so that a dial with
+// grpc.FailOnNonTempDialError returns it immediately.
+type tlsHandshakeError struct {
+	addr string
+	err  error
+}
+
+func (e tlsHandshakeError) Error() string {
+	return fmt.Sprintf("TLS handshake with server %v failed: %v", e.addr, e.err)
+}
+
+func (e tlsHandshakeError) Unwrap() error {
+	return e.err
+}
+
+func (e tlsHandshakeError) Temporary() bool {
+	return false
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -13,12 +15,22 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	grpcresolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
 
 	"github.com/hashicorp/consul/agent/grpc/private/internal/testservice"
 	"github.com/hashicorp/consul/agent/grpc/private/resolver"
 	"github.com/hashicorp/consul/agent/metadata"
 	"github.com/hashicorp/consul/ipaddr"
 	"github.com/hashicorp/consul/sdk/freeport"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/consul/tlsutil"
 	"github.com/hashicorp/consul/types"
 )
@@ -136,6 +148,59 @@ func TestNewDialer_WithALPNWrapper(t *testing.T) {
 	assert.True(t, calledALPN, "expected ALPNWrapper to be called")
 }
 
+func TestNewDialer_SameAddrInMultipleDCs(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(logError(t, lis.Close))
+
+	// The same address is advertised by a server in each datacenter. Each
+	// must be dialed with the TLS settings of its own datacenter.
+	builder := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, builder)
+	for _, dc := range []string{"dc1", "dc2"} {
+		builder.AddServer(types.AreaWAN, &metadata.Server{
+			Name:       "server-1",
+			ID:         "ID1",
+			Datacenter: dc,
+			Addr:       lis.Addr(),
+			UseTLS:     true,
+		})
+	}
+
+	var dcs []string
+	wrapper := func(dc string, conn net.Conn) (net.Conn, error) {
+		dcs = append(dcs, dc)
+		return conn, nil
+	}
+	cfg := ClientConnPoolConfig{
+		Servers:               builder,
+		TLSWrapper:            wrapper,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+	}
+	dial := newDialer(cfg, &gatewayResolverDep{})
+
+	ctx := context.Background()
+	for _, dc := range []string{"dc1", "dc2"} {
+		conn, err := dial(ctx, resolver.DCPrefix(dc, lis.Addr().String()))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	}
+	require.Equal(t, []string{"dc1", "dc2"}, dcs)
+
+	pool := NewClientConnPool(cfg)
+	conn1, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	conn2, err := pool.ClientConn("dc2")
+	require.NoError(t, err)
+	require.NotSame(t, conn1, conn2)
+
+	again, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	require.Same(t, conn1, again)
+}
+
 func TestNewDialer_IntegrationWithTLSEnabledHandler(t *testing.T) {
 	// if this test is failing because of expired certificates
 	// use the procedure in test/CA-GENERATION.md
@@ -181,6 +246,92 @@ func TestNewDialer_IntegrationWithTLSEnabledHandler(t *testing.T) {
 	require.True(t, atomic.LoadInt32(&srv.rpc.alpnConnEstablished) == 0)
 }
 
+func TestClientConnPool_VerifyHandshake(t *testing.T) {
+	// if this test is failing because of expired certificates
+	// use the procedure in test/CA-GENERATION.md
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	srvTLSConf, err := tlsutil.NewConfigurator(tlsutil.Config{
+		InternalRPC: tlsutil.ProtocolConfig{
+			VerifyIncoming: true,
+			CAFile:         "../../../test/hostname/CertAuth.crt",
+			CertFile:       "../../../test/hostname/Alice.crt",
+			KeyFile:        "../../../test/hostname/Alice.key",
+			VerifyOutgoing: true,
+		},
+	}, hclog.New(nil))
+	require.NoError(t, err)
+
+	srv := newSimpleTestServer(t, "server-1", "dc1", srvTLSConf)
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	newPool := func(t *testing.T, domain string, serverName func(string) string) *ClientConnPool {
+		tlsConf, err := tlsutil.NewConfigurator(tlsutil.Config{
+			InternalRPC: tlsutil.ProtocolConfig{
+				CAFile:               "../../../test/hostname/CertAuth.crt",
+				CertFile:             "../../../test/hostname/Bob.crt",
+				KeyFile:              "../../../test/hostname/Bob.key",
+				VerifyOutgoing:       true,
+				VerifyServerHostname: true,
+			},
+			Domain: domain,
+		}, hclog.New(nil))
+		require.NoError(t, err)
+
+		return NewClientConnPool(ClientConnPoolConfig{
+			Servers:               res,
+			TLSWrapper:            TLSWrapper(tlsConf.OutgoingRPCWrapper()),
+			ServerNameFunc:        serverName,
+			ServerNameTLSWrapper:  ServerNameTLSWrapper(tlsConf.OutgoingRPCServerNameWrapper()),
+			UseTLSForDC:           tlsConf.UseTLS,
+			DialingFromServer:     true,
+			DialingFromDatacenter: "dc1",
+			DialTimeout:           2 * time.Second,
+			VerifyHandshake:       true,
+		})
+	}
+
+	t.Run("matching server name", func(t *testing.T) {
+		conn, err := newPool(t, "consul", nil).ClientConn("dc1")
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.Equal(t, connectivity.Ready, conn.GetState())
+	})
+
+	t.Run("mismatched server name", func(t *testing.T) {
+		start := time.Now()
+		_, err := newPool(t, "example.com", nil).ClientConn("dc1")
+		require.Error(t, err)
+		require.Less(t, int64(time.Since(start)), int64(2*time.Second),
+			"expected the dial to fail before the timeout")
+		require.Contains(t, err.Error(), "TLS handshake with server "+srv.addr.String()+" failed")
+		require.Contains(t, err.Error(), "server.dc1.example.com")
+	})
+
+	t.Run("custom server name", func(t *testing.T) {
+		serverName := func(dc string) string {
+			return "server." + dc + ".consul"
+		}
+		// The domain does not match the certificate, but it is not used to
+		// derive the server name.
+		conn, err := newPool(t, "example.com", serverName).ClientConn("dc1")
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.Equal(t, connectivity.Ready, conn.GetState())
+	})
+
+	t.Run("mismatched custom server name", func(t *testing.T) {
+		serverName := func(dc string) string {
+			return "consul-" + dc + ".internal.example"
+		}
+		_, err := newPool(t, "consul", serverName).ClientConn("dc1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "consul-dc1.internal.example")
+	})
+}
+
 func TestNewDialer_IntegrationWithTLSEnabledHandler_viaMeshGateway(t *testing.T) {
 	// if this test is failing because of expired certificates
 	// use the procedure in test/CA-GENERATION.md
@@ -294,6 +445,365 @@ func TestClientConnPool_IntegrationWithGRPCResolver_Failover(t *testing.T) {
 	require.NotEqual(t, resp.ServerName, first.ServerName)
 }
 
+func TestClientConnPool_IntegrationWithGRPCResolver_ServerAddressChange(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+	})
+
+	srv1 := newSimpleTestServer(t, "server-1", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv1.Metadata())
+
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	resp, err := client.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	require.Equal(t, "server-1", resp.ServerName)
+
+	// The same server restarts with a new address. The servers are indexed by
+	// ID, so the new address replaces the old one.
+	srv1.shutdown()
+	srv2 := newSimpleTestServer(t, "server-1", "dc1", nil)
+	t.Cleanup(srv2.shutdown)
+	require.NotEqual(t, srv1.addr.String(), srv2.addr.String())
+	res.AddServer(types.AreaWAN, srv2.Metadata())
+
+	_, err = res.ServerForGlobalAddr(resolver.DCPrefix("dc1", srv1.addr.String()))
+	require.Error(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(r, err)
+		require.Equal(r, "server-1", resp.ServerName)
+	})
+}
+
+func TestClientConnPool_IntegrationWithGRPCResolver_ServerAffinity(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+	})
+
+	addServer := func(i int) *metadata.Server {
+		srv := newSimpleTestServer(t, fmt.Sprintf("server-%d", i), "dc1", nil)
+		t.Cleanup(srv.shutdown)
+		res.AddServer(types.AreaWAN, srv.Metadata())
+		return srv.Metadata()
+	}
+	var servers []*metadata.Server
+	for i := 0; i < 2; i++ {
+		servers = append(servers, addServer(i))
+	}
+
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+
+	first, err := client.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+
+	// Changes to the set of servers should not move the connection while the
+	// server it is connected to is still available.
+	for i := 2; i < 8; i++ {
+		servers = append(servers, addServer(i))
+
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+		require.Equal(t, first.ServerName, resp.ServerName)
+	}
+
+	res.RemoveServer(types.AreaWAN, servers[len(servers)-1])
+	resp, err := client.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	require.Equal(t, first.ServerName, resp.ServerName)
+}
+
+func TestClientConnPool_DrainServer(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+	})
+
+	for i := 0; i < 3; i++ {
+		srv := newSimpleTestServer(t, fmt.Sprintf("server-%d", i), "dc1", nil)
+		res.AddServer(types.AreaWAN, srv.Metadata())
+		t.Cleanup(srv.shutdown)
+	}
+
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	first, err := client.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+
+	// Start a stream on the server before it is drained.
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	defer streamCancel()
+	stream, err := client.Flow(streamCtx, &testservice.Req{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	require.Error(t, pool.DrainServer("unknown"))
+	require.NoError(t, pool.DrainServer(first.ServerName))
+
+	// New calls are sent to another server.
+	var second string
+	retry.Run(t, func(r *retry.R) {
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(r, err)
+		require.NotEqual(r, first.ServerName, resp.ServerName)
+		second = resp.ServerName
+	})
+	for i := 0; i < 5; i++ {
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+		require.Equal(t, second, resp.ServerName)
+	}
+
+	// The stream which was in flight on the drained server is not interrupted.
+	for i := 0; i < 5; i++ {
+		_, err := stream.Recv()
+		require.NoError(t, err)
+	}
+}
+
+func TestClientConnPool_MaxConnAge(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		MaxConnAge:            200 * time.Millisecond,
+	})
+
+	var dials int32
+	dial := pool.dialer
+	pool.dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return dial(ctx, addr)
+	}
+
+	srv := newSimpleTestServer(t, "server-1", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	_, err = client.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+
+	// Start a stream on the connection before it is recycled.
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	defer streamCancel()
+	stream, err := client.Flow(streamCtx, &testservice.Req{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	// New calls are sent on a new connection once the first one is too old.
+	retry.Run(t, func(r *retry.R) {
+		_, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(r, err)
+		require.GreaterOrEqual(r, atomic.LoadInt32(&dials), int32(2))
+	})
+
+	// The stream which was in flight on the old connection is not interrupted.
+	for i := 0; i < 5; i++ {
+		_, err := stream.Recv()
+		require.NoError(t, err)
+	}
+}
+
+func TestClientConnPool_ResetServer(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+	})
+
+	var (
+		lock  sync.Mutex
+		dials = make(map[string]int)
+	)
+	dial := pool.dialer
+	pool.dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+		lock.Lock()
+		dials[addr]++
+		lock.Unlock()
+		return dial(ctx, addr)
+	}
+	dialsTo := func(srv testServer) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return dials[resolver.DCPrefix(srv.dc, srv.addr.String())]
+	}
+
+	srv1 := newSimpleTestServer(t, "server-1", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv1.Metadata())
+	t.Cleanup(srv1.shutdown)
+	srv2 := newSimpleTestServer(t, "server-2", "dc2", nil)
+	res.AddServer(types.AreaWAN, srv2.Metadata())
+	t.Cleanup(srv2.shutdown)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	conn1, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client1 := testservice.NewSimpleClient(conn1)
+	conn2, err := pool.ClientConn("dc2")
+	require.NoError(t, err)
+	client2 := testservice.NewSimpleClient(conn2)
+
+	_, err = client1.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	_, err = client2.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	require.Equal(t, 1, dialsTo(srv1))
+	require.Equal(t, 1, dialsTo(srv2))
+
+	require.False(t, pool.ResetServer("unknown"))
+	require.True(t, pool.ResetServer("server-1"))
+
+	// The next call to the server is made on a new connection.
+	retry.Run(t, func(r *retry.R) {
+		resp, err := client1.Something(ctx, &testservice.Req{})
+		require.NoError(r, err)
+		require.Equal(r, "server-1", resp.ServerName)
+	})
+	require.Equal(t, 2, dialsTo(srv1))
+
+	// The connection to the other server is not affected.
+	require.Equal(t, connectivity.Ready, conn2.GetState())
+	_, err = client2.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	require.Equal(t, 1, dialsTo(srv2))
+}
+
+func TestClientConnPool_WarmStandby(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		WarmStandby:           true,
+	})
+
+	var (
+		dialsLock sync.Mutex
+		dials     = make(map[string]int)
+	)
+	dial := pool.dialer
+	pool.dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+		dialsLock.Lock()
+		dials[addr]++
+		dialsLock.Unlock()
+		return dial(ctx, addr)
+	}
+	dialCount := func(addr string) int {
+		dialsLock.Lock()
+		defer dialsLock.Unlock()
+		return dials[addr]
+	}
+
+	servers := make(map[string]testServer)
+	for i := 0; i < 3; i++ {
+		srv := newSimpleTestServer(t, fmt.Sprintf("server-%d", i), "dc1", nil)
+		servers[srv.name] = srv
+		res.AddServer(types.AreaWAN, srv.Metadata())
+	}
+	stopped := make(map[string]bool)
+	t.Cleanup(func() {
+		for name, srv := range servers {
+			if !stopped[name] {
+				srv.shutdown()
+			}
+		}
+	})
+
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	first, err := client.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+
+	// Wait for the standby connection to be established.
+	retry.Run(t, func(r *retry.R) {
+		dialsLock.Lock()
+		defer dialsLock.Unlock()
+		require.Len(r, dials, 2)
+	})
+	var standbyAddr string
+	dialsLock.Lock()
+	for addr := range dials {
+		if addr != resolver.DCPrefix("dc1", servers[first.ServerName].addr.String()) {
+			standbyAddr = addr
+		}
+	}
+	dialsLock.Unlock()
+	require.NotEmpty(t, standbyAddr)
+
+	// Calls stay on the primary while it is available.
+	for i := 0; i < 5; i++ {
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+		require.Equal(t, first.ServerName, resp.ServerName)
+	}
+
+	servers[first.ServerName].shutdown()
+	stopped[first.ServerName] = true
+
+	retry.Run(t, func(r *retry.R) {
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(r, err)
+		require.NotEqual(r, first.ServerName, resp.ServerName)
+		require.Equal(r, standbyAddr, resolver.DCPrefix("dc1", servers[resp.ServerName].addr.String()))
+	})
+	require.Equal(t, 1, dialCount(standbyAddr), "expected the standby to be used without a new dial")
+}
+
 func TestClientConnPool_ForwardToLeader_Failover(t *testing.T) {
 	count := 3
 	res := resolver.NewServerResolverBuilder(newConfig(t))
@@ -338,6 +848,329 @@ func TestClientConnPool_ForwardToLeader_Failover(t *testing.T) {
 	require.Equal(t, resp.ServerName, servers[1].name)
 }
 
+func TestClientConnPool_Ping(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	healthSrv := health.NewServer()
+	srv := newTestServer(t, hclog.Default(), "server-1", "dc1", nil, func(server *grpc.Server) {
+		grpc_health_v1.RegisterHealthServer(server, healthSrv)
+	})
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	noHealthSrv := newSimpleTestServer(t, "server-2", "dc1", nil)
+	res.AddServer(types.AreaWAN, noHealthSrv.Metadata())
+	t.Cleanup(noHealthSrv.shutdown)
+
+	rpcPinger := &fakePinger{}
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		RPCPinger:             rpcPinger,
+	})
+
+	t.Run("serving", func(t *testing.T) {
+		ok, err := pool.Ping("dc1", "server-1", srv.addr)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("not serving", func(t *testing.T) {
+		healthSrv.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+		ok, err := pool.Ping("dc1", "server-1", srv.addr)
+		require.Error(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("falls back to RPC ping", func(t *testing.T) {
+		ok, err := pool.Ping("dc1", "server-2", noHealthSrv.addr)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, 1, rpcPinger.calls)
+	})
+}
+
+func TestClientConnPool_DialTimeout(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	// 10.255.255.1 is not routable, so the connection attempt never completes.
+	addr := &net.TCPAddr{IP: net.ParseIP("10.255.255.1"), Port: 8300}
+	res.AddServer(types.AreaWAN, &metadata.Server{
+		ID:         "server-1",
+		Name:       "server-1.dc1",
+		ShortName:  "server-1",
+		Datacenter: "dc1",
+		Addr:       addr,
+	})
+
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		DialTimeout:           100 * time.Millisecond,
+	})
+
+	start := time.Now()
+	ok, err := pool.Ping("dc1", "server-1", addr)
+	require.Error(t, err)
+	require.False(t, ok)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestClientConnPool_MaxRecvMsgSize(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	srv := newSimpleTestServer(t, "server-1", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	newClient := func(t *testing.T, size int) testservice.SimpleClient {
+		pool := NewClientConnPool(ClientConnPoolConfig{
+			Servers:               res,
+			UseTLSForDC:           useTLSForDcAlwaysTrue,
+			DialingFromServer:     true,
+			DialingFromDatacenter: "dc1",
+			MaxRecvMsgSize:        size,
+		})
+		conn, err := pool.ClientConn("dc1")
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return testservice.NewSimpleClient(conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+
+	t.Run("response larger than the limit", func(t *testing.T) {
+		_, err := newClient(t, 4).Something(ctx, &testservice.Req{})
+		require.Error(t, err)
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("response smaller than the limit", func(t *testing.T) {
+		resp, err := newClient(t, 1024).Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+		require.Equal(t, "server-1", resp.ServerName)
+	})
+}
+
+func TestClientConnPool_Warm(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	srv := newSimpleTestServer(t, "server-1", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	// 10.255.255.1 is not routable, so the connection attempt never completes.
+	unreachable := &metadata.Server{
+		ID:         "server-2",
+		Name:       "server-2.dc2",
+		ShortName:  "server-2",
+		Datacenter: "dc2",
+		Addr:       &net.TCPAddr{IP: net.ParseIP("10.255.255.1"), Port: 8300},
+	}
+	res.AddServer(types.AreaWAN, unreachable)
+
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		DialTimeout:           200 * time.Millisecond,
+	})
+
+	err := pool.Warm([]*metadata.Server{srv.Metadata(), unreachable})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "datacenter dc2")
+	require.NotContains(t, err.Error(), "datacenter dc1")
+
+	pool.connsLock.Lock()
+	defer pool.connsLock.Unlock()
+	require.Len(t, pool.conns, 2)
+
+	conn := pool.conns[fmt.Sprintf("consul://%s/server.dc1", res.Authority())]
+	require.NotNil(t, conn)
+	require.Equal(t, connectivity.Ready, conn.GetState())
+}
+
+func TestClientConnPool_ClientConnForAddr_UnixSocket(t *testing.T) {
+	path := filepath.Join(testutil.TempDir(t, "grpc"), "grpc.sock")
+	lis, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	testservice.RegisterSimpleServer(srv, &simple{name: "local", dc: "dc1"})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               resolver.NewServerResolverBuilder(newConfig(t)),
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromDatacenter: "dc1",
+	})
+
+	conn, err := pool.ClientConnForAddr("unix://" + path)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+
+	resp, err := testservice.NewSimpleClient(conn).Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	require.Equal(t, "local", resp.ServerName)
+
+	same, err := pool.ClientConnForAddr("unix://" + path)
+	require.NoError(t, err)
+	require.Same(t, conn, same)
+
+	_, err = pool.ClientConnForAddr("127.0.0.1:8502")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported address")
+}
+
+func TestClientConnPool_ClientConnForTarget(t *testing.T) {
+	var addrs []grpcresolver.Address
+	for _, name := range []string{"server-1", "server-2", "server-3"} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		srv := grpc.NewServer()
+		testservice.RegisterSimpleServer(srv, &simple{name: name, dc: "dc1"})
+		go srv.Serve(lis)
+		t.Cleanup(srv.Stop)
+		addrs = append(addrs, grpcresolver.Address{Addr: lis.Addr().String()})
+	}
+
+	r := manual.NewBuilderWithScheme("consul-test-targets")
+	r.InitialState(grpcresolver.State{Addresses: addrs})
+	grpcresolver.Register(r)
+
+	cfg := ClientConnPoolConfig{
+		Servers:               resolver.NewServerResolverBuilder(newConfig(t)),
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromDatacenter: "dc1",
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		pool := NewClientConnPool(cfg)
+		_, err := pool.ClientConnForTarget("consul-test-targets:///servers")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not enabled")
+	})
+
+	cfg.ResolverTargets = true
+	pool := NewClientConnPool(cfg)
+	conn, err := pool.ClientConnForTarget("consul-test-targets:///servers")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	same, err := pool.ClientConnForTarget("consul-test-targets:///servers")
+	require.NoError(t, err)
+	require.Same(t, conn, same)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	client := testservice.NewSimpleClient(conn)
+
+	// Calls are spread across every endpoint once their connections are ready.
+	retry.Run(t, func(r *retry.R) {
+		seen := make(map[string]struct{})
+		for i := 0; i < 12; i++ {
+			resp, err := client.Something(ctx, &testservice.Req{})
+			require.NoError(r, err)
+			seen[resp.ServerName] = struct{}{}
+		}
+		require.Len(r, seen, 3)
+	})
+}
+
+func TestClientConnPool_Interceptors(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	srv := newSimpleTestServer(t, "server-1", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	var unaryCalls, streamCalls int32
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		UnaryInterceptors: []grpc.UnaryClientInterceptor{
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				atomic.AddInt32(&unaryCalls, 1)
+				return invoker(ctx, method, req, reply, cc, opts...)
+			},
+		},
+		StreamInterceptors: []grpc.StreamClientInterceptor{
+			func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				atomic.AddInt32(&streamCalls, 1)
+				return streamer(ctx, desc, cc, method, opts...)
+			},
+		},
+	})
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&unaryCalls))
+	require.Equal(t, int32(0), atomic.LoadInt32(&streamCalls))
+
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	stream, err := client.Flow(streamCtx, &testservice.Req{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	streamCancel()
+	require.Equal(t, int32(1), atomic.LoadInt32(&streamCalls))
+}
+
+func TestNewClientConnPool_Keepalive(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+
+	t.Run("defaults", func(t *testing.T) {
+		pool := NewClientConnPool(ClientConnPoolConfig{Servers: res})
+		require.Equal(t, 30*time.Second, pool.keepalive.Time)
+		require.Equal(t, 10*time.Second, pool.keepalive.Timeout)
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		pool := NewClientConnPool(ClientConnPoolConfig{
+			Servers:          res,
+			KeepaliveTime:    time.Minute,
+			KeepaliveTimeout: 5 * time.Second,
+		})
+		require.Equal(t, time.Minute, pool.keepalive.Time)
+		require.Equal(t, 5*time.Second, pool.keepalive.Timeout)
+	})
+}
+
+type fakePinger struct {
+	calls int
+}
+
+func (p *fakePinger) Ping(string, string, net.Addr) (bool, error) {
+	p.calls++
+	return true, nil
+}
+
 func newConfig(t *testing.T) resolver.Config {
 	n := t.Name()
 	s := strings.Replace(n, "/", "", -1)
@@ -0,0 +1,86 @@
+package private
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// gzipCompressorPrefix is the prefix of the names of the gzip compressors
+// registered by this package. The level is appended to the prefix.
+const gzipCompressorPrefix = "consul-gzip-"
+
+// The compressors are registered for every level, so that servers can
+// decompress the calls of clients which use any level. gRPC requires that
+// compressors are registered globally.
+func init() {
+	for level := gzip.BestSpeed; level <= gzip.BestCompression; level++ {
+		encoding.RegisterCompressor(newGzipCompressor(level))
+	}
+}
+
+// UseCompressionLevel returns a grpc.CallOption which compresses the messages
+// of a call with gzip at level, from 1 (best speed) to 9 (best compression).
+// The servers respond with the same compressor, so the messages received by
+// the call are also compressed at level. Higher levels reduce the size of
+// large messages, such as the snapshots of subscriptions, at the cost of more
+// CPU time on the servers and the agents.
+func UseCompressionLevel(level int) (grpc.CallOption, error) {
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d: must be between %d and %d",
+			level, gzip.BestSpeed, gzip.BestCompression)
+	}
+	return grpc.UseCompressor(gzipCompressorName(level)), nil
+}
+
+func gzipCompressorName(level int) string {
+	return fmt.Sprintf("%s%d", gzipCompressorPrefix, level)
+}
+
+// gzipCompressor is an encoding.Compressor which uses a fixed gzip level. The
+// writers are pooled because they are expensive to allocate.
+type gzipCompressor struct {
+	name    string
+	level   int
+	writers sync.Pool
+}
+
+func newGzipCompressor(level int) *gzipCompressor {
+	return &gzipCompressor{name: gzipCompressorName(level), level: level}
+}
+
+func (c *gzipCompressor) Name() string {
+	return c.name
+}
+
+func (c *gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if z, ok := c.writers.Get().(*gzip.Writer); ok {
+		z.Reset(w)
+		return &pooledGzipWriter{Writer: z, pool: &c.writers}, nil
+	}
+	z, err := gzip.NewWriterLevel(w, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledGzipWriter{Writer: z, pool: &c.writers}, nil
+}
+
+func (c *gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// pooledGzipWriter returns the gzip.Writer to the pool when it is closed.
+type pooledGzipWriter struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledGzipWriter) Close() error {
+	err := w.Writer.Close()
+	w.pool.Put(w.Writer)
+	return err
+}
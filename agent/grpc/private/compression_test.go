@@ -0,0 +1,88 @@
+package private
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+
+	"github.com/hashicorp/consul/agent/grpc/private/internal/testservice"
+)
+
+func TestUseCompressionLevel(t *testing.T) {
+	recorder := &compressionRecorder{}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer(grpc.StatsHandler(recorder))
+	testservice.RegisterSimpleServer(srv, &simple{name: "server-1", dc: "dc1"})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+
+	t.Run("configured level", func(t *testing.T) {
+		opt, err := UseCompressionLevel(7)
+		require.NoError(t, err)
+
+		resp, err := client.Something(ctx, &testservice.Req{}, opt)
+		require.NoError(t, err)
+		require.Equal(t, "server-1", resp.ServerName)
+		require.Equal(t, "consul-gzip-7", recorder.last())
+	})
+
+	t.Run("no compression", func(t *testing.T) {
+		_, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+		require.Equal(t, "", recorder.last())
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		for _, level := range []int{-1, 0, 10} {
+			_, err := UseCompressionLevel(level)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid compression level")
+		}
+	})
+}
+
+// compressionRecorder is a stats.Handler which records the compressor used by
+// the last call received by the server.
+type compressionRecorder struct {
+	lock        sync.Mutex
+	compression string
+}
+
+func (r *compressionRecorder) last() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.compression
+}
+
+func (r *compressionRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		r.lock.Lock()
+		r.compression = h.Compression
+		r.lock.Unlock()
+	}
+}
+
+func (r *compressionRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) HandleConn(context.Context, stats.ConnStats) {}
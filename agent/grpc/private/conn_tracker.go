@@ -0,0 +1,90 @@
+package private
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// connTracker tracks the open connections to each server, by server ID, so
+// that the connections to a single server can be closed by
+// ClientConnPool.ResetServer.
+type connTracker struct {
+	servers ServerLocator
+
+	lock  sync.Mutex
+	conns map[string]map[*trackedConn]struct{}
+}
+
+func newConnTracker(servers ServerLocator) *connTracker {
+	return &connTracker{
+		servers: servers,
+		conns:   make(map[string]map[*trackedConn]struct{}),
+	}
+}
+
+// wrapDialer returns a dialer which tracks the connections returned by next.
+func (t *connTracker) wrapDialer(next dialer) dialer {
+	return func(ctx context.Context, globalAddr string) (net.Conn, error) {
+		conn, err := next(ctx, globalAddr)
+		if err != nil {
+			return nil, err
+		}
+		server, err := t.servers.ServerForGlobalAddr(globalAddr)
+		if err != nil {
+			// The server was removed after the connection was established.
+			return conn, nil
+		}
+
+		tc := &trackedConn{Conn: conn, tracker: t, serverID: server.ID}
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		serverConns, ok := t.conns[server.ID]
+		if !ok {
+			serverConns = make(map[*trackedConn]struct{})
+			t.conns[server.ID] = serverConns
+		}
+		serverConns[tc] = struct{}{}
+		return tc, nil
+	}
+}
+
+// closeServer closes all the open connections to the server with the ID. It
+// returns false if there were no open connections to the server.
+func (t *connTracker) closeServer(serverID string) bool {
+	t.lock.Lock()
+	serverConns := t.conns[serverID]
+	delete(t.conns, serverID)
+	t.lock.Unlock()
+
+	for conn := range serverConns {
+		conn.Conn.Close()
+	}
+	return len(serverConns) > 0
+}
+
+func (t *connTracker) remove(conn *trackedConn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	serverConns, ok := t.conns[conn.serverID]
+	if !ok {
+		return
+	}
+	delete(serverConns, conn)
+	if len(serverConns) == 0 {
+		delete(t.conns, conn.serverID)
+	}
+}
+
+// trackedConn is a net.Conn which is removed from its connTracker when it is
+// closed.
+type trackedConn struct {
+	net.Conn
+	tracker  *connTracker
+	serverID string
+}
+
+func (c *trackedConn) Close() error {
+	c.tracker.remove(c)
+	return c.Conn.Close()
+}
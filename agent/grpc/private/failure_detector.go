@@ -0,0 +1,222 @@
+package private
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/hashicorp/consul/agent/grpc/private/resolver"
+)
+
+// FailureDetectorConfig configures the detection of servers which fail
+// consistently. A server is marked unhealthy after Threshold consecutive
+// failures, and is excluded from the connections in the pool until it is
+// probed successfully with Ping.
+type FailureDetectorConfig struct {
+	// Threshold is the number of consecutive failed connection attempts or
+	// unary calls after which a server is marked unhealthy. A call fails when
+	// it returns codes.Unavailable. Defaults to 5.
+	Threshold int
+
+	// ProbeInterval is how often an unhealthy server is probed with Ping.
+	// Defaults to 10 seconds.
+	ProbeInterval time.Duration
+}
+
+const (
+	defaultFailureThreshold     = 5
+	defaultFailureProbeInterval = 10 * time.Second
+)
+
+// ServerHealthSetter is implemented by a ServerLocator which can exclude
+// unhealthy servers from the addresses used by the connections in the pool.
+// When the ServerLocator does not implement it, the failure detector reports
+// the health of servers in Stats, but connections continue to use them.
+type ServerHealthSetter interface {
+	// SetServerHealthy marks the server with the global address as healthy
+	// or unhealthy.
+	SetServerHealthy(globalAddr string, healthy bool)
+}
+
+var _ ServerHealthSetter = (*resolver.ServerResolverBuilder)(nil)
+
+// ServerHealth is the state of the failure detector for a server.
+type ServerHealth struct {
+	// Addr is the address of the server, prefixed with its datacenter.
+	Addr string
+	// ConsecutiveFailures is the number of failures since the last successful
+	// connection attempt or call.
+	ConsecutiveFailures int
+	// Healthy is false once the server has reached the failure threshold,
+	// until it is probed successfully.
+	Healthy bool
+	// UnhealthySince is the time the server was marked unhealthy, or the zero
+	// value if the server is healthy.
+	UnhealthySince time.Time
+}
+
+// ClientConnPoolStats describes the state of a ClientConnPool.
+type ClientConnPoolStats struct {
+	// Servers is the state of the failure detector for each server which has
+	// failed, sorted by Addr. It is empty when the failure detector is not
+	// enabled.
+	Servers []ServerHealth
+}
+
+// failureDetector counts the consecutive failures of each server, and marks
+// the servers which reach the threshold unhealthy until a probe succeeds.
+type failureDetector struct {
+	threshold     int
+	probeInterval time.Duration
+	servers       ServerLocator
+	setter        ServerHealthSetter
+	ping          func(dc, nodeName string, addr net.Addr) (bool, error)
+
+	lock   sync.Mutex
+	byAddr map[string]*ServerHealth
+}
+
+func newFailureDetector(cfg FailureDetectorConfig, servers ServerLocator, ping func(dc, nodeName string, addr net.Addr) (bool, error)) *failureDetector {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = defaultFailureThreshold
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultFailureProbeInterval
+	}
+	setter, _ := servers.(ServerHealthSetter)
+	return &failureDetector{
+		threshold:     cfg.Threshold,
+		probeInterval: cfg.ProbeInterval,
+		servers:       servers,
+		setter:        setter,
+		ping:          ping,
+		byAddr:        make(map[string]*ServerHealth),
+	}
+}
+
+// succeeded resets the count of consecutive failures of the server. An
+// unhealthy server is only marked healthy by a successful probe.
+func (d *failureDetector) succeeded(globalAddr string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if h, ok := d.byAddr[globalAddr]; ok && h.Healthy {
+		h.ConsecutiveFailures = 0
+	}
+}
+
+// failed records a failure of the server, and marks it unhealthy when it
+// reaches the threshold. Failures of addresses which do not belong to a known
+// server, such as mesh gateways, are ignored.
+func (d *failureDetector) failed(globalAddr string) {
+	server, err := d.servers.ServerForGlobalAddr(globalAddr)
+	if err != nil {
+		return
+	}
+
+	d.lock.Lock()
+	h, ok := d.byAddr[globalAddr]
+	if !ok {
+		h = &ServerHealth{Addr: globalAddr, Healthy: true}
+		d.byAddr[globalAddr] = h
+	}
+	h.ConsecutiveFailures++
+	if !h.Healthy || h.ConsecutiveFailures < d.threshold {
+		d.lock.Unlock()
+		return
+	}
+	h.Healthy = false
+	h.UnhealthySince = time.Now()
+	d.lock.Unlock()
+
+	if d.setter != nil {
+		d.setter.SetServerHealthy(globalAddr, false)
+	}
+	go d.probe(globalAddr, server.Datacenter, server.ShortName, server.Addr)
+}
+
+// probe pings the unhealthy server every probeInterval until it succeeds, or
+// until the server is removed, and then marks the server healthy.
+func (d *failureDetector) probe(globalAddr, dc, nodeName string, addr net.Addr) {
+	ticker := time.NewTicker(d.probeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := d.servers.ServerForGlobalAddr(globalAddr); err != nil {
+			break
+		}
+		if ok, _ := d.ping(dc, nodeName, addr); ok {
+			break
+		}
+	}
+
+	d.lock.Lock()
+	delete(d.byAddr, globalAddr)
+	d.lock.Unlock()
+
+	if d.setter != nil {
+		d.setter.SetServerHealthy(globalAddr, true)
+	}
+}
+
+func (d *failureDetector) stats() []ServerHealth {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	result := make([]ServerHealth, 0, len(d.byAddr))
+	for _, h := range d.byAddr {
+		result = append(result, *h)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Addr < result[j].Addr
+	})
+	return result
+}
+
+// wrapDialer returns a dialer which records the result of each connection
+// attempt made by next.
+func (d *failureDetector) wrapDialer(next dialer) dialer {
+	return func(ctx context.Context, globalAddr string) (net.Conn, error) {
+		conn, err := next(ctx, globalAddr)
+		switch {
+		case err == nil:
+			d.succeeded(globalAddr)
+		case ctx.Err() == nil:
+			d.failed(globalAddr)
+		}
+		return conn, err
+	}
+}
+
+// unaryInterceptor returns an interceptor which records the result of each
+// unary call to the servers in the datacenter. Streams are not recorded,
+// because the server of a stream is only known once the stream is finished.
+func (d *failureDetector) unaryInterceptor(dc string) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		var p peer.Peer
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		if p.Addr == nil {
+			return err
+		}
+		globalAddr := resolver.DCPrefix(dc, p.Addr.String())
+		if status.Code(err) == codes.Unavailable {
+			d.failed(globalAddr)
+		} else {
+			d.succeeded(globalAddr)
+		}
+		return err
+	}
+}
@@ -0,0 +1,122 @@
+package private
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/hashicorp/consul/agent/grpc/private/internal/testservice"
+	"github.com/hashicorp/consul/agent/grpc/private/resolver"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/consul/types"
+)
+
+func TestClientConnPool_FailureDetector(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	failing := &unavailable{}
+	failingSrv := newTestServer(t, hclog.Default(), "server-1", "dc1", nil, func(server *grpc.Server) {
+		testservice.RegisterSimpleServer(server, failing)
+		grpc_health_v1.RegisterHealthServer(server, healthSrv)
+	})
+	t.Cleanup(failingSrv.shutdown)
+	failingAddr := resolver.DCPrefix("dc1", failingSrv.addr.String())
+
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		FailureDetector: &FailureDetectorConfig{
+			Threshold:     3,
+			ProbeInterval: 50 * time.Millisecond,
+		},
+	})
+
+	// Connect to the failing server before the other server is added, so that
+	// it is used by the connection.
+	res.AddServer(types.AreaWAN, failingSrv.Metadata())
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	_, err = client.Something(ctx, &testservice.Req{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, []ServerHealth{
+		{Addr: failingAddr, ConsecutiveFailures: 1, Healthy: true},
+	}, pool.Stats().Servers)
+
+	srv := newSimpleTestServer(t, "server-2", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	for i := 0; i < 2; i++ {
+		_, err = client.Something(ctx, &testservice.Req{})
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	}
+
+	stats := pool.Stats().Servers
+	require.Len(t, stats, 1)
+	require.Equal(t, failingAddr, stats[0].Addr)
+	require.Equal(t, 3, stats[0].ConsecutiveFailures)
+	require.False(t, stats[0].Healthy)
+	require.False(t, stats[0].UnhealthySince.IsZero())
+
+	// The connection to the unhealthy server is closed, and calls are sent to
+	// the other server.
+	retry.Run(t, func(r *retry.R) {
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(r, err)
+		require.Equal(r, "server-2", resp.ServerName)
+	})
+	calls := atomic.LoadInt32(&failing.calls)
+	for i := 0; i < 5; i++ {
+		resp, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+		require.Equal(t, "server-2", resp.ServerName)
+	}
+	require.Equal(t, calls, atomic.LoadInt32(&failing.calls))
+
+	// The server is marked healthy again once a probe succeeds.
+	healthSrv.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	retry.Run(t, func(r *retry.R) {
+		require.Empty(r, pool.Stats().Servers)
+	})
+}
+
+func TestClientConnPool_Stats_FailureDetectorDisabled(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	pool := NewClientConnPool(ClientConnPoolConfig{Servers: res})
+	require.Empty(t, pool.Stats().Servers)
+}
+
+// unavailable is a testservice.SimpleServer which fails every call with
+// codes.Unavailable.
+type unavailable struct {
+	calls int32
+}
+
+func (s *unavailable) Flow(*testservice.Req, testservice.Simple_FlowServer) error {
+	atomic.AddInt32(&s.calls, 1)
+	return status.Error(codes.Unavailable, "server is failing")
+}
+
+func (s *unavailable) Something(context.Context, *testservice.Req) (*testservice.Resp, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return nil, status.Error(codes.Unavailable, "server is failing")
+}
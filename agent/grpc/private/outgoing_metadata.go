@@ -0,0 +1,56 @@
+package private
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataExtractor returns the metadata to send with a call, from the context
+// of the call. It may be used to propagate values such as tracing headers
+// (ex: the W3C traceparent) to the servers. It is called once for each call,
+// and may return nil when there is nothing to add.
+type MetadataExtractor func(ctx context.Context) metadata.MD
+
+// withExtractedMetadata adds the metadata returned by extract to the outgoing
+// metadata of ctx.
+func withExtractedMetadata(ctx context.Context, extract MetadataExtractor) context.Context {
+	md := extract(ctx)
+	if len(md) == 0 {
+		return ctx
+	}
+	kv := make([]string, 0, 2*md.Len())
+	for key, values := range md {
+		for _, value := range values {
+			kv = append(kv, key, value)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func metadataUnaryInterceptor(extract MetadataExtractor) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(withExtractedMetadata(ctx, extract), method, req, reply, cc, opts...)
+	}
+}
+
+func metadataStreamInterceptor(extract MetadataExtractor) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(withExtractedMetadata(ctx, extract), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,101 @@
+package private
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/hashicorp/consul/agent/grpc/private/internal/testservice"
+	"github.com/hashicorp/consul/agent/grpc/private/resolver"
+	"github.com/hashicorp/consul/types"
+)
+
+type traceparentKey struct{}
+
+func traceparentMetadata(ctx context.Context) metadata.MD {
+	if tp, ok := ctx.Value(traceparentKey{}).(string); ok {
+		return metadata.Pairs("traceparent", tp)
+	}
+	return nil
+}
+
+func TestMetadataUnaryInterceptor(t *testing.T) {
+	var outgoing metadata.MD
+	invoker := func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	interceptor := metadataUnaryInterceptor(traceparentMetadata)
+
+	t.Run("value in context", func(t *testing.T) {
+		tp := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+		ctx := context.WithValue(context.Background(), traceparentKey{}, tp)
+		ctx = metadata.AppendToOutgoingContext(ctx, "existing", "value")
+
+		err := interceptor(ctx, "/Method", nil, nil, nil, invoker)
+		require.NoError(t, err)
+		require.Equal(t, []string{tp}, outgoing.Get("traceparent"))
+		require.Equal(t, []string{"value"}, outgoing.Get("existing"))
+	})
+
+	t.Run("no value in context", func(t *testing.T) {
+		err := interceptor(context.Background(), "/Method", nil, nil, nil, invoker)
+		require.NoError(t, err)
+		require.Empty(t, outgoing.Get("traceparent"))
+	})
+}
+
+func TestClientConnPool_Metadata(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	srv := newSimpleTestServer(t, "server-1", "dc1", nil)
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	var unaryMD, streamMD metadata.MD
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		Metadata:              traceparentMetadata,
+		UnaryInterceptors: []grpc.UnaryClientInterceptor{
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				unaryMD, _ = metadata.FromOutgoingContext(ctx)
+				return invoker(ctx, method, req, reply, cc, opts...)
+			},
+		},
+		StreamInterceptors: []grpc.StreamClientInterceptor{
+			func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				streamMD, _ = metadata.FromOutgoingContext(ctx)
+				return streamer(ctx, desc, cc, method, opts...)
+			},
+		},
+	})
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	tp := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+	ctx = context.WithValue(ctx, traceparentKey{}, tp)
+
+	_, err = client.Something(ctx, &testservice.Req{})
+	require.NoError(t, err)
+	require.Equal(t, []string{tp}, unaryMD.Get("traceparent"))
+	require.Len(t, unaryMD.Get(RequestIDMetadataKey), 1)
+
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	defer streamCancel()
+	stream, err := client.Flow(streamCtx, &testservice.Req{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []string{tp}, streamMD.Get("traceparent"))
+}
@@ -0,0 +1,76 @@
+package private
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDMetadataKey is the gRPC metadata key used to send the request ID of
+// a call to the servers. The request ID may be used to correlate the logs of
+// the client and the server.
+const RequestIDMetadataKey = "x-consul-request-id"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context that sends id as the request ID of any
+// gRPC calls made with the context.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set by ContextWithRequestID, or
+// the request ID received in the metadata of an incoming gRPC call. Returns an
+// empty string if ctx has no request ID.
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// withRequestID adds the request ID from ctx to the outgoing metadata. If ctx
+// has no request ID a new one is generated.
+func withRequestID(ctx context.Context) context.Context {
+	id := RequestIDFromContext(ctx)
+	if id == "" {
+		var err error
+		id, err = uuid.GenerateUUID()
+		if err != nil {
+			id = time.Now().Format(time.RFC3339Nano)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDMetadataKey, id)
+}
+
+func requestIDUnaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	return invoker(withRequestID(ctx), method, req, reply, cc, opts...)
+}
+
+func requestIDStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	return streamer(withRequestID(ctx), desc, cc, method, opts...)
+}
@@ -0,0 +1,43 @@
+package private
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDUnaryInterceptor(t *testing.T) {
+	var outgoing metadata.MD
+	invoker := func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+
+	t.Run("from context", func(t *testing.T) {
+		ctx := ContextWithRequestID(context.Background(), "the-request-id")
+		err := requestIDUnaryInterceptor(ctx, "/Method", nil, nil, nil, invoker)
+		require.NoError(t, err)
+		require.Equal(t, []string{"the-request-id"}, outgoing.Get(RequestIDMetadataKey))
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		err := requestIDUnaryInterceptor(context.Background(), "/Method", nil, nil, nil, invoker)
+		require.NoError(t, err)
+		ids := outgoing.Get(RequestIDMetadataKey)
+		require.Len(t, ids, 1)
+		require.NotEmpty(t, ids[0])
+	})
+
+	t.Run("from incoming call", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(RequestIDMetadataKey, "forwarded-id"))
+		require.Equal(t, "forwarded-id", RequestIDFromContext(ctx))
+
+		err := requestIDUnaryInterceptor(ctx, "/Method", nil, nil, nil, invoker)
+		require.NoError(t, err)
+		require.Equal(t, []string{"forwarded-id"}, outgoing.Get(RequestIDMetadataKey))
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
 
 	"github.com/hashicorp/consul/agent/metadata"
@@ -22,6 +23,15 @@ type ServerResolverBuilder struct {
 	// servers is an index of Servers by area and Server.ID. The map contains server IDs
 	// for all datacenters.
 	servers map[types.AreaID]map[string]*metadata.Server
+	// unhealthy contains the global addresses of the servers which were marked
+	// unhealthy by SetServerHealthy.
+	unhealthy map[string]struct{}
+	// drained contains the IDs of the servers which were drained by
+	// DrainServer.
+	drained map[string]struct{}
+	// generations contains the number of times the connections to each server,
+	// by global address, were recycled by RecycleServer.
+	generations map[string]uint64
 	// resolvers is an index of connections to the serverResolver which manages
 	// addresses of servers for that connection.
 	resolvers map[resolver.ClientConn]*serverResolver
@@ -37,9 +47,12 @@ type Config struct {
 
 func NewServerResolverBuilder(cfg Config) *ServerResolverBuilder {
 	return &ServerResolverBuilder{
-		cfg:       cfg,
-		servers:   make(map[types.AreaID]map[string]*metadata.Server),
-		resolvers: make(map[resolver.ClientConn]*serverResolver),
+		cfg:         cfg,
+		servers:     make(map[types.AreaID]map[string]*metadata.Server),
+		unhealthy:   make(map[string]struct{}),
+		drained:     make(map[string]struct{}),
+		generations: make(map[string]uint64),
+		resolvers:   make(map[resolver.ClientConn]*serverResolver),
 	}
 }
 
@@ -186,10 +199,20 @@ func (s *ServerResolverBuilder) RemoveServer(areaID types.AreaID, server *metada
 		return // already gone
 	}
 
+	// The caller may only identify the server by its ID and datacenter, so the
+	// address is taken from the server which was added.
+	if added, ok := areaServers[uniqueID(server)]; ok {
+		server = added
+	}
 	delete(areaServers, uniqueID(server))
 	if len(areaServers) == 0 {
 		delete(s.servers, areaID)
 	}
+	if server.Addr != nil {
+		delete(s.unhealthy, DCPrefix(server.Datacenter, server.Addr.String()))
+		delete(s.generations, DCPrefix(server.Datacenter, server.Addr.String()))
+	}
+	delete(s.drained, server.ID)
 
 	addrs := s.getDCAddrs(server.Datacenter)
 	for _, resolver := range s.resolvers {
@@ -199,12 +222,122 @@ func (s *ServerResolverBuilder) RemoveServer(areaID types.AreaID, server *metada
 	}
 }
 
+// SetServerHealthy marks the server with the global address as healthy or
+// unhealthy. Unhealthy servers are excluded from the addresses passed to the
+// resolvers of their datacenter, so that connections to them are closed,
+// unless every server in the datacenter is unhealthy.
+func (s *ServerResolverBuilder) SetServerHealthy(globalAddr string, healthy bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, unhealthy := s.unhealthy[globalAddr]
+	if healthy != unhealthy {
+		return // unchanged
+	}
+	if healthy {
+		delete(s.unhealthy, globalAddr)
+	} else {
+		s.unhealthy[globalAddr] = struct{}{}
+	}
+
+	dc, ok := s.datacenterForGlobalAddr(globalAddr)
+	if !ok {
+		return
+	}
+	addrs := s.getDCAddrs(dc)
+	for _, resolver := range s.resolvers {
+		if resolver.datacenter == dc {
+			resolver.updateAddrs(addrs)
+		}
+	}
+}
+
+// DrainServer excludes the server with the ID from the addresses passed to the
+// resolvers of its datacenter, the same as an unhealthy server, so that new
+// calls are sent to the other servers. The connections to the server are
+// closed gracefully by gRPC, once the calls in flight on them have finished.
+// The server remains drained until it is removed. DrainServer returns false if
+// there is no server with the ID.
+func (s *ServerResolverBuilder) DrainServer(serverID string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dcs := make(map[string]struct{})
+	for _, areaServers := range s.servers {
+		for _, server := range areaServers {
+			if server.ID == serverID {
+				dcs[server.Datacenter] = struct{}{}
+			}
+		}
+	}
+	if len(dcs) == 0 {
+		return false
+	}
+	if _, ok := s.drained[serverID]; ok {
+		return true // unchanged
+	}
+	s.drained[serverID] = struct{}{}
+
+	for dc := range dcs {
+		addrs := s.getDCAddrs(dc)
+		for _, resolver := range s.resolvers {
+			if resolver.datacenter == dc {
+				resolver.updateAddrs(addrs)
+			}
+		}
+	}
+	return true
+}
+
+// generationKey is the key of the attribute which holds the generation of a
+// server address. See RecycleServer.
+type generationKey struct{}
+
+// RecycleServer replaces the connections to the server with the global address
+// with new connections. It changes the attributes of the address of the server
+// passed to the resolvers of its datacenter, which causes gRPC to establish a
+// new connection for new calls, and to close the previous connection
+// gracefully, once the calls in flight on it have finished. RecycleServer
+// returns false if there is no server with the global address.
+func (s *ServerResolverBuilder) RecycleServer(globalAddr string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dc, ok := s.datacenterForGlobalAddr(globalAddr)
+	if !ok {
+		return false
+	}
+	s.generations[globalAddr]++
+
+	addrs := s.getDCAddrs(dc)
+	for _, resolver := range s.resolvers {
+		if resolver.datacenter == dc {
+			resolver.updateAddrs(addrs)
+		}
+	}
+	return true
+}
+
+// datacenterForGlobalAddr returns the datacenter of the server with the global
+// address. This method requires that lock is held for reads.
+func (s *ServerResolverBuilder) datacenterForGlobalAddr(globalAddr string) (string, bool) {
+	for _, areaServers := range s.servers {
+		for _, server := range areaServers {
+			if DCPrefix(server.Datacenter, server.Addr.String()) == globalAddr {
+				return server.Datacenter, true
+			}
+		}
+	}
+	return "", false
+}
+
 // getDCAddrs returns a list of the server addresses for the given datacenter.
-// This method requires that lock is held for reads.
+// Servers which are marked unhealthy or drained are excluded, unless all of
+// them are excluded. This method requires that lock is held for reads.
 func (s *ServerResolverBuilder) getDCAddrs(dc string) []resolver.Address {
 	var (
-		addrs         []resolver.Address
-		keptServerIDs = make(map[string]struct{})
+		addrs, excluded []resolver.Address
+		keptServerIDs   = make(map[string]struct{})
 	)
 	for _, areaServers := range s.servers {
 		for _, server := range areaServers {
@@ -218,13 +351,28 @@ func (s *ServerResolverBuilder) getDCAddrs(dc string) []resolver.Address {
 			}
 			keptServerIDs[server.ID] = struct{}{}
 
-			addrs = append(addrs, resolver.Address{
+			addr := resolver.Address{
 				// NOTE: the address persisted here is only dialable using our custom dialer
 				Addr:       DCPrefix(server.Datacenter, server.Addr.String()),
 				ServerName: server.Name,
-			})
+			}
+			if gen := s.generations[addr.Addr]; gen > 0 {
+				addr.Attributes = attributes.New(generationKey{}, gen)
+			}
+			if _, ok := s.unhealthy[addr.Addr]; ok {
+				excluded = append(excluded, addr)
+				continue
+			}
+			if _, ok := s.drained[server.ID]; ok {
+				excluded = append(excluded, addr)
+				continue
+			}
+			addrs = append(addrs, addr)
 		}
 	}
+	if len(addrs) == 0 {
+		return excluded
+	}
 	return addrs
 }
 
@@ -260,10 +408,25 @@ type serverResolver struct {
 var _ resolver.Resolver = (*serverResolver)(nil)
 
 // updateAddrs updates this serverResolver's ClientConn to use the given set of
-// addrs.
+// addrs. If the first address from the previous update is still in addrs it is
+// kept first, so that the ClientConn remains connected to the same server.
+// Switching servers would force every stream on the connection to reconnect,
+// and may cause the servers to send a new snapshot to every subscriber.
 func (r *serverResolver) updateAddrs(addrs []resolver.Address) {
 	r.addrLock.Lock()
 	defer r.addrLock.Unlock()
+
+	if len(r.addrs) > 0 {
+		// addrs may be shared with other resolvers, so copy it before reordering.
+		addrs = append([]resolver.Address(nil), addrs...)
+		current := r.addrs[0].Addr
+		for i, addr := range addrs {
+			if addr.Addr == current {
+				addrs[0], addrs[i] = addrs[i], addrs[0]
+				break
+			}
+		}
+	}
 	r.updateAddrsLocked(addrs)
 }
 
@@ -1,17 +1,21 @@
 package subscribe
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/hashicorp/go-hclog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/hashicorp/consul/acl"
 	"github.com/hashicorp/consul/agent/consul/state"
 	"github.com/hashicorp/consul/agent/consul/stream"
+	"github.com/hashicorp/consul/agent/grpc/private"
 	"github.com/hashicorp/consul/agent/structs"
 	"github.com/hashicorp/consul/proto/pbservice"
 	"github.com/hashicorp/consul/proto/pbsubscribe"
@@ -22,6 +26,16 @@ import (
 type Server struct {
 	Backend Backend
 	Logger  Logger
+	// ServerID is sent to subscribers in the header metadata of the stream,
+	// using pbsubscribe.ServerIDMetadataKey, so that clients can tell which
+	// server served a subscription. It is not sent when it is empty.
+	ServerID string
+	// IsLeader is used to report whether the server is the leader to
+	// subscribers which set pbsubscribe.RequireLeaderMetadataKey. Those
+	// subscriptions are forwarded to the leader by Backend.Forward, so IsLeader
+	// only returns false while leadership changes. If IsLeader is nil, it is
+	// not reported, and those subscribers retry.
+	IsLeader func() bool
 }
 
 func NewServer(backend Backend, logger Logger) *Server {
@@ -42,8 +56,13 @@ type Backend interface {
 }
 
 func (h *Server) Subscribe(req *pbsubscribe.SubscribeRequest, serverStream pbsubscribe.StateChangeSubscription_SubscribeServer) error {
-	logger := newLoggerForRequest(h.Logger, req)
-	handled, err := h.Backend.Forward(req, forwardToDC(req, serverStream, logger))
+	logger := newLoggerForRequest(h.Logger, req).
+		With("request_id", private.RequestIDFromContext(serverStream.Context()))
+	var info structs.RPCInfo = req
+	if requiresLeader(serverStream.Context()) {
+		info = leaderRequest{SubscribeRequest: req}
+	}
+	handled, err := h.Backend.Forward(info, forwardToDC(req, serverStream, logger))
 	if handled || err != nil {
 		return err
 	}
@@ -60,6 +79,9 @@ func (h *Server) Subscribe(req *pbsubscribe.SubscribeRequest, serverStream pbsub
 	if req.Key == "" {
 		return status.Error(codes.InvalidArgument, "Key is required")
 	}
+	if req.Key == structs.WildcardSpecifier && req.Topic != pbsubscribe.Topic_ServiceHealth {
+		return status.Errorf(codes.InvalidArgument, "Key %q is not supported for topic %v", req.Key, req.Topic)
+	}
 
 	sub, err := h.Backend.Subscribe(toStreamSubscribeRequest(req, entMeta))
 	if err != nil {
@@ -68,6 +90,19 @@ func (h *Server) Subscribe(req *pbsubscribe.SubscribeRequest, serverStream pbsub
 	defer sub.Unsubscribe()
 
 	ctx := serverStream.Context()
+	md := metadata.MD{}
+	if h.ServerID != "" {
+		md.Set(pbsubscribe.ServerIDMetadataKey, h.ServerID)
+	}
+	if requiresLeader(ctx) && h.IsLeader != nil {
+		md.Set(pbsubscribe.LeaderMetadataKey, strconv.FormatBool(h.IsLeader()))
+	}
+	if md.Len() > 0 {
+		if err := serverStream.SendHeader(md); err != nil {
+			return err
+		}
+	}
+
 	elog := &eventLogger{logger: logger}
 	for {
 		event, err := sub.Next(ctx)
@@ -84,7 +119,10 @@ func (h *Server) Subscribe(req *pbsubscribe.SubscribeRequest, serverStream pbsub
 		}
 
 		elog.Trace(event)
-		e := newEventFromStreamEvent(event)
+		e, err := newEventFromStreamEvent(event)
+		if err != nil {
+			return err
+		}
 		if err := serverStream.Send(e); err != nil {
 			return err
 		}
@@ -92,6 +130,14 @@ func (h *Server) Subscribe(req *pbsubscribe.SubscribeRequest, serverStream pbsub
 }
 
 func toStreamSubscribeRequest(req *pbsubscribe.SubscribeRequest, entMeta acl.EnterpriseMeta) *stream.SubscribeRequest {
+	if req.Key == structs.WildcardSpecifier {
+		return &stream.SubscribeRequest{
+			Topic:   req.Topic,
+			Subject: stream.SubjectWildcard,
+			Token:   req.Token,
+			Index:   req.Index,
+		}
+	}
 	return &stream.SubscribeRequest{
 		Topic: req.Topic,
 		Subject: state.EventSubjectService{
@@ -103,21 +149,56 @@ func toStreamSubscribeRequest(req *pbsubscribe.SubscribeRequest, entMeta acl.Ent
 	}
 }
 
+// leaderRequest is a SubscribeRequest which does not allow stale reads, so that
+// Backend.Forward forwards it to the leader when the server is a follower. It
+// is used for subscriptions which set pbsubscribe.RequireLeaderMetadataKey.
+type leaderRequest struct {
+	*pbsubscribe.SubscribeRequest
+}
+
+// AllowStaleRead implements structs.RPCInfo
+func (leaderRequest) AllowStaleRead() bool {
+	return false
+}
+
+// forwardToDC returns the function used by Backend.Forward to forward the
+// subscription to another datacenter, or to the leader of this datacenter.
 func forwardToDC(
 	req *pbsubscribe.SubscribeRequest,
 	serverStream pbsubscribe.StateChangeSubscription_SubscribeServer,
 	logger Logger,
 ) func(conn *grpc.ClientConn) error {
 	return func(conn *grpc.ClientConn) error {
-		logger.Trace("forwarding to another DC")
+		logger.Trace("forwarding to another server")
 		defer logger.Trace("forwarded stream closed")
 
+		ctx := serverStream.Context()
+		if requiresLeader(ctx) {
+			ctx = metadata.AppendToOutgoingContext(ctx, pbsubscribe.RequireLeaderMetadataKey, "true")
+		}
+
 		client := pbsubscribe.NewStateChangeSubscriptionClient(conn)
-		streamHandle, err := client.Subscribe(serverStream.Context(), req)
+		streamHandle, err := client.Subscribe(ctx, req)
 		if err != nil {
 			return err
 		}
 
+		// Send the ID and the leader status of the remote server, so that the
+		// subscriber knows which server produced the events.
+		if md, err := streamHandle.Header(); err == nil {
+			header := metadata.MD{}
+			for _, key := range []string{pbsubscribe.ServerIDMetadataKey, pbsubscribe.LeaderMetadataKey} {
+				if values := md.Get(key); len(values) > 0 {
+					header.Set(key, values[0])
+				}
+			}
+			if header.Len() > 0 {
+				if err := serverStream.SendHeader(header); err != nil {
+					return err
+				}
+			}
+		}
+
 		for {
 			event, err := streamHandle.Recv()
 			if err != nil {
@@ -130,26 +211,40 @@ func forwardToDC(
 	}
 }
 
-func newEventFromStreamEvent(event stream.Event) *pbsubscribe.Event {
+// requiresLeader returns true if the subscriber set
+// pbsubscribe.RequireLeaderMetadataKey in the metadata of the request.
+func requiresLeader(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(pbsubscribe.RequireLeaderMetadataKey)
+	return len(values) > 0 && values[0] == "true"
+}
+
+func newEventFromStreamEvent(event stream.Event) (*pbsubscribe.Event, error) {
 	e := &pbsubscribe.Event{Index: event.Index}
 	switch {
 	case event.IsEndOfSnapshot():
 		e.Payload = &pbsubscribe.Event_EndOfSnapshot{EndOfSnapshot: true}
-		return e
+		return e, nil
 	case event.IsNewSnapshotToFollow():
 		e.Payload = &pbsubscribe.Event_NewSnapshotToFollow{NewSnapshotToFollow: true}
-		return e
+		return e, nil
+	}
+	if err := setPayload(e, event.Payload); err != nil {
+		return nil, err
 	}
-	setPayload(e, event.Payload)
-	return e
+	return e, nil
 }
 
-func setPayload(e *pbsubscribe.Event, payload stream.Payload) {
+func setPayload(e *pbsubscribe.Event, payload stream.Payload) error {
 	switch p := payload.(type) {
 	case *stream.PayloadEvents:
+		events, err := batchEventsFromEventSlice(p.Items)
+		if err != nil {
+			return err
+		}
 		e.Payload = &pbsubscribe.Event_EventBatch{
 			EventBatch: &pbsubscribe.EventBatch{
-				Events: batchEventsFromEventSlice(p.Items),
+				Events: events,
 			},
 		}
 	case state.EventPayloadCheckServiceNode:
@@ -160,17 +255,38 @@ func setPayload(e *pbsubscribe.Event, payload stream.Payload) {
 				CheckServiceNode: pbservice.NewCheckServiceNodeFromStructs(p.Value),
 			},
 		}
+	case state.EventPayloadGatewayService:
+		e.Payload = &pbsubscribe.Event_GatewayService{
+			GatewayService: &pbsubscribe.GatewayServiceUpdate{
+				Op:             p.Op,
+				GatewayService: pbsubscribe.NewGatewayServiceFromStructs(p.Value),
+			},
+		}
+	case state.EventPayloadConfigEntry:
+		entry, err := pbsubscribe.NewConfigEntryFromStructs(p.Value)
+		if err != nil {
+			return err
+		}
+		e.Payload = &pbsubscribe.Event_ConfigEntry{
+			ConfigEntry: &pbsubscribe.ConfigEntryUpdate{
+				Op:          p.Op,
+				ConfigEntry: entry,
+			},
+		}
 	default:
 		panic(fmt.Sprintf("unexpected payload: %T: %#v", p, p))
 	}
+	return nil
 }
 
-func batchEventsFromEventSlice(events []stream.Event) []*pbsubscribe.Event {
+func batchEventsFromEventSlice(events []stream.Event) ([]*pbsubscribe.Event, error) {
 	result := make([]*pbsubscribe.Event, len(events))
 	for i := range events {
 		event := events[i]
 		result[i] = &pbsubscribe.Event{Index: event.Index}
-		setPayload(result[i], event.Payload)
+		if err := setPayload(result[i], event.Payload); err != nil {
+			return nil, err
+		}
 	}
-	return result
+	return result, nil
 }
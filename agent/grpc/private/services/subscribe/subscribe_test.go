@@ -16,6 +16,7 @@ import (
 	"golang.org/x/sync/errgroup"
 	gogrpc "google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/hashicorp/consul/acl"
@@ -273,6 +274,80 @@ func TestServer_Subscribe_IntegrationWithBackend(t *testing.T) {
 	})
 }
 
+func TestServer_Subscribe_IntegrationWithBackend_Wildcard(t *testing.T) {
+	backend := newTestBackend(t)
+	addr := runTestServer(t, NewServer(backend, hclog.New(nil)))
+	ids := newCounter()
+
+	register := func(t *testing.T, label, node, service string) {
+		req := &structs.RegisterRequest{
+			Node:       node,
+			Address:    "3.4.5.6",
+			Datacenter: "dc1",
+			Service: &structs.NodeService{
+				ID:      service + "1",
+				Service: service,
+				Port:    8080,
+			},
+		}
+		require.NoError(t, backend.store.EnsureRegistration(ids.Next(label), req))
+	}
+
+	runStep(t, "register instances of two services", func(t *testing.T) {
+		register(t, "redis", "node1", "redis")
+		register(t, "api", "node2", "api")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	conn, err := gogrpc.DialContext(ctx, addr.String(), gogrpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(logError(t, conn.Close))
+
+	streamClient := pbsubscribe.NewStateChangeSubscriptionClient(conn)
+	chEvents := make(chan eventOrError, 0)
+
+	runStep(t, "receive a snapshot of every service", func(t *testing.T) {
+		streamHandle, err := streamClient.Subscribe(ctx, &pbsubscribe.SubscribeRequest{
+			Topic: pbsubscribe.Topic_ServiceHealth,
+			Key:   structs.WildcardSpecifier,
+		})
+		require.NoError(t, err)
+		go recvEvents(chEvents, streamHandle)
+
+		var services []string
+		for i := 0; i < 2; i++ {
+			event := getEvent(t, chEvents)
+			require.Equal(t, ids.For("api"), event.Index)
+			services = append(services, event.GetServiceHealth().CheckServiceNode.Service.Service)
+		}
+		require.ElementsMatch(t, []string{"redis", "api"}, services)
+		require.True(t, getEvent(t, chEvents).GetEndOfSnapshot())
+	})
+
+	runStep(t, "receive the events of a new service", func(t *testing.T) {
+		register(t, "web", "node3", "web")
+
+		event := getEvent(t, chEvents)
+		require.Equal(t, ids.For("web"), event.Index)
+		require.Equal(t, pbsubscribe.CatalogOp_Register, event.GetServiceHealth().Op)
+		require.Equal(t, "web", event.GetServiceHealth().CheckServiceNode.Service.Service)
+	})
+
+	runStep(t, "the connect topic does not support the wildcard", func(t *testing.T) {
+		streamHandle, err := streamClient.Subscribe(ctx, &pbsubscribe.SubscribeRequest{
+			Topic: pbsubscribe.Topic_ServiceHealthConnect,
+			Key:   structs.WildcardSpecifier,
+		})
+		require.NoError(t, err)
+
+		_, err = streamHandle.Recv()
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument.String(), status.Code(err).String())
+	})
+}
+
 type eventOrError struct {
 	event *pbsubscribe.Event
 	err   error
@@ -314,6 +389,9 @@ type testBackend struct {
 	store       *state.Store
 	authorizer  func(token string, entMeta *acl.EnterpriseMeta) acl.Authorizer
 	forwardConn *gogrpc.ClientConn
+	// leaderConn is used to forward the requests which do not allow stale
+	// reads, like the Server of a follower.
+	leaderConn *gogrpc.ClientConn
 }
 
 func (b testBackend) ResolveTokenAndDefaultMeta(
@@ -324,10 +402,13 @@ func (b testBackend) ResolveTokenAndDefaultMeta(
 	return b.authorizer(token, entMeta), nil
 }
 
-func (b testBackend) Forward(_ structs.RPCInfo, fn func(*gogrpc.ClientConn) error) (handled bool, err error) {
+func (b testBackend) Forward(info structs.RPCInfo, fn func(*gogrpc.ClientConn) error) (handled bool, err error) {
 	if b.forwardConn != nil {
 		return true, fn(b.forwardConn)
 	}
+	if b.leaderConn != nil && !info.AllowStaleRead() {
+		return true, fn(b.leaderConn)
+	}
 	return false, nil
 }
 
@@ -644,6 +725,56 @@ func TestServer_Subscribe_IntegrationWithBackend_ForwardToDC(t *testing.T) {
 	})
 }
 
+func TestServer_Subscribe_IntegrationWithBackend_RequireLeader(t *testing.T) {
+	backendLeader := newTestBackend(t)
+	srvLeader := NewServer(backendLeader, hclog.New(nil))
+	srvLeader.ServerID = "leader"
+	srvLeader.IsLeader = func() bool { return true }
+	addrLeader := runTestServer(t, srvLeader)
+
+	backendFollower := newTestBackend(t)
+	srvFollower := NewServer(backendFollower, hclog.New(nil))
+	srvFollower.ServerID = "follower"
+	srvFollower.IsLeader = func() bool { return false }
+	addrFollower := runTestServer(t, srvFollower)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	connLeader, err := gogrpc.DialContext(ctx, addrLeader.String(), gogrpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(logError(t, connLeader.Close))
+	backendFollower.leaderConn = connLeader
+
+	conn, err := gogrpc.DialContext(ctx, addrFollower.String(), gogrpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(logError(t, conn.Close))
+	client := pbsubscribe.NewStateChangeSubscriptionClient(conn)
+
+	req := &pbsubscribe.SubscribeRequest{Topic: pbsubscribe.Topic_ServiceHealth, Key: "redis"}
+
+	runStep(t, "subscriptions are served by the follower", func(t *testing.T) {
+		streamHandle, err := client.Subscribe(ctx, req)
+		require.NoError(t, err)
+
+		md, err := streamHandle.Header()
+		require.NoError(t, err)
+		require.Equal(t, []string{"follower"}, md.Get(pbsubscribe.ServerIDMetadataKey))
+		require.Empty(t, md.Get(pbsubscribe.LeaderMetadataKey))
+	})
+
+	runStep(t, "subscriptions which require the leader are forwarded", func(t *testing.T) {
+		leaderCtx := metadata.AppendToOutgoingContext(ctx, pbsubscribe.RequireLeaderMetadataKey, "true")
+		streamHandle, err := client.Subscribe(leaderCtx, req)
+		require.NoError(t, err)
+
+		md, err := streamHandle.Header()
+		require.NoError(t, err)
+		require.Equal(t, []string{"leader"}, md.Get(pbsubscribe.ServerIDMetadataKey))
+		require.Equal(t, []string{"true"}, md.Get(pbsubscribe.LeaderMetadataKey))
+	})
+}
+
 func TestServer_Subscribe_IntegrationWithBackend_FilterEventsByACLToken(t *testing.T) {
 	if testing.Short() {
 		t.Skip("too slow for testing.Short")
@@ -956,10 +1087,19 @@ func TestNewEventFromSteamEvent(t *testing.T) {
 
 	fn := func(t *testing.T, tc testCase) {
 		expected := tc.expected
-		actual := newEventFromStreamEvent(tc.event)
+		actual, err := newEventFromStreamEvent(tc.event)
+		require.NoError(t, err)
 		prototest.AssertDeepEqual(t, expected, actual, cmpopts.EquateEmpty())
 	}
 
+	serviceDefaults := &structs.ServiceConfigEntry{
+		Kind:     structs.ServiceDefaults,
+		Name:     "web1",
+		Protocol: "http",
+	}
+	serviceDefaultsProto, err := pbsubscribe.NewConfigEntryFromStructs(serviceDefaults)
+	require.NoError(t, err)
+
 	var testCases = []testCase{
 		{
 			name:  "end of snapshot",
@@ -1091,6 +1231,57 @@ func TestNewEventFromSteamEvent(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "event payload GatewayService",
+			event: stream.Event{
+				Index: 2002,
+				Payload: state.EventPayloadGatewayService{
+					Op: pbsubscribe.CatalogOp_Register,
+					Value: &structs.GatewayService{
+						Gateway:     structs.NewServiceName("gateway", nil),
+						Service:     structs.NewServiceName("web1", nil),
+						GatewayKind: structs.ServiceKindTerminatingGateway,
+						SNI:         "web1.example.com",
+					},
+				},
+			},
+			expected: &pbsubscribe.Event{
+				Index: 2002,
+				Payload: &pbsubscribe.Event_GatewayService{
+					GatewayService: &pbsubscribe.GatewayServiceUpdate{
+						Op: pbsubscribe.CatalogOp_Register,
+						GatewayService: &pbsubscribe.GatewayService{
+							Gateway:               "gateway",
+							GatewayEnterpriseMeta: &pbcommon.EnterpriseMeta{},
+							Service:               "web1",
+							ServiceEnterpriseMeta: &pbcommon.EnterpriseMeta{},
+							GatewayKind:           "terminating-gateway",
+							SNI:                   "web1.example.com",
+							RaftIndex:             &pbcommon.RaftIndex{},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "event payload ConfigEntry",
+			event: stream.Event{
+				Index: 2003,
+				Payload: state.EventPayloadConfigEntry{
+					Op:    pbsubscribe.CatalogOp_Register,
+					Value: serviceDefaults,
+				},
+			},
+			expected: &pbsubscribe.Event{
+				Index: 2003,
+				Payload: &pbsubscribe.Event_ConfigEntry{
+					ConfigEntry: &pbsubscribe.ConfigEntryUpdate{
+						Op:          pbsubscribe.CatalogOp_Register,
+						ConfigEntry: serviceDefaultsProto,
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -0,0 +1,48 @@
+package private
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// slowCallUnaryInterceptor returns an interceptor which logs a warning for
+// each unary call which takes longer than threshold, with the method, the
+// address of the server, and the duration of the call.
+func slowCallUnaryInterceptor(logger hclog.Logger, threshold time.Duration) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		var p peer.Peer
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		elapsed := time.Since(start)
+		if elapsed <= threshold {
+			return err
+		}
+
+		server := "unknown"
+		if p.Addr != nil {
+			server = p.Addr.String()
+		}
+		args := []interface{}{
+			"method", method,
+			"server", server,
+			"duration", elapsed,
+			"threshold", threshold,
+		}
+		if err != nil {
+			args = append(args, "error", err)
+		}
+		logger.Warn("slow gRPC call", args...)
+		return err
+	}
+}
@@ -0,0 +1,103 @@
+package private
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/hashicorp/consul/agent/grpc/private/internal/testservice"
+	"github.com/hashicorp/consul/agent/grpc/private/resolver"
+	"github.com/hashicorp/consul/types"
+)
+
+func TestClientConnPool_SlowCallThreshold(t *testing.T) {
+	res := resolver.NewServerResolverBuilder(newConfig(t))
+	registerWithGRPC(t, res)
+
+	slow := &slowSimple{}
+	srv := newTestServer(t, hclog.Default(), "server-1", "dc1", nil, func(server *grpc.Server) {
+		testservice.RegisterSimpleServer(server, slow)
+	})
+	res.AddServer(types.AreaWAN, srv.Metadata())
+	t.Cleanup(srv.shutdown)
+
+	var buf syncBuffer
+	pool := NewClientConnPool(ClientConnPoolConfig{
+		Servers:               res,
+		UseTLSForDC:           useTLSForDcAlwaysTrue,
+		DialingFromServer:     true,
+		DialingFromDatacenter: "dc1",
+		SlowCallThreshold:     50 * time.Millisecond,
+		Logger:                hclog.New(&hclog.LoggerOptions{Output: &buf}),
+	})
+	conn, err := pool.ClientConn("dc1")
+	require.NoError(t, err)
+	client := testservice.NewSimpleClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+
+	t.Run("fast call is not logged", func(t *testing.T) {
+		_, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+		require.Empty(t, buf.String())
+	})
+
+	t.Run("slow call is logged", func(t *testing.T) {
+		slow.setDelay(100 * time.Millisecond)
+		_, err := client.Something(ctx, &testservice.Req{})
+		require.NoError(t, err)
+
+		out := buf.String()
+		require.Contains(t, out, "[WARN]  slow gRPC call")
+		require.Contains(t, out, "method=/testservice.Simple/Something")
+		require.Contains(t, out, "server="+srv.addr.String())
+		require.Contains(t, out, "duration=")
+	})
+}
+
+// slowSimple is a testservice.SimpleServer which waits for a configurable
+// delay before it responds to Something.
+type slowSimple struct {
+	simple
+	lock  sync.Mutex
+	delay time.Duration
+}
+
+func (s *slowSimple) setDelay(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.delay = d
+}
+
+func (s *slowSimple) Something(ctx context.Context, req *testservice.Req) (*testservice.Resp, error) {
+	s.lock.Lock()
+	delay := s.delay
+	s.lock.Unlock()
+	time.Sleep(delay)
+	return s.simple.Something(ctx, req)
+}
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
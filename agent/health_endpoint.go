@@ -190,6 +190,14 @@ func (s *HTTPHandlers) healthServiceNodes(resp http.ResponseWriter, req *http.Re
 		args.TagFilter = true
 	}
 
+	if floor := params.Get("index-floor"); floor != "" {
+		index, err := strconv.ParseUint(floor, 10, 64)
+		if err != nil {
+			return nil, BadRequestError{Reason: "Invalid value for ?index-floor"}
+		}
+		args.IndexFloor = index
+	}
+
 	// Determine the prefix
 	var prefix string
 	switch healthType {
@@ -1380,6 +1380,58 @@ func TestHealthServiceNodes_PassingFilter(t *testing.T) {
 	})
 }
 
+func TestHealthServiceNodes_IndexFloor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("too slow for testing.Short")
+	}
+
+	t.Parallel()
+	a := NewTestAgent(t, "")
+	defer a.Shutdown()
+	testrpc.WaitForTestAgent(t, a.RPC, "dc1")
+
+	args := &structs.RegisterRequest{
+		Datacenter: "dc1",
+		Node:       "bar",
+		Address:    "127.0.0.1",
+		Service: &structs.NodeService{
+			ID:      "test",
+			Service: "test",
+		},
+	}
+	var out struct{}
+	require.NoError(t, a.RPC("Catalog.Register", args, &out))
+
+	req, _ := http.NewRequest("GET", "/v1/health/service/test", nil)
+	resp := httptest.NewRecorder()
+	_, err := a.srv.HealthServiceNodes(resp, req)
+	require.NoError(t, err)
+	index, err := strconv.ParseUint(resp.Header().Get("X-Consul-Index"), 10, 64)
+	require.NoError(t, err)
+
+	t.Run("returns a result at the floor", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/health/service/test?index-floor=%d&wait=5s", index), nil)
+		resp := httptest.NewRecorder()
+		start := time.Now()
+		obj, err := a.srv.HealthServiceNodes(resp, req)
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 4*time.Second)
+		require.Len(t, obj.(structs.CheckServiceNodes), 1)
+		result, err := strconv.ParseUint(resp.Header().Get("X-Consul-Index"), 10, 64)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, result, index)
+	})
+
+	t.Run("bad value", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/v1/health/service/test?index-floor=nope", nil)
+		resp := httptest.NewRecorder()
+		_, err := a.srv.HealthServiceNodes(resp, req)
+		_, ok := err.(BadRequestError)
+		require.True(t, ok, "expected bad request, got %v", err)
+		require.Contains(t, err.Error(), "Invalid value for ?index-floor")
+	})
+}
+
 func TestHealthServiceNodes_CheckType(t *testing.T) {
 	if testing.Short() {
 		t.Skip("too slow for testing.Short")
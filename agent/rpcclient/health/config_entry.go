@@ -0,0 +1,199 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/consul/agent/cache"
+	cachetype "github.com/hashicorp/consul/agent/cache-types"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+var errConfigEntryKindName = errors.New("config entry results require the kind and the name of the config entry")
+
+// ConfigEntry returns the config entry of req.Kind named req.Name. With the
+// streaming backend the config entry is materialized from a subscription to
+// the ConfigEntries topic, otherwise it is read from the config-entry cache
+// type or the ConfigEntry.Get RPC. The Entry of the result is nil if the config
+// entry does not exist.
+func (c *Client) ConfigEntry(
+	ctx context.Context,
+	req structs.ConfigEntryQuery,
+) (structs.ConfigEntryResponse, cache.ResultMeta, error) {
+	if req.Kind == "" || req.Name == "" {
+		return structs.ConfigEntryResponse{}, cache.ResultMeta{}, errConfigEntryKindName
+	}
+	if c.useConfigEntryStreaming() && (req.QueryOptions.UseCache || req.QueryOptions.MinQueryIndex > 0) {
+		c.QueryOptionDefaults(&req.QueryOptions)
+
+		result, err := c.ViewStore.Get(ctx, c.newConfigEntryRequest(req))
+		c.recordStreamingResult(ctx, err)
+		switch {
+		case err != nil && c.useStreamingFallback():
+			// fall through to the non-streaming backend below.
+		case err != nil:
+			return structs.ConfigEntryResponse{}, cache.ResultMeta{}, err
+		default:
+			return *result.Value.(*structs.ConfigEntryResponse), resultMeta(result), nil
+		}
+	}
+
+	var out structs.ConfigEntryResponse
+	if !req.QueryOptions.UseCache {
+		err := c.NetRPC.RPC("ConfigEntry.Get", &req, &out)
+		return out, cache.ResultMeta{}, err
+	}
+
+	raw, md, err := c.Cache.Get(ctx, cachetype.ConfigEntryName, &req)
+	if err != nil {
+		return out, md, err
+	}
+	value, ok := raw.(*structs.ConfigEntryResponse)
+	if !ok {
+		panic("wrong response type for cachetype.ConfigEntryName")
+	}
+	return *value, md, nil
+}
+
+// NotifyConfigEntry is the same as ConfigEntry, but sends the results to ch as
+// they change, the same as Notify.
+func (c *Client) NotifyConfigEntry(
+	ctx context.Context,
+	req structs.ConfigEntryQuery,
+	correlationID string,
+	ch chan<- cache.UpdateEvent,
+) error {
+	if req.Kind == "" || req.Name == "" {
+		return errConfigEntryKindName
+	}
+	if c.useConfigEntryStreaming() {
+		return c.ViewStore.Notify(ctx, c.newConfigEntryRequest(req), correlationID, ch)
+	}
+	return c.Cache.Notify(ctx, cachetype.ConfigEntryName, &req, correlationID, ch)
+}
+
+// useConfigEntryStreaming is the same as useStreaming for config entry
+// requests, which are never ingress or near requests.
+func (c *Client) useConfigEntryStreaming() bool {
+	return c.UseStreamingBackend && !c.useStreamingFallback()
+}
+
+func (c *Client) newConfigEntryRequest(req structs.ConfigEntryQuery) configEntryRequest {
+	return configEntryRequest{
+		ConfigEntryQuery: req,
+		deps:             c.MaterializerDeps,
+	}
+}
+
+// configEntryRequest is a request for a config entry, which is materialized
+// from a subscription to the ConfigEntries topic.
+type configEntryRequest struct {
+	structs.ConfigEntryQuery
+	deps MaterializerDeps
+}
+
+func (r configEntryRequest) CacheInfo() cache.RequestInfo {
+	return r.ConfigEntryQuery.CacheInfo()
+}
+
+func (r configEntryRequest) Type() string {
+	return "agent.rpcclient.health.configEntryRequest"
+}
+
+func (r configEntryRequest) NewMaterializer() (*submatview.Materializer, error) {
+	req := r.ConfigEntryQuery
+	topic := topicForRequest(r)
+	return submatview.NewMaterializer(submatview.Deps{
+		View:   &configEntryView{},
+		Client: r.deps.client(),
+		Logger: r.deps.Logger,
+		Request: func(index uint64) *pbsubscribe.SubscribeRequest {
+			return &pbsubscribe.SubscribeRequest{
+				Topic:      topic,
+				Key:        pbsubscribe.ConfigEntryKey(req.Kind, req.Name),
+				Token:      req.Token,
+				Datacenter: req.Datacenter,
+				Index:      index,
+				Namespace:  req.EnterpriseMeta.NamespaceOrEmpty(),
+				Partition:  req.EnterpriseMeta.PartitionOrEmpty(),
+			}
+		},
+		EventBufferSize:         r.deps.EventBufferSize,
+		SnapshotTimeout:         r.deps.SnapshotTimeout,
+		SnapshotTimeoutFraction: r.deps.snapshotTimeoutFraction(),
+		CallOptions:             r.deps.callOptions(),
+		MaxSubscriptionLifetime: r.deps.MaxSubscriptionLifetime,
+		BackoffResetPeriod:      r.deps.BackoffResetPeriod,
+		StatusActions:           r.deps.StatusActions,
+		ConsumerLagThreshold:    r.deps.ConsumerLagThreshold,
+		OnConsumerLag:           r.deps.OnConsumerLag,
+	}), nil
+}
+
+func init() {
+	submatview.RegisterEventDecoder(pbsubscribe.Topic_ConfigEntries, decodeConfigEntry)
+}
+
+// decodeConfigEntry is the submatview.EventDecoder of the ConfigEntries topic.
+// It returns the *pbsubscribe.ConfigEntryUpdate of the event.
+func decodeConfigEntry(event *pbsubscribe.Event) (interface{}, error) {
+	update := event.GetConfigEntry()
+	if update == nil {
+		return nil, fmt.Errorf("unexpected event type for config entry view: %T",
+			event.GetPayload())
+	}
+	return update, nil
+}
+
+// configEntryView implements submatview.View for a single config entry.
+type configEntryView struct {
+	// entry is the config entry, or nil if it does not exist.
+	entry structs.ConfigEntry
+}
+
+// Update implements View.
+func (v *configEntryView) Update(events []*pbsubscribe.Event) error {
+	for _, event := range events {
+		value, err := submatview.DecodeEvent(pbsubscribe.Topic_ConfigEntries, event)
+		if err != nil {
+			return err
+		}
+		update, ok := value.(*pbsubscribe.ConfigEntryUpdate)
+		if !ok {
+			return fmt.Errorf("unexpected decoded event type for config entry view: %T", value)
+		}
+
+		switch update.Op {
+		case pbsubscribe.CatalogOp_Register:
+			entry, err := pbsubscribe.ConfigEntryToStructs(update.ConfigEntry)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				return fmt.Errorf("config entry event is missing the config entry")
+			}
+			v.entry = entry
+		case pbsubscribe.CatalogOp_Deregister:
+			v.entry = nil
+		}
+	}
+	return nil
+}
+
+// Result returns the structs.ConfigEntryResponse stored by the view.
+func (v *configEntryView) Result(index uint64) interface{} {
+	return &structs.ConfigEntryResponse{
+		Entry: v.entry,
+		QueryMeta: structs.QueryMeta{
+			Index:   index,
+			Backend: structs.QueryBackendStreaming,
+		},
+	}
+}
+
+func (v *configEntryView) Reset() {
+	v.entry = nil
+}
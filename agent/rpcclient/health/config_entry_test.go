@@ -0,0 +1,161 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	cachetype "github.com/hashicorp/consul/agent/cache-types"
+	"github.com/hashicorp/consul/agent/config"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func TestClient_ConfigEntry_IntegrationWithStore(t *testing.T) {
+	subscribed := make(chan *pbsubscribe.SubscribeRequest, 10)
+	client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+		if req.Key != "service-defaults/web" || req.Topic != pbsubscribe.Topic_ConfigEntries {
+			return fmt.Errorf("unexpected subscription to %v %q", req.Topic, req.Key)
+		}
+		subscribed <- req
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{
+		ViewStore:           submatview.NewStore(hclog.New(nil)),
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		MaterializerDeps: MaterializerDeps{
+			Client: client,
+			Logger: hclog.New(nil),
+		},
+	}
+	req := structs.ConfigEntryQuery{
+		Datacenter:   "dc1",
+		Kind:         structs.ServiceDefaults,
+		Name:         "web",
+		QueryOptions: structs.QueryOptions{UseCache: true, MaxQueryTime: time.Second},
+	}
+
+	protocol := func(result structs.ConfigEntryResponse) string {
+		return result.Entry.(*structs.ServiceConfigEntry).Protocol
+	}
+
+	client.QueueEvents(
+		newEventConfigEntry(t, 5, pbsubscribe.CatalogOp_Register, "http"),
+		newEndOfSnapshotEvent(5))
+
+	runStep(t, "snapshot of the config entry", func(t *testing.T) {
+		result, _, err := c.ConfigEntry(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
+		require.Equal(t, structs.QueryBackendStreaming, result.Backend)
+		require.Equal(t, "web", result.Entry.GetName())
+		require.Equal(t, "http", protocol(result))
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "the config entry is updated", func(t *testing.T) {
+		client.QueueEvents(newEventConfigEntry(t, 10, pbsubscribe.CatalogOp_Register, "grpc"))
+
+		result, _, err := c.ConfigEntry(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), result.Index)
+		require.Equal(t, "grpc", protocol(result))
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "the config entry is deleted", func(t *testing.T) {
+		client.QueueEvents(newEventConfigEntry(t, 20, pbsubscribe.CatalogOp_Deregister, "grpc"))
+
+		result, _, err := c.ConfigEntry(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(20), result.Index)
+		require.Nil(t, result.Entry)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "reconnects and resumes from the last index", func(t *testing.T) {
+		<-subscribed
+		client.QueueErr(tempError("broken pipe"))
+		client.QueueEvents(newEventConfigEntry(t, 30, pbsubscribe.CatalogOp_Register, "http2"))
+
+		result, _, err := c.ConfigEntry(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(30), result.Index)
+		require.Equal(t, "http2", protocol(result))
+
+		resubscribed := <-subscribed
+		require.Equal(t, uint64(20), resubscribed.Index)
+	})
+}
+
+func TestClient_ConfigEntry_WithoutStreaming(t *testing.T) {
+	newClient := func() *Client {
+		return &Client{
+			NetRPC:              &fakeNetRPC{},
+			Cache:               &fakeCache{},
+			ViewStore:           &fakeViewStore{},
+			UseStreamingBackend: false,
+			QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		}
+	}
+	req := structs.ConfigEntryQuery{Datacenter: "dc1", Kind: structs.ServiceDefaults, Name: "web"}
+
+	t.Run("rpc", func(t *testing.T) {
+		c := newClient()
+		_, _, err := c.ConfigEntry(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ConfigEntry.Get"}, c.NetRPC.(*fakeNetRPC).calls)
+		require.Empty(t, c.ViewStore.(*fakeViewStore).calls)
+	})
+
+	t.Run("notify uses the cache", func(t *testing.T) {
+		c := newClient()
+		err := c.NotifyConfigEntry(context.Background(), req, "id", nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{cachetype.ConfigEntryName}, c.Cache.(*fakeCache).calls)
+		require.Empty(t, c.ViewStore.(*fakeViewStore).calls)
+	})
+
+	t.Run("requires the kind and the name", func(t *testing.T) {
+		c := newClient()
+		_, _, err := c.ConfigEntry(context.Background(), structs.ConfigEntryQuery{Kind: structs.ServiceDefaults})
+		require.Equal(t, errConfigEntryKindName, err)
+		err = c.NotifyConfigEntry(context.Background(), structs.ConfigEntryQuery{Name: "web"}, "id", nil)
+		require.Equal(t, errConfigEntryKindName, err)
+	})
+}
+
+func newEventConfigEntry(t *testing.T, index uint64, op pbsubscribe.CatalogOp, protocol string) *pbsubscribe.Event {
+	entry, err := pbsubscribe.NewConfigEntryFromStructs(&structs.ServiceConfigEntry{
+		Kind:     structs.ServiceDefaults,
+		Name:     "web",
+		Protocol: protocol,
+		RaftIndex: structs.RaftIndex{
+			CreateIndex: index,
+			ModifyIndex: index,
+		},
+	})
+	require.NoError(t, err)
+	return &pbsubscribe.Event{
+		Index: index,
+		Payload: &pbsubscribe.Event_ConfigEntry{
+			ConfigEntry: &pbsubscribe.ConfigEntryUpdate{
+				Op:          op,
+				ConfigEntry: entry,
+			},
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package health
+
+import (
+	"github.com/hashicorp/consul/agent/structs"
+)
+
+// maxRemovedNodes is the number of removed nodes retained by changeLog. Deltas
+// can not be computed for requests with an index older than the oldest removal
+// which was discarded.
+const maxRemovedNodes = 1024
+
+// changeLog records the index at which each node of a healthView was added,
+// updated, or removed, so that the changes since an index can be returned by
+// ResultSince.
+type changeLog struct {
+	added    map[string]uint64
+	modified map[string]uint64
+	removed  map[string]removedNode
+
+	// floor is the lowest index for which all the changes are known. It is
+	// unset until the first result is returned after the view is reset,
+	// because the nodes removed before the reset are not known.
+	floor    uint64
+	floorSet bool
+}
+
+type removedNode struct {
+	index uint64
+	added uint64
+	node  structs.CheckServiceNode
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{
+		added:    make(map[string]uint64),
+		modified: make(map[string]uint64),
+		removed:  make(map[string]removedNode),
+	}
+}
+
+func (c *changeLog) upsert(id string, exists bool, index uint64) {
+	if !exists {
+		c.added[id] = index
+	}
+	c.modified[id] = index
+	delete(c.removed, id)
+}
+
+func (c *changeLog) remove(id string, node structs.CheckServiceNode, index uint64) {
+	c.removed[id] = removedNode{index: index, added: c.added[id], node: node}
+	delete(c.added, id)
+	delete(c.modified, id)
+
+	if len(c.removed) <= maxRemovedNodes {
+		return
+	}
+	oldestID, oldest := "", removedNode{index: index}
+	for id, r := range c.removed {
+		if r.index <= oldest.index {
+			oldestID, oldest = id, r
+		}
+	}
+	delete(c.removed, oldestID)
+	if oldest.index > c.floor {
+		c.floor = oldest.index
+	}
+}
+
+// ResultSince implements submatview.DeltaView. When options.Delta is set it
+// returns a structs.IndexedCheckServiceNodesDelta with the changes since the
+// since index, otherwise it returns the same value as Result.
+func (s *healthView) ResultSince(index, since uint64) interface{} {
+	if !s.options.Delta {
+		return s.Result(index)
+	}
+
+	c := s.changes
+	if !c.floorSet && index > 0 {
+		c.floor = index
+		c.floorSet = true
+	}
+
+	result := structs.IndexedCheckServiceNodesDelta{
+		QueryMeta: s.queryMeta(index),
+	}
+	if since == 0 || !c.floorSet || since < c.floor {
+		result.Full = true
+		for _, node := range s.state {
+			result.Added = append(result.Added, node)
+		}
+		s.sortDelta(&result)
+		return &result
+	}
+
+	for id, node := range s.state {
+		switch {
+		case c.added[id] > since:
+			result.Added = append(result.Added, node)
+		case c.modified[id] > since:
+			result.Changed = append(result.Changed, node)
+		}
+	}
+	for _, r := range c.removed {
+		// Nodes which were added and removed since the index were never
+		// returned, so they are not included.
+		if r.index > since && r.added <= since {
+			result.Removed = append(result.Removed, r.node)
+		}
+	}
+	s.sortDelta(&result)
+	return &result
+}
+
+func (s *healthView) sortDelta(result *structs.IndexedCheckServiceNodesDelta) {
+	for _, nodes := range []structs.CheckServiceNodes{result.Added, result.Changed, result.Removed} {
+		sortCheckServiceNodes(&structs.IndexedCheckServiceNodes{Nodes: nodes}, s.options)
+	}
+}
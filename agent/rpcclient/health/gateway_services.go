@@ -0,0 +1,217 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/consul/agent/cache"
+	cachetype "github.com/hashicorp/consul/agent/cache-types"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+var errGatewayServicesEmpty = errors.New("gateway services results require the name of the gateway")
+
+// GatewayServices returns the services linked to the gateway named by
+// req.ServiceName. With the streaming backend the services are materialized
+// from a subscription to the GatewayServices topic, otherwise they are read from
+// the gateway-services cache type or the Catalog.GatewayServices RPC.
+func (c *Client) GatewayServices(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+) (structs.IndexedGatewayServices, cache.ResultMeta, error) {
+	if req.ServiceName == "" {
+		return structs.IndexedGatewayServices{}, cache.ResultMeta{}, errGatewayServicesEmpty
+	}
+	if c.useStreaming(req) && (req.QueryOptions.UseCache || req.QueryOptions.MinQueryIndex > 0) {
+		c.QueryOptionDefaults(&req.QueryOptions)
+
+		result, err := c.ViewStore.Get(ctx, c.newGatewayServicesRequest(req))
+		c.recordStreamingResult(ctx, err)
+		switch {
+		case err != nil && c.useStreamingFallback():
+			// fall through to the non-streaming backend below.
+		case err != nil:
+			return structs.IndexedGatewayServices{}, cache.ResultMeta{}, err
+		default:
+			return *result.Value.(*structs.IndexedGatewayServices), resultMeta(result), nil
+		}
+	}
+
+	var out structs.IndexedGatewayServices
+	if !req.QueryOptions.UseCache {
+		err := c.NetRPC.RPC("Catalog.GatewayServices", &req, &out)
+		return out, cache.ResultMeta{}, err
+	}
+
+	raw, md, err := c.Cache.Get(ctx, cachetype.GatewayServicesName, &req)
+	if err != nil {
+		return out, md, err
+	}
+	value, ok := raw.(*structs.IndexedGatewayServices)
+	if !ok {
+		panic("wrong response type for cachetype.GatewayServicesName")
+	}
+	return *value, md, nil
+}
+
+// NotifyGatewayServices is the same as GatewayServices, but sends the results
+// to ch as they change, the same as Notify.
+func (c *Client) NotifyGatewayServices(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+	correlationID string,
+	ch chan<- cache.UpdateEvent,
+) error {
+	if req.ServiceName == "" {
+		return errGatewayServicesEmpty
+	}
+	if c.useStreaming(req) {
+		return c.ViewStore.Notify(ctx, c.newGatewayServicesRequest(req), correlationID, ch)
+	}
+	return c.Cache.Notify(ctx, cachetype.GatewayServicesName, &req, correlationID, ch)
+}
+
+func (c *Client) newGatewayServicesRequest(req structs.ServiceSpecificRequest) gatewayServicesRequest {
+	// The view options only apply to the nodes of a service.
+	req.ViewOptions = structs.ServiceViewOptions{}
+	return gatewayServicesRequest{
+		ServiceSpecificRequest: req,
+		deps:                   c.MaterializerDeps,
+	}
+}
+
+// gatewayServicesRequest is a request for the services linked to a gateway,
+// which are materialized from a subscription to the GatewayServices topic.
+type gatewayServicesRequest struct {
+	structs.ServiceSpecificRequest
+	deps MaterializerDeps
+}
+
+func (r gatewayServicesRequest) CacheInfo() cache.RequestInfo {
+	return r.ServiceSpecificRequest.CacheInfo()
+}
+
+func (r gatewayServicesRequest) Type() string {
+	return "agent.rpcclient.health.gatewayServicesRequest"
+}
+
+func (r gatewayServicesRequest) NewMaterializer() (*submatview.Materializer, error) {
+	req := r.ServiceSpecificRequest
+	topic := topicForRequest(r)
+	return submatview.NewMaterializer(submatview.Deps{
+		View:   newGatewayServicesView(),
+		Client: r.deps.client(),
+		Logger: r.deps.Logger,
+		Request: func(index uint64) *pbsubscribe.SubscribeRequest {
+			return &pbsubscribe.SubscribeRequest{
+				Topic:      topic,
+				Key:        req.ServiceName,
+				Token:      req.Token,
+				Datacenter: req.Datacenter,
+				Index:      index,
+				Namespace:  req.EnterpriseMeta.NamespaceOrEmpty(),
+				Partition:  req.EnterpriseMeta.PartitionOrEmpty(),
+			}
+		},
+		EventBufferSize:         r.deps.EventBufferSize,
+		SnapshotTimeout:         r.deps.SnapshotTimeout,
+		SnapshotTimeoutFraction: r.deps.snapshotTimeoutFraction(),
+		CallOptions:             r.deps.callOptions(),
+		MaxSubscriptionLifetime: r.deps.MaxSubscriptionLifetime,
+		BackoffResetPeriod:      r.deps.BackoffResetPeriod,
+		StatusActions:           r.deps.StatusActions,
+		ConsumerLagThreshold:    r.deps.ConsumerLagThreshold,
+		OnConsumerLag:           r.deps.OnConsumerLag,
+	}), nil
+}
+
+func init() {
+	submatview.RegisterEventDecoder(pbsubscribe.Topic_GatewayServices, decodeGatewayService)
+}
+
+// decodeGatewayService is the submatview.EventDecoder of the GatewayServices
+// topic. It returns the *pbsubscribe.GatewayServiceUpdate of the event.
+func decodeGatewayService(event *pbsubscribe.Event) (interface{}, error) {
+	update := event.GetGatewayService()
+	if update == nil {
+		return nil, fmt.Errorf("unexpected event type for gateway services view: %T",
+			event.GetPayload())
+	}
+	return update, nil
+}
+
+// gatewayServicesView implements submatview.View for the services linked to a
+// gateway.
+type gatewayServicesView struct {
+	// state contains the linked services, keyed by the name of the service and
+	// the port of the gateway listener, which is the same key as the
+	// gateway-services table of the servers.
+	state map[string]*structs.GatewayService
+}
+
+func newGatewayServicesView() *gatewayServicesView {
+	return &gatewayServicesView{state: make(map[string]*structs.GatewayService)}
+}
+
+// Update implements View.
+func (v *gatewayServicesView) Update(events []*pbsubscribe.Event) error {
+	for _, event := range events {
+		value, err := submatview.DecodeEvent(pbsubscribe.Topic_GatewayServices, event)
+		if err != nil {
+			return err
+		}
+		update, ok := value.(*pbsubscribe.GatewayServiceUpdate)
+		if !ok {
+			return fmt.Errorf("unexpected decoded event type for gateway services view: %T", value)
+		}
+
+		gs := pbsubscribe.GatewayServiceToStructs(update.GatewayService)
+		if gs == nil {
+			return fmt.Errorf("gateway service event is missing the gateway service")
+		}
+		id := gatewayServiceID(gs)
+		switch update.Op {
+		case pbsubscribe.CatalogOp_Register:
+			v.state[id] = gs
+		case pbsubscribe.CatalogOp_Deregister:
+			delete(v.state, id)
+		}
+	}
+	return nil
+}
+
+func gatewayServiceID(gs *structs.GatewayService) string {
+	return gs.Service.String() + "/" + strconv.Itoa(gs.Port)
+}
+
+// Result returns the structs.IndexedGatewayServices stored by the view, sorted
+// by the name of the service and the port.
+func (v *gatewayServicesView) Result(index uint64) interface{} {
+	result := &structs.IndexedGatewayServices{
+		Services: make(structs.GatewayServices, 0, len(v.state)),
+		QueryMeta: structs.QueryMeta{
+			Index:   index,
+			Backend: structs.QueryBackendStreaming,
+		},
+	}
+	for _, gs := range v.state {
+		result.Services = append(result.Services, gs)
+	}
+	sort.Slice(result.Services, func(i, j int) bool {
+		a, b := result.Services[i], result.Services[j]
+		if a.Service.String() != b.Service.String() {
+			return a.Service.String() < b.Service.String()
+		}
+		return a.Port < b.Port
+	})
+	return result
+}
+
+func (v *gatewayServicesView) Reset() {
+	v.state = make(map[string]*structs.GatewayService)
+}
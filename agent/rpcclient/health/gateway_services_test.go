@@ -0,0 +1,178 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	cachetype "github.com/hashicorp/consul/agent/cache-types"
+	"github.com/hashicorp/consul/agent/config"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/proto/pbcommon"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func TestClient_GatewayServices_IntegrationWithStore(t *testing.T) {
+	subscribed := make(chan *pbsubscribe.SubscribeRequest, 10)
+	client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+		if req.Key != "gateway" || req.Topic != pbsubscribe.Topic_GatewayServices {
+			return fmt.Errorf("unexpected subscription to %v %q", req.Topic, req.Key)
+		}
+		subscribed <- req
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{
+		ViewStore:           submatview.NewStore(hclog.New(nil)),
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		MaterializerDeps: MaterializerDeps{
+			Client: client,
+			Logger: hclog.New(nil),
+		},
+	}
+	req := structs.ServiceSpecificRequest{
+		Datacenter:   "dc1",
+		ServiceName:  "gateway",
+		QueryOptions: structs.QueryOptions{UseCache: true, MaxQueryTime: time.Second},
+	}
+
+	serviceNames := func(result structs.IndexedGatewayServices) []string {
+		names := []string{}
+		for _, gs := range result.Services {
+			names = append(names, gs.Service.Name)
+		}
+		return names
+	}
+
+	client.QueueEvents(
+		newEventGatewayService(5, pbsubscribe.CatalogOp_Register, "web"),
+		newEventGatewayService(5, pbsubscribe.CatalogOp_Register, "api"),
+		newEndOfSnapshotEvent(5))
+
+	runStep(t, "snapshot of the linked services", func(t *testing.T) {
+		result, _, err := c.GatewayServices(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
+		require.Equal(t, structs.QueryBackendStreaming, result.Backend)
+		require.Equal(t, []string{"api", "web"}, serviceNames(result))
+		require.Equal(t, "gateway", result.Services[0].Gateway.Name)
+		require.Equal(t, structs.ServiceKindTerminatingGateway, result.Services[0].GatewayKind)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "a linked service is added", func(t *testing.T) {
+		client.QueueEvents(newEventGatewayService(10, pbsubscribe.CatalogOp_Register, "db"))
+
+		result, _, err := c.GatewayServices(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), result.Index)
+		require.Equal(t, []string{"api", "db", "web"}, serviceNames(result))
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "a linked service is removed", func(t *testing.T) {
+		client.QueueEvents(newEventGatewayService(20, pbsubscribe.CatalogOp_Deregister, "web"))
+
+		result, _, err := c.GatewayServices(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(20), result.Index)
+		require.Equal(t, []string{"api", "db"}, serviceNames(result))
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "reconnects and resumes from the last index", func(t *testing.T) {
+		<-subscribed
+		client.QueueErr(tempError("broken pipe"))
+		client.QueueEvents(newEventGatewayService(30, pbsubscribe.CatalogOp_Register, "cache"))
+
+		result, _, err := c.GatewayServices(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(30), result.Index)
+		require.Equal(t, []string{"api", "cache", "db"}, serviceNames(result))
+
+		resubscribed := <-subscribed
+		require.Equal(t, uint64(20), resubscribed.Index)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "reconnects and receives a new snapshot", func(t *testing.T) {
+		client.QueueErr(tempError("broken pipe"))
+		client.QueueEvents(
+			newNewSnapshotToFollowEvent(),
+			newEventGatewayService(40, pbsubscribe.CatalogOp_Register, "web"),
+			newEndOfSnapshotEvent(40))
+
+		result, _, err := c.GatewayServices(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(40), result.Index)
+		require.Equal(t, []string{"web"}, serviceNames(result))
+	})
+}
+
+func TestClient_GatewayServices_WithoutStreaming(t *testing.T) {
+	newClient := func() *Client {
+		return &Client{
+			NetRPC:              &fakeNetRPC{},
+			Cache:               &fakeCache{},
+			ViewStore:           &fakeViewStore{},
+			UseStreamingBackend: false,
+			QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		}
+	}
+	req := structs.ServiceSpecificRequest{Datacenter: "dc1", ServiceName: "gateway"}
+
+	t.Run("rpc", func(t *testing.T) {
+		c := newClient()
+		_, _, err := c.GatewayServices(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, []string{"Catalog.GatewayServices"}, c.NetRPC.(*fakeNetRPC).calls)
+		require.Empty(t, c.ViewStore.(*fakeViewStore).calls)
+	})
+
+	t.Run("notify uses the cache", func(t *testing.T) {
+		c := newClient()
+		err := c.NotifyGatewayServices(context.Background(), req, "id", nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{cachetype.GatewayServicesName}, c.Cache.(*fakeCache).calls)
+		require.Empty(t, c.ViewStore.(*fakeViewStore).calls)
+	})
+
+	t.Run("requires the name of the gateway", func(t *testing.T) {
+		c := newClient()
+		_, _, err := c.GatewayServices(context.Background(), structs.ServiceSpecificRequest{})
+		require.Equal(t, errGatewayServicesEmpty, err)
+	})
+}
+
+func newEventGatewayService(index uint64, op pbsubscribe.CatalogOp, service string) *pbsubscribe.Event {
+	return &pbsubscribe.Event{
+		Index: index,
+		Payload: &pbsubscribe.Event_GatewayService{
+			GatewayService: &pbsubscribe.GatewayServiceUpdate{
+				Op: op,
+				GatewayService: &pbsubscribe.GatewayService{
+					Gateway:     "gateway",
+					Service:     service,
+					GatewayKind: string(structs.ServiceKindTerminatingGateway),
+					RaftIndex: &pbcommon.RaftIndex{
+						CreateIndex: index,
+						ModifyIndex: index,
+					},
+				},
+			},
+		},
+	}
+}
@@ -2,11 +2,19 @@ package health
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/hashicorp/consul/acl"
 	"github.com/hashicorp/consul/agent/cache"
 	"github.com/hashicorp/consul/agent/structs"
 	"github.com/hashicorp/consul/agent/submatview"
-	"github.com/hashicorp/consul/proto/pbsubscribe"
 )
 
 // Client provides access to service health data.
@@ -18,6 +26,20 @@ type Client struct {
 	CacheName           string
 	UseStreamingBackend bool
 	QueryOptionDefaults func(options *structs.QueryOptions)
+
+	// StreamingFailureThreshold is the number of consecutive failures of the
+	// streaming backend after which requests fall back to the cache or RPC
+	// backend. Only the errors for which isStreamingFailure returns true are
+	// counted. A value of 0 disables the fallback.
+	StreamingFailureThreshold int
+	// StreamingRetryInterval is how long requests continue to use the fallback
+	// before the streaming backend is tried again.
+	StreamingRetryInterval time.Duration
+
+	// fallbackLock protects the fields below it.
+	fallbackLock      sync.Mutex
+	streamingFailures int
+	fallbackUntil     time.Time
 }
 
 type NetRPC interface {
@@ -32,51 +54,282 @@ type CacheGetter interface {
 type MaterializedViewStore interface {
 	Get(ctx context.Context, req submatview.Request) (submatview.Result, error)
 	Notify(ctx context.Context, req submatview.Request, cID string, ch chan<- cache.UpdateEvent) error
+	Warm(reqs []submatview.Request) error
+}
+
+// Transports reported by CallInfo.Transport.
+const (
+	// TransportGRPC is used when the result was read from a view materialized
+	// from a gRPC streaming subscription.
+	TransportGRPC = "grpc"
+	// TransportCache is used when the result was read from the agent cache.
+	TransportCache = "cache"
+	// TransportRPC is used when the result was returned by an RPC to the
+	// servers.
+	TransportRPC = "rpc"
+)
+
+// CallInfo describes how a request was served.
+type CallInfo struct {
+	// Transport is one of TransportGRPC, TransportCache, or TransportRPC.
+	Transport string
+	// Target is the target of the gRPC connection used by the subscription when
+	// Transport is TransportGRPC. It is empty for other transports, because
+	// the server used by the cache or RPC is selected by the agent.
+	Target string
 }
 
 func (c *Client) ServiceNodes(
 	ctx context.Context,
 	req structs.ServiceSpecificRequest,
 ) (structs.IndexedCheckServiceNodes, cache.ResultMeta, error) {
-	if c.useStreaming(req) && (req.QueryOptions.UseCache || req.QueryOptions.MinQueryIndex > 0) {
+	out, md, _, err := c.ServiceNodesWithInfo(ctx, req)
+	return out, md, err
+}
+
+// ServiceNodesWithInfo is the same as ServiceNodes, but also returns a
+// CallInfo which describes how the request was served. It may be used for
+// testing and diagnostics.
+func (c *Client) ServiceNodesWithInfo(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+) (structs.IndexedCheckServiceNodes, cache.ResultMeta, CallInfo, error) {
+	if err := checkServiceNodesViewOptions(req.ViewOptions); err != nil {
+		return structs.IndexedCheckServiceNodes{}, cache.ResultMeta{}, CallInfo{}, err
+	}
+	if c.useStreaming(req) && (req.QueryOptions.UseCache || req.QueryOptions.MinQueryIndex > 0 || req.IndexFloor > 0) {
 		c.QueryOptionDefaults(&req.QueryOptions)
 
+		info := CallInfo{Transport: TransportGRPC}
+		if conn := c.MaterializerDeps.Conn; conn != nil {
+			info.Target = conn.Target()
+		}
 		result, err := c.ViewStore.Get(ctx, c.newServiceRequest(req))
-		if err != nil {
-			return structs.IndexedCheckServiceNodes{}, cache.ResultMeta{}, err
+		c.recordStreamingResult(ctx, err)
+		switch {
+		case err != nil && c.useStreamingFallback():
+			// fall through to the non-streaming backend below.
+		case err != nil:
+			return structs.IndexedCheckServiceNodes{}, cache.ResultMeta{}, info, err
+		default:
+			meta := resultMeta(result)
+			return *result.Value.(*structs.IndexedCheckServiceNodes), meta, info, err
 		}
-		meta := cache.ResultMeta{Index: result.Index, Hit: result.Cached}
-		return *result.Value.(*structs.IndexedCheckServiceNodes), meta, err
 	}
 
-	out, md, err := c.getServiceNodes(ctx, req)
+	if err := checkViewOptionsWithoutStreaming(req.ViewOptions); err != nil {
+		return structs.IndexedCheckServiceNodes{}, cache.ResultMeta{}, CallInfo{}, err
+	}
+
+	out, md, info, err := c.getServiceNodes(ctx, req)
 	if err != nil {
-		return out, md, err
+		return out, md, info, err
 	}
 
 	// TODO: DNSServer emitted a metric here, do we still need it?
 	if req.QueryOptions.AllowStale && req.QueryOptions.MaxStaleDuration > 0 && out.QueryMeta.LastContact > req.MaxStaleDuration {
 		req.AllowStale = false
-		err := c.NetRPC.RPC("Health.ServiceNodes", &req, &out)
-		return out, cache.ResultMeta{}, err
+		md, info = cache.ResultMeta{}, CallInfo{Transport: TransportRPC}
+		if err := c.NetRPC.RPC("Health.ServiceNodes", &req, &out); err != nil {
+			return out, md, info, err
+		}
 	}
 
-	return out, md, err
+	applyViewOptions(&out, req.ViewOptions)
+	return out, md, info, nil
+}
+
+// checkViewOptionsWithoutStreaming returns an error if opts contains an option
+// which is only supported by the streaming backend. It is used for requests
+// which are served by the cache or an RPC.
+func checkViewOptionsWithoutStreaming(opts structs.ServiceViewOptions) error {
+	switch {
+	case opts.ArrivalOrder && !opts.SortByHealth:
+		return fmt.Errorf("ViewOptions.ArrivalOrder %w", errViewOptionRequiresStreaming)
+	case opts.HealthChangesOnly:
+		return fmt.Errorf("ViewOptions.HealthChangesOnly %w", errViewOptionRequiresStreaming)
+	case opts.RequireLeader:
+		return fmt.Errorf("ViewOptions.RequireLeader %w", errViewOptionRequiresStreaming)
+	}
+	return nil
+}
+
+// applyViewOptions applies the options which change the result of the request
+// to a result which was served by the cache or an RPC, so that the result is
+// the same as the one from the streaming backend. SkipSort and AllowPartial
+// do not change which nodes are returned, so they are ignored. out.Nodes may
+// be shared with the cache, so it is copied instead of being modified.
+func applyViewOptions(out *structs.IndexedCheckServiceNodes, opts structs.ServiceViewOptions) {
+	if !opts.OnlyPassing && !opts.SortByHealth && !opts.ServiceChecksOnly {
+		return
+	}
+
+	mode := opts.HealthAggregation
+	nodes := make(structs.CheckServiceNodes, 0, len(out.Nodes))
+	for _, csn := range out.Nodes {
+		if opts.OnlyPassing && healthRank(csn, mode) != 0 {
+			continue
+		}
+		nodes = append(nodes, csn)
+	}
+	if opts.SortByHealth {
+		// The servers have already sorted the nodes, so a stable sort keeps
+		// their order within each health status.
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return healthRank(nodes[i], mode) < healthRank(nodes[j], mode)
+		})
+	}
+	// The checks of the node are removed last, because they are used to
+	// aggregate the health status of the node.
+	if opts.ServiceChecksOnly {
+		for i := range nodes {
+			nodes[i].Checks = serviceChecks(nodes[i].Checks)
+		}
+	}
+	out.Nodes = nodes
+}
+
+var (
+	errDeltaRequiresServiceNodesDelta = errors.New("ViewOptions.Delta is only supported by ServiceNodesDelta")
+	errDeltaRequiresStreaming         = errors.New("delta results require the streaming backend")
+
+	errProtoRequiresServiceNodesWithProto = errors.New("ViewOptions.IncludeProto is only supported by ServiceNodesWithProto")
+	errProtoRequiresStreaming             = errors.New("protobuf results require the streaming backend")
+
+	errIDsRequiresServiceIDs = errors.New("ViewOptions.IDsOnly is only supported by ServiceIDs")
+	errIDsRequiresStreaming  = errors.New("ID only results require the streaming backend")
+
+	errViewOptionRequiresStreaming = errors.New("requires the streaming backend")
+
+	errNotifyViewOptionsRequireStreaming = errors.New("ViewOptions.OnlyPassing, SortByHealth, and ServiceChecksOnly require the streaming backend for notifications")
+)
+
+// ServiceNodesDelta returns the changes to the nodes of the service since the
+// MinQueryIndex of req. It is only supported by the streaming backend, and
+// returns an error when the request would be served by another backend.
+func (c *Client) ServiceNodesDelta(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+) (structs.IndexedCheckServiceNodesDelta, cache.ResultMeta, error) {
+	if !c.useStreaming(req) {
+		return structs.IndexedCheckServiceNodesDelta{}, cache.ResultMeta{}, errDeltaRequiresStreaming
+	}
+	c.QueryOptionDefaults(&req.QueryOptions)
+	req.ViewOptions.Delta = true
+	req.ViewOptions.IDsOnly = false
+
+	result, err := c.ViewStore.Get(ctx, c.newServiceRequest(req))
+	if err != nil {
+		return structs.IndexedCheckServiceNodesDelta{}, cache.ResultMeta{}, err
+	}
+	meta := resultMeta(result)
+	return *result.Value.(*structs.IndexedCheckServiceNodesDelta), meta, nil
+}
+
+// ServiceNodesWithProto returns the nodes of the service along with their
+// protobuf form, as received from the servers. It is only supported by the
+// streaming backend, and returns an error when the request would be served by
+// another backend.
+func (c *Client) ServiceNodesWithProto(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+) (IndexedCheckServiceNodesWithProto, cache.ResultMeta, error) {
+	if !c.useStreaming(req) {
+		return IndexedCheckServiceNodesWithProto{}, cache.ResultMeta{}, errProtoRequiresStreaming
+	}
+	c.QueryOptionDefaults(&req.QueryOptions)
+	req.ViewOptions.IncludeProto = true
+	req.ViewOptions.Delta = false
+	req.ViewOptions.IDsOnly = false
+
+	result, err := c.ViewStore.Get(ctx, c.newServiceRequest(req))
+	if err != nil {
+		return IndexedCheckServiceNodesWithProto{}, cache.ResultMeta{}, err
+	}
+	meta := resultMeta(result)
+	return *result.Value.(*IndexedCheckServiceNodesWithProto), meta, nil
+}
+
+// ServiceIDs returns the sorted "node/serviceID" of each instance of the
+// service, instead of the nodes. It may be used by callers which only need to
+// detect changes to the instances, to avoid the cost of copying the nodes. It
+// is only supported by the streaming backend, and returns an error when the
+// request would be served by another backend.
+func (c *Client) ServiceIDs(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+) (IndexedServiceIDs, cache.ResultMeta, error) {
+	if !c.useStreaming(req) {
+		return IndexedServiceIDs{}, cache.ResultMeta{}, errIDsRequiresStreaming
+	}
+	c.QueryOptionDefaults(&req.QueryOptions)
+	req.ViewOptions.IDsOnly = true
+	req.ViewOptions.IncludeProto = false
+	req.ViewOptions.Delta = false
+
+	result, err := c.ViewStore.Get(ctx, c.newServiceRequest(req))
+	if err != nil {
+		return IndexedServiceIDs{}, cache.ResultMeta{}, err
+	}
+	meta := resultMeta(result)
+	return *result.Value.(*IndexedServiceIDs), meta, nil
+}
+
+// FetchServiceNodes gets the result of req from store, and returns the nodes
+// of the service without a type assertion at the call site. It is a typed
+// alternative to MaterializedViewStore.Get for requests which return
+// structs.IndexedCheckServiceNodes. An error is returned if the result has a
+// different type, for example when req sets ServiceViewOptions.Delta,
+// IncludeProto, or IDsOnly.
+func FetchServiceNodes(
+	ctx context.Context,
+	store MaterializedViewStore,
+	req submatview.Request,
+) (*structs.IndexedCheckServiceNodes, cache.ResultMeta, error) {
+	result, err := store.Get(ctx, req)
+	if err != nil {
+		return nil, cache.ResultMeta{}, err
+	}
+	nodes, ok := result.Value.(*structs.IndexedCheckServiceNodes)
+	if !ok {
+		return nil, cache.ResultMeta{}, fmt.Errorf("unexpected result type %T, expected %T", result.Value, nodes)
+	}
+	return nodes, resultMeta(result), nil
+}
+
+// resultMeta returns the cache.ResultMeta of a result from the ViewStore.
+func resultMeta(result submatview.Result) cache.ResultMeta {
+	return cache.ResultMeta{
+		Index:         result.Index,
+		Hit:           result.Cached,
+		Hash:          result.Hash,
+		ServerID:      result.ServerID,
+		EventsApplied: result.EventsApplied,
+		Age:           result.Age,
+	}
 }
 
 func (c *Client) getServiceNodes(
 	ctx context.Context,
 	req structs.ServiceSpecificRequest,
-) (structs.IndexedCheckServiceNodes, cache.ResultMeta, error) {
+) (structs.IndexedCheckServiceNodes, cache.ResultMeta, CallInfo, error) {
+	// A blocking query returns a result whose index is greater than
+	// MinQueryIndex, so a result which satisfies IndexFloor is one with an index
+	// greater than IndexFloor-1.
+	if req.IndexFloor > 0 && req.MinQueryIndex < req.IndexFloor-1 {
+		req.MinQueryIndex = req.IndexFloor - 1
+	}
+
 	var out structs.IndexedCheckServiceNodes
 	if !req.QueryOptions.UseCache {
 		err := c.NetRPC.RPC("Health.ServiceNodes", &req, &out)
-		return out, cache.ResultMeta{}, err
+		return out, cache.ResultMeta{}, CallInfo{Transport: TransportRPC}, err
 	}
 
+	info := CallInfo{Transport: TransportCache}
 	raw, md, err := c.Cache.Get(ctx, c.CacheName, &req)
 	if err != nil {
-		return out, md, err
+		return out, md, info, err
 	}
 
 	value, ok := raw.(*structs.IndexedCheckServiceNodes)
@@ -84,7 +337,21 @@ func (c *Client) getServiceNodes(
 		panic("wrong response type for cachetype.HealthServicesName")
 	}
 
-	return *value, md, nil
+	return *value, md, info, nil
+}
+
+// checkServiceNodesViewOptions returns an error if opts would change the type
+// of the result from structs.IndexedCheckServiceNodes, or if opts are invalid.
+func checkServiceNodesViewOptions(opts structs.ServiceViewOptions) error {
+	switch {
+	case opts.Delta:
+		return errDeltaRequiresServiceNodesDelta
+	case opts.IncludeProto:
+		return errProtoRequiresServiceNodesWithProto
+	case opts.IDsOnly:
+		return errIDsRequiresServiceIDs
+	}
+	return opts.HealthAggregation.Validate()
 }
 
 func (c *Client) Notify(
@@ -93,16 +360,116 @@ func (c *Client) Notify(
 	correlationID string,
 	ch chan<- cache.UpdateEvent,
 ) error {
+	// The results sent to ch have the same type as the results of ServiceNodes.
+	if err := checkServiceNodesViewOptions(req.ViewOptions); err != nil {
+		return err
+	}
 	if c.useStreaming(req) {
 		sr := c.newServiceRequest(req)
 		return c.ViewStore.Notify(ctx, sr, correlationID, ch)
 	}
 
+	// The results sent by the cache can not be changed by applyViewOptions.
+	if err := checkViewOptionsWithoutStreaming(req.ViewOptions); err != nil {
+		return err
+	}
+	if opts := req.ViewOptions; opts.OnlyPassing || opts.SortByHealth || opts.ServiceChecksOnly {
+		return errNotifyViewOptionsRequireStreaming
+	}
 	return c.Cache.Notify(ctx, c.CacheName, &req, correlationID, ch)
 }
 
+// Warm starts the streaming subscriptions for requests, so that the first
+// request for each of them is served from a materialized view without waiting
+// for a snapshot. It may be called when the agent starts, for services which
+// are known to be queried. Requests which would not be served by the streaming
+// backend are ignored.
+func (c *Client) Warm(requests []*structs.ServiceSpecificRequest) error {
+	var reqs []submatview.Request
+	for _, r := range requests {
+		req := *r
+		if !c.useStreaming(req) {
+			continue
+		}
+		c.QueryOptionDefaults(&req.QueryOptions)
+		reqs = append(reqs, c.newServiceRequest(req))
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	return c.ViewStore.Warm(reqs)
+}
+
 func (c *Client) useStreaming(req structs.ServiceSpecificRequest) bool {
-	return c.UseStreamingBackend && !req.Ingress && req.Source.Node == ""
+	return c.UseStreamingBackend && !req.Ingress && req.Source.Node == "" && !c.useStreamingFallback()
+}
+
+// useStreamingFallback returns true if the streaming backend has failed
+// StreamingFailureThreshold times in a row, and StreamingRetryInterval has not
+// yet passed since the last failure.
+func (c *Client) useStreamingFallback() bool {
+	if c.StreamingFailureThreshold == 0 {
+		return false
+	}
+	c.fallbackLock.Lock()
+	defer c.fallbackLock.Unlock()
+	return time.Now().Before(c.fallbackUntil)
+}
+
+// recordStreamingResult tracks the consecutive failures of the streaming
+// backend, and starts the fallback to the non-streaming backend when
+// StreamingFailureThreshold is reached. Errors which are not failures of the
+// streaming backend do not change the count.
+func (c *Client) recordStreamingResult(ctx context.Context, err error) {
+	if c.StreamingFailureThreshold == 0 || ctx.Err() != nil {
+		return
+	}
+	if err != nil && !isStreamingFailure(err) {
+		return
+	}
+	c.fallbackLock.Lock()
+	defer c.fallbackLock.Unlock()
+
+	if err == nil {
+		c.streamingFailures = 0
+		return
+	}
+	c.streamingFailures++
+	if c.streamingFailures >= c.StreamingFailureThreshold {
+		c.streamingFailures = 0
+		c.fallbackUntil = time.Now().Add(c.StreamingRetryInterval)
+	}
+}
+
+// isStreamingFailure returns true if err is a failure of a streaming
+// subscription or of its connection to the servers. Errors caused by the
+// request, such as an invalid filter or an ACL denial, context errors, and
+// ErrViewStale are not failures of the streaming backend, and the other
+// backends would not serve those requests any better.
+func isStreamingFailure(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, submatview.ErrViewStale):
+		return false
+	case errors.Is(err, submatview.ErrSubscriptionACLDenied):
+		return false
+	case acl.IsErrPermissionDenied(err), acl.IsErrNotFound(err):
+		return false
+	case errors.Is(err, submatview.ErrSubscriptionReset), errors.Is(err, submatview.ErrSnapshotTimeout):
+		return true
+	}
+
+	var withStatus interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &withStatus) {
+		return false
+	}
+	switch withStatus.GRPCStatus().Code() {
+	case codes.OK, codes.Canceled, codes.DeadlineExceeded, codes.InvalidArgument,
+		codes.PermissionDenied, codes.Unauthenticated:
+		return false
+	}
+	return true
 }
 
 func (c *Client) newServiceRequest(req structs.ServiceSpecificRequest) serviceRequest {
@@ -138,10 +505,24 @@ func (r serviceRequest) NewMaterializer() (*submatview.Materializer, error) {
 	if err != nil {
 		return nil, err
 	}
+	view.concurrency = r.deps.SnapshotConcurrency
+	view.disableSort = r.deps.DisableSort
+	view.onEvent = r.deps.OnEvent
+	view.maxInstances = r.deps.MaxInstances
 	return submatview.NewMaterializer(submatview.Deps{
-		View:    view,
-		Client:  pbsubscribe.NewStateChangeSubscriptionClient(r.deps.Conn),
-		Logger:  r.deps.Logger,
-		Request: newMaterializerRequest(r.ServiceSpecificRequest),
+		View:                    view,
+		Client:                  r.deps.client(),
+		Logger:                  r.deps.Logger,
+		Request:                 newMaterializerRequest(r.ServiceSpecificRequest),
+		EventBufferSize:         r.deps.EventBufferSize,
+		SnapshotTimeout:         r.deps.SnapshotTimeout,
+		SnapshotTimeoutFraction: r.deps.snapshotTimeoutFraction(),
+		CallOptions:             r.deps.callOptions(),
+		MaxSubscriptionLifetime: r.deps.MaxSubscriptionLifetime,
+		BackoffResetPeriod:      r.deps.BackoffResetPeriod,
+		StatusActions:           r.deps.StatusActions,
+		RequireLeader:           r.ViewOptions.RequireLeader,
+		ConsumerLagThreshold:    r.deps.ConsumerLagThreshold,
+		OnConsumerLag:           r.deps.OnConsumerLag,
 	}), nil
 }
@@ -2,15 +2,21 @@ package health
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/hashicorp/consul/agent/cache"
 	"github.com/hashicorp/consul/agent/config"
 	"github.com/hashicorp/consul/agent/structs"
 	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
 )
 
 func TestClient_ServiceNodes_BackendRouting(t *testing.T) {
@@ -62,6 +68,15 @@ func TestClient_ServiceNodes_BackendRouting(t *testing.T) {
 			},
 			expected: useStreaming,
 		},
+		{
+			name: "use streaming for IndexFloor",
+			req: structs.ServiceSpecificRequest{
+				Datacenter:  "dc1",
+				ServiceName: "web1",
+				IndexFloor:  22,
+			},
+			expected: useStreaming,
+		},
 		{
 			name: "use cache for ingress request",
 			req: structs.ServiceSpecificRequest{
@@ -91,6 +106,124 @@ func TestClient_ServiceNodes_BackendRouting(t *testing.T) {
 	}
 }
 
+func TestClient_ServiceNodesWithInfo_Transport(t *testing.T) {
+	newClient := func() *Client {
+		return &Client{
+			NetRPC:              &fakeNetRPC{},
+			Cache:               &fakeCache{},
+			ViewStore:           &fakeViewStore{},
+			CacheName:           "cache-no-streaming",
+			UseStreamingBackend: true,
+			QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		}
+	}
+
+	type testCase struct {
+		name     string
+		req      structs.ServiceSpecificRequest
+		expected string
+	}
+	var testCases = []testCase{
+		{
+			name:     "rpc",
+			req:      structs.ServiceSpecificRequest{Datacenter: "dc1", ServiceName: "web1"},
+			expected: TransportRPC,
+		},
+		{
+			name: "grpc",
+			req: structs.ServiceSpecificRequest{
+				Datacenter:   "dc1",
+				ServiceName:  "web1",
+				QueryOptions: structs.QueryOptions{UseCache: true},
+			},
+			expected: TransportGRPC,
+		},
+		{
+			name: "cache",
+			req: structs.ServiceSpecificRequest{
+				Datacenter:   "dc1",
+				ServiceName:  "web1",
+				QueryOptions: structs.QueryOptions{UseCache: true},
+				Ingress:      true,
+			},
+			expected: TransportCache,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, info, err := newClient().ServiceNodesWithInfo(context.Background(), tc.req)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, info.Transport)
+			require.Empty(t, info.Target)
+		})
+	}
+
+	t.Run("cache after streaming fallback", func(t *testing.T) {
+		c := newClient()
+		c.ViewStore = &failingViewStore{}
+		c.StreamingFailureThreshold = 1
+		c.StreamingRetryInterval = time.Minute
+
+		req := structs.ServiceSpecificRequest{
+			Datacenter:   "dc1",
+			ServiceName:  "web1",
+			QueryOptions: structs.QueryOptions{UseCache: true},
+		}
+		_, _, info, err := c.ServiceNodesWithInfo(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, TransportCache, info.Transport)
+	})
+}
+
+func TestClient_ServiceNodes_RejectsIncludeProto(t *testing.T) {
+	c := &Client{
+		NetRPC:              &fakeNetRPC{},
+		Cache:               &fakeCache{},
+		ViewStore:           &fakeViewStore{},
+		CacheName:           "cache-no-streaming",
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+	}
+	req := structs.ServiceSpecificRequest{
+		Datacenter:   "dc1",
+		ServiceName:  "web1",
+		QueryOptions: structs.QueryOptions{UseCache: true},
+		ViewOptions:  structs.ServiceViewOptions{IncludeProto: true},
+	}
+
+	_, _, err := c.ServiceNodes(context.Background(), req)
+	require.Equal(t, errProtoRequiresServiceNodesWithProto, err)
+
+	c.UseStreamingBackend = false
+	_, _, err = c.ServiceNodesWithProto(context.Background(), req)
+	require.Equal(t, errProtoRequiresStreaming, err)
+}
+
+func TestClient_ServiceNodes_RejectsIDsOnly(t *testing.T) {
+	c := &Client{
+		NetRPC:              &fakeNetRPC{},
+		Cache:               &fakeCache{},
+		ViewStore:           &fakeViewStore{},
+		CacheName:           "cache-no-streaming",
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+	}
+	req := structs.ServiceSpecificRequest{
+		Datacenter:   "dc1",
+		ServiceName:  "web1",
+		QueryOptions: structs.QueryOptions{UseCache: true},
+		ViewOptions:  structs.ServiceViewOptions{IDsOnly: true},
+	}
+
+	_, _, err := c.ServiceNodes(context.Background(), req)
+	require.Equal(t, errIDsRequiresServiceIDs, err)
+
+	c.UseStreamingBackend = false
+	_, _, err = c.ServiceIDs(context.Background(), req)
+	require.Equal(t, errIDsRequiresStreaming, err)
+}
+
 func useRPC(t *testing.T, c *Client) {
 	t.Helper()
 
@@ -159,10 +292,12 @@ func (f *fakeCache) Notify(_ context.Context, t string, _ cache.Request, _ strin
 
 type fakeNetRPC struct {
 	calls []string
+	args  []interface{}
 }
 
-func (f *fakeNetRPC) RPC(method string, _ interface{}, _ interface{}) error {
+func (f *fakeNetRPC) RPC(method string, args interface{}, _ interface{}) error {
 	f.calls = append(f.calls, method)
+	f.args = append(f.args, args)
 	return nil
 }
 
@@ -180,6 +315,26 @@ func (f *fakeViewStore) Notify(_ context.Context, req submatview.Request, _ stri
 	return nil
 }
 
+func (f *fakeViewStore) Warm(reqs []submatview.Request) error {
+	f.calls = append(f.calls, reqs...)
+	return nil
+}
+
+func TestFetchServiceNodes_UnexpectedResultType(t *testing.T) {
+	store := &idsViewStore{}
+	_, _, err := FetchServiceNodes(context.Background(), store, serviceRequest{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unexpected result type *health.IndexedServiceIDs")
+}
+
+type idsViewStore struct {
+	fakeViewStore
+}
+
+func (f *idsViewStore) Get(context.Context, submatview.Request) (submatview.Result, error) {
+	return submatview.Result{Value: &IndexedServiceIDs{}}, nil
+}
+
 func TestClient_Notify_BackendRouting(t *testing.T) {
 	type testCase struct {
 		name     string
@@ -237,6 +392,39 @@ func TestClient_Notify_BackendRouting(t *testing.T) {
 	}
 }
 
+func TestClient_Notify_RejectsResultTypeViewOptions(t *testing.T) {
+	cases := map[string]struct {
+		opts     structs.ServiceViewOptions
+		expected error
+	}{
+		"delta":         {opts: structs.ServiceViewOptions{Delta: true}, expected: errDeltaRequiresServiceNodesDelta},
+		"include proto": {opts: structs.ServiceViewOptions{IncludeProto: true}, expected: errProtoRequiresServiceNodesWithProto},
+		"ids only":      {opts: structs.ServiceViewOptions{IDsOnly: true}, expected: errIDsRequiresServiceIDs},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &Client{
+				NetRPC:              &fakeNetRPC{},
+				Cache:               &fakeCache{},
+				ViewStore:           &fakeViewStore{},
+				CacheName:           "cache-no-streaming",
+				UseStreamingBackend: true,
+			}
+			req := structs.ServiceSpecificRequest{
+				Datacenter:  "dc1",
+				ServiceName: "web1",
+				ViewOptions: tc.opts,
+			}
+
+			err := c.Notify(context.Background(), req, "cid", nil)
+			require.Equal(t, tc.expected, err)
+			require.Empty(t, c.ViewStore.(*fakeViewStore).calls)
+			require.Empty(t, c.Cache.(*fakeCache).calls)
+		})
+	}
+}
+
 func TestClient_ServiceNodes_SetsDefaults(t *testing.T) {
 	store := &fakeViewStore{}
 	c := &Client{
@@ -261,3 +449,361 @@ func TestClient_ServiceNodes_SetsDefaults(t *testing.T) {
 	require.Len(t, store.calls, 1)
 	require.Equal(t, 100*time.Second, store.calls[0].CacheInfo().Timeout)
 }
+
+func TestClient_ServiceNodes_IndexFloorWithoutStreaming(t *testing.T) {
+	run := func(t *testing.T, minIndex, floor, expected uint64) {
+		rpc := &fakeNetRPC{}
+		c := &Client{
+			NetRPC:              rpc,
+			Cache:               &fakeCache{},
+			ViewStore:           &fakeViewStore{},
+			CacheName:           "cache-no-streaming",
+			QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		}
+
+		req := structs.ServiceSpecificRequest{
+			Datacenter:   "dc1",
+			ServiceName:  "web1",
+			IndexFloor:   floor,
+			QueryOptions: structs.QueryOptions{MinQueryIndex: minIndex},
+		}
+		_, _, err := c.ServiceNodes(context.Background(), req)
+		require.NoError(t, err)
+
+		require.Len(t, rpc.args, 1)
+		args := rpc.args[0].(*structs.ServiceSpecificRequest)
+		require.Equal(t, expected, args.MinQueryIndex)
+	}
+
+	t.Run("blocks until the floor", func(t *testing.T) {
+		run(t, 5, 22, 21)
+	})
+	t.Run("MinQueryIndex above the floor", func(t *testing.T) {
+		run(t, 30, 22, 30)
+	})
+	t.Run("no floor", func(t *testing.T) {
+		run(t, 5, 0, 5)
+	})
+}
+
+func TestClient_ServiceNodes_ViewOptionsWithoutStreaming(t *testing.T) {
+	buildTestNode := func(name, nodeStatus, serviceStatus string) structs.CheckServiceNode {
+		return structs.CheckServiceNode{
+			Node:    &structs.Node{Node: name},
+			Service: &structs.NodeService{ID: "web", Service: "web"},
+			Checks: structs.HealthChecks{
+				{Node: name, CheckID: "serf", Status: nodeStatus},
+				{Node: name, CheckID: "web", ServiceID: "web", Status: serviceStatus},
+			},
+		}
+	}
+	critical := buildTestNode("node-a", api.HealthPassing, api.HealthCritical)
+	passing := buildTestNode("node-b", api.HealthPassing, api.HealthPassing)
+	warning := buildTestNode("node-c", api.HealthWarning, api.HealthPassing)
+
+	run := func(t *testing.T, opts structs.ServiceViewOptions) (structs.CheckServiceNodes, error) {
+		cached := &structs.IndexedCheckServiceNodes{
+			Nodes: structs.CheckServiceNodes{critical, passing, warning},
+		}
+		c := &Client{
+			NetRPC:              &fakeNetRPC{},
+			Cache:               &resultCache{result: cached},
+			ViewStore:           &fakeViewStore{},
+			CacheName:           "cache-no-streaming",
+			QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		}
+
+		req := structs.ServiceSpecificRequest{
+			Datacenter:   "dc1",
+			ServiceName:  "web",
+			QueryOptions: structs.QueryOptions{UseCache: true},
+			ViewOptions:  opts,
+		}
+		out, _, err := c.ServiceNodes(context.Background(), req)
+
+		// The result stored in the cache must not be modified.
+		require.Equal(t, structs.CheckServiceNodes{critical, passing, warning}, cached.Nodes)
+		return out.Nodes, err
+	}
+
+	t.Run("OnlyPassing", func(t *testing.T) {
+		nodes, err := run(t, structs.ServiceViewOptions{OnlyPassing: true})
+		require.NoError(t, err)
+		require.Equal(t, structs.CheckServiceNodes{passing}, nodes)
+
+		nodes, err = run(t, structs.ServiceViewOptions{
+			OnlyPassing:       true,
+			HealthAggregation: structs.HealthAggregationWarningAsPassing,
+		})
+		require.NoError(t, err)
+		require.Equal(t, structs.CheckServiceNodes{passing, warning}, nodes)
+	})
+
+	t.Run("SortByHealth", func(t *testing.T) {
+		nodes, err := run(t, structs.ServiceViewOptions{SortByHealth: true})
+		require.NoError(t, err)
+		require.Equal(t, structs.CheckServiceNodes{passing, warning, critical}, nodes)
+	})
+
+	t.Run("ServiceChecksOnly", func(t *testing.T) {
+		nodes, err := run(t, structs.ServiceViewOptions{
+			ServiceChecksOnly: true,
+			OnlyPassing:       true,
+		})
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+		require.Equal(t, structs.HealthChecks{passing.Checks[1]}, nodes[0].Checks)
+	})
+
+	t.Run("options which are ignored", func(t *testing.T) {
+		nodes, err := run(t, structs.ServiceViewOptions{SkipSort: true, AllowPartial: true})
+		require.NoError(t, err)
+		require.Equal(t, structs.CheckServiceNodes{critical, passing, warning}, nodes)
+	})
+
+	t.Run("options which require streaming", func(t *testing.T) {
+		for name, opts := range map[string]structs.ServiceViewOptions{
+			"ArrivalOrder":      {ArrivalOrder: true},
+			"HealthChangesOnly": {HealthChangesOnly: true},
+			"RequireLeader":     {RequireLeader: true},
+		} {
+			_, err := run(t, opts)
+			require.EqualError(t, err, fmt.Sprintf("ViewOptions.%s requires the streaming backend", name))
+		}
+	})
+
+	t.Run("invalid HealthAggregation", func(t *testing.T) {
+		_, err := run(t, structs.ServiceViewOptions{HealthAggregation: "warning-is-fine"})
+		require.EqualError(t, err, `unknown health aggregation "warning-is-fine"`)
+	})
+}
+
+func TestClient_Notify_ViewOptionsWithoutStreaming(t *testing.T) {
+	c := &Client{
+		NetRPC:    &fakeNetRPC{},
+		Cache:     &fakeCache{},
+		ViewStore: &fakeViewStore{},
+		CacheName: "cache-no-streaming",
+	}
+	req := structs.ServiceSpecificRequest{
+		Datacenter:  "dc1",
+		ServiceName: "web",
+		ViewOptions: structs.ServiceViewOptions{OnlyPassing: true},
+	}
+
+	err := c.Notify(context.Background(), req, "cid", nil)
+	require.Equal(t, errNotifyViewOptionsRequireStreaming, err)
+
+	req.ViewOptions = structs.ServiceViewOptions{RequireLeader: true}
+	err = c.Notify(context.Background(), req, "cid", nil)
+	require.EqualError(t, err, "ViewOptions.RequireLeader requires the streaming backend")
+
+	req.ViewOptions = structs.ServiceViewOptions{SkipSort: true}
+	require.NoError(t, c.Notify(context.Background(), req, "cid", nil))
+}
+
+// resultCache is a CacheGetter which returns result from Get.
+type resultCache struct {
+	result *structs.IndexedCheckServiceNodes
+}
+
+func (f *resultCache) Get(_ context.Context, _ string, _ cache.Request) (interface{}, cache.ResultMeta, error) {
+	return f.result, cache.ResultMeta{}, nil
+}
+
+func (f *resultCache) Notify(_ context.Context, _ string, _ cache.Request, _ string, _ chan<- cache.UpdateEvent) error {
+	return nil
+}
+
+func TestClient_Warm(t *testing.T) {
+	store := &fakeViewStore{}
+	c := &Client{
+		ViewStore:           store,
+		CacheName:           "cache-no-streaming",
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{
+			MaxQueryTime:     200 * time.Second,
+			DefaultQueryTime: 100 * time.Second,
+		}),
+	}
+
+	reqs := []*structs.ServiceSpecificRequest{
+		{Datacenter: "dc1", ServiceName: "web1"},
+		{Datacenter: "dc1", ServiceName: "web2", Ingress: true},
+		{Datacenter: "dc1", ServiceName: "web3"},
+	}
+	require.NoError(t, c.Warm(reqs))
+
+	require.Len(t, store.calls, 2)
+	require.Equal(t, "web1", store.calls[0].(serviceRequest).ServiceName)
+	require.Equal(t, "web3", store.calls[1].(serviceRequest).ServiceName)
+	// The defaults are applied so that the entries match later requests.
+	require.Equal(t, 100*time.Second, store.calls[0].CacheInfo().Timeout)
+	// The requests passed to Warm are not modified.
+	require.Equal(t, time.Duration(0), reqs[0].MaxQueryTime)
+}
+
+func TestClient_ServiceNodes_StreamingFallback(t *testing.T) {
+	store := &failingViewStore{}
+	c := &Client{
+		NetRPC:                    &fakeNetRPC{},
+		Cache:                     &fakeCache{},
+		ViewStore:                 store,
+		CacheName:                 "cache-no-streaming",
+		UseStreamingBackend:       true,
+		QueryOptionDefaults:       config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		StreamingFailureThreshold: 2,
+		StreamingRetryInterval:    50 * time.Millisecond,
+	}
+
+	req := structs.ServiceSpecificRequest{
+		Datacenter:   "dc1",
+		ServiceName:  "web1",
+		QueryOptions: structs.QueryOptions{UseCache: true},
+	}
+
+	_, _, err := c.ServiceNodes(context.Background(), req)
+	require.Error(t, err)
+	require.Equal(t, 1, store.calls)
+
+	// The second failure reaches the threshold, so the result is returned by
+	// the fallback.
+	_, _, err = c.ServiceNodes(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, 2, store.calls)
+	require.Equal(t, []string{"cache-no-streaming"}, c.Cache.(*fakeCache).calls)
+
+	_, _, err = c.ServiceNodes(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, 2, store.calls)
+	require.Len(t, c.Cache.(*fakeCache).calls, 2)
+
+	err = c.Notify(context.Background(), req, "cid", nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, store.calls)
+	require.Len(t, c.Cache.(*fakeCache).calls, 3)
+
+	// Streaming is tried again after the retry interval.
+	time.Sleep(60 * time.Millisecond)
+	_, _, err = c.ServiceNodes(context.Background(), req)
+	require.Error(t, err)
+	require.Equal(t, 3, store.calls)
+}
+
+func TestClient_ServiceNodes_StreamingFallback_IgnoresRequestErrors(t *testing.T) {
+	requestErrors := map[string]error{
+		"invalid filter":    fmt.Errorf(`invalid filter "Service.Foo == 1": unknown selector`),
+		"acl denied":        fmt.Errorf("%w: Permission denied", submatview.ErrSubscriptionACLDenied),
+		"acl not found":     status.Error(codes.Unknown, "ACL not found"),
+		"permission denied": status.Error(codes.PermissionDenied, "Permission denied"),
+		"invalid argument":  status.Error(codes.InvalidArgument, "Key is required"),
+		"view stale":        fmt.Errorf("%w: disconnected from the servers for 1m0s", submatview.ErrViewStale),
+		"canceled":          context.Canceled,
+	}
+
+	for name, requestErr := range requestErrors {
+		t.Run(name, func(t *testing.T) {
+			store := &failingViewStore{err: requestErr}
+			c := &Client{
+				NetRPC:                    &fakeNetRPC{},
+				Cache:                     &fakeCache{},
+				ViewStore:                 store,
+				CacheName:                 "cache-no-streaming",
+				UseStreamingBackend:       true,
+				QueryOptionDefaults:       config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+				StreamingFailureThreshold: 1,
+				StreamingRetryInterval:    time.Minute,
+			}
+
+			req := structs.ServiceSpecificRequest{
+				Datacenter:   "dc1",
+				ServiceName:  "web1",
+				QueryOptions: structs.QueryOptions{UseCache: true},
+			}
+			for i := 0; i < 3; i++ {
+				_, _, err := c.ServiceNodes(context.Background(), req)
+				require.Equal(t, requestErr, err)
+			}
+			require.Equal(t, 3, store.calls)
+			require.Empty(t, c.Cache.(*fakeCache).calls)
+		})
+	}
+}
+
+type failingViewStore struct {
+	calls int
+	// err is returned by Get. If err is nil, Get returns the error of a server
+	// which does not support streaming.
+	err error
+}
+
+func (f *failingViewStore) Get(context.Context, submatview.Request) (submatview.Result, error) {
+	f.calls++
+	if f.err != nil {
+		return submatview.Result{}, f.err
+	}
+	return submatview.Result{}, status.Error(codes.Unimplemented, "unknown service pbsubscribe.StateChangeSubscription")
+}
+
+func (f *failingViewStore) Notify(context.Context, submatview.Request, string, chan<- cache.UpdateEvent) error {
+	f.calls++
+	return nil
+}
+
+func (f *failingViewStore) Warm([]submatview.Request) error {
+	f.calls++
+	return nil
+}
+
+func TestClient_ServiceNodes_MaxSubscriptionLifetime(t *testing.T) {
+	subscribed := make(chan *pbsubscribe.SubscribeRequest, 10)
+	client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+		subscribed <- req
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{
+		ViewStore:           submatview.NewStore(hclog.New(nil)),
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		MaterializerDeps: MaterializerDeps{
+			Client:                  client,
+			Logger:                  hclog.New(nil),
+			MaxSubscriptionLifetime: 100 * time.Millisecond,
+		},
+	}
+	req := structs.ServiceSpecificRequest{
+		Datacenter:   "dc1",
+		ServiceName:  "web",
+		QueryOptions: structs.QueryOptions{UseCache: true, MaxQueryTime: time.Second},
+	}
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEndOfSnapshotEvent(5))
+
+	result, _, err := c.ServiceNodes(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+	require.Equal(t, uint64(0), (<-subscribed).Index)
+
+	// The subscription is replaced once its lifetime expires, and the new
+	// subscription resumes from the index of the view.
+	select {
+	case rotated := <-subscribed:
+		require.Equal(t, uint64(5), rotated.Index)
+	case <-time.After(time.Second):
+		t.Fatalf("expected the subscription to be replaced")
+	}
+
+	client.QueueEvents(newEventServiceHealthRegister(10, 2, "web"))
+
+	req.QueryOptions.MinQueryIndex = result.Index
+	result, _, err = c.ServiceNodes(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), result.Index)
+	require.Len(t, result.Nodes, 2, "expected the view to be preserved")
+}
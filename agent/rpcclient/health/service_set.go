@@ -0,0 +1,383 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/mitchellh/hashstructure"
+
+	"github.com/hashicorp/consul/acl"
+	"github.com/hashicorp/consul/agent/cache"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+var (
+	errServiceSetRequiresStreaming = errors.New("service set results require the streaming backend")
+	errServiceSetEmpty             = errors.New("a service set requires at least one service name")
+	errServiceSetConnect           = errors.New("service set results do not support Connect requests")
+)
+
+// IndexedServiceSetNodes is the result of ServiceSetNodes.
+type IndexedServiceSetNodes struct {
+	// Services contains the nodes of each service, keyed by the name of the
+	// service. The Index of each service is the index of the last event which
+	// changed its nodes, so that a caller can tell which services changed.
+	Services map[string]structs.IndexedCheckServiceNodes
+
+	structs.QueryMeta
+}
+
+// ServiceSetNodes returns the nodes of each of services. The services are
+// materialized from a single subscription to the events of every service,
+// instead of one subscription for each service. The other fields of req,
+// except ServiceName, apply to each service. It is only supported by the
+// streaming backend, and returns an error when the request would be served by
+// another backend.
+func (c *Client) ServiceSetNodes(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+	services []string,
+) (IndexedServiceSetNodes, cache.ResultMeta, error) {
+	sr, err := c.newServiceSetRequest(req, services)
+	if err != nil {
+		return IndexedServiceSetNodes{}, cache.ResultMeta{}, err
+	}
+	result, err := c.ViewStore.Get(ctx, sr)
+	if err != nil {
+		return IndexedServiceSetNodes{}, cache.ResultMeta{}, err
+	}
+	meta := resultMeta(result)
+	return *result.Value.(*IndexedServiceSetNodes), meta, nil
+}
+
+// NotifyServiceSet is the same as ServiceSetNodes, but sends the results to ch
+// as they change, the same as Notify.
+func (c *Client) NotifyServiceSet(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+	services []string,
+	correlationID string,
+	ch chan<- cache.UpdateEvent,
+) error {
+	sr, err := c.newServiceSetRequest(req, services)
+	if err != nil {
+		return err
+	}
+	return c.ViewStore.Notify(ctx, sr, correlationID, ch)
+}
+
+// AllServiceNodes returns the nodes of every service, the same as
+// ServiceSetNodes for a set which contains every service. A service is
+// included in the result while it has at least one instance, so services
+// appear and disappear from the result as their first instance is registered
+// and their last instance is deregistered. It is only supported by the
+// streaming backend, and returns an error when the request would be served by
+// another backend.
+func (c *Client) AllServiceNodes(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+) (IndexedServiceSetNodes, cache.ResultMeta, error) {
+	sr, err := c.newAllServicesRequest(req)
+	if err != nil {
+		return IndexedServiceSetNodes{}, cache.ResultMeta{}, err
+	}
+	result, err := c.ViewStore.Get(ctx, sr)
+	if err != nil {
+		return IndexedServiceSetNodes{}, cache.ResultMeta{}, err
+	}
+	meta := resultMeta(result)
+	return *result.Value.(*IndexedServiceSetNodes), meta, nil
+}
+
+// NotifyAllServices is the same as AllServiceNodes, but sends the results to
+// ch as they change, the same as Notify.
+func (c *Client) NotifyAllServices(
+	ctx context.Context,
+	req structs.ServiceSpecificRequest,
+	correlationID string,
+	ch chan<- cache.UpdateEvent,
+) error {
+	sr, err := c.newAllServicesRequest(req)
+	if err != nil {
+		return err
+	}
+	return c.ViewStore.Notify(ctx, sr, correlationID, ch)
+}
+
+func (c *Client) newServiceSetRequest(req structs.ServiceSpecificRequest, services []string) (serviceSetRequest, error) {
+	if len(services) == 0 {
+		return serviceSetRequest{}, errServiceSetEmpty
+	}
+	sorted := make([]string, len(services))
+	copy(sorted, services)
+	sort.Strings(sorted)
+	return c.newServiceSetRequestForServices(req, sorted)
+}
+
+// newAllServicesRequest returns a serviceSetRequest for every service.
+func (c *Client) newAllServicesRequest(req structs.ServiceSpecificRequest) (serviceSetRequest, error) {
+	return c.newServiceSetRequestForServices(req, nil)
+}
+
+func (c *Client) newServiceSetRequestForServices(req structs.ServiceSpecificRequest, services []string) (serviceSetRequest, error) {
+	switch {
+	case req.Connect:
+		return serviceSetRequest{}, errServiceSetConnect
+	case !c.useStreaming(req):
+		return serviceSetRequest{}, errServiceSetRequiresStreaming
+	}
+	if err := req.ViewOptions.HealthAggregation.Validate(); err != nil {
+		return serviceSetRequest{}, err
+	}
+	c.QueryOptionDefaults(&req.QueryOptions)
+	req.ServiceName = ""
+	req.ViewOptions.Delta = false
+	req.ViewOptions.IncludeProto = false
+	req.ViewOptions.IDsOnly = false
+
+	return serviceSetRequest{
+		ServiceSpecificRequest: req,
+		services:               services,
+		deps:                   c.MaterializerDeps,
+	}, nil
+}
+
+// serviceSetRequest is a request for the nodes of a set of services, which are
+// materialized from a subscription with the wildcard key.
+type serviceSetRequest struct {
+	structs.ServiceSpecificRequest
+	// services are the sorted names of the services in the set, or nil for
+	// every service.
+	services []string
+	deps     MaterializerDeps
+}
+
+func (r serviceSetRequest) CacheInfo() cache.RequestInfo {
+	info := r.ServiceSpecificRequest.CacheInfo()
+	v, err := hashstructure.Hash([]interface{}{info.Key, r.services}, nil)
+	if err == nil {
+		info.Key = strconv.FormatUint(v, 10)
+	}
+	return info
+}
+
+func (r serviceSetRequest) Type() string {
+	return "agent.rpcclient.health.serviceSetRequest"
+}
+
+func (r serviceSetRequest) NewMaterializer() (*submatview.Materializer, error) {
+	view := newServiceSetView(r.ServiceSpecificRequest, r.services, func(req structs.ServiceSpecificRequest) (*healthView, error) {
+		hv, err := newHealthView(req)
+		if err != nil {
+			return nil, err
+		}
+		hv.concurrency = r.deps.SnapshotConcurrency
+		hv.disableSort = r.deps.DisableSort
+		hv.onEvent = r.deps.OnEvent
+		hv.maxInstances = r.deps.MaxInstances
+		return hv, nil
+	})
+	// Validate the request once, so that an invalid filter is returned to the
+	// caller instead of failing the subscription.
+	if _, err := view.newView(r.ServiceSpecificRequest); err != nil {
+		return nil, err
+	}
+
+	req := r.ServiceSpecificRequest
+	req.ServiceName = structs.WildcardSpecifier
+	return submatview.NewMaterializer(submatview.Deps{
+		View:                    view,
+		Client:                  r.deps.client(),
+		Logger:                  r.deps.Logger,
+		Request:                 newMaterializerRequest(req),
+		EventBufferSize:         r.deps.EventBufferSize,
+		SnapshotTimeout:         r.deps.SnapshotTimeout,
+		SnapshotTimeoutFraction: r.deps.snapshotTimeoutFraction(),
+		CallOptions:             r.deps.callOptions(),
+		MaxSubscriptionLifetime: r.deps.MaxSubscriptionLifetime,
+		BackoffResetPeriod:      r.deps.BackoffResetPeriod,
+		StatusActions:           r.deps.StatusActions,
+		RequireLeader:           r.ViewOptions.RequireLeader,
+		ConsumerLagThreshold:    r.deps.ConsumerLagThreshold,
+		OnConsumerLag:           r.deps.OnConsumerLag,
+	}), nil
+}
+
+// serviceSetView implements submatview.View for the nodes of a set of
+// services, materialized from the events of every service. The nodes of each
+// service are stored in their own healthView, so that the filter and the view
+// options of the request are applied the same way as for a single service.
+type serviceSetView struct {
+	// req is the request for each service, without the ServiceName.
+	req structs.ServiceSpecificRequest
+	// services is the set of names of the services in the view. The events
+	// of other services are ignored. A nil set contains every service.
+	services map[string]struct{}
+	// newView returns the healthView for the request of a service.
+	newView func(req structs.ServiceSpecificRequest) (*healthView, error)
+
+	knownLeader bool
+	// views contains the view of each service which has nodes.
+	views map[string]*healthView
+	// indexes contains the index of the last event applied to the view of each
+	// service.
+	indexes map[string]uint64
+}
+
+func newServiceSetView(
+	req structs.ServiceSpecificRequest,
+	services []string,
+	newView func(req structs.ServiceSpecificRequest) (*healthView, error),
+) *serviceSetView {
+	s := &serviceSetView{
+		req:     req,
+		newView: newView,
+		views:   make(map[string]*healthView),
+		indexes: make(map[string]uint64),
+	}
+	if services != nil {
+		s.services = make(map[string]struct{}, len(services))
+		for _, name := range services {
+			s.services[name] = struct{}{}
+		}
+	}
+	return s
+}
+
+// Update implements View. The events are applied to the view of their service
+// in the order they were received.
+func (s *serviceSetView) Update(events []*pbsubscribe.Event) error {
+	var order []string
+	grouped := make(map[string][]*pbsubscribe.Event)
+	for _, event := range events {
+		name, ok := s.serviceName(event)
+		if !ok {
+			continue
+		}
+		if _, exists := grouped[name]; !exists {
+			order = append(order, name)
+		}
+		grouped[name] = append(grouped[name], event)
+	}
+
+	for _, name := range order {
+		view, ok := s.views[name]
+		if !ok {
+			req := s.req
+			req.ServiceName = name
+			var err error
+			view, err = s.newView(req)
+			if err != nil {
+				return err
+			}
+			// Only the first update of the set is a snapshot.
+			view.knownLeader = s.knownLeader
+			s.views[name] = view
+		}
+
+		serviceEvents := grouped[name]
+		if err := view.Update(serviceEvents); err != nil {
+			return err
+		}
+		s.indexes[name] = serviceEvents[len(serviceEvents)-1].Index
+		if len(view.state) == 0 {
+			delete(s.views, name)
+			// A service which is not in the result does not need its index.
+			if s.services == nil {
+				delete(s.indexes, name)
+			}
+		}
+	}
+	s.knownLeader = true
+	return nil
+}
+
+// serviceName returns the name of the service of the event, or false if the
+// event is not for one of the services of the view.
+func (s *serviceSetView) serviceName(event *pbsubscribe.Event) (string, bool) {
+	svc := event.GetServiceHealth().GetCheckServiceNode().GetService()
+	if svc == nil {
+		return "", false
+	}
+	if _, ok := s.services[svc.Service]; s.services != nil && !ok {
+		return "", false
+	}
+
+	// The wildcard subscription receives the events of every partition and
+	// namespace which can be read with the token.
+	meta := svc.GetEnterpriseMeta()
+	entMeta := acl.NewEnterpriseMetaWithPartition(meta.GetPartition(), meta.GetNamespace())
+	if entMeta.PartitionOrDefault() != s.req.EnterpriseMeta.PartitionOrDefault() ||
+		entMeta.NamespaceOrDefault() != s.req.EnterpriseMeta.NamespaceOrDefault() {
+		return "", false
+	}
+	return svc.Service, true
+}
+
+// Result returns the IndexedServiceSetNodes stored by the view. Every service
+// of the set is included in the result, with no nodes if it has no instances.
+// When the view contains every service, only the services with instances are
+// included.
+func (s *serviceSetView) Result(index uint64) interface{} {
+	services := s.services
+	if services == nil {
+		services = make(map[string]struct{}, len(s.views))
+		for name := range s.views {
+			services[name] = struct{}{}
+		}
+	}
+
+	result := &IndexedServiceSetNodes{
+		Services: make(map[string]structs.IndexedCheckServiceNodes, len(services)),
+		QueryMeta: structs.QueryMeta{
+			Index:       index,
+			Backend:     structs.QueryBackendStreaming,
+			KnownLeader: s.knownLeader,
+		},
+	}
+	for name := range services {
+		serviceIndex, ok := s.indexes[name]
+		if !ok {
+			serviceIndex = index
+		}
+
+		view, ok := s.views[name]
+		if !ok {
+			result.Services[name] = structs.IndexedCheckServiceNodes{
+				Nodes: structs.CheckServiceNodes{},
+				QueryMeta: structs.QueryMeta{
+					Index:       serviceIndex,
+					Backend:     structs.QueryBackendStreaming,
+					KnownLeader: s.knownLeader,
+				},
+			}
+			continue
+		}
+		result.Services[name] = *view.Result(serviceIndex).(*structs.IndexedCheckServiceNodes)
+	}
+	return result
+}
+
+// ResultHash implements submatview.HashedView. It combines the hashes of the
+// views of the services, which already include the name of the service of each
+// instance.
+func (s *serviceSetView) ResultHash() uint64 {
+	var hash uint64
+	for _, view := range s.views {
+		hash ^= view.ResultHash()
+	}
+	return hash
+}
+
+func (s *serviceSetView) Reset() {
+	for _, view := range s.views {
+		view.Reset()
+	}
+	s.knownLeader = false
+	s.views = make(map[string]*healthView)
+	s.indexes = make(map[string]uint64)
+}
@@ -0,0 +1,284 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/agent/config"
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func TestClient_ServiceSetNodes_IntegrationWithStore(t *testing.T) {
+	client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+		if req.Key != structs.WildcardSpecifier || req.Topic != pbsubscribe.Topic_ServiceHealth {
+			return fmt.Errorf("unexpected subscription to %v %q", req.Topic, req.Key)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{
+		ViewStore:           submatview.NewStore(hclog.New(nil)),
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		MaterializerDeps: MaterializerDeps{
+			Client: client,
+			Logger: hclog.New(nil),
+		},
+	}
+	services := []string{"web", "db", "api"}
+	req := structs.ServiceSpecificRequest{
+		Datacenter:   "dc1",
+		QueryOptions: structs.QueryOptions{MaxQueryTime: time.Second},
+	}
+
+	// nodeNames returns the names of the nodes of each service, and the index
+	// of each service.
+	nodeNames := func(result IndexedServiceSetNodes) (map[string][]string, map[string]uint64) {
+		names := make(map[string][]string)
+		indexes := make(map[string]uint64)
+		for service, nodes := range result.Services {
+			names[service] = []string{}
+			for _, csn := range nodes.Nodes {
+				names[service] = append(names[service], csn.Node.Node)
+			}
+			indexes[service] = nodes.Index
+		}
+		return names, indexes
+	}
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "db"),
+		newEventServiceHealthRegister(5, 3, "api"),
+		newEventServiceHealthRegister(5, 4, "cache"),
+		newEndOfSnapshotEvent(5))
+
+	runStep(t, "snapshot of every service in the set", func(t *testing.T) {
+		result, _, err := c.ServiceSetNodes(ctx, req, services)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1"},
+			"db":  {"node2"},
+			"api": {"node3"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 5, "db": 5, "api": 5}, indexes)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "a new instance only changes its service", func(t *testing.T) {
+		client.QueueEvents(newEventServiceHealthRegister(10, 5, "web"))
+
+		result, _, err := c.ServiceSetNodes(ctx, req, services)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1", "node5"},
+			"db":  {"node2"},
+			"api": {"node3"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 10, "db": 5, "api": 5}, indexes)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "a service without instances has no nodes", func(t *testing.T) {
+		client.QueueEvents(newEventServiceHealthDeregister(20, 2, "db"))
+
+		result, _, err := c.ServiceSetNodes(ctx, req, services)
+		require.NoError(t, err)
+		require.Equal(t, uint64(20), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1", "node5"},
+			"db":  {},
+			"api": {"node3"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 10, "db": 20, "api": 5}, indexes)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "events for services outside the set are ignored", func(t *testing.T) {
+		client.QueueEvents(newEventBatchWithEvents(
+			newEventServiceHealthRegister(30, 6, "cache"),
+			newEventServiceHealthRegister(30, 7, "api")))
+
+		result, _, err := c.ServiceSetNodes(ctx, req, services)
+		require.NoError(t, err)
+		require.Equal(t, uint64(30), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1", "node5"},
+			"db":  {},
+			"api": {"node3", "node7"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 10, "db": 20, "api": 30}, indexes)
+	})
+}
+
+func TestClient_ServiceSetNodes_InvalidRequest(t *testing.T) {
+	c := &Client{
+		ViewStore:           &fakeViewStore{},
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+	}
+
+	_, _, err := c.ServiceSetNodes(context.Background(), structs.ServiceSpecificRequest{}, nil)
+	require.Equal(t, errServiceSetEmpty, err)
+
+	req := structs.ServiceSpecificRequest{Connect: true}
+	_, _, err = c.ServiceSetNodes(context.Background(), req, []string{"web"})
+	require.Equal(t, errServiceSetConnect, err)
+
+	c.UseStreamingBackend = false
+	_, _, err = c.ServiceSetNodes(context.Background(), structs.ServiceSpecificRequest{}, []string{"web"})
+	require.Equal(t, errServiceSetRequiresStreaming, err)
+}
+
+func TestClient_AllServiceNodes_IntegrationWithStore(t *testing.T) {
+	client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+		if req.Key != structs.WildcardSpecifier || req.Topic != pbsubscribe.Topic_ServiceHealth {
+			return fmt.Errorf("unexpected subscription to %v %q", req.Topic, req.Key)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{
+		ViewStore:           submatview.NewStore(hclog.New(nil)),
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+		MaterializerDeps: MaterializerDeps{
+			Client: client,
+			Logger: hclog.New(nil),
+		},
+	}
+	req := structs.ServiceSpecificRequest{
+		Datacenter:   "dc1",
+		QueryOptions: structs.QueryOptions{MaxQueryTime: time.Second},
+	}
+
+	// nodeNames returns the names of the nodes of each service, and the index
+	// of each service.
+	nodeNames := func(result IndexedServiceSetNodes) (map[string][]string, map[string]uint64) {
+		names := make(map[string][]string)
+		indexes := make(map[string]uint64)
+		for service, nodes := range result.Services {
+			names[service] = []string{}
+			for _, csn := range nodes.Nodes {
+				names[service] = append(names[service], csn.Node.Node)
+			}
+			indexes[service] = nodes.Index
+		}
+		return names, indexes
+	}
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "db"),
+		newEndOfSnapshotEvent(5))
+
+	runStep(t, "snapshot of every service", func(t *testing.T) {
+		result, _, err := c.AllServiceNodes(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1"},
+			"db":  {"node2"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 5, "db": 5}, indexes)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "a new service appears", func(t *testing.T) {
+		client.QueueEvents(newEventServiceHealthRegister(10, 3, "api"))
+
+		result, _, err := c.AllServiceNodes(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1"},
+			"db":  {"node2"},
+			"api": {"node3"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 5, "db": 5, "api": 10}, indexes)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "a service without instances is removed", func(t *testing.T) {
+		client.QueueEvents(newEventServiceHealthDeregister(20, 2, "db"))
+
+		result, _, err := c.AllServiceNodes(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(20), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1"},
+			"api": {"node3"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 5, "api": 10}, indexes)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "a removed service appears again", func(t *testing.T) {
+		client.QueueEvents(newEventServiceHealthRegister(30, 4, "db"))
+
+		result, _, err := c.AllServiceNodes(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(30), result.Index)
+
+		names, indexes := nodeNames(result)
+		require.Equal(t, map[string][]string{
+			"web": {"node1"},
+			"db":  {"node4"},
+			"api": {"node3"},
+		}, names)
+		require.Equal(t, map[string]uint64{"web": 5, "db": 30, "api": 10}, indexes)
+	})
+}
+
+func TestClient_AllServiceNodes_InvalidRequest(t *testing.T) {
+	c := &Client{
+		ViewStore:           &fakeViewStore{},
+		UseStreamingBackend: true,
+		QueryOptionDefaults: config.ApplyDefaultQueryOptions(&config.RuntimeConfig{}),
+	}
+
+	_, _, err := c.AllServiceNodes(context.Background(), structs.ServiceSpecificRequest{Connect: true})
+	require.Equal(t, errServiceSetConnect, err)
+
+	c.UseStreamingBackend = false
+	_, _, err = c.AllServiceNodes(context.Background(), structs.ServiceSpecificRequest{})
+	require.Equal(t, errServiceSetRequiresStreaming, err)
+
+	err = c.NotifyAllServices(context.Background(), structs.ServiceSpecificRequest{}, "id", nil)
+	require.Equal(t, errServiceSetRequiresStreaming, err)
+}
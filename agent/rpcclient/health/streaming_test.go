@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/hashicorp/consul/proto/pbsubscribe"
 )
@@ -46,6 +47,12 @@ func (t *streamClient) Subscribe(
 	return t, nil
 }
 
+// Header returns empty metadata, because the mock does not represent a
+// particular server.
+func (t *streamClient) Header() (metadata.MD, error) {
+	return metadata.MD{}, nil
+}
+
 func (t *streamClient) QueueEvents(events ...*pbsubscribe.Event) {
 	for _, e := range events {
 		t.events <- eventOrErr{Event: e}
@@ -1,30 +1,234 @@
 package health
 
 import (
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/prometheus"
 	"github.com/hashicorp/go-bexpr"
 	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/hashstructure"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"github.com/hashicorp/consul/agent/grpc/private"
 	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/proto/pbservice"
 	"github.com/hashicorp/consul/proto/pbsubscribe"
+	"github.com/hashicorp/consul/types"
 )
 
+var Counters = []prometheus.CounterDefinition{
+	{
+		Name: []string{"rpcclient", "health", "truncated"},
+		Help: "Counts the number of service instances excluded from streaming health views because the view reached MaxInstances.",
+	},
+}
+
+var Summaries = []prometheus.SummaryDefinition{
+	{
+		Name: []string{"rpcclient", "health", "snapshot", "instances"},
+		Help: "Measures the number of service instances in each snapshot applied to a streaming health view.",
+	},
+	{
+		Name: []string{"rpcclient", "health", "snapshot", "apply"},
+		Help: "Measures the time it takes to apply a snapshot to a streaming health view and sort its service instances.",
+	},
+}
+
 type MaterializerDeps struct {
 	Conn   *grpc.ClientConn
 	Logger hclog.Logger
+
+	// Client is used by materializers to subscribe to events. It may be a
+	// submatview.SharedStreamClient, so that materializers which subscribe to
+	// the same events share a single stream. If Client is nil, a client for
+	// ConnPool is used, or a client for Conn if ConnPool is nil.
+	Client submatview.StreamClient
+
+	// ConnPool provides the connection used to open each subscription, for
+	// the servers of Datacenter. See NewPoolStreamClient.
+	ConnPool ClientConnPool
+
+	// Datacenter is the datacenter of the connections from ConnPool. It is the
+	// local datacenter of the agent, because subscriptions for other
+	// datacenters are forwarded by the local servers.
+	Datacenter string
+
+	// EventBufferSize is passed to submatview.Deps.EventBufferSize.
+	EventBufferSize int
+
+	// SnapshotTimeout is passed to submatview.Deps.SnapshotTimeout.
+	SnapshotTimeout time.Duration
+
+	// SnapshotTimeoutFraction is passed to
+	// submatview.Deps.SnapshotTimeoutFraction. If both SnapshotTimeout and
+	// SnapshotTimeoutFraction are 0, requests wait for the snapshot for half of
+	// their timeout.
+	SnapshotTimeoutFraction float64
+
+	// MaxRecvMsgSize is the maximum size in bytes of an event received by the
+	// subscription. Snapshots of very large services may exceed the gRPC
+	// default of 4MB. Raising the limit allows those snapshots to be received,
+	// at the cost of allowing a single event to allocate more memory on the
+	// agent. If MaxRecvMsgSize is 0, the limit of the connection is used.
+	MaxRecvMsgSize int
+
+	// MaxSubscriptionLifetime is passed to
+	// submatview.Deps.MaxSubscriptionLifetime.
+	MaxSubscriptionLifetime time.Duration
+
+	// BackoffResetPeriod is passed to submatview.Deps.BackoffResetPeriod.
+	BackoffResetPeriod time.Duration
+
+	// CompressionLevel compresses the events of subscriptions with gzip at the
+	// level, from 1 (best speed) to 9 (best compression). See
+	// private.UseCompressionLevel. If CompressionLevel is 0, the events are not
+	// compressed.
+	CompressionLevel int
+
+	// SnapshotConcurrency is the maximum number of goroutines used to convert
+	// and filter the instances of a large batch of events, such as the
+	// snapshot received when a subscription is started. The instances are
+	// still applied to the view in the order of the events, so the result is
+	// the same as when the events are processed serially. If
+	// SnapshotConcurrency is 0 or 1, events are processed serially.
+	SnapshotConcurrency int
+
+	// DisableSort returns the nodes of every result without sorting them, the
+	// same as setting ServiceViewOptions.SkipSort on every request. It may be
+	// used when the results are always consumed by callers which impose their
+	// own order. Requests with ServiceViewOptions.SortByHealth set are still
+	// sorted.
+	DisableSort bool
+
+	// OnEvent is called for each change applied to the view of every
+	// materializer, in the order the events are applied, and before the result
+	// is sorted. It may be used to maintain custom indices of the nodes, for
+	// example by availability zone. op is CatalogOp_Register when a node is
+	// added or updated, and CatalogOp_Deregister when a node is removed,
+	// including when the view is reset before a new snapshot. Instances which
+	// are excluded by the filter of the request are not reported.
+	//
+	// OnEvent is called while the view is locked, so it must return quickly
+	// and must not block or call back into the materializer. Any slow work
+	// must be handed off to another goroutine. csn is shared with the view and
+	// must not be modified.
+	OnEvent EventHook
+
+	// MaxInstances is the maximum number of service instances stored by the
+	// view of each materializer, to bound the memory used by a service with a
+	// very large number of instances. Once a view stores MaxInstances
+	// instances, new instances are excluded from the view until it is reset,
+	// and the results of the view have Truncated set. Instances already in
+	// the view are still updated and removed. If MaxInstances is 0, the
+	// number of instances is not limited.
+	MaxInstances int
+
+	// StatusActions is passed to submatview.Deps.StatusActions.
+	StatusActions map[codes.Code]submatview.StatusAction
+
+	// ConsumerLagThreshold is passed to submatview.Deps.ConsumerLagThreshold.
+	ConsumerLagThreshold time.Duration
+
+	// OnConsumerLag is passed to submatview.Deps.OnConsumerLag.
+	OnConsumerLag func(lag submatview.ConsumerLag)
+}
+
+// EventHook is the type of MaterializerDeps.OnEvent.
+type EventHook func(op pbsubscribe.CatalogOp, csn structs.CheckServiceNode)
+
+// defaultSnapshotTimeoutFraction is the SnapshotTimeoutFraction used when
+// neither SnapshotTimeout nor SnapshotTimeoutFraction are set.
+const defaultSnapshotTimeoutFraction = 0.5
+
+// snapshotTimeoutFraction returns the SnapshotTimeoutFraction for the
+// materializer.
+func (d MaterializerDeps) snapshotTimeoutFraction() float64 {
+	if d.SnapshotTimeout == 0 && d.SnapshotTimeoutFraction == 0 {
+		return defaultSnapshotTimeoutFraction
+	}
+	return d.SnapshotTimeoutFraction
+}
+
+// client returns the StreamClient used by materializers.
+func (d MaterializerDeps) client() submatview.StreamClient {
+	switch {
+	case d.Client != nil:
+		return d.Client
+	case d.ConnPool != nil:
+		return NewPoolStreamClient(d.ConnPool, d.Datacenter)
+	}
+	return pbsubscribe.NewStateChangeSubscriptionClient(d.Conn)
+}
+
+// ClientConnPool provides gRPC connections to the servers of a datacenter. It
+// is implemented by the shared connection pool of the agent.
+type ClientConnPool interface {
+	ClientConn(datacenter string) (*grpc.ClientConn, error)
+}
+
+// NewPoolStreamClient returns a submatview.StreamClient which opens each
+// subscription on the connection returned by pool when the subscription is
+// started. Subscriptions use the TLS, keepalive, and server rebalancing of the
+// pooled connection, the same as the other gRPC clients of the agent.
+func NewPoolStreamClient(pool ClientConnPool, datacenter string) submatview.StreamClient {
+	return poolStreamClient{pool: pool, datacenter: datacenter}
+}
+
+type poolStreamClient struct {
+	pool       ClientConnPool
+	datacenter string
+}
+
+func (c poolStreamClient) Subscribe(
+	ctx context.Context,
+	in *pbsubscribe.SubscribeRequest,
+	opts ...grpc.CallOption,
+) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	conn, err := c.pool.ClientConn(c.datacenter)
+	if err != nil {
+		// The subscription is retried, the same as when the connection is
+		// unavailable.
+		return nil, status.Errorf(codes.Unavailable, "failed to get a connection to the servers: %v", err)
+	}
+	return pbsubscribe.NewStateChangeSubscriptionClient(conn).Subscribe(ctx, in, opts...)
+}
+
+// callOptions returns the grpc.CallOptions for the subscription. An invalid
+// CompressionLevel is logged and ignored.
+func (d MaterializerDeps) callOptions() []grpc.CallOption {
+	var opts []grpc.CallOption
+	if d.MaxRecvMsgSize != 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(d.MaxRecvMsgSize))
+	}
+	if d.CompressionLevel != 0 {
+		opt, err := private.UseCompressionLevel(d.CompressionLevel)
+		if err != nil {
+			d.Logger.Warn("ignoring compression for subscriptions", "error", err)
+		} else {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
 }
 
 func newMaterializerRequest(srvReq structs.ServiceSpecificRequest) func(index uint64) *pbsubscribe.SubscribeRequest {
+	topic := serviceHealthTopic(srvReq)
 	return func(index uint64) *pbsubscribe.SubscribeRequest {
-		req := &pbsubscribe.SubscribeRequest{
-			Topic:      pbsubscribe.Topic_ServiceHealth,
+		return &pbsubscribe.SubscribeRequest{
+			Topic:      topic,
 			Key:        srvReq.ServiceName,
 			Token:      srvReq.Token,
 			Datacenter: srvReq.Datacenter,
@@ -32,66 +236,414 @@ func newMaterializerRequest(srvReq structs.ServiceSpecificRequest) func(index ui
 			Namespace:  srvReq.EnterpriseMeta.NamespaceOrEmpty(),
 			Partition:  srvReq.EnterpriseMeta.PartitionOrEmpty(),
 		}
-		if srvReq.Connect {
-			req.Topic = pbsubscribe.Topic_ServiceHealthConnect
-		}
-		return req
 	}
 }
 
+// topicForRequest returns the topic of the events which are used to
+// materialize the result of req. It returns Topic_Unknown for requests which
+// are not materialized by this package.
+func topicForRequest(req submatview.Request) pbsubscribe.Topic {
+	switch r := req.(type) {
+	case serviceRequest:
+		return serviceHealthTopic(r.ServiceSpecificRequest)
+	case serviceSetRequest:
+		return serviceHealthTopic(r.ServiceSpecificRequest)
+	case gatewayServicesRequest:
+		return pbsubscribe.Topic_GatewayServices
+	case configEntryRequest:
+		return pbsubscribe.Topic_ConfigEntries
+	default:
+		return pbsubscribe.Topic_Unknown
+	}
+}
+
+// serviceHealthTopic returns the topic of the events for the nodes of the
+// service of req.
+func serviceHealthTopic(req structs.ServiceSpecificRequest) pbsubscribe.Topic {
+	if req.Connect {
+		return pbsubscribe.Topic_ServiceHealthConnect
+	}
+	return pbsubscribe.Topic_ServiceHealth
+}
+
+func init() {
+	submatview.RegisterEventDecoder(pbsubscribe.Topic_ServiceHealth, decodeServiceHealth)
+	submatview.RegisterEventDecoder(pbsubscribe.Topic_ServiceHealthConnect, decodeServiceHealth)
+}
+
+// decodeServiceHealth is the submatview.EventDecoder of the service health
+// topics. It returns the *pbsubscribe.ServiceHealthUpdate of the event.
+func decodeServiceHealth(event *pbsubscribe.Event) (interface{}, error) {
+	serviceHealth := event.GetServiceHealth()
+	if serviceHealth == nil {
+		return nil, fmt.Errorf("unexpected event type for service health view: %T",
+			event.GetPayload())
+	}
+	return serviceHealth, nil
+}
+
 func newHealthView(req structs.ServiceSpecificRequest) (*healthView, error) {
+	if err := req.ViewOptions.HealthAggregation.Validate(); err != nil {
+		return nil, err
+	}
 	fe, err := newFilterEvaluator(req)
 	if err != nil {
 		return nil, err
 	}
 	return &healthView{
-		state:  make(map[string]structs.CheckServiceNode),
-		filter: fe,
+		topic:     serviceHealthTopic(req),
+		state:     make(map[string]structs.CheckServiceNode),
+		protos:    make(map[string]*pbservice.CheckServiceNode),
+		arrival:   newArrivalIndex(),
+		skipped:   make(map[string]struct{}),
+		truncated: make(map[string]struct{}),
+		hashes:    make(map[string]uint64),
+		filter:    fe,
+		options:   req.ViewOptions,
+		changes:   newChangeLog(),
 	}, nil
 }
 
+// minConcurrentEvents is the smallest number of events in a batch that will be
+// evaluated concurrently. Smaller batches are not worth the cost of starting
+// the workers.
+const minConcurrentEvents = 128
+
 // healthView implements submatview.View for storing the view state
 // of a service health result. We store it as a map to make updates and
 // deletions a little easier but we could just store a result type
 // (IndexedCheckServiceNodes) and update it in place for each event - that
 // involves re-sorting each time etc. though.
 type healthView struct {
-	state       map[string]structs.CheckServiceNode
+	// topic is the topic of the events applied to the view. They are decoded
+	// with the decoder registered for the topic.
+	topic pbsubscribe.Topic
+	state map[string]structs.CheckServiceNode
+	// filter is compiled once, when the view is created, and is used to
+	// evaluate every event for the lifetime of the view, including after Reset.
 	filter      filterEvaluator
 	knownLeader bool
+	options     structs.ServiceViewOptions
+
+	// protos contains the protobuf form of each node in state, when
+	// options.IncludeProto is set.
+	protos map[string]*pbservice.CheckServiceNode
+
+	// arrival contains the IDs of the nodes in state in the order they were
+	// added, when options.ArrivalOrder is set.
+	arrival *arrivalIndex
+
+	// skipped contains the IDs of the instances which could not be processed
+	// when options.AllowPartial is set.
+	skipped map[string]struct{}
+
+	// changes records the index of the changes to state when options.Delta is
+	// set.
+	changes *changeLog
+
+	// hash is the value returned by ResultHash. It is the XOR of hashes, so it
+	// does not depend on the order of the instances, and it is updated
+	// incrementally when an instance is added or removed, instead of hashing
+	// every instance after each update.
+	hash uint64
+
+	// hashes contains the hash of each instance of state which is included in
+	// hash. See instanceHash.
+	hashes map[string]uint64
+
+	// concurrency is the maximum number of goroutines used to evaluate a
+	// batch of events. See MaterializerDeps.SnapshotConcurrency.
+	concurrency int
+
+	// disableSort is set from MaterializerDeps.DisableSort.
+	disableSort bool
+
+	// onEvent is set from MaterializerDeps.OnEvent.
+	onEvent EventHook
+
+	// maxInstances is set from MaterializerDeps.MaxInstances.
+	maxInstances int
+
+	// truncated contains the IDs of the instances which were excluded from
+	// state because it already contained maxInstances instances.
+	truncated map[string]struct{}
 }
 
 // Update implements View
 func (s *healthView) Update(events []*pbsubscribe.Event) error {
-	s.knownLeader = true
-	for _, event := range events {
-		serviceHealth := event.GetServiceHealth()
-		if serviceHealth == nil {
-			return fmt.Errorf("unexpected event type for service health view: %T",
-				event.GetPayload())
+	// The first update after the view is created or reset applies the
+	// snapshot.
+	snapshot := !s.knownLeader
+	start := time.Now()
+
+	updates := make([]*pbsubscribe.ServiceHealthUpdate, len(events))
+	for i, event := range events {
+		update, err := s.decode(event)
+		if err != nil {
+			return err
 		}
+		updates[i] = update
+	}
 
+	s.knownLeader = true
+	evaluated := s.evaluateConcurrently(updates)
+	truncated := 0
+	for i, event := range events {
+		serviceHealth := updates[i]
 		id := serviceHealth.CheckServiceNode.UniqueID()
+		delete(s.skipped, id)
+		delete(s.truncated, id)
 		switch serviceHealth.Op {
 		case pbsubscribe.CatalogOp_Register:
-			csn, err := pbservice.CheckServiceNodeToStructs(serviceHealth.CheckServiceNode)
-			if err != nil {
-				return err
-			}
-			if csn == nil {
-				return errors.New("check service node was unexpectedly nil")
+			var e evaluation
+			if evaluated != nil {
+				e = evaluated[i]
+			} else {
+				e.passed, e.csn, e.err = s.evaluate(serviceHealth.CheckServiceNode)
 			}
-			passed, err := s.filter.Evaluate(*csn)
-			if err != nil {
+			switch err := e.err; {
+			case err != nil && s.options.AllowPartial:
+				s.skipped[id] = struct{}{}
+				s.remove(id, event.Index)
+			case err != nil:
 				return err
-			} else if passed {
-				s.state[id] = *csn
-			} else {
-				delete(s.state, id)
+			case e.passed && s.full(id):
+				s.truncated[id] = struct{}{}
+				truncated++
+			case e.passed:
+				csn, pbcsn := *e.csn, serviceHealth.CheckServiceNode
+				if s.options.ServiceChecksOnly {
+					csn, pbcsn = withServiceChecksOnly(csn, pbcsn)
+				}
+				s.upsert(id, csn, event.Index)
+				if s.options.IncludeProto {
+					s.protos[id] = pbcsn
+				}
+			default:
+				s.remove(id, event.Index)
 			}
 
 		case pbsubscribe.CatalogOp_Deregister:
-			delete(s.state, id)
+			s.remove(id, event.Index)
+		}
+	}
+	if truncated > 0 {
+		metrics.IncrCounter([]string{"rpcclient", "health", "truncated"}, float32(truncated))
+	}
+	if snapshot {
+		metrics.AddSample([]string{"rpcclient", "health", "snapshot", "instances"}, float32(len(events)))
+		metrics.MeasureSince([]string{"rpcclient", "health", "snapshot", "apply"}, start)
+	}
+	return nil
+}
+
+// withServiceChecksOnly returns copies of csn and pbcsn without the checks of
+// the node. The checks are not modified.
+func withServiceChecksOnly(
+	csn structs.CheckServiceNode,
+	pbcsn *pbservice.CheckServiceNode,
+) (structs.CheckServiceNode, *pbservice.CheckServiceNode) {
+	csn.Checks = serviceChecks(csn.Checks)
+
+	var pbchecks []*pbservice.HealthCheck
+	for _, check := range pbcsn.Checks {
+		if check.ServiceID != "" {
+			pbchecks = append(pbchecks, check)
+		}
+	}
+	pbcsn = &pbservice.CheckServiceNode{
+		Node:    pbcsn.Node,
+		Service: pbcsn.Service,
+		Checks:  pbchecks,
+	}
+	return csn, pbcsn
+}
+
+// serviceChecks returns the checks of the service from checks, excluding the
+// checks of the node. checks is not modified.
+func serviceChecks(checks structs.HealthChecks) structs.HealthChecks {
+	var result structs.HealthChecks
+	for _, check := range checks {
+		if check.ServiceID != "" {
+			result = append(result, check)
+		}
+	}
+	return result
+}
+
+// decode decodes event with the decoder registered for the topic of the view.
+func (s *healthView) decode(event *pbsubscribe.Event) (*pbsubscribe.ServiceHealthUpdate, error) {
+	value, err := submatview.DecodeEvent(s.topic, event)
+	if err != nil {
+		return nil, err
+	}
+	update, ok := value.(*pbsubscribe.ServiceHealthUpdate)
+	if !ok {
+		return nil, fmt.Errorf("unexpected decoded event type for service health view: %T", value)
+	}
+	return update, nil
+}
+
+// full returns true if the instance with id can not be added to the view,
+// because the view already contains maxInstances other instances.
+func (s *healthView) full(id string) bool {
+	if s.maxInstances <= 0 || len(s.state) < s.maxInstances {
+		return false
+	}
+	_, exists := s.state[id]
+	return !exists
+}
+
+func (s *healthView) upsert(id string, csn structs.CheckServiceNode, index uint64) {
+	if s.options.Delta {
+		_, exists := s.state[id]
+		s.changes.upsert(id, exists, index)
+	}
+	s.state[id] = csn
+	s.updateHash(id, csn)
+	if s.options.ArrivalOrder {
+		s.arrival.add(id)
+	}
+	if s.onEvent != nil {
+		s.onEvent(pbsubscribe.CatalogOp_Register, csn)
+	}
+}
+
+func (s *healthView) remove(id string, index uint64) {
+	delete(s.protos, id)
+	csn, ok := s.state[id]
+	if !ok {
+		return
+	}
+	if s.options.Delta {
+		s.changes.remove(id, csn, index)
+	}
+	delete(s.state, id)
+	s.hash ^= s.hashes[id]
+	delete(s.hashes, id)
+	s.arrival.remove(id)
+	if s.onEvent != nil {
+		s.onEvent(pbsubscribe.CatalogOp_Deregister, csn)
+	}
+}
+
+// arrivalIndex orders the IDs of the instances of a view by the order in which
+// they were added, so that they can be returned without sorting them.
+type arrivalIndex struct {
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newArrivalIndex() *arrivalIndex {
+	return &arrivalIndex{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+// add appends id to the end of the order, unless it is already in the index.
+func (a *arrivalIndex) add(id string) {
+	if _, ok := a.elements[id]; ok {
+		return
+	}
+	a.elements[id] = a.order.PushBack(id)
+}
+
+func (a *arrivalIndex) remove(id string) {
+	if e, ok := a.elements[id]; ok {
+		a.order.Remove(e)
+		delete(a.elements, id)
+	}
+}
+
+// ids returns the IDs in the order they were added.
+func (a *arrivalIndex) ids() []string {
+	ids := make([]string, 0, a.order.Len())
+	for e := a.order.Front(); e != nil; e = e.Next() {
+		ids = append(ids, e.Value.(string))
+	}
+	return ids
+}
+
+// evaluate converts the CheckServiceNode from the event, and returns true if it
+// passes the filter. An error is returned if the CheckServiceNode is malformed
+// or can not be evaluated by the filter.
+func (s *healthView) evaluate(pbcsn *pbservice.CheckServiceNode) (bool, *structs.CheckServiceNode, error) {
+	csn, err := pbservice.CheckServiceNodeToStructs(pbcsn)
+	if err != nil {
+		return false, nil, err
+	}
+	if csn == nil {
+		return false, nil, errors.New("check service node was unexpectedly nil")
+	}
+	if err := validateCheckServiceNode(*csn); err != nil {
+		return false, nil, fmt.Errorf("invalid check service node %v: %w", pbcsn.UniqueID(), err)
+	}
+	passed, err := s.filter.Evaluate(*csn)
+	if err != nil {
+		return false, nil, err
+	}
+	return passed, csn, nil
+}
+
+// evaluation is the result of healthView.evaluate for a single event.
+type evaluation struct {
+	passed bool
+	csn    *structs.CheckServiceNode
+	err    error
+}
+
+// evaluateConcurrently evaluates the register updates in updates using up to
+// s.concurrency goroutines. The returned slice has the same length as updates,
+// and contains the result for the update at the same position. Returns nil if
+// the updates should be evaluated serially.
+func (s *healthView) evaluateConcurrently(updates []*pbsubscribe.ServiceHealthUpdate) []evaluation {
+	if s.concurrency <= 1 || len(updates) < minConcurrentEvents {
+		return nil
+	}
+
+	workers := s.concurrency
+	if workers > len(updates) {
+		workers = len(updates)
+	}
+
+	result := make([]evaluation, len(updates))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				serviceHealth := updates[i]
+				if serviceHealth.Op != pbsubscribe.CatalogOp_Register {
+					continue
+				}
+				e := &result[i]
+				e.passed, e.csn, e.err = s.evaluate(serviceHealth.CheckServiceNode)
+			}
+		}()
+	}
+	for i := range updates {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return result
+}
+
+// validateCheckServiceNode returns an error if csn is missing any of the
+// fields which are required to sort and filter the results.
+func validateCheckServiceNode(csn structs.CheckServiceNode) error {
+	switch {
+	case csn.Node == nil:
+		return errors.New("missing node")
+	case csn.Service == nil:
+		return errors.New("missing service")
+	}
+	for i, check := range csn.Checks {
+		if check == nil {
+			return fmt.Errorf("missing check at position %d", i)
 		}
 	}
 	return nil
@@ -106,11 +658,11 @@ func newFilterEvaluator(req structs.ServiceSpecificRequest) (filterEvaluator, er
 
 	typ := reflect.TypeOf(structs.CheckServiceNode{})
 	if req.Filter != "" {
-		e, err := bexpr.CreateEvaluatorForType(req.Filter, nil, typ)
+		f, err := structs.NewCheckServiceNodeFilter(req.Filter, req.ViewOptions.HealthAggregation)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid filter %q: %w", req.Filter, err)
 		}
-		evaluators = append(evaluators, e)
+		evaluators = append(evaluators, bexprEvaluator{filter: f})
 	}
 
 	if req.ServiceTag != "" {
@@ -122,6 +674,10 @@ func newFilterEvaluator(req structs.ServiceSpecificRequest) (filterEvaluator, er
 		evaluators = append(evaluators, serviceTagEvaluator{tags: req.ServiceTags})
 	}
 
+	if req.ViewOptions.OnlyPassing {
+		evaluators = append(evaluators, passingEvaluator{mode: req.ViewOptions.HealthAggregation})
+	}
+
 	for key, value := range req.NodeMetaFilters {
 		expr := fmt.Sprintf(`"%s" in Node.Meta.%s`, value, key)
 		e, err := bexpr.CreateEvaluatorForType(expr, nil, typ)
@@ -164,41 +720,273 @@ func (m multiFilterEvaluator) Evaluate(data interface{}) (bool, error) {
 }
 
 // sortCheckServiceNodes sorts the results to match memdb semantics
-// Sort results by Node.Node, if 2 instances match, order by Service.ID
+// Sort results by Node.Node, if 2 instances match, order by Service.ID, and
+// then by Node.ID so that the order is total.
 // Will allow result to be stable sorted and match queries without cache
-func sortCheckServiceNodes(serviceNodes *structs.IndexedCheckServiceNodes) {
+// If opts.SortByHealth is true the results are first grouped by health status,
+// and the order above is applied within each group.
+func sortCheckServiceNodes(serviceNodes *structs.IndexedCheckServiceNodes, opts structs.ServiceViewOptions) {
 	sort.SliceStable(serviceNodes.Nodes, func(i, j int) bool {
-		left := serviceNodes.Nodes[i]
-		right := serviceNodes.Nodes[j]
-		if left.Node.Node == right.Node.Node {
-			return left.Service.ID < right.Service.ID
+		return lessCheckServiceNode(serviceNodes.Nodes[i], serviceNodes.Nodes[j], opts)
+	})
+}
+
+// lessCheckServiceNode returns true if left is ordered before right by
+// sortCheckServiceNodes.
+func lessCheckServiceNode(left, right structs.CheckServiceNode, opts structs.ServiceViewOptions) bool {
+	if opts.SortByHealth {
+		mode := opts.HealthAggregation
+		if l, r := healthRank(left, mode), healthRank(right, mode); l != r {
+			return l < r
 		}
+	}
+	if left.Node.Node != right.Node.Node {
 		return left.Node.Node < right.Node.Node
-	})
+	}
+	if left.Service.ID != right.Service.ID {
+		return left.Service.ID < right.Service.ID
+	}
+	// Entries with the same node name and service ID are not expected, but
+	// are ordered by node ID so that the order does not depend on the order
+	// of the state map.
+	return left.Node.ID < right.Node.ID
+}
+
+// healthRank returns the position of the aggregated health status of the
+// node checks when ordered as passing, warning, critical.
+func healthRank(csn structs.CheckServiceNode, mode structs.HealthAggregation) int {
+	switch csn.AggregatedStatus(mode) {
+	case api.HealthPassing:
+		return 0
+	case api.HealthWarning:
+		return 1
+	default:
+		return 2
+	}
 }
 
-// Result returns the structs.IndexedCheckServiceNodes stored by this view.
+// Result returns the structs.IndexedCheckServiceNodes stored by this view. When
+// options.IncludeProto is set it returns an IndexedCheckServiceNodesWithProto,
+// and when options.IDsOnly is set it returns an IndexedServiceIDs.
 func (s *healthView) Result(index uint64) interface{} {
+	if s.options.IDsOnly {
+		return s.resultIDs(index)
+	}
+
 	result := structs.IndexedCheckServiceNodes{
-		Nodes: make(structs.CheckServiceNodes, 0, len(s.state)),
-		QueryMeta: structs.QueryMeta{
-			Index:       index,
-			Backend:     structs.QueryBackendStreaming,
-			KnownLeader: s.knownLeader,
-			LastContact: 0,
-		},
+		Nodes:     make(structs.CheckServiceNodes, 0, len(s.state)),
+		QueryMeta: s.queryMeta(index),
+		Truncated: len(s.truncated) > 0,
+	}
+	s.setSkipped(&result)
+
+	if s.options.IncludeProto {
+		return s.resultWithProto(result)
 	}
+
+	if s.arrivalOrdered() {
+		for _, id := range s.arrival.ids() {
+			result.Nodes = append(result.Nodes, s.state[id])
+		}
+		return &result
+	}
+
 	for _, node := range s.state {
 		result.Nodes = append(result.Nodes, node)
 	}
-	sortCheckServiceNodes(&result)
-
+	if s.sorted() {
+		sortCheckServiceNodes(&result, s.options)
+	}
 	return &result
 }
 
+// sorted returns true if the nodes of the result are sorted. They are not
+// sorted when sorting is disabled by the request or by the MaterializerDeps,
+// or when the request sets ArrivalOrder, unless the request sets SortByHealth.
+func (s *healthView) sorted() bool {
+	return s.options.SortByHealth || !(s.options.SkipSort || s.options.ArrivalOrder || s.disableSort)
+}
+
+// arrivalOrdered returns true if the nodes of the result are returned in the
+// order they were added to the view.
+func (s *healthView) arrivalOrdered() bool {
+	return s.options.ArrivalOrder && !s.options.SortByHealth
+}
+
+// IndexedCheckServiceNodesWithProto is the result of a view with
+// ServiceViewOptions.IncludeProto set.
+type IndexedCheckServiceNodesWithProto struct {
+	structs.IndexedCheckServiceNodes
+
+	// Proto contains the same nodes as Nodes, in the same order, in the
+	// protobuf form they were received in from the servers. The values are
+	// shared with the view, so they must not be modified.
+	Proto []*pbservice.CheckServiceNode
+}
+
+// resultWithProto adds the nodes of the view to result, along with their
+// protobuf form. The IDs of the nodes are sorted so that both forms are
+// returned in the same order.
+func (s *healthView) resultWithProto(result structs.IndexedCheckServiceNodes) *IndexedCheckServiceNodesWithProto {
+	var ids []string
+	if s.arrivalOrdered() {
+		ids = s.arrival.ids()
+	} else {
+		ids = make([]string, 0, len(s.state))
+		for id := range s.state {
+			ids = append(ids, id)
+		}
+	}
+	if s.sorted() {
+		sort.SliceStable(ids, func(i, j int) bool {
+			return lessCheckServiceNode(s.state[ids[i]], s.state[ids[j]], s.options)
+		})
+	}
+
+	withProto := &IndexedCheckServiceNodesWithProto{
+		IndexedCheckServiceNodes: result,
+		Proto:                    make([]*pbservice.CheckServiceNode, 0, len(ids)),
+	}
+	for _, id := range ids {
+		withProto.Nodes = append(withProto.Nodes, s.state[id])
+		withProto.Proto = append(withProto.Proto, s.protos[id])
+	}
+	return withProto
+}
+
+// IndexedServiceIDs is the result of a view with ServiceViewOptions.IDsOnly
+// set.
+type IndexedServiceIDs struct {
+	// IDs contains the "node/serviceID" of each instance of the service, in
+	// sorted order.
+	IDs []string
+
+	structs.QueryMeta
+}
+
+// resultIDs returns the IDs of the instances in the view. The IDs are always
+// sorted, so that two results with the same instances are equal.
+func (s *healthView) resultIDs(index uint64) *IndexedServiceIDs {
+	result := &IndexedServiceIDs{
+		IDs:       make([]string, 0, len(s.state)),
+		QueryMeta: s.queryMeta(index),
+	}
+	for _, csn := range s.state {
+		result.IDs = append(result.IDs, csn.Node.Node+"/"+csn.Service.ID)
+	}
+	sort.Strings(result.IDs)
+	return result
+}
+
+// setSkipped sets Degraded and Skipped on result when instances were skipped
+// because options.AllowPartial is set.
+func (s *healthView) setSkipped(result *structs.IndexedCheckServiceNodes) {
+	if len(s.skipped) == 0 {
+		return
+	}
+	result.Degraded = true
+	result.Skipped = make([]string, 0, len(s.skipped))
+	for id := range s.skipped {
+		result.Skipped = append(result.Skipped, id)
+	}
+	sort.Strings(result.Skipped)
+}
+
+// ResultHash implements submatview.HashedView. The hash combines the hash of
+// each instance with XOR, so it does not depend on the order in which events
+// were received, and it is maintained by upsert and remove so that it does not
+// need to be recomputed after each update. When options.HealthChangesOnly is
+// set only the IDs of the passing instances are hashed, so that the
+// materializer does not wake up requests for other changes.
+func (s *healthView) ResultHash() uint64 {
+	return s.hash
+}
+
+// updateHash replaces the hash of the instance with id in the hash of the view
+// with the hash of csn.
+func (s *healthView) updateHash(id string, csn structs.CheckServiceNode) {
+	s.hash ^= s.hashes[id]
+	delete(s.hashes, id)
+	if h, ok := s.instanceHash(id, csn); ok {
+		s.hash ^= h
+		s.hashes[id] = h
+	}
+}
+
+// instanceHash returns the hash of an instance to include in the hash of the
+// view, or false if the instance is not included. When
+// options.HealthChangesOnly is set only passing instances are included, and
+// only their ID is hashed.
+func (s *healthView) instanceHash(id string, csn structs.CheckServiceNode) (uint64, bool) {
+	var v interface{} = csn
+	if s.options.HealthChangesOnly {
+		if healthRank(csn, s.options.HealthAggregation) != 0 {
+			return 0, false
+		}
+		v = id
+	}
+	h, err := hashstructure.Hash(v, nil)
+	if err != nil {
+		// Only possible if CheckServiceNode contains a type which can not be
+		// hashed.
+		return 0, false
+	}
+	return h, true
+}
+
+func (s *healthView) queryMeta(index uint64) structs.QueryMeta {
+	return structs.QueryMeta{
+		Index:       index,
+		Backend:     structs.QueryBackendStreaming,
+		KnownLeader: s.knownLeader,
+		LastContact: 0,
+	}
+}
+
 func (s *healthView) Reset() {
+	if s.onEvent != nil {
+		for _, csn := range s.state {
+			s.onEvent(pbsubscribe.CatalogOp_Deregister, csn)
+		}
+	}
 	s.knownLeader = false
+	s.hash = 0
+	s.hashes = make(map[string]uint64)
 	s.state = make(map[string]structs.CheckServiceNode)
+	s.protos = make(map[string]*pbservice.CheckServiceNode)
+	s.arrival = newArrivalIndex()
+	s.skipped = make(map[string]struct{})
+	s.truncated = make(map[string]struct{})
+	s.changes = newChangeLog()
+}
+
+// debugNode describes a service instance in the DebugState of a healthView.
+type debugNode struct {
+	Node      string
+	NodeID    types.NodeID
+	ServiceID string
+	Status    string
+}
+
+// DebugState implements submatview.DebugView. It returns the service
+// instances in the view, sorted by node and service ID.
+func (s *healthView) DebugState() interface{} {
+	nodes := make([]debugNode, 0, len(s.state))
+	for _, csn := range s.state {
+		nodes = append(nodes, debugNode{
+			Node:      csn.Node.Node,
+			NodeID:    csn.Node.ID,
+			ServiceID: csn.Service.ID,
+			Status:    csn.AggregatedStatus(s.options.HealthAggregation),
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Node != nodes[j].Node {
+			return nodes[i].Node < nodes[j].Node
+		}
+		return nodes[i].ServiceID < nodes[j].ServiceID
+	})
+	return nodes
 }
 
 // serviceTagEvaluator implements the filterEvaluator to perform filtering
@@ -223,6 +1011,33 @@ func (m serviceTagEvaluator) Evaluate(data interface{}) (bool, error) {
 	return true, nil
 }
 
+// bexprEvaluator evaluates the filter of the request with the same selectors
+// as the servers, including the aggregated Status of the node.
+type bexprEvaluator struct {
+	filter *structs.CheckServiceNodeFilter
+}
+
+func (m bexprEvaluator) Evaluate(data interface{}) (bool, error) {
+	csn, ok := data.(structs.CheckServiceNode)
+	if !ok {
+		return false, fmt.Errorf("unexpected type %T for structs.CheckServiceNode filter", data)
+	}
+	return m.filter.Match(csn)
+}
+
+// passingEvaluator filters out nodes whose aggregated health is not passing.
+type passingEvaluator struct {
+	mode structs.HealthAggregation
+}
+
+func (m passingEvaluator) Evaluate(data interface{}) (bool, error) {
+	csn, ok := data.(structs.CheckServiceNode)
+	if !ok {
+		return false, fmt.Errorf("unexpected type %T for structs.CheckServiceNode filter", data)
+	}
+	return healthRank(csn, m.mode) == 0, nil
+}
+
 func serviceHasTag(sn *structs.NodeService, tag string) bool {
 	for _, t := range sn.Tags {
 		if strings.EqualFold(t, tag) {
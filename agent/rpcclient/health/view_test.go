@@ -2,25 +2,33 @@ package health
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/google/go-cmp/cmp"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-uuid"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/hashicorp/consul/agent/structs"
 	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/proto/pbcommon"
 	"github.com/hashicorp/consul/proto/pbservice"
 	"github.com/hashicorp/consul/proto/pbsubscribe"
 	"github.com/hashicorp/consul/proto/prototest"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/consul/types"
 )
 
@@ -65,28 +73,1624 @@ func TestSortCheckServiceNodes_OrderIsConsistentWithRPCResponse(t *testing.T) {
 		Nodes:     structs.CheckServiceNodes{three, two, zero, one},
 		QueryMeta: structs.QueryMeta{Index: index},
 	}
-	sortCheckServiceNodes(&result)
+	sortCheckServiceNodes(&result, structs.ServiceViewOptions{})
 	expected := structs.CheckServiceNodes{zero, one, two, three}
 	require.Equal(t, expected, result.Nodes)
 }
 
+func TestSortCheckServiceNodes_DuplicateNodeAndServiceID(t *testing.T) {
+	buildTestNode := func(nodeID string) structs.CheckServiceNode {
+		return structs.CheckServiceNode{
+			Node:    &structs.Node{ID: types.NodeID(nodeID), Node: "node1"},
+			Service: &structs.NodeService{ID: "web", Service: "web"},
+		}
+	}
+	a := buildTestNode("11111111-2222-3333-4444-000000000001")
+	b := buildTestNode("11111111-2222-3333-4444-000000000002")
+	c := buildTestNode("11111111-2222-3333-4444-000000000003")
+	expected := structs.CheckServiceNodes{a, b, c}
+
+	for _, nodes := range []structs.CheckServiceNodes{{a, b, c}, {c, b, a}, {b, c, a}} {
+		result := structs.IndexedCheckServiceNodes{Nodes: nodes}
+		sortCheckServiceNodes(&result, structs.ServiceViewOptions{})
+		require.Equal(t, expected, result.Nodes)
+	}
+}
+
+func TestSortCheckServiceNodes_ByHealth(t *testing.T) {
+	buildTestNode := func(nodeName string, status string) structs.CheckServiceNode {
+		return structs.CheckServiceNode{
+			Node:    &structs.Node{Node: nodeName},
+			Service: &structs.NodeService{ID: "web", Service: "web"},
+			Checks: structs.HealthChecks{
+				{Node: nodeName, CheckID: "serf", Status: api.HealthPassing},
+				{Node: nodeName, CheckID: "web", ServiceID: "web", Status: status},
+			},
+		}
+	}
+	a := buildTestNode("node-a", api.HealthCritical)
+	b := buildTestNode("node-b", api.HealthPassing)
+	c := buildTestNode("node-c", api.HealthWarning)
+	d := buildTestNode("node-d", api.HealthPassing)
+	e := buildTestNode("node-e", api.HealthCritical)
+
+	result := structs.IndexedCheckServiceNodes{
+		Nodes: structs.CheckServiceNodes{e, d, c, b, a},
+	}
+	sortCheckServiceNodes(&result, structs.ServiceViewOptions{SortByHealth: true})
+	expected := structs.CheckServiceNodes{b, d, c, a, e}
+	require.Equal(t, expected, result.Nodes)
+
+	sortCheckServiceNodes(&result, structs.ServiceViewOptions{})
+	expected = structs.CheckServiceNodes{a, b, c, d, e}
+	require.Equal(t, expected, result.Nodes)
+}
+
+func TestNewFilterEvaluator_OnlyPassing(t *testing.T) {
+	buildTestNode := func(nodeStatus, serviceStatus string) structs.CheckServiceNode {
+		return structs.CheckServiceNode{
+			Node:    &structs.Node{Node: "node1"},
+			Service: &structs.NodeService{ID: "web", Service: "web"},
+			Checks: structs.HealthChecks{
+				{Node: "node1", CheckID: "serf", Status: nodeStatus},
+				{Node: "node1", CheckID: "web", ServiceID: "web", Status: serviceStatus},
+			},
+		}
+	}
+	serviceWarning := buildTestNode(api.HealthPassing, api.HealthWarning)
+	nodeWarning := buildTestNode(api.HealthWarning, api.HealthPassing)
+	nodeCritical := buildTestNode(api.HealthCritical, api.HealthPassing)
+
+	type testCase struct {
+		mode     structs.HealthAggregation
+		expected map[string]bool
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		e, err := newFilterEvaluator(structs.ServiceSpecificRequest{
+			ViewOptions: structs.ServiceViewOptions{
+				OnlyPassing:       true,
+				HealthAggregation: tc.mode,
+			},
+		})
+		require.NoError(t, err)
+
+		nodes := map[string]structs.CheckServiceNode{
+			"service warning": serviceWarning,
+			"node warning":    nodeWarning,
+			"node critical":   nodeCritical,
+		}
+		for name, csn := range nodes {
+			actual, err := e.Evaluate(csn)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected[name], actual, name)
+		}
+	}
+
+	testCases := map[string]testCase{
+		"strict": {
+			mode: structs.HealthAggregationStrict,
+			expected: map[string]bool{
+				"service warning": false,
+				"node warning":    false,
+				"node critical":   false,
+			},
+		},
+		"warning as passing": {
+			mode: structs.HealthAggregationWarningAsPassing,
+			expected: map[string]bool{
+				"service warning": true,
+				"node warning":    true,
+				"node critical":   false,
+			},
+		},
+		"ignore node checks": {
+			mode: structs.HealthAggregationIgnoreNodeChecks,
+			expected: map[string]bool{
+				"service warning": false,
+				"node warning":    true,
+				"node critical":   true,
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestNewHealthView_InvalidHealthAggregation(t *testing.T) {
+	_, err := newHealthView(structs.ServiceSpecificRequest{
+		ViewOptions: structs.ServiceViewOptions{HealthAggregation: "warning-is-fine"},
+	})
+	require.EqualError(t, err, `unknown health aggregation "warning-is-fine"`)
+}
+
+func TestNewFilterEvaluator_AggregatedStatus(t *testing.T) {
+	buildTestNode := func(nodeStatus, serviceStatus string) structs.CheckServiceNode {
+		return structs.CheckServiceNode{
+			Node:    &structs.Node{Node: "node1"},
+			Service: &structs.NodeService{ID: "web", Service: "web"},
+			Checks: structs.HealthChecks{
+				{Node: "node1", CheckID: "serf", Status: nodeStatus},
+				{Node: "node1", CheckID: "web", ServiceID: "web", Status: serviceStatus},
+			},
+		}
+	}
+	nodes := map[string]structs.CheckServiceNode{
+		"passing":         buildTestNode(api.HealthPassing, api.HealthPassing),
+		"service warning": buildTestNode(api.HealthPassing, api.HealthWarning),
+		"node critical":   buildTestNode(api.HealthCritical, api.HealthPassing),
+	}
+
+	type testCase struct {
+		filter   string
+		mode     structs.HealthAggregation
+		expected map[string]bool
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		e, err := newFilterEvaluator(structs.ServiceSpecificRequest{
+			QueryOptions: structs.QueryOptions{Filter: tc.filter},
+			ViewOptions:  structs.ServiceViewOptions{HealthAggregation: tc.mode},
+		})
+		require.NoError(t, err)
+
+		for name, csn := range nodes {
+			actual, err := e.Evaluate(csn)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected[name], actual, name)
+		}
+	}
+
+	testCases := map[string]testCase{
+		"passing": {
+			filter: `Status == "passing"`,
+			expected: map[string]bool{
+				"passing":         true,
+				"service warning": false,
+				"node critical":   false,
+			},
+		},
+		"not critical": {
+			filter: `Status != "critical"`,
+			expected: map[string]bool{
+				"passing":         true,
+				"service warning": true,
+				"node critical":   false,
+			},
+		},
+		"ignore node checks": {
+			filter: `Status == "passing"`,
+			mode:   structs.HealthAggregationIgnoreNodeChecks,
+			expected: map[string]bool{
+				"passing":         true,
+				"service warning": false,
+				"node critical":   true,
+			},
+		},
+		"combined with other selectors": {
+			filter: `Status == "warning" and Service.Service == "web"`,
+			expected: map[string]bool{
+				"passing":         false,
+				"service warning": true,
+				"node critical":   false,
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+
+	t.Run("recomputed for each event", func(t *testing.T) {
+		view, err := newHealthView(structs.ServiceSpecificRequest{
+			QueryOptions: structs.QueryOptions{Filter: `Status == "passing"`},
+		})
+		require.NoError(t, err)
+
+		withCheck := func(index uint64, status string) *pbsubscribe.Event {
+			event := newEventServiceHealthRegister(index, 1, "web")
+			event.GetServiceHealth().CheckServiceNode.Checks = []*pbservice.HealthCheck{
+				{
+					Node:      "node1",
+					CheckID:   "web",
+					ServiceID: "web",
+					Status:    status,
+					RaftIndex: &pbcommon.RaftIndex{CreateIndex: index, ModifyIndex: index},
+				},
+			}
+			return event
+		}
+
+		require.NoError(t, view.Update([]*pbsubscribe.Event{withCheck(5, api.HealthPassing)}))
+		require.Len(t, view.Result(5).(*structs.IndexedCheckServiceNodes).Nodes, 1)
+
+		require.NoError(t, view.Update([]*pbsubscribe.Event{withCheck(6, api.HealthCritical)}))
+		require.Len(t, view.Result(6).(*structs.IndexedCheckServiceNodes).Nodes, 0)
+
+		require.NoError(t, view.Update([]*pbsubscribe.Event{withCheck(7, api.HealthPassing)}))
+		require.Len(t, view.Result(7).(*structs.IndexedCheckServiceNodes).Nodes, 1)
+	})
+}
+
+func TestNewMaterializerRequest_Topic(t *testing.T) {
+	type testCase struct {
+		req      structs.ServiceSpecificRequest
+		expected pbsubscribe.Topic
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		require.Equal(t, tc.expected, serviceHealthTopic(tc.req))
+		require.Equal(t, tc.expected, topicForRequest(serviceRequest{ServiceSpecificRequest: tc.req}))
+
+		subReq := newMaterializerRequest(tc.req)(7)
+		require.Equal(t, tc.expected, subReq.Topic)
+		require.Equal(t, tc.req.ServiceName, subReq.Key)
+		require.Equal(t, uint64(7), subReq.Index)
+		require.Equal(t, tc.req.EnterpriseMeta.NamespaceOrEmpty(), subReq.Namespace)
+		require.Equal(t, tc.req.EnterpriseMeta.PartitionOrEmpty(), subReq.Partition)
+	}
+
+	testCases := map[string]testCase{
+		"service": {
+			req:      structs.ServiceSpecificRequest{ServiceName: "web"},
+			expected: pbsubscribe.Topic_ServiceHealth,
+		},
+		"connect": {
+			req:      structs.ServiceSpecificRequest{ServiceName: "web", Connect: true},
+			expected: pbsubscribe.Topic_ServiceHealthConnect,
+		},
+		"service with tag filter": {
+			req: structs.ServiceSpecificRequest{
+				ServiceName: "web",
+				ServiceTags: []string{"primary"},
+				TagFilter:   true,
+			},
+			expected: pbsubscribe.Topic_ServiceHealth,
+		},
+		"service in partition": {
+			req: structs.ServiceSpecificRequest{
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaWithPartition("part-a", "ns1"),
+			},
+			expected: pbsubscribe.Topic_ServiceHealth,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestTopicForRequest(t *testing.T) {
+	testCases := map[string]struct {
+		req      submatview.Request
+		expected pbsubscribe.Topic
+	}{
+		"service set": {
+			req:      serviceSetRequest{services: []string{"api", "web"}},
+			expected: pbsubscribe.Topic_ServiceHealth,
+		},
+		"connect service set": {
+			req: serviceSetRequest{
+				ServiceSpecificRequest: structs.ServiceSpecificRequest{Connect: true},
+				services:               []string{"api", "web"},
+			},
+			expected: pbsubscribe.Topic_ServiceHealthConnect,
+		},
+		"gateway services": {
+			req:      gatewayServicesRequest{},
+			expected: pbsubscribe.Topic_GatewayServices,
+		},
+		"config entry": {
+			req:      configEntryRequest{},
+			expected: pbsubscribe.Topic_ConfigEntries,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, topicForRequest(tc.req))
+		})
+	}
+}
+
+func TestHealthView_Result_SkipSort(t *testing.T) {
+	view, err := newHealthView(structs.ServiceSpecificRequest{
+		ViewOptions: structs.ServiceViewOptions{SkipSort: true},
+	})
+	require.NoError(t, err)
+
+	var (
+		events   []*pbsubscribe.Event
+		expected []string
+	)
+	for i := 0; i < 20; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+		expected = append(expected, fmt.Sprintf("node%d", i))
+	}
+	require.NoError(t, view.Update(events))
+
+	result := view.Result(5).(*structs.IndexedCheckServiceNodes)
+	var actual []string
+	for _, csn := range result.Nodes {
+		actual = append(actual, csn.Node.Node)
+	}
+	require.ElementsMatch(t, expected, actual)
+	require.Equal(t, uint64(5), result.Index)
+}
+
+func TestHealthView_Result_DisableSort(t *testing.T) {
+	var events []*pbsubscribe.Event
+	for i := 0; i < 20; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+	events = append(events, newEventServiceHealthDeregister(6, 3, "web"))
+
+	run := func(t *testing.T, opts structs.ServiceViewOptions, disableSort bool) structs.IndexedCheckServiceNodes {
+		view, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(t, err)
+		view.disableSort = disableSort
+		require.NoError(t, view.Update(events))
+
+		if opts.IncludeProto {
+			result := view.Result(6).(*IndexedCheckServiceNodesWithProto)
+			require.Len(t, result.Proto, len(result.Nodes))
+			return result.IndexedCheckServiceNodes
+		}
+		return *view.Result(6).(*structs.IndexedCheckServiceNodes)
+	}
+
+	sorted := run(t, structs.ServiceViewOptions{}, false)
+	require.Len(t, sorted.Nodes, 19)
+
+	unsorted := run(t, structs.ServiceViewOptions{}, true)
+	require.ElementsMatch(t, sorted.Nodes, unsorted.Nodes)
+	require.Equal(t, sorted.QueryMeta, unsorted.QueryMeta)
+
+	unsorted = run(t, structs.ServiceViewOptions{IncludeProto: true}, true)
+	require.ElementsMatch(t, sorted.Nodes, unsorted.Nodes)
+
+	// SortByHealth is still applied when sorting is disabled.
+	sortByHealth := structs.ServiceViewOptions{SortByHealth: true}
+	require.Equal(t, run(t, sortByHealth, false), run(t, sortByHealth, true))
+}
+
+func TestHealthView_Update_RegisteredDecoder(t *testing.T) {
+	var topics []pbsubscribe.Topic
+	for _, topic := range []pbsubscribe.Topic{pbsubscribe.Topic_ServiceHealth, pbsubscribe.Topic_ServiceHealthConnect} {
+		topic := topic
+		submatview.RegisterEventDecoder(topic, func(event *pbsubscribe.Event) (interface{}, error) {
+			topics = append(topics, topic)
+			return decodeServiceHealth(event)
+		})
+		t.Cleanup(func() {
+			submatview.RegisterEventDecoder(topic, decodeServiceHealth)
+		})
+	}
+
+	view, err := newHealthView(structs.ServiceSpecificRequest{Connect: true})
+	require.NoError(t, err)
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+	}))
+	require.Equal(t, []pbsubscribe.Topic{
+		pbsubscribe.Topic_ServiceHealthConnect,
+		pbsubscribe.Topic_ServiceHealthConnect,
+	}, topics)
+	require.Len(t, view.Result(5).(*structs.IndexedCheckServiceNodes).Nodes, 2)
+
+	err = view.Update([]*pbsubscribe.Event{newEndOfSnapshotEvent(6)})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unexpected event type for service health view")
+}
+
+func TestHealthView_Update_ServiceChecksOnly(t *testing.T) {
+	event := newEventServiceHealthRegister(5, 1, "web")
+	event.GetServiceHealth().CheckServiceNode.Checks = []*pbservice.HealthCheck{
+		{Node: "node1", CheckID: "serfHealth", Status: api.HealthCritical, RaftIndex: &pbcommon.RaftIndex{}},
+		{Node: "node1", CheckID: "web", ServiceID: "web", Status: api.HealthPassing, RaftIndex: &pbcommon.RaftIndex{}},
+	}
+
+	run := func(t *testing.T, opts structs.ServiceViewOptions) *IndexedCheckServiceNodesWithProto {
+		opts.IncludeProto = true
+		view, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(t, err)
+		require.NoError(t, view.Update([]*pbsubscribe.Event{event}))
+		return view.Result(5).(*IndexedCheckServiceNodesWithProto)
+	}
+
+	runStep(t, "node checks are excluded", func(t *testing.T) {
+		result := run(t, structs.ServiceViewOptions{ServiceChecksOnly: true})
+		require.Len(t, result.Nodes, 1)
+		require.Len(t, result.Nodes[0].Checks, 1)
+		require.Equal(t, "web", result.Nodes[0].Checks[0].ServiceID)
+		require.Len(t, result.Proto[0].Checks, 1)
+		require.Equal(t, "web", result.Proto[0].Checks[0].ServiceID)
+
+		// The checks of the event are not modified.
+		require.Len(t, event.GetServiceHealth().CheckServiceNode.Checks, 2)
+	})
+
+	runStep(t, "node checks are still used for health", func(t *testing.T) {
+		result := run(t, structs.ServiceViewOptions{ServiceChecksOnly: true, OnlyPassing: true})
+		require.Len(t, result.Nodes, 0)
+	})
+
+	runStep(t, "node checks are included by default", func(t *testing.T) {
+		result := run(t, structs.ServiceViewOptions{})
+		require.Len(t, result.Nodes, 1)
+		require.Len(t, result.Nodes[0].Checks, 2)
+		require.Len(t, result.Proto[0].Checks, 2)
+	})
+}
+
+func TestHealthView_Result_ArrivalOrder(t *testing.T) {
+	view, err := newHealthView(structs.ServiceSpecificRequest{
+		ViewOptions: structs.ServiceViewOptions{ArrivalOrder: true},
+	})
+	require.NoError(t, err)
+
+	nodes := func(t *testing.T, index uint64) []string {
+		result := view.Result(index).(*structs.IndexedCheckServiceNodes)
+		var actual []string
+		for _, csn := range result.Nodes {
+			actual = append(actual, csn.Node.Node)
+		}
+		return actual
+	}
+
+	var (
+		events   []*pbsubscribe.Event
+		expected []string
+	)
+	for _, i := range []int{7, 3, 12, 0, 5, 9, 1} {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+		expected = append(expected, fmt.Sprintf("node%d", i))
+	}
+	require.NoError(t, view.Update(events))
+
+	runStep(t, "nodes are returned in the order they were received", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			require.Equal(t, expected, nodes(t, 5))
+		}
+	})
+
+	runStep(t, "updated nodes keep their position", func(t *testing.T) {
+		require.NoError(t, view.Update([]*pbsubscribe.Event{
+			newEventServiceHealthRegister(6, 12, "web"),
+			newEventServiceHealthRegister(6, 7, "web"),
+		}))
+		require.Equal(t, expected, nodes(t, 6))
+	})
+
+	runStep(t, "nodes added again are moved to the end", func(t *testing.T) {
+		require.NoError(t, view.Update([]*pbsubscribe.Event{
+			newEventServiceHealthDeregister(7, 3, "web"),
+			newEventServiceHealthDeregister(7, 5, "web"),
+			newEventServiceHealthRegister(7, 3, "web"),
+			newEventServiceHealthRegister(7, 4, "web"),
+		}))
+		expected := []string{"node7", "node12", "node0", "node9", "node1", "node3", "node4"}
+		require.Equal(t, expected, nodes(t, 7))
+	})
+
+	runStep(t, "order is cleared by Reset", func(t *testing.T) {
+		view.Reset()
+		require.NoError(t, view.Update([]*pbsubscribe.Event{
+			newEventServiceHealthRegister(8, 2, "web"),
+			newEventServiceHealthRegister(8, 1, "web"),
+		}))
+		require.Equal(t, []string{"node2", "node1"}, nodes(t, 8))
+	})
+
+	runStep(t, "SortByHealth takes precedence", func(t *testing.T) {
+		view.options.SortByHealth = true
+		require.Equal(t, []string{"node1", "node2"}, nodes(t, 8))
+	})
+}
+
+func TestHealthView_OnEvent(t *testing.T) {
+	type call struct {
+		op   pbsubscribe.CatalogOp
+		node string
+	}
+	var calls []call
+
+	view, err := newHealthView(structs.ServiceSpecificRequest{
+		QueryOptions: structs.QueryOptions{Filter: `Node.Node != "node3"`},
+	})
+	require.NoError(t, err)
+	view.onEvent = func(op pbsubscribe.CatalogOp, csn structs.CheckServiceNode) {
+		calls = append(calls, call{op: op, node: csn.Node.Node})
+	}
+
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEventServiceHealthRegister(5, 3, "web"),
+	}))
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEventServiceHealthDeregister(6, 1, "web"),
+		newEventServiceHealthRegister(6, 2, "web"),
+		newEventServiceHealthDeregister(6, 3, "web"),
+	}))
+
+	expected := []call{
+		{op: pbsubscribe.CatalogOp_Register, node: "node1"},
+		{op: pbsubscribe.CatalogOp_Register, node: "node2"},
+		{op: pbsubscribe.CatalogOp_Deregister, node: "node1"},
+		{op: pbsubscribe.CatalogOp_Register, node: "node2"},
+	}
+	require.Equal(t, expected, calls)
+
+	// Resetting the view removes the remaining nodes.
+	calls = nil
+	view.Reset()
+	require.Equal(t, []call{{op: pbsubscribe.CatalogOp_Deregister, node: "node2"}}, calls)
+}
+
+func TestHealthView_Update_AllowPartial(t *testing.T) {
+	malformed := newEventServiceHealthRegister(5, 2, "web")
+	csn := malformed.GetServiceHealth().CheckServiceNode
+	csn.Checks = append(csn.Checks, nil)
+
+	events := []*pbsubscribe.Event{
+		newEventServiceHealthRegister(5, 1, "web"),
+		malformed,
+		newEventServiceHealthRegister(5, 3, "web"),
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		view, err := newHealthView(structs.ServiceSpecificRequest{})
+		require.NoError(t, err)
+
+		err = view.Update(events)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing check at position")
+	})
+
+	t.Run("allow partial", func(t *testing.T) {
+		view, err := newHealthView(structs.ServiceSpecificRequest{
+			ViewOptions: structs.ServiceViewOptions{AllowPartial: true},
+		})
+		require.NoError(t, err)
+		require.NoError(t, view.Update(events))
+
+		result := view.Result(5).(*structs.IndexedCheckServiceNodes)
+		var nodes []string
+		for _, csn := range result.Nodes {
+			nodes = append(nodes, csn.Node.Node)
+		}
+		require.Equal(t, []string{"node1", "node3"}, nodes)
+		require.True(t, result.Degraded)
+		require.Equal(t, []string{csn.UniqueID()}, result.Skipped)
+
+		// A valid update for the instance clears the degraded state.
+		require.NoError(t, view.Update([]*pbsubscribe.Event{
+			newEventServiceHealthRegister(6, 2, "web"),
+		}))
+		result = view.Result(6).(*structs.IndexedCheckServiceNodes)
+		require.Len(t, result.Nodes, 3)
+		require.False(t, result.Degraded)
+		require.Empty(t, result.Skipped)
+	})
+}
+
+func TestHealthView_Update_MaxInstances(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("consul.health.test")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	metrics.NewGlobal(cfg, sink)
+	t.Cleanup(func() {
+		metrics.NewGlobal(cfg, &metrics.BlackholeSink{})
+	})
+
+	view, err := newHealthView(structs.ServiceSpecificRequest{})
+	require.NoError(t, err)
+	view.maxInstances = 2
+
+	nodeNames := func(result *structs.IndexedCheckServiceNodes) []string {
+		var names []string
+		for _, csn := range result.Nodes {
+			names = append(names, csn.Node.Node)
+		}
+		return names
+	}
+
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEventServiceHealthRegister(5, 3, "web"),
+	}))
+	result := view.Result(5).(*structs.IndexedCheckServiceNodes)
+	require.Equal(t, []string{"node1", "node2"}, nodeNames(result))
+	require.True(t, result.Truncated)
+
+	// Instances already in the view are still updated.
+	updated := newEventServiceHealthRegister(6, 2, "web")
+	updated.GetServiceHealth().CheckServiceNode.Service.Port = 9090
+	require.NoError(t, view.Update([]*pbsubscribe.Event{updated}))
+	result = view.Result(6).(*structs.IndexedCheckServiceNodes)
+	require.Equal(t, []string{"node1", "node2"}, nodeNames(result))
+	require.Equal(t, 9090, result.Nodes[1].Service.Port)
+	require.True(t, result.Truncated)
+
+	// The result is no longer truncated once the excluded instance is removed.
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEventServiceHealthDeregister(7, 3, "web"),
+	}))
+	result = view.Result(7).(*structs.IndexedCheckServiceNodes)
+	require.Equal(t, []string{"node1", "node2"}, nodeNames(result))
+	require.False(t, result.Truncated)
+
+	data := sink.Data()
+	require.Len(t, data, 1)
+	data[0].RLock()
+	defer data[0].RUnlock()
+	counter, ok := data[0].Counters["consul.health.test.rpcclient.health.truncated"]
+	require.True(t, ok, "missing truncated counter")
+	require.Equal(t, float64(1), counter.Sum)
+}
+
+func TestHealthView_Update_SnapshotMetrics(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("consul.health.test")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	metrics.NewGlobal(cfg, sink)
+	t.Cleanup(func() {
+		metrics.NewGlobal(cfg, &metrics.BlackholeSink{})
+	})
+
+	view, err := newHealthView(structs.ServiceSpecificRequest{})
+	require.NoError(t, err)
+
+	var events []*pbsubscribe.Event
+	for i := 0; i < 50; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+	require.NoError(t, view.Update(events))
+
+	// Updates after the snapshot are not recorded.
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEventServiceHealthRegister(6, 50, "web"),
+	}))
+
+	// A new snapshot is recorded after the view is reset.
+	view.Reset()
+	require.NoError(t, view.Update(events[:10]))
+
+	data := sink.Data()
+	require.Len(t, data, 1)
+	data[0].RLock()
+	defer data[0].RUnlock()
+
+	instances, ok := data[0].Samples["consul.health.test.rpcclient.health.snapshot.instances"]
+	require.True(t, ok, "missing snapshot instances sample")
+	require.Equal(t, 2, instances.Count)
+	require.Equal(t, float64(60), instances.Sum)
+	require.Equal(t, float64(50), instances.Max)
+
+	apply, ok := data[0].Samples["consul.health.test.rpcclient.health.snapshot.apply"]
+	require.True(t, ok, "missing snapshot apply sample")
+	require.Equal(t, 2, apply.Count)
+	require.Greater(t, apply.Max, float64(0))
+}
+
+func TestHealthView_Result_IncludeProto(t *testing.T) {
+	var events []*pbsubscribe.Event
+	for i := 0; i < 20; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+	events = append(events,
+		newEventServiceHealthDeregister(6, 3, "web"),
+		newEventServiceHealthRegister(6, 7, "web"))
+
+	run := func(t *testing.T, opts structs.ServiceViewOptions) *structs.IndexedCheckServiceNodes {
+		view, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(t, err)
+		require.NoError(t, view.Update(events))
+
+		if !opts.IncludeProto {
+			return view.Result(6).(*structs.IndexedCheckServiceNodes)
+		}
+
+		result := view.Result(6).(*IndexedCheckServiceNodesWithProto)
+		require.Len(t, result.Proto, len(result.Nodes))
+		for i, pbcsn := range result.Proto {
+			csn, err := pbservice.CheckServiceNodeToStructs(pbcsn)
+			require.NoError(t, err)
+			require.Equal(t, result.Nodes[i], *csn)
+		}
+		return &result.IndexedCheckServiceNodes
+	}
+
+	expected := run(t, structs.ServiceViewOptions{})
+	require.Len(t, expected.Nodes, 19)
+	require.Equal(t, expected, run(t, structs.ServiceViewOptions{IncludeProto: true}))
+
+	sortByHealth := structs.ServiceViewOptions{SortByHealth: true}
+	expected = run(t, sortByHealth)
+	sortByHealth.IncludeProto = true
+	require.Equal(t, expected, run(t, sortByHealth))
+
+	skipSort := run(t, structs.ServiceViewOptions{SkipSort: true, IncludeProto: true})
+	require.ElementsMatch(t, expected.Nodes, skipSort.Nodes)
+}
+
+func TestHealthView_Result_IDsOnly(t *testing.T) {
+	var events []*pbsubscribe.Event
+	for i := 0; i < 20; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+	events = append(events,
+		newEventServiceHealthDeregister(6, 3, "web"),
+		newEventServiceHealthRegister(6, 7, "web"))
+
+	run := func(t *testing.T, opts structs.ServiceViewOptions) interface{} {
+		view, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(t, err)
+		require.NoError(t, view.Update(events))
+		return view.Result(6)
+	}
+
+	full := run(t, structs.ServiceViewOptions{}).(*structs.IndexedCheckServiceNodes)
+	var expected []string
+	for _, csn := range full.Nodes {
+		expected = append(expected, csn.Node.Node+"/"+csn.Service.ID)
+	}
+	sort.Strings(expected)
+
+	result := run(t, structs.ServiceViewOptions{IDsOnly: true}).(*IndexedServiceIDs)
+	require.Len(t, result.IDs, 19)
+	require.Equal(t, expected, result.IDs)
+	require.Equal(t, full.QueryMeta, result.QueryMeta)
+
+	// The IDs are sorted even when sorting of the nodes is skipped.
+	result = run(t, structs.ServiceViewOptions{IDsOnly: true, SkipSort: true}).(*IndexedServiceIDs)
+	require.Equal(t, expected, result.IDs)
+}
+
+func BenchmarkHealthView_Result(b *testing.B) {
+	var events []*pbsubscribe.Event
+	for i := 0; i < 5000; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+
+	run := func(b *testing.B, opts structs.ServiceViewOptions, disableSort bool) {
+		view, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(b, err)
+		view.disableSort = disableSort
+		require.NoError(b, view.Update(events))
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			view.Result(5)
+		}
+	}
+
+	b.Run("sorted", func(b *testing.B) {
+		run(b, structs.ServiceViewOptions{}, false)
+	})
+	b.Run("skip sort", func(b *testing.B) {
+		run(b, structs.ServiceViewOptions{SkipSort: true}, false)
+	})
+	b.Run("sort disabled", func(b *testing.B) {
+		run(b, structs.ServiceViewOptions{}, true)
+	})
+}
+
+func BenchmarkHealthView_Update(b *testing.B) {
+	var events []*pbsubscribe.Event
+	for i := 0; i < 5000; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+
+	run := func(b *testing.B, opts structs.ServiceViewOptions) {
+		view, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(b, err)
+		require.NoError(b, view.Update(events))
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			event := newEventServiceHealthRegister(uint64(6+i), i%5000, "web")
+			require.NoError(b, view.Update([]*pbsubscribe.Event{event}))
+			view.ResultHash()
+		}
+	}
+
+	b.Run("default", func(b *testing.B) {
+		run(b, structs.ServiceViewOptions{})
+	})
+	b.Run("health changes only", func(b *testing.B) {
+		run(b, structs.ServiceViewOptions{HealthChangesOnly: true})
+	})
+}
+
+func TestHealthView_ResultHash_Incremental(t *testing.T) {
+	for _, opts := range []structs.ServiceViewOptions{{}, {HealthChangesOnly: true}} {
+		view, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(t, err)
+		require.NoError(t, view.Update([]*pbsubscribe.Event{
+			newEventServiceHealthRegister(5, 1, "web"),
+			newEventServiceHealthRegister(5, 2, "web"),
+			newEventServiceHealthRegister(5, 3, "web"),
+		}))
+		require.NoError(t, view.Update([]*pbsubscribe.Event{
+			newEventServiceHealthDeregister(6, 2, "web"),
+			newEventServiceHealthRegister(7, 3, "web"),
+		}))
+
+		fresh, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(t, err)
+		require.NoError(t, fresh.Update([]*pbsubscribe.Event{
+			newEventServiceHealthRegister(7, 3, "web"),
+			newEventServiceHealthRegister(5, 1, "web"),
+		}))
+		require.Equal(t, fresh.ResultHash(), view.ResultHash())
+
+		require.NoError(t, view.Update([]*pbsubscribe.Event{
+			newEventServiceHealthDeregister(8, 1, "web"),
+			newEventServiceHealthDeregister(8, 3, "web"),
+		}))
+		empty, err := newHealthView(structs.ServiceSpecificRequest{ViewOptions: opts})
+		require.NoError(t, err)
+		require.Equal(t, empty.ResultHash(), view.ResultHash())
+	}
+}
+
+func TestHealthView_Update_FilterCompiledOnce(t *testing.T) {
+	req := structs.ServiceSpecificRequest{
+		NodeMetaFilters: map[string]string{"zone": "a"},
+		QueryOptions:    structs.QueryOptions{Filter: `Node.Node != "node2"`},
+	}
+	view, err := newHealthView(req)
+	require.NoError(t, err)
+	filter := view.filter
+
+	newEvent := func(index uint64, nodeNum int, zone string) *pbsubscribe.Event {
+		event := newEventServiceHealthRegister(index, nodeNum, "web")
+		event.GetServiceHealth().CheckServiceNode.Node.Meta = map[string]string{"zone": zone}
+		return event
+	}
+
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEvent(5, 1, "a"),
+		newEvent(5, 2, "a"),
+		newEvent(5, 3, "b"),
+	}))
+	result := view.Result(5).(*structs.IndexedCheckServiceNodes)
+	require.Equal(t, []string{"node1"}, nodeNames(result.Nodes))
+
+	require.NoError(t, view.Update([]*pbsubscribe.Event{newEvent(6, 4, "a")}))
+	result = view.Result(6).(*structs.IndexedCheckServiceNodes)
+	require.Equal(t, []string{"node1", "node4"}, nodeNames(result.Nodes))
+
+	view.Reset()
+	require.NoError(t, view.Update([]*pbsubscribe.Event{
+		newEvent(7, 2, "a"),
+		newEvent(7, 5, "a"),
+	}))
+	result = view.Result(7).(*structs.IndexedCheckServiceNodes)
+	require.Equal(t, []string{"node5"}, nodeNames(result.Nodes))
+
+	// The same compiled filter is used for every update.
+	require.True(t, filter == view.filter)
+}
+
+func nodeNames(nodes structs.CheckServiceNodes) []string {
+	var names []string
+	for _, csn := range nodes {
+		names = append(names, csn.Node.Node)
+	}
+	return names
+}
+
+// BenchmarkHealthView_Update_Filter compares the cost of applying events with
+// the filter compiled once by the view, to the cost of compiling the filter for
+// every event.
+func BenchmarkHealthView_Update_Filter(b *testing.B) {
+	req := structs.ServiceSpecificRequest{
+		NodeMetaFilters: map[string]string{"zone": "a"},
+		QueryOptions:    structs.QueryOptions{Filter: `Service.Port == 8080 and Node.Node != "node2"`},
+	}
+	event := newEventServiceHealthRegister(5, 1, "web")
+
+	b.Run("compiled once", func(b *testing.B) {
+		view, err := newHealthView(req)
+		require.NoError(b, err)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, view.Update([]*pbsubscribe.Event{event}))
+		}
+	})
+	b.Run("compiled per event", func(b *testing.B) {
+		view, err := newHealthView(req)
+		require.NoError(b, err)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			view.filter, err = newFilterEvaluator(req)
+			require.NoError(b, err)
+			require.NoError(b, view.Update([]*pbsubscribe.Event{event}))
+		}
+	})
+}
+
+func TestHealthView_Update_Concurrent(t *testing.T) {
+	var events []*pbsubscribe.Event
+	for i := 0; i < 1000; i++ {
+		event := newEventServiceHealthRegister(5, i, "web")
+		// Malformed instances are not updated by the events below, so they
+		// remain skipped.
+		if i%100 == 55 {
+			csn := event.GetServiceHealth().CheckServiceNode
+			csn.Checks = append(csn.Checks, nil)
+		}
+		events = append(events, event)
+	}
+	// Later events for the same instance must be applied in order.
+	for i := 0; i < 1000; i += 10 {
+		events = append(events, newEventServiceHealthDeregister(5, i, "web"))
+	}
+	for i := 0; i < 1000; i += 20 {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+
+	req := structs.ServiceSpecificRequest{
+		ViewOptions:  structs.ServiceViewOptions{AllowPartial: true},
+		QueryOptions: structs.QueryOptions{Filter: `Node.Node matches "^node[0-9]*[0-7]$"`},
+	}
+	run := func(t *testing.T, concurrency int) *structs.IndexedCheckServiceNodes {
+		view, err := newHealthView(req)
+		require.NoError(t, err)
+		view.concurrency = concurrency
+		require.NoError(t, view.Update(events))
+		return view.Result(5).(*structs.IndexedCheckServiceNodes)
+	}
+
+	serial := run(t, 1)
+	require.NotEmpty(t, serial.Nodes)
+	require.True(t, serial.Degraded)
+	require.Equal(t, serial, run(t, 8))
+	require.Equal(t, serial, run(t, 2000))
+
+	t.Run("error is returned without AllowPartial", func(t *testing.T) {
+		view, err := newHealthView(structs.ServiceSpecificRequest{})
+		require.NoError(t, err)
+		view.concurrency = 8
+		err = view.Update(events)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing check at position")
+	})
+}
+
+func BenchmarkHealthView_Update_Snapshot(b *testing.B) {
+	var events []*pbsubscribe.Event
+	for i := 0; i < 5000; i++ {
+		events = append(events, newEventServiceHealthRegister(5, i, "web"))
+	}
+	req := structs.ServiceSpecificRequest{
+		QueryOptions: structs.QueryOptions{Filter: `Service.Port == 8080`},
+	}
+
+	run := func(b *testing.B, concurrency int) {
+		for i := 0; i < b.N; i++ {
+			view, err := newHealthView(req)
+			require.NoError(b, err)
+			view.concurrency = concurrency
+			require.NoError(b, view.Update(events))
+		}
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		run(b, 1)
+	})
+	b.Run("concurrency 4", func(b *testing.B) {
+		run(b, 4)
+	})
+	b.Run("concurrency 16", func(b *testing.B) {
+		run(b, 16)
+	})
+}
+
 func TestHealthView_IntegrationWithStore_WithEmptySnapshot(t *testing.T) {
 	if testing.Short() {
 		t.Skip("too slow for testing.Short")
 	}
 
-	namespace := getNamespace(pbcommon.DefaultEnterpriseMeta.Namespace)
-	streamClient := newStreamClient(validateNamespace(namespace))
+	namespace := getNamespace(pbcommon.DefaultEnterpriseMeta.Namespace)
+	streamClient := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	// Initially there are no services registered. Server should send an
+	// EndOfSnapshot message immediately with index of 1.
+	streamClient.QueueEvents(newEndOfSnapshotEvent(1))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+			},
+		},
+		streamClient: streamClient,
+	}
+	empty := &structs.IndexedCheckServiceNodes{
+		Nodes: structs.CheckServiceNodes{},
+		QueryMeta: structs.QueryMeta{
+			Index:       1,
+			Backend:     structs.QueryBackendStreaming,
+			KnownLeader: true,
+		},
+	}
+
+	runStep(t, "empty snapshot returned", func(t *testing.T) {
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+
+		require.Equal(t, uint64(1), result.Index)
+		require.Equal(t, empty, result.Value)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "blocks for timeout", func(t *testing.T) {
+		// Subsequent fetch should block for the timeout
+		start := time.Now()
+		req.QueryOptions.MaxQueryTime = 200 * time.Millisecond
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed := time.Since(start)
+		require.True(t, elapsed >= 200*time.Millisecond,
+			"Fetch should have blocked until timeout")
+
+		require.Equal(t, req.QueryOptions.MinQueryIndex, result.Index, "result index should not have changed")
+		require.Equal(t, empty, result.Value, "result value should not have changed")
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	var lastResultValue structs.CheckServiceNodes
+
+	runStep(t, "blocks until update", func(t *testing.T) {
+		// Make another blocking query with a longer timeout and trigger an update
+		// event part way through.
+		start := time.Now()
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			streamClient.QueueEvents(newEventServiceHealthRegister(4, 1, "web"))
+		}()
+
+		req.QueryOptions.MaxQueryTime = time.Second
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed := time.Since(start)
+		require.True(t, elapsed >= 200*time.Millisecond,
+			"Fetch should have blocked until the event was delivered")
+		require.True(t, elapsed < time.Second,
+			"Fetch should have returned before the timeout")
+
+		require.Equal(t, uint64(4), result.Index, "result index should not have changed")
+		lastResultValue = result.Value.(*structs.IndexedCheckServiceNodes).Nodes
+		require.Len(t, lastResultValue, 1,
+			"result value should contain the new registration")
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "reconnects and resumes after temporary error", func(t *testing.T) {
+		streamClient.QueueErr(tempError("broken pipe"))
+
+		// Next fetch will continue to block until timeout and receive the same
+		// result.
+		start := time.Now()
+		req.QueryOptions.MaxQueryTime = 200 * time.Millisecond
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed := time.Since(start)
+		require.True(t, elapsed >= 200*time.Millisecond,
+			"Fetch should have blocked until timeout")
+
+		require.Equal(t, req.QueryOptions.MinQueryIndex, result.Index,
+			"result index should not have changed")
+		require.Equal(t, lastResultValue, result.Value.(*structs.IndexedCheckServiceNodes).Nodes,
+			"result value should not have changed")
+
+		req.QueryOptions.MinQueryIndex = result.Index
+
+		// But an update should still be noticed due to reconnection
+		streamClient.QueueEvents(newEventServiceHealthRegister(10, 2, "web"))
+
+		start = time.Now()
+		req.QueryOptions.MaxQueryTime = time.Second
+		result, err = store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed = time.Since(start)
+		require.True(t, elapsed < time.Second,
+			"Fetch should have returned before the timeout")
+
+		require.Equal(t, uint64(10), result.Index, "result index should not have changed")
+		lastResultValue = result.Value.(*structs.IndexedCheckServiceNodes).Nodes
+		require.Len(t, lastResultValue, 2,
+			"result value should contain the new registration")
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "returns non-temporary error to watchers", func(t *testing.T) {
+		// Wait and send the error while fetcher is waiting
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			streamClient.QueueErr(errors.New("invalid request"))
+		}()
+
+		// Next fetch should return the error
+		start := time.Now()
+		req.QueryOptions.MaxQueryTime = time.Second
+		result, err := store.Get(ctx, req)
+		require.Error(t, err)
+		elapsed := time.Since(start)
+		require.True(t, elapsed >= 200*time.Millisecond,
+			"Fetch should have blocked until error was sent")
+		require.True(t, elapsed < time.Second,
+			"Fetch should have returned before the timeout")
+
+		require.Equal(t, req.QueryOptions.MinQueryIndex, result.Index, "result index should not have changed")
+		require.Equal(t, lastResultValue, result.Value.(*structs.IndexedCheckServiceNodes).Nodes)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+
+		// But an update should still be noticed due to reconnection
+		streamClient.QueueEvents(newEventServiceHealthRegister(req.QueryOptions.MinQueryIndex+5, 3, "web"))
+
+		req.QueryOptions.MaxQueryTime = time.Second
+		result, err = store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed = time.Since(start)
+		require.True(t, elapsed < time.Second, "Fetch should have returned before the timeout")
+
+		require.Equal(t, req.QueryOptions.MinQueryIndex+5, result.Index, "result index should not have changed")
+		require.Len(t, result.Value.(*structs.IndexedCheckServiceNodes).Nodes, 3,
+			"result value should contain the new registration")
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+}
+
+type tempError string
+
+func (e tempError) Error() string {
+	return string(e)
+}
+
+func (e tempError) Temporary() bool {
+	return true
+}
+
+func TestHealthView_IntegrationWithStore_WithFullSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("too slow for testing.Short")
+	}
+
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	// Create an initial snapshot of 3 instances on different nodes
+	registerServiceWeb := func(index uint64, nodeNum int) *pbsubscribe.Event {
+		return newEventServiceHealthRegister(index, nodeNum, "web")
+	}
+	client.QueueEvents(
+		registerServiceWeb(5, 1),
+		registerServiceWeb(5, 2),
+		registerServiceWeb(5, 3),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+			},
+		},
+		streamClient: client,
+	}
+
+	runStep(t, "full snapshot returned", func(t *testing.T) {
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+
+		require.Equal(t, uint64(5), result.Index)
+		expected := newExpectedNodes("node1", "node2", "node3")
+		expected.Index = 5
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "blocks until deregistration", func(t *testing.T) {
+		// Make another blocking query with a longer timeout and trigger an update
+		// event part way through.
+		start := time.Now()
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+
+			// Deregister instance on node1
+			client.QueueEvents(newEventServiceHealthDeregister(20, 1, "web"))
+		}()
+
+		req.QueryOptions.MaxQueryTime = time.Second
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed := time.Since(start)
+		require.True(t, elapsed >= 200*time.Millisecond,
+			"Fetch should have blocked until the event was delivered")
+		require.True(t, elapsed < time.Second,
+			"Fetch should have returned before the timeout")
+
+		require.Equal(t, uint64(20), result.Index)
+		expected := newExpectedNodes("node2", "node3")
+		expected.Index = 20
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "server reload is respected", func(t *testing.T) {
+		// Simulates the server noticing the request's ACL token privs changing. To
+		// detect this we'll queue up the new snapshot as a different set of nodes
+		// to the first.
+		client.QueueErr(status.Error(codes.Aborted, "reset by server"))
+
+		client.QueueEvents(
+			registerServiceWeb(50, 3), // overlap existing node
+			registerServiceWeb(50, 4),
+			registerServiceWeb(50, 5),
+			newEndOfSnapshotEvent(50))
+
+		// Make another blocking query with THE SAME index. It should immediately
+		// return the new snapshot.
+		start := time.Now()
+		req.QueryOptions.MaxQueryTime = time.Second
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed := time.Since(start)
+		require.True(t, elapsed < time.Second,
+			"Fetch should have returned before the timeout")
+
+		require.Equal(t, uint64(50), result.Index)
+		expected := newExpectedNodes("node3", "node4", "node5")
+		expected.Index = 50
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "reconnects and receives new snapshot when server state has changed", func(t *testing.T) {
+		client.QueueErr(tempError("temporary connection error"))
+
+		client.QueueEvents(
+			newNewSnapshotToFollowEvent(),
+			registerServiceWeb(50, 3), // overlap existing node
+			registerServiceWeb(50, 4),
+			registerServiceWeb(50, 5),
+			newEndOfSnapshotEvent(50))
+
+		start := time.Now()
+		req.QueryOptions.MinQueryIndex = 49
+		req.QueryOptions.MaxQueryTime = time.Second
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		elapsed := time.Since(start)
+		require.True(t, elapsed < time.Second,
+			"Fetch should have returned before the timeout")
+
+		require.Equal(t, uint64(50), result.Index)
+		expected := newExpectedNodes("node3", "node4", "node5")
+		expected.Index = 50
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+	})
+}
+
+func TestHealthView_IntegrationWithStore_ResumeFromIndex(t *testing.T) {
+	namespace := getNamespace("ns2")
+	validate := validateNamespace(namespace)
+	subscribed := make(chan *pbsubscribe.SubscribeRequest, 10)
+	client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+		subscribed <- req
+		return validate(req)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+			},
+		},
+		streamClient: client,
+	}
+
+	runStep(t, "full snapshot returned", func(t *testing.T) {
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+
+		require.Equal(t, uint64(5), result.Index)
+		expected := newExpectedNodes("node1", "node2")
+		expected.Index = 5
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+		first := <-subscribed
+		require.Equal(t, uint64(0), first.Index)
+
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	runStep(t, "resumes from the last index without a new snapshot", func(t *testing.T) {
+		client.QueueErr(tempError("broken pipe"))
+		// The server state has not changed, so only new events are sent.
+		client.QueueEvents(newEventServiceHealthRegister(10, 3, "web"))
+
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+
+		require.Equal(t, uint64(10), result.Index)
+		expected := newExpectedNodes("node1", "node2", "node3")
+		expected.Index = 10
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+		select {
+		case resumed := <-subscribed:
+			require.Equal(t, uint64(5), resumed.Index)
+		default:
+			t.Fatalf("expected the subscription to be resumed")
+		}
+	})
+}
+
+func TestHealthView_IntegrationWithStore_WeightsUpdate(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+			},
+		},
+		streamClient: client,
+	}
+
+	result, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+
+	update := newEventServiceHealthRegister(8, 1, "web")
+	update.GetServiceHealth().CheckServiceNode.Service.Weights = &pbservice.Weights{
+		Passing: 7,
+		Warning: 2,
+	}
+	client.QueueEvents(update)
+
+	req.QueryOptions.MinQueryIndex = result.Index
+	result, err = store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), result.Index)
+
+	nodes := result.Value.(*structs.IndexedCheckServiceNodes)
+	require.Equal(t, uint64(8), nodes.Index)
+	require.Len(t, nodes.Nodes, 1)
+	require.Equal(t, &structs.Weights{Passing: 7, Warning: 2}, nodes.Nodes[0].Service.Weights)
+}
+
+func TestHealthView_IntegrationWithStore_FetchServiceNodes(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+			},
+		},
+		streamClient: client,
+	}
+
+	nodes, meta, err := FetchServiceNodes(ctx, store, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), meta.Index)
+
+	expected := newExpectedNodes("node1", "node2")
+	expected.Index = 5
+	prototest.AssertDeepEqual(t, expected, nodes, cmpCheckServiceNodeNames)
+}
+
+func TestHealthView_IntegrationWithStore_ResultHash(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+			},
+		},
+		streamClient: client,
+	}
+
+	first, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), first.Index)
+	require.NotZero(t, first.Hash)
+
+	client.QueueEvents(newEventServiceHealthRegister(8, 3, "web"))
+	req.QueryOptions.MinQueryIndex = 5
+	second, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), second.Index)
+	require.NotEqual(t, first.Hash, second.Hash)
+
+	client.QueueEvents(newEventServiceHealthDeregister(9, 3, "web"))
+	req.QueryOptions.MinQueryIndex = 8
+	third, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), third.Index)
+	require.Equal(t, first.Hash, third.Hash)
+}
+
+func TestHealthView_IntegrationWithStore_UnchangedResultDoesNotUnblock(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: 200 * time.Millisecond},
+			},
+		},
+		streamClient: client,
+	}
+
+	first, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), first.Index)
+
+	runStep(t, "a batch with no visible change blocks until the timeout", func(t *testing.T) {
+		start := time.Now()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			client.QueueEvents(newEventBatchWithEvents(
+				newEventServiceHealthRegister(10, 2, "web"),
+				newEventServiceHealthDeregister(10, 2, "web")))
+		}()
+
+		req.QueryOptions.MinQueryIndex = 5
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.True(t, time.Since(start) >= 200*time.Millisecond,
+			"Fetch should have blocked until timeout")
+
+		// The index is still tracked, so the next request blocks from the new
+		// index.
+		require.Equal(t, uint64(10), result.Index)
+		require.Equal(t, first.Hash, result.Hash)
+		require.Len(t, result.Value.(*structs.IndexedCheckServiceNodes).Nodes, 1)
+	})
+
+	runStep(t, "a visible change unblocks", func(t *testing.T) {
+		client.QueueEvents(newEventServiceHealthRegister(11, 2, "web"))
+
+		start := time.Now()
+		req.QueryOptions.MinQueryIndex = 10
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.True(t, time.Since(start) < 200*time.Millisecond,
+			"Fetch should have returned before the timeout")
+		require.Equal(t, uint64(11), result.Index)
+		require.Len(t, result.Value.(*structs.IndexedCheckServiceNodes).Nodes, 2)
+	})
+}
+
+func TestHealthView_IntegrationWithStore_HealthChangesOnly(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	store := submatview.NewStore(hclog.New(nil))
-	go store.Run(ctx)
 
-	// Initially there are no services registered. Server should send an
-	// EndOfSnapshot message immediately with index of 1.
-	streamClient.QueueEvents(newEndOfSnapshotEvent(1))
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEndOfSnapshotEvent(5))
 
 	req := serviceRequestStub{
 		serviceRequest: serviceRequest{
@@ -94,184 +1698,587 @@ func TestHealthView_IntegrationWithStore_WithEmptySnapshot(t *testing.T) {
 				Datacenter:     "dc1",
 				ServiceName:    "web",
 				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
-				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: 200 * time.Millisecond},
+				ViewOptions:    structs.ServiceViewOptions{HealthChangesOnly: true},
 			},
 		},
-		streamClient: streamClient,
+		streamClient: client,
 	}
-	empty := &structs.IndexedCheckServiceNodes{
-		Nodes: structs.CheckServiceNodes{},
-		QueryMeta: structs.QueryMeta{
-			Index:       1,
-			Backend:     structs.QueryBackendStreaming,
-			KnownLeader: true,
+
+	first, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), first.Index)
+
+	runStep(t, "a metadata change blocks until the timeout", func(t *testing.T) {
+		start := time.Now()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			update := newEventServiceHealthRegister(8, 2, "web")
+			update.GetServiceHealth().CheckServiceNode.Service.Meta = map[string]string{"version": "2"}
+			client.QueueEvents(update)
+		}()
+
+		req.QueryOptions.MinQueryIndex = 5
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.True(t, time.Since(start) >= 200*time.Millisecond,
+			"Fetch should have blocked until timeout")
+
+		// The index is still tracked, and the result includes the change.
+		require.Equal(t, uint64(8), result.Index)
+		nodes := result.Value.(*structs.IndexedCheckServiceNodes).Nodes
+		require.Len(t, nodes, 2)
+		for _, node := range nodes {
+			if node.Node.Node == "node2" {
+				require.Equal(t, "2", node.Service.Meta["version"])
+			}
+		}
+	})
+
+	runStep(t, "a health change unblocks", func(t *testing.T) {
+		update := newEventServiceHealthRegister(9, 2, "web")
+		update.GetServiceHealth().CheckServiceNode.Checks = []*pbservice.HealthCheck{
+			{Node: "node2", CheckID: "web", ServiceID: "web", Status: api.HealthCritical, RaftIndex: &pbcommon.RaftIndex{}},
+		}
+		client.QueueEvents(update)
+
+		start := time.Now()
+		req.QueryOptions.MinQueryIndex = 8
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.True(t, time.Since(start) < 200*time.Millisecond,
+			"Fetch should have returned before the timeout")
+		require.Equal(t, uint64(9), result.Index)
+		require.Len(t, result.Value.(*structs.IndexedCheckServiceNodes).Nodes, 2)
+	})
+}
+
+func TestHealthView_IntegrationWithStore_Delta(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+				ViewOptions:    structs.ServiceViewOptions{Delta: true},
+			},
 		},
+		streamClient: client,
 	}
 
-	runStep(t, "empty snapshot returned", func(t *testing.T) {
+	nodeNames := func(nodes structs.CheckServiceNodes) []string {
+		var names []string
+		for _, csn := range nodes {
+			names = append(names, csn.Node.Node)
+		}
+		return names
+	}
+
+	runStep(t, "first request returns all the nodes", func(t *testing.T) {
 		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
 
-		require.Equal(t, uint64(1), result.Index)
-		require.Equal(t, empty, result.Value)
+		delta := result.Value.(*structs.IndexedCheckServiceNodesDelta)
+		require.True(t, delta.Full)
+		require.Equal(t, []string{"node1", "node2"}, nodeNames(delta.Added))
+		require.Empty(t, delta.Changed)
+		require.Empty(t, delta.Removed)
+		require.Equal(t, uint64(5), delta.Index)
+		req.QueryOptions.MinQueryIndex = result.Index
+	})
+
+	// fullReq returns all the nodes, so that the deltas can be compared to the
+	// changes between two full results. It uses its own client, which receives
+	// the same events.
+	fullReq := req
+	fullReq.ViewOptions = structs.ServiceViewOptions{}
+	fullClient := newStreamClient(validateNamespace(namespace))
+	fullReq.streamClient = fullClient
+	fullClient.QueueEvents(
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEndOfSnapshotEvent(5))
+	fullResult := func(t *testing.T, index uint64) structs.CheckServiceNodes {
+		fullReq.QueryOptions.MinQueryIndex = index - 1
+		result, err := store.Get(ctx, fullReq)
+		require.NoError(t, err)
+		require.Equal(t, index, result.Index)
+		return result.Value.(*structs.IndexedCheckServiceNodes).Nodes
+	}
+
+	runStep(t, "next request returns the changes", func(t *testing.T) {
+		before := fullResult(t, 5)
 
+		updated := newEventServiceHealthRegister(8, 2, "web")
+		updated.GetServiceHealth().CheckServiceNode.Service.Port = 9090
+		batch := newEventBatchWithEvents(
+			newEventServiceHealthRegister(8, 3, "web"),
+			newEventServiceHealthDeregister(8, 1, "web"),
+			updated)
+		client.QueueEvents(batch)
+		fullClient.QueueEvents(batch)
+
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(8), result.Index)
+
+		delta := result.Value.(*structs.IndexedCheckServiceNodesDelta)
+		require.False(t, delta.Full)
+		require.Equal(t, []string{"node3"}, nodeNames(delta.Added))
+		require.Equal(t, []string{"node2"}, nodeNames(delta.Changed))
+		require.Equal(t, 9090, delta.Changed[0].Service.Port)
+		require.Equal(t, []string{"node1"}, nodeNames(delta.Removed))
+
+		diff := structs.DiffCheckServiceNodes(before, fullResult(t, 8))
+		require.Equal(t, diff.Added, delta.Added)
+		require.Equal(t, diff.Changed, delta.Changed)
+		require.Equal(t, diff.Removed, delta.Removed)
 		req.QueryOptions.MinQueryIndex = result.Index
 	})
 
-	runStep(t, "blocks for timeout", func(t *testing.T) {
-		// Subsequent fetch should block for the timeout
-		start := time.Now()
-		req.QueryOptions.MaxQueryTime = 200 * time.Millisecond
+	runStep(t, "changes are relative to the index of the request", func(t *testing.T) {
+		client.QueueEvents(newEventServiceHealthDeregister(9, 3, "web"))
+
 		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
-		elapsed := time.Since(start)
-		require.True(t, elapsed >= 200*time.Millisecond,
-			"Fetch should have blocked until timeout")
+		require.Equal(t, uint64(9), result.Index)
+
+		delta := result.Value.(*structs.IndexedCheckServiceNodesDelta)
+		require.False(t, delta.Full)
+		require.Empty(t, delta.Added)
+		require.Empty(t, delta.Changed)
+		require.Equal(t, []string{"node3"}, nodeNames(delta.Removed))
+
+		// A request from the first index receives all the changes since then.
+		// node3 was added and removed after that index, so it is not included.
+		req.QueryOptions.MinQueryIndex = 5
+		result, err = store.Get(ctx, req)
+		require.NoError(t, err)
+
+		delta = result.Value.(*structs.IndexedCheckServiceNodesDelta)
+		require.Empty(t, delta.Added)
+		require.Equal(t, []string{"node2"}, nodeNames(delta.Changed))
+		require.Equal(t, []string{"node1"}, nodeNames(delta.Removed))
+	})
+}
+
+func TestHealthView_ResultHash_IsOrderIndependent(t *testing.T) {
+	events := []*pbsubscribe.Event{
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEventServiceHealthRegister(5, 3, "web"),
+	}
+
+	v1, err := newHealthView(structs.ServiceSpecificRequest{})
+	require.NoError(t, err)
+	require.NoError(t, v1.Update(events))
+
+	v2, err := newHealthView(structs.ServiceSpecificRequest{})
+	require.NoError(t, err)
+	require.NoError(t, v2.Update([]*pbsubscribe.Event{events[2], events[0], events[1]}))
+
+	require.Equal(t, v1.ResultHash(), v2.ResultHash())
+}
+
+func TestHealthView_IntegrationWithStore_InvalidFilter(t *testing.T) {
+	var subscribed int32
+	client := newStreamClient(func(*pbsubscribe.SubscribeRequest) error {
+		atomic.AddInt32(&subscribed, 1)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:  "dc1",
+				ServiceName: "web",
+				QueryOptions: structs.QueryOptions{
+					MaxQueryTime: time.Second,
+					Filter:       "Service.Meta.version ==",
+				},
+			},
+		},
+		streamClient: client,
+	}
+
+	start := time.Now()
+	_, err := store.Get(ctx, req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `invalid filter "Service.Meta.version =="`)
+	require.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	require.Equal(t, int32(0), atomic.LoadInt32(&subscribed))
+}
+
+func TestHealthView_IntegrationWithStore_SeparateMaterializerPerToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	var revoked atomic.Value
+	revoked.Store("")
+	newRequest := func(token string) serviceRequestStub {
+		client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+			switch {
+			case req.Token != token:
+				return fmt.Errorf("expected request.Token %v, got %v", token, req.Token)
+			case req.Token == revoked.Load().(string):
+				return status.Error(codes.PermissionDenied, "Permission denied")
+			}
+			return nil
+		})
+		client.QueueEvents(
+			newEventServiceHealthRegister(5, 1, "web"),
+			newEndOfSnapshotEvent(5))
+		return serviceRequestStub{
+			serviceRequest: serviceRequest{
+				ServiceSpecificRequest: structs.ServiceSpecificRequest{
+					Datacenter:  "dc1",
+					ServiceName: "web",
+					QueryOptions: structs.QueryOptions{
+						Token:        token,
+						MaxQueryTime: time.Second,
+					},
+				},
+			},
+			streamClient: client,
+		}
+	}
+	reqA := newRequest("token-a")
+	reqB := newRequest("token-b")
+
+	for _, req := range []serviceRequestStub{reqA, reqB} {
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
+	}
+	require.Len(t, store.Subscriptions(), 2)
+
+	// Revoking token-a only fails the materializer for token-a.
+	revoked.Store("token-a")
+	reqA.streamClient.(*streamClient).QueueErr(status.Error(codes.PermissionDenied, "Permission denied"))
+	reqA.QueryOptions.MinQueryIndex = 5
+	retry.Run(t, func(r *retry.R) {
+		_, err := store.Get(ctx, reqA)
+		require.True(r, errors.Is(err, submatview.ErrSubscriptionACLDenied), "unexpected error %v", err)
+	})
+
+	reqB.streamClient.(*streamClient).QueueEvents(newEventServiceHealthRegister(6, 2, "web"))
+	reqB.QueryOptions.MinQueryIndex = 5
+	result, err := store.Get(ctx, reqB)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), result.Index)
+	require.Len(t, result.Value.(*structs.IndexedCheckServiceNodes).Nodes, 2)
+}
+
+func TestHealthView_IntegrationWithStore_SeparateMaterializerPerPartition(t *testing.T) {
+	partA, partB := getPartition("part-a"), getPartition("part-b")
+	if partA == partB {
+		t.Skip("admin partitions are an enterprise feature")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	newRequest := func(partition string, nodeNum int) serviceRequestStub {
+		client := newStreamClient(validatePartition(partition))
+		client.QueueEvents(
+			newEventServiceHealthRegister(5, nodeNum, "web"),
+			newEndOfSnapshotEvent(5))
+		return serviceRequestStub{
+			serviceRequest: serviceRequest{
+				ServiceSpecificRequest: structs.ServiceSpecificRequest{
+					Datacenter:     "dc1",
+					ServiceName:    "web",
+					EnterpriseMeta: structs.NewEnterpriseMetaWithPartition(partition, ""),
+					QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+				},
+			},
+			streamClient: client,
+		}
+	}
+	reqA := newRequest(partA, 1)
+	reqB := newRequest(partB, 2)
+	require.NotEqual(t, reqA.CacheInfo().Key, reqB.CacheInfo().Key)
+
+	result, err := store.Get(ctx, reqA)
+	require.NoError(t, err)
+	expected := newExpectedNodes("node1")
+	expected.Index = 5
+	prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+	result, err = store.Get(ctx, reqB)
+	require.NoError(t, err)
+	expected = newExpectedNodes("node2")
+	expected.Index = 5
+	prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+	require.Len(t, store.Subscriptions(), 2)
+
+	// An event in part-b is not visible to requests in part-a.
+	reqB.streamClient.(*streamClient).QueueEvents(newEventServiceHealthRegister(6, 3, "web"))
+	reqB.QueryOptions.MinQueryIndex = 5
+	result, err = store.Get(ctx, reqB)
+	require.NoError(t, err)
+	expected = newExpectedNodes("node2", "node3")
+	expected.Index = 6
+	prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+
+	result, err = store.Get(ctx, reqA)
+	require.NoError(t, err)
+	expected = newExpectedNodes("node1")
+	expected.Index = 5
+	prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+}
+
+func TestHealthView_DebugJSON(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newStreamClient(nil)
+	client.QueueEvents(
+		newEventServiceHealthRegister(5, 2, "web"),
+		newEventServiceHealthRegister(5, 1, "web"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:  "dc1",
+				ServiceName: "web",
+			},
+		},
+		streamClient: client,
+	}
+	m, err := req.NewMaterializer()
+	require.NoError(t, err)
+	go m.Run(ctx)
+
+	type dump struct {
+		Topic     string
+		Key       string
+		Index     uint64
+		Connected bool
+		Events    submatview.EventCounts
+		View      []debugNode
+	}
+
+	var state dump
+	retry.Run(t, func(r *retry.R) {
+		raw, err := m.DebugJSON()
+		require.NoError(r, err)
+		state = dump{}
+		require.NoError(r, json.Unmarshal(raw, &state))
+		require.Equal(r, uint64(5), state.Index)
+	})
+
+	require.Equal(t, "ServiceHealth", state.Topic)
+	require.Equal(t, "web", state.Key)
+	require.True(t, state.Connected)
+	require.Equal(t, submatview.EventCounts{Updates: 1, Events: 2}, state.Events)
+	require.Equal(t, []debugNode{
+		{
+			Node:      "node1",
+			NodeID:    "11111111-2222-3333-4444-000000000001",
+			ServiceID: "web",
+			Status:    api.HealthPassing,
+		},
+		{
+			Node:      "node2",
+			NodeID:    "11111111-2222-3333-4444-000000000002",
+			ServiceID: "web",
+			Status:    api.HealthPassing,
+		},
+	}, state.View)
+}
+
+func TestHealthView_IntegrationWithStore_FilterOnServiceMeta(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := submatview.NewStore(hclog.New(nil))
+
+	withVersion := func(e *pbsubscribe.Event, version string) *pbsubscribe.Event {
+		e.GetServiceHealth().CheckServiceNode.Service.Meta = map[string]string{"version": version}
+		return e
+	}
+
+	client.QueueEvents(
+		withVersion(newEventServiceHealthRegister(5, 1, "web"), "v1"),
+		withVersion(newEventServiceHealthRegister(5, 2, "web"), "v2"),
+		newEndOfSnapshotEvent(5))
+
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:     "dc1",
+				ServiceName:    "web",
+				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				QueryOptions: structs.QueryOptions{
+					MaxQueryTime: time.Second,
+					Filter:       `Service.Meta.version == "v2"`,
+				},
+			},
+		},
+		streamClient: client,
+	}
 
-		require.Equal(t, req.QueryOptions.MinQueryIndex, result.Index, "result index should not have changed")
-		require.Equal(t, empty, result.Value, "result value should not have changed")
+	runStep(t, "snapshot is filtered", func(t *testing.T) {
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
 
+		expected := newExpectedNodes("node2")
+		expected.Index = 5
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
 		req.QueryOptions.MinQueryIndex = result.Index
 	})
 
-	var lastResultValue structs.CheckServiceNodes
-
-	runStep(t, "blocks until update", func(t *testing.T) {
-		// Make another blocking query with a longer timeout and trigger an update
-		// event part way through.
-		start := time.Now()
-		go func() {
-			time.Sleep(200 * time.Millisecond)
-			streamClient.QueueEvents(newEventServiceHealthRegister(4, 1, "web"))
-		}()
+	runStep(t, "node enters the result when its meta matches", func(t *testing.T) {
+		client.QueueEvents(withVersion(newEventServiceHealthRegister(8, 1, "web"), "v2"))
 
-		req.QueryOptions.MaxQueryTime = time.Second
 		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
-		elapsed := time.Since(start)
-		require.True(t, elapsed >= 200*time.Millisecond,
-			"Fetch should have blocked until the event was delivered")
-		require.True(t, elapsed < time.Second,
-			"Fetch should have returned before the timeout")
-
-		require.Equal(t, uint64(4), result.Index, "result index should not have changed")
-		lastResultValue = result.Value.(*structs.IndexedCheckServiceNodes).Nodes
-		require.Len(t, lastResultValue, 1,
-			"result value should contain the new registration")
+		require.Equal(t, uint64(8), result.Index)
 
+		expected := newExpectedNodes("node1", "node2")
+		expected.Index = 8
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
 		req.QueryOptions.MinQueryIndex = result.Index
 	})
 
-	runStep(t, "reconnects and resumes after temporary error", func(t *testing.T) {
-		streamClient.QueueErr(tempError("broken pipe"))
+	runStep(t, "node leaves the result when its meta no longer matches", func(t *testing.T) {
+		client.QueueEvents(withVersion(newEventServiceHealthRegister(9, 2, "web"), "v3"))
 
-		// Next fetch will continue to block until timeout and receive the same
-		// result.
-		start := time.Now()
-		req.QueryOptions.MaxQueryTime = 200 * time.Millisecond
 		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
-		elapsed := time.Since(start)
-		require.True(t, elapsed >= 200*time.Millisecond,
-			"Fetch should have blocked until timeout")
+		require.Equal(t, uint64(9), result.Index)
 
-		require.Equal(t, req.QueryOptions.MinQueryIndex, result.Index,
-			"result index should not have changed")
-		require.Equal(t, lastResultValue, result.Value.(*structs.IndexedCheckServiceNodes).Nodes,
-			"result value should not have changed")
+		expected := newExpectedNodes("node1")
+		expected.Index = 9
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+	})
+}
 
-		req.QueryOptions.MinQueryIndex = result.Index
+func TestHealthView_IntegrationWithStore_NodeMetaFilters(t *testing.T) {
+	namespace := getNamespace("ns2")
+	client := newStreamClient(validateNamespace(namespace))
 
-		// But an update should still be noticed due to reconnection
-		streamClient.QueueEvents(newEventServiceHealthRegister(10, 2, "web"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		start = time.Now()
-		req.QueryOptions.MaxQueryTime = time.Second
-		result, err = store.Get(ctx, req)
-		require.NoError(t, err)
-		elapsed = time.Since(start)
-		require.True(t, elapsed < time.Second,
-			"Fetch should have returned before the timeout")
+	store := submatview.NewStore(hclog.New(nil))
 
-		require.Equal(t, uint64(10), result.Index, "result index should not have changed")
-		lastResultValue = result.Value.(*structs.IndexedCheckServiceNodes).Nodes
-		require.Len(t, lastResultValue, 2,
-			"result value should contain the new registration")
+	withRack := func(e *pbsubscribe.Event, rack string) *pbsubscribe.Event {
+		e.GetServiceHealth().CheckServiceNode.Node.Meta = map[string]string{"rack": rack}
+		return e
+	}
 
-		req.QueryOptions.MinQueryIndex = result.Index
-	})
+	client.QueueEvents(
+		withRack(newEventServiceHealthRegister(5, 1, "web"), "r1"),
+		withRack(newEventServiceHealthRegister(5, 2, "web"), "r2"),
+		newEventServiceHealthRegister(5, 3, "web"),
+		newEndOfSnapshotEvent(5))
 
-	runStep(t, "returns non-temporary error to watchers", func(t *testing.T) {
-		// Wait and send the error while fetcher is waiting
-		go func() {
-			time.Sleep(200 * time.Millisecond)
-			streamClient.QueueErr(errors.New("invalid request"))
-		}()
+	req := serviceRequestStub{
+		serviceRequest: serviceRequest{
+			ServiceSpecificRequest: structs.ServiceSpecificRequest{
+				Datacenter:      "dc1",
+				ServiceName:     "web",
+				EnterpriseMeta:  structs.NewEnterpriseMetaInDefaultPartition(namespace),
+				NodeMetaFilters: map[string]string{"rack": "r2"},
+				QueryOptions:    structs.QueryOptions{MaxQueryTime: time.Second},
+			},
+		},
+		streamClient: client,
+	}
 
-		// Next fetch should return the error
-		start := time.Now()
-		req.QueryOptions.MaxQueryTime = time.Second
+	runStep(t, "snapshot is filtered", func(t *testing.T) {
 		result, err := store.Get(ctx, req)
-		require.Error(t, err)
-		elapsed := time.Since(start)
-		require.True(t, elapsed >= 200*time.Millisecond,
-			"Fetch should have blocked until error was sent")
-		require.True(t, elapsed < time.Second,
-			"Fetch should have returned before the timeout")
-
-		require.Equal(t, req.QueryOptions.MinQueryIndex, result.Index, "result index should not have changed")
-		require.Equal(t, lastResultValue, result.Value.(*structs.IndexedCheckServiceNodes).Nodes)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
 
+		expected := newExpectedNodes("node2")
+		expected.Index = 5
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
 		req.QueryOptions.MinQueryIndex = result.Index
+	})
 
-		// But an update should still be noticed due to reconnection
-		streamClient.QueueEvents(newEventServiceHealthRegister(req.QueryOptions.MinQueryIndex+5, 3, "web"))
+	runStep(t, "node enters the result when its node meta matches", func(t *testing.T) {
+		client.QueueEvents(withRack(newEventServiceHealthRegister(8, 3, "web"), "r2"))
 
-		req.QueryOptions.MaxQueryTime = time.Second
-		result, err = store.Get(ctx, req)
+		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
-		elapsed = time.Since(start)
-		require.True(t, elapsed < time.Second, "Fetch should have returned before the timeout")
-
-		require.Equal(t, req.QueryOptions.MinQueryIndex+5, result.Index, "result index should not have changed")
-		require.Len(t, result.Value.(*structs.IndexedCheckServiceNodes).Nodes, 3,
-			"result value should contain the new registration")
+		require.Equal(t, uint64(8), result.Index)
 
+		expected := newExpectedNodes("node2", "node3")
+		expected.Index = 8
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
 		req.QueryOptions.MinQueryIndex = result.Index
 	})
-}
 
-type tempError string
+	runStep(t, "node leaves the result when its node meta no longer matches", func(t *testing.T) {
+		client.QueueEvents(withRack(newEventServiceHealthRegister(9, 2, "web"), "r1"))
 
-func (e tempError) Error() string {
-	return string(e)
-}
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(9), result.Index)
 
-func (e tempError) Temporary() bool {
-	return true
+		expected := newExpectedNodes("node3")
+		expected.Index = 9
+		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+	})
 }
 
-func TestHealthView_IntegrationWithStore_WithFullSnapshot(t *testing.T) {
+func TestHealthView_IntegrationWithStore_MaxStaleDurationWhileDisconnected(t *testing.T) {
 	if testing.Short() {
 		t.Skip("too slow for testing.Short")
 	}
 
 	namespace := getNamespace("ns2")
-	client := newStreamClient(validateNamespace(namespace))
+	validate := validateNamespace(namespace)
+	var broken int32
+	client := newStreamClient(func(req *pbsubscribe.SubscribeRequest) error {
+		if atomic.LoadInt32(&broken) == 1 {
+			return tempError("connection refused")
+		}
+		return validate(req)
+	})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	store := submatview.NewStore(hclog.New(nil))
 
-	// Create an initial snapshot of 3 instances on different nodes
-	registerServiceWeb := func(index uint64, nodeNum int) *pbsubscribe.Event {
-		return newEventServiceHealthRegister(index, nodeNum, "web")
-	}
 	client.QueueEvents(
-		registerServiceWeb(5, 1),
-		registerServiceWeb(5, 2),
-		registerServiceWeb(5, 3),
+		newEventServiceHealthRegister(5, 1, "web"),
 		newEndOfSnapshotEvent(5))
 
 	req := serviceRequestStub{
@@ -280,105 +2287,64 @@ func TestHealthView_IntegrationWithStore_WithFullSnapshot(t *testing.T) {
 				Datacenter:     "dc1",
 				ServiceName:    "web",
 				EnterpriseMeta: structs.NewEnterpriseMetaInDefaultPartition(namespace),
-				QueryOptions:   structs.QueryOptions{MaxQueryTime: time.Second},
+				QueryOptions: structs.QueryOptions{
+					MaxQueryTime:     time.Second,
+					AllowStale:       true,
+					MaxStaleDuration: 200 * time.Millisecond,
+				},
 			},
 		},
 		streamClient: client,
 	}
 
-	runStep(t, "full snapshot returned", func(t *testing.T) {
+	runStep(t, "snapshot returned", func(t *testing.T) {
 		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
-
 		require.Equal(t, uint64(5), result.Index)
-		expected := newExpectedNodes("node1", "node2", "node3")
-		expected.Index = 5
-		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
-
-		req.QueryOptions.MinQueryIndex = result.Index
 	})
 
-	runStep(t, "blocks until deregistration", func(t *testing.T) {
-		// Make another blocking query with a longer timeout and trigger an update
-		// event part way through.
-		start := time.Now()
-		go func() {
-			time.Sleep(200 * time.Millisecond)
-
-			// Deregister instance on node1
-			client.QueueEvents(newEventServiceHealthDeregister(20, 1, "web"))
-		}()
+	runStep(t, "stale result returned within max stale duration", func(t *testing.T) {
+		atomic.StoreInt32(&broken, 1)
+		client.QueueErr(tempError("broken pipe"))
+		time.Sleep(50 * time.Millisecond)
 
-		req.QueryOptions.MaxQueryTime = time.Second
 		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
-		elapsed := time.Since(start)
-		require.True(t, elapsed >= 200*time.Millisecond,
-			"Fetch should have blocked until the event was delivered")
-		require.True(t, elapsed < time.Second,
-			"Fetch should have returned before the timeout")
-
-		require.Equal(t, uint64(20), result.Index)
-		expected := newExpectedNodes("node2", "node3")
-		expected.Index = 20
-		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
-
-		req.QueryOptions.MinQueryIndex = result.Index
+		require.Equal(t, uint64(5), result.Index)
 	})
 
-	runStep(t, "server reload is respected", func(t *testing.T) {
-		// Simulates the server noticing the request's ACL token privs changing. To
-		// detect this we'll queue up the new snapshot as a different set of nodes
-		// to the first.
-		client.QueueErr(status.Error(codes.Aborted, "reset by server"))
-
-		client.QueueEvents(
-			registerServiceWeb(50, 3), // overlap existing node
-			registerServiceWeb(50, 4),
-			registerServiceWeb(50, 5),
-			newEndOfSnapshotEvent(50))
+	runStep(t, "error returned after max stale duration", func(t *testing.T) {
+		time.Sleep(250 * time.Millisecond)
 
-		// Make another blocking query with THE SAME index. It should immediately
-		// return the new snapshot.
-		start := time.Now()
-		req.QueryOptions.MaxQueryTime = time.Second
 		result, err := store.Get(ctx, req)
-		require.NoError(t, err)
-		elapsed := time.Since(start)
-		require.True(t, elapsed < time.Second,
-			"Fetch should have returned before the timeout")
+		require.True(t, errors.Is(err, submatview.ErrViewStale), "unexpected error: %v", err)
+		require.Equal(t, uint64(5), result.Index)
+	})
 
-		require.Equal(t, uint64(50), result.Index)
-		expected := newExpectedNodes("node3", "node4", "node5")
-		expected.Index = 50
-		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+	runStep(t, "stale result returned without max stale duration", func(t *testing.T) {
+		other := req
+		other.QueryOptions.AllowStale = false
+		other.QueryOptions.MaxAge = 100 * time.Millisecond
+		result, err := store.Get(ctx, other)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
 
-		req.QueryOptions.MinQueryIndex = result.Index
+		other.QueryOptions.AllowStale = true
+		other.QueryOptions.MaxStaleDuration = 0
+		result, err = store.Get(ctx, other)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
 	})
 
-	runStep(t, "reconnects and receives new snapshot when server state has changed", func(t *testing.T) {
-		client.QueueErr(tempError("temporary connection error"))
-
-		client.QueueEvents(
-			newNewSnapshotToFollowEvent(),
-			registerServiceWeb(50, 3), // overlap existing node
-			registerServiceWeb(50, 4),
-			registerServiceWeb(50, 5),
-			newEndOfSnapshotEvent(50))
+	runStep(t, "no error after reconnecting", func(t *testing.T) {
+		atomic.StoreInt32(&broken, 0)
+		client.QueueEvents(newEventServiceHealthRegister(10, 2, "web"))
 
-		start := time.Now()
-		req.QueryOptions.MinQueryIndex = 49
-		req.QueryOptions.MaxQueryTime = time.Second
+		req.QueryOptions.MinQueryIndex = 5
+		req.QueryOptions.MaxQueryTime = 5 * time.Second
 		result, err := store.Get(ctx, req)
 		require.NoError(t, err)
-		elapsed := time.Since(start)
-		require.True(t, elapsed < time.Second,
-			"Fetch should have returned before the timeout")
-
-		require.Equal(t, uint64(50), result.Index)
-		expected := newExpectedNodes("node3", "node4", "node5")
-		expected.Index = 50
-		prototest.AssertDeepEqual(t, expected, result.Value, cmpCheckServiceNodeNames)
+		require.Equal(t, uint64(10), result.Index)
 	})
 }
 
@@ -532,6 +2498,80 @@ func TestHealthView_IntegrationWithStore_Filtering(t *testing.T) {
 	})
 }
 
+func TestPoolStreamClient_Subscribe(t *testing.T) {
+	srv := grpc.NewServer()
+	pbsubscribe.RegisterStateChangeSubscriptionServer(srv, snapshotSubscriptionServer{})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	pool := &fakeClientConnPool{conn: conn}
+	client := NewPoolStreamClient(pool, "dc1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runStep(t, "pool errors are retried as unavailable", func(t *testing.T) {
+		pool.err = errors.New("no servers")
+		_, err := client.Subscribe(ctx, &pbsubscribe.SubscribeRequest{Key: "web"})
+		require.Equal(t, codes.Unavailable, status.Code(err))
+		require.Contains(t, err.Error(), "no servers")
+	})
+
+	runStep(t, "subscribes on the pooled connection", func(t *testing.T) {
+		pool.err = nil
+		sub, err := client.Subscribe(ctx, &pbsubscribe.SubscribeRequest{Key: "web"})
+		require.NoError(t, err)
+
+		event, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, "web", event.GetServiceHealth().GetCheckServiceNode().GetService().GetService())
+
+		event, err = sub.Recv()
+		require.NoError(t, err)
+		require.True(t, event.GetEndOfSnapshot())
+	})
+
+	require.Equal(t, []string{"dc1", "dc1"}, pool.datacenters)
+}
+
+type fakeClientConnPool struct {
+	conn        *grpc.ClientConn
+	err         error
+	datacenters []string
+}
+
+func (p *fakeClientConnPool) ClientConn(datacenter string) (*grpc.ClientConn, error) {
+	p.datacenters = append(p.datacenters, datacenter)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.conn, nil
+}
+
+// snapshotSubscriptionServer sends a snapshot with one instance of the service
+// of the request, and then waits for the subscription to be closed.
+type snapshotSubscriptionServer struct{}
+
+func (snapshotSubscriptionServer) Subscribe(
+	req *pbsubscribe.SubscribeRequest,
+	sub pbsubscribe.StateChangeSubscription_SubscribeServer,
+) error {
+	if err := sub.Send(newEventServiceHealthRegister(5, 1, req.Key)); err != nil {
+		return err
+	}
+	if err := sub.Send(newEndOfSnapshotEvent(5)); err != nil {
+		return err
+	}
+	<-sub.Context().Done()
+	return nil
+}
+
 // serviceRequestStub overrides NewMaterializer so that test can use a fake
 // StreamClient.
 type serviceRequestStub struct {
@@ -668,6 +2708,20 @@ func validateNamespace(ns string) func(request *pbsubscribe.SubscribeRequest) er
 	}
 }
 
+func getPartition(partition string) string {
+	meta := structs.NewEnterpriseMetaWithPartition(partition, "")
+	return meta.PartitionOrEmpty()
+}
+
+func validatePartition(partition string) func(request *pbsubscribe.SubscribeRequest) error {
+	return func(request *pbsubscribe.SubscribeRequest) error {
+		if request.Partition != partition {
+			return fmt.Errorf("expected request.Partition %v, got %v", partition, request.Partition)
+		}
+		return nil
+	}
+}
+
 func runStep(t *testing.T, name string, fn func(t *testing.T)) {
 	t.Helper()
 	if !t.Run(name, fn) {
@@ -23,6 +23,7 @@ import (
 	"github.com/hashicorp/consul/agent/pool"
 	"github.com/hashicorp/consul/agent/router"
 	"github.com/hashicorp/consul/agent/rpc/middleware"
+	"github.com/hashicorp/consul/agent/rpcclient/health"
 	"github.com/hashicorp/consul/agent/submatview"
 	"github.com/hashicorp/consul/agent/token"
 	"github.com/hashicorp/consul/agent/xds"
@@ -112,10 +113,12 @@ func NewBaseDeps(configLoader ConfigLoader, logOut io.Writer) (BaseDeps, error)
 		Servers:               builder,
 		SrcAddr:               d.ConnPool.SrcAddr,
 		TLSWrapper:            grpc.TLSWrapper(d.TLSConfigurator.OutgoingRPCWrapper()),
+		ServerNameTLSWrapper:  grpc.ServerNameTLSWrapper(d.TLSConfigurator.OutgoingRPCServerNameWrapper()),
 		ALPNWrapper:           grpc.ALPNWrapper(d.TLSConfigurator.OutgoingALPNRPCWrapper()),
 		UseTLSForDC:           d.TLSConfigurator.UseTLS,
 		DialingFromServer:     cfg.ServerMode,
 		DialingFromDatacenter: cfg.Datacenter,
+		RPCPinger:             d.ConnPool,
 	})
 	d.LeaderForwarder = builder
 
@@ -221,6 +224,7 @@ func getPrometheusDefs(cfg lib.TelemetryConfig, isServer bool) ([]prometheus.Gau
 		consul.ReplicationGauges,
 		CertExpirationGauges,
 		Gauges,
+		submatview.Gauges,
 		raftGauges,
 		serverGauges,
 	}
@@ -272,6 +276,8 @@ func getPrometheusDefs(cfg lib.TelemetryConfig, isServer bool) ([]prometheus.Gau
 		consul.RPCCounters,
 		grpc.StatsCounters,
 		local.StateCounters,
+		health.Counters,
+		submatview.Counters,
 		raftCounters,
 	}
 	// Flatten definitions
@@ -325,6 +331,7 @@ func getPrometheusDefs(cfg lib.TelemetryConfig, isServer bool) ([]prometheus.Gau
 		consul.TxnSummaries,
 		fsm.CommandsSummaries,
 		fsm.SnapshotSummaries,
+		health.Summaries,
 		raftSummaries,
 	}
 	// Flatten definitions
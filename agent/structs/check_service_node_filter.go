@@ -0,0 +1,95 @@
+package structs
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/go-bexpr"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// AggregatedStatus returns the health status of the node aggregated from the
+// statuses of its checks: api.HealthPassing, api.HealthWarning, or
+// api.HealthCritical. mode controls how the statuses are aggregated.
+func (csn *CheckServiceNode) AggregatedStatus(mode HealthAggregation) string {
+	status := api.HealthPassing
+	for _, check := range csn.Checks {
+		if mode == HealthAggregationIgnoreNodeChecks && check.ServiceID == "" {
+			continue
+		}
+		switch check.Status {
+		case api.HealthCritical:
+			return api.HealthCritical
+		case api.HealthWarning:
+			if mode != HealthAggregationWarningAsPassing {
+				status = api.HealthWarning
+			}
+		}
+	}
+	return status
+}
+
+// CheckServiceNodeFilter is a bexpr filter for CheckServiceNodes. It supports
+// the same selectors as CheckServiceNode, and Status, the status of the node
+// returned by AggregatedStatus. It is used by both the servers and the
+// streaming backend, so that a filter has the same result with either.
+type CheckServiceNodeFilter struct {
+	evaluator *bexpr.Evaluator
+	mode      HealthAggregation
+}
+
+// filterableCheckServiceNode is the type evaluated by CheckServiceNodeFilter.
+type filterableCheckServiceNode struct {
+	Node    *Node
+	Service *NodeService
+	Checks  HealthChecks
+	// Status is api.HealthPassing, api.HealthWarning, or api.HealthCritical.
+	Status string
+}
+
+// NewCheckServiceNodeFilter returns a filter for the expression. The Status
+// selector is aggregated using mode. An empty expression matches every node.
+func NewCheckServiceNodeFilter(expression string, mode HealthAggregation) (*CheckServiceNodeFilter, error) {
+	f := &CheckServiceNodeFilter{mode: mode}
+	if expression == "" {
+		return f, nil
+	}
+	e, err := bexpr.CreateEvaluatorForType(expression, nil, reflect.TypeOf(filterableCheckServiceNode{}))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create boolean expression evaluator: %v", err)
+	}
+	f.evaluator = e
+	return f, nil
+}
+
+// Match returns true if csn matches the filter.
+func (f *CheckServiceNodeFilter) Match(csn CheckServiceNode) (bool, error) {
+	if f.evaluator == nil {
+		return true, nil
+	}
+	return f.evaluator.Evaluate(filterableCheckServiceNode{
+		Node:    csn.Node,
+		Service: csn.Service,
+		Checks:  csn.Checks,
+		Status:  csn.AggregatedStatus(f.mode),
+	})
+}
+
+// Execute returns the nodes which match the filter. nodes is not modified.
+func (f *CheckServiceNodeFilter) Execute(nodes CheckServiceNodes) (CheckServiceNodes, error) {
+	if f.evaluator == nil {
+		return nodes, nil
+	}
+	result := make(CheckServiceNodes, 0, len(nodes))
+	for _, csn := range nodes {
+		ok, err := f.Match(csn)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, csn)
+		}
+	}
+	return result, nil
+}
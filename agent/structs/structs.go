@@ -664,10 +664,123 @@ type ServiceSpecificRequest struct {
 	// Ingress if true will only search for Ingress gateways for the given service.
 	Ingress bool
 
+	// ViewOptions customize how the agent builds the result of the request.
+	// The servers only use HealthAggregation, for the Status selector of the
+	// filter.
+	ViewOptions ServiceViewOptions
+
+	// IndexFloor is the lowest index of a result which satisfies the request.
+	// It may be set to the index of a write to read a result which includes
+	// that write. Unlike MinQueryIndex, a result whose index is equal to
+	// IndexFloor is returned without waiting for another change.
+	IndexFloor uint64
+
 	acl.EnterpriseMeta `hcl:",squash" mapstructure:",squash"`
 	QueryOptions
 }
 
+// ServiceViewOptions are agent-local options used by the streaming backend
+// when materializing the result of a ServiceSpecificRequest. When the request
+// is served by the cache or an RPC, the agent applies SortByHealth,
+// OnlyPassing, and ServiceChecksOnly to the result, and the options which are
+// only supported by the streaming backend return an error. The zero value
+// produces the same result as the Health.ServiceNodes endpoint.
+type ServiceViewOptions struct {
+	// SortByHealth groups the nodes by their aggregated health status, with
+	// passing nodes first and critical nodes last. Within each group the nodes
+	// keep the default order.
+	SortByHealth bool
+
+	// OnlyPassing excludes nodes whose aggregated health status is not passing.
+	OnlyPassing bool
+
+	// HealthAggregation controls how the statuses of the checks of a node are
+	// aggregated into the health status used by SortByHealth, OnlyPassing, and
+	// the Status selector of the filter.
+	HealthAggregation HealthAggregation
+
+	// SkipSort returns the nodes without sorting them, which avoids the cost of
+	// sorting large results on every update. The order of the nodes is not
+	// stable and may change between two results with the same nodes. SkipSort
+	// is ignored when SortByHealth is set.
+	SkipSort bool
+
+	// ArrivalOrder returns the nodes in the order they were first added to the
+	// view by the events received from the servers, instead of sorting them.
+	// It is cheaper than sorting, and unlike SkipSort the order is stable
+	// between results. An instance which is updated keeps its position, and an
+	// instance which is removed and added again is moved to the end.
+	// ArrivalOrder is ignored when SortByHealth is set. It is only supported by
+	// the streaming backend.
+	ArrivalOrder bool
+
+	// AllowPartial excludes service instances which can not be processed,
+	// instead of failing the whole request. When an instance is excluded the
+	// result has Degraded set, and its ID is added to Skipped.
+	AllowPartial bool
+
+	// Delta returns an IndexedCheckServiceNodesDelta with the changes since the
+	// MinQueryIndex of the request, instead of all the nodes.
+	Delta bool
+
+	// IncludeProto also returns the nodes in the protobuf form they were
+	// received in from the servers, so that callers which forward the result
+	// over gRPC do not have to convert the nodes back from the struct form.
+	IncludeProto bool
+
+	// IDsOnly returns only the sorted "node/serviceID" of each instance,
+	// instead of the nodes, for callers which only need to detect changes to
+	// the instances of the service.
+	IDsOnly bool
+
+	// HealthChangesOnly only wakes up blocking queries when the set of
+	// instances whose aggregated health status is passing changes. Other
+	// changes, such as to the metadata or weights of the instances, still
+	// advance the index of the view, but are only returned with the next
+	// change to the healthy instances, or when the query times out. It is
+	// only supported by the streaming backend.
+	HealthChangesOnly bool
+
+	// ServiceChecksOnly excludes the checks of the node from the Checks of
+	// each instance, so that only the checks of the service are returned. The
+	// checks of the node are still used by the filter of the request, and to
+	// aggregate the health status used by SortByHealth and OnlyPassing.
+	ServiceChecksOnly bool
+
+	// RequireLeader requires the subscription used to materialize the result to
+	// be served by the leader, so that the result is not materialized from a
+	// stale follower. Subscriptions served by another server are retried. It
+	// is only supported by the streaming backend.
+	RequireLeader bool
+}
+
+// HealthAggregation is a strategy for aggregating the statuses of the checks
+// of a CheckServiceNode into a single health status.
+type HealthAggregation string
+
+const (
+	// HealthAggregationStrict uses the worst status of all the checks.
+	HealthAggregationStrict HealthAggregation = ""
+
+	// HealthAggregationWarningAsPassing uses the worst status of all the checks,
+	// but treats warning checks as passing.
+	HealthAggregationWarningAsPassing HealthAggregation = "warning-as-passing"
+
+	// HealthAggregationIgnoreNodeChecks uses the worst status of the service
+	// checks, ignoring the checks of the node.
+	HealthAggregationIgnoreNodeChecks HealthAggregation = "ignore-node-checks"
+)
+
+// Validate returns an error if a is not one of the HealthAggregation constants.
+func (a HealthAggregation) Validate() error {
+	switch a {
+	case HealthAggregationStrict, HealthAggregationWarningAsPassing, HealthAggregationIgnoreNodeChecks:
+		return nil
+	default:
+		return fmt.Errorf("unknown health aggregation %q", string(a))
+	}
+}
+
 func (r *ServiceSpecificRequest) RequestDatacenter() string {
 	return r.Datacenter
 }
@@ -680,6 +793,10 @@ func (r *ServiceSpecificRequest) CacheInfo() cache.RequestInfo {
 		Timeout:        r.MaxQueryTime,
 		MaxAge:         r.MaxAge,
 		MustRevalidate: r.MustRevalidate,
+		IndexFloor:     r.IndexFloor,
+	}
+	if r.AllowStale {
+		info.MaxStaleDuration = r.MaxStaleDuration
 	}
 
 	// To calculate the cache key we hash over all the fields that affect the
@@ -709,6 +826,7 @@ func (r *ServiceSpecificRequest) CacheInfo() cache.RequestInfo {
 		r.EnterpriseMeta,
 		r.Ingress,
 		r.ServiceKind,
+		r.ViewOptions,
 	}, nil)
 	if err == nil {
 		// If there is an error, we don't set the key. A blank key forces
@@ -724,6 +842,33 @@ func (r *ServiceSpecificRequest) CacheMinIndex() uint64 {
 	return r.QueryOptions.MinQueryIndex
 }
 
+// NewServiceSpecificRequest returns a ServiceSpecificRequest for the nodes of
+// the service in the datacenter. The EnterpriseMeta is defaulted and
+// normalized the same way as NewServiceName, so that requests for the same
+// service share the same cache key. UseCache is set so that the request is
+// served by the agent cache or the streaming backend. An error is returned if
+// the datacenter or the service name are missing.
+func NewServiceSpecificRequest(datacenter, serviceName string, entMeta *acl.EnterpriseMeta) (ServiceSpecificRequest, error) {
+	switch {
+	case datacenter == "":
+		return ServiceSpecificRequest{}, fmt.Errorf("missing datacenter")
+	case serviceName == "":
+		return ServiceSpecificRequest{}, fmt.Errorf("missing service name")
+	}
+	if entMeta == nil {
+		entMeta = DefaultEnterpriseMetaInDefaultPartition()
+	}
+
+	req := ServiceSpecificRequest{
+		Datacenter:     datacenter,
+		ServiceName:    serviceName,
+		EnterpriseMeta: *entMeta,
+		QueryOptions:   QueryOptions{UseCache: true},
+	}
+	req.EnterpriseMeta.Normalize()
+	return req, nil
+}
+
 // NodeSpecificRequest is used to request the information about a single node
 type NodeSpecificRequest struct {
 	Datacenter         string
@@ -1869,6 +2014,56 @@ OUTER:
 	return nodes[:n]
 }
 
+// DiffCheckServiceNodes returns the changes from the nodes in a to the nodes in
+// b, in the same form as the result of a request with ServiceViewOptions.Delta
+// set. Instances are matched by their partition, node name, namespace, and
+// service ID, so the order of the nodes does not matter. Added and Changed
+// contain the instances from b, and Removed the instances from a. The nodes
+// in each list are sorted by node name and service ID.
+func DiffCheckServiceNodes(a, b CheckServiceNodes) IndexedCheckServiceNodesDelta {
+	before := make(map[string]CheckServiceNode, len(a))
+	for _, csn := range a {
+		before[checkServiceNodeKey(csn)] = csn
+	}
+
+	var diff IndexedCheckServiceNodesDelta
+	for _, csn := range b {
+		key := checkServiceNodeKey(csn)
+		prev, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, csn)
+		case !reflect.DeepEqual(prev, csn):
+			diff.Changed = append(diff.Changed, csn)
+		}
+		delete(before, key)
+	}
+	for _, csn := range before {
+		diff.Removed = append(diff.Removed, csn)
+	}
+
+	for _, nodes := range []CheckServiceNodes{diff.Added, diff.Changed, diff.Removed} {
+		sort.Slice(nodes, func(i, j int) bool {
+			return checkServiceNodeKey(nodes[i]) < checkServiceNodeKey(nodes[j])
+		})
+	}
+	return diff
+}
+
+// checkServiceNodeKey identifies the service instance of csn in
+// DiffCheckServiceNodes.
+func checkServiceNodeKey(csn CheckServiceNode) string {
+	var partition, node string
+	if csn.Node != nil {
+		partition, node = csn.Node.PartitionOrDefault(), csn.Node.Node
+	}
+	var service string
+	if csn.Service != nil {
+		service = csn.Service.CompoundServiceID().String()
+	}
+	return partition + "/" + UniqueID(node, service)
+}
+
 // NodeInfo is used to dump all associated information about
 // a node. This is currently used for the UI only, as it is
 // rather expensive to generate.
@@ -2056,6 +2251,41 @@ type IndexedHealthChecks struct {
 
 type IndexedCheckServiceNodes struct {
 	Nodes CheckServiceNodes
+
+	// Degraded is true when some service instances were excluded from Nodes
+	// because they could not be processed. It is only set by the streaming
+	// backend when ServiceViewOptions.AllowPartial is set.
+	Degraded bool `json:",omitempty"`
+	// Skipped contains the unique IDs of the service instances which were
+	// excluded from Nodes when Degraded is true.
+	Skipped []string `json:",omitempty"`
+
+	// Truncated is true when some service instances were excluded from Nodes
+	// because the view reached the maximum number of instances. It is only set
+	// by the streaming backend.
+	Truncated bool `json:",omitempty"`
+
+	QueryMeta
+}
+
+// IndexedCheckServiceNodesDelta is the result of a streaming health request
+// with ServiceViewOptions.Delta set. It contains the changes to the nodes
+// since the MinQueryIndex of the request.
+type IndexedCheckServiceNodesDelta struct {
+	// Full is true when the changes since MinQueryIndex are not known, because
+	// MinQueryIndex is 0 or older than the changes which are retained. Added
+	// then contains all the nodes, which replace the nodes of earlier results.
+	Full bool
+
+	// Added are the nodes which were added since MinQueryIndex.
+	Added CheckServiceNodes
+	// Changed are the new values of the nodes which were updated since
+	// MinQueryIndex.
+	Changed CheckServiceNodes
+	// Removed are the last values of the nodes which were removed since
+	// MinQueryIndex.
+	Removed CheckServiceNodes
+
 	QueryMeta
 }
 
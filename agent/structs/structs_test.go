@@ -1543,6 +1543,46 @@ func TestCheckServiceNodes_Shuffle(t *testing.T) {
 	}
 }
 
+func TestDiffCheckServiceNodes(t *testing.T) {
+	newNode := func(node, service string, status string) CheckServiceNode {
+		return CheckServiceNode{
+			Node:    &Node{Node: node, Address: "127.0.0.1"},
+			Service: &NodeService{ID: service, Service: "web"},
+			Checks: HealthChecks{
+				&HealthCheck{Node: node, CheckID: "web", ServiceID: service, Status: status},
+			},
+		}
+	}
+
+	a := CheckServiceNodes{
+		newNode("node1", "web1", api.HealthPassing),
+		newNode("node2", "web1", api.HealthPassing),
+		newNode("node3", "web1", api.HealthPassing),
+		newNode("node3", "web2", api.HealthPassing),
+	}
+	b := CheckServiceNodes{
+		newNode("node4", "web1", api.HealthPassing),
+		newNode("node3", "web2", api.HealthPassing),
+		newNode("node2", "web1", api.HealthCritical),
+		newNode("node3", "web1", api.HealthPassing),
+		newNode("node1", "web2", api.HealthPassing),
+	}
+
+	diff := DiffCheckServiceNodes(a, b)
+	require.Equal(t, CheckServiceNodes{b[4], b[0]}, diff.Added)
+	require.Equal(t, CheckServiceNodes{b[2]}, diff.Changed)
+	require.Equal(t, CheckServiceNodes{a[0]}, diff.Removed)
+
+	// The order of the nodes does not matter.
+	reversed := make(CheckServiceNodes, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+	require.Equal(t, diff, DiffCheckServiceNodes(a, reversed))
+
+	require.Equal(t, IndexedCheckServiceNodesDelta{}, DiffCheckServiceNodes(b, reversed))
+}
+
 func TestCheckServiceNodes_Filter(t *testing.T) {
 	nodes := CheckServiceNodes{
 		CheckServiceNode{
@@ -1646,6 +1686,61 @@ func TestCheckServiceNodes_Filter(t *testing.T) {
 	}
 }
 
+func TestCheckServiceNodeFilter_Status(t *testing.T) {
+	newNode := func(name string, nodeStatus, serviceStatus string) CheckServiceNode {
+		return CheckServiceNode{
+			Node:    &Node{Node: name},
+			Service: &NodeService{ID: "web", Service: "web"},
+			Checks: HealthChecks{
+				&HealthCheck{CheckID: "node", Status: nodeStatus},
+				&HealthCheck{CheckID: "web", ServiceID: "web", Status: serviceStatus},
+			},
+		}
+	}
+	nodes := CheckServiceNodes{
+		newNode("passing", api.HealthPassing, api.HealthPassing),
+		newNode("warning", api.HealthPassing, api.HealthWarning),
+		newNode("critical-node", api.HealthCritical, api.HealthPassing),
+	}
+
+	run := func(t *testing.T, mode HealthAggregation, expected ...string) {
+		t.Helper()
+		f, err := NewCheckServiceNodeFilter("Status == passing", mode)
+		require.NoError(t, err)
+		filtered, err := f.Execute(nodes)
+		require.NoError(t, err)
+
+		var names []string
+		for _, csn := range filtered {
+			names = append(names, csn.Node.Node)
+		}
+		require.Equal(t, expected, names)
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		run(t, HealthAggregationStrict, "passing")
+	})
+	t.Run("warning as passing", func(t *testing.T) {
+		run(t, HealthAggregationWarningAsPassing, "passing", "warning")
+	})
+	t.Run("ignore node checks", func(t *testing.T) {
+		run(t, HealthAggregationIgnoreNodeChecks, "passing", "critical-node")
+	})
+
+	t.Run("empty expression", func(t *testing.T) {
+		f, err := NewCheckServiceNodeFilter("", HealthAggregationStrict)
+		require.NoError(t, err)
+		filtered, err := f.Execute(nodes)
+		require.NoError(t, err)
+		require.Equal(t, nodes, filtered)
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		_, err := NewCheckServiceNodeFilter("Health == passing", HealthAggregationStrict)
+		require.Error(t, err)
+	})
+}
+
 func TestCheckServiceNode_CanRead(t *testing.T) {
 	type testCase struct {
 		name     string
@@ -1893,7 +1988,41 @@ func TestNodeSpecificRequest_CacheInfoKey(t *testing.T) {
 }
 
 func TestServiceSpecificRequest_CacheInfoKey(t *testing.T) {
-	assertCacheInfoKeyIsComplete(t, &ServiceSpecificRequest{})
+	assertCacheInfoKeyIsComplete(t, &ServiceSpecificRequest{}, "IndexFloor")
+}
+
+func TestNewServiceSpecificRequest(t *testing.T) {
+	t.Run("missing datacenter", func(t *testing.T) {
+		_, err := NewServiceSpecificRequest("", "web", nil)
+		require.EqualError(t, err, "missing datacenter")
+	})
+
+	t.Run("missing service name", func(t *testing.T) {
+		_, err := NewServiceSpecificRequest("dc1", "", nil)
+		require.EqualError(t, err, "missing service name")
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		req, err := NewServiceSpecificRequest("dc1", "web", nil)
+		require.NoError(t, err)
+
+		expected := ServiceSpecificRequest{
+			Datacenter:     "dc1",
+			ServiceName:    "web",
+			EnterpriseMeta: *DefaultEnterpriseMetaInDefaultPartition(),
+			QueryOptions:   QueryOptions{UseCache: true},
+		}
+		require.Equal(t, expected, req)
+	})
+
+	t.Run("same cache key as an equivalent request", func(t *testing.T) {
+		req, err := NewServiceSpecificRequest("dc1", "web", acl.DefaultEnterpriseMeta())
+		require.NoError(t, err)
+
+		other, err := NewServiceSpecificRequest("dc1", "web", nil)
+		require.NoError(t, err)
+		require.Equal(t, other.CacheInfo().Key, req.CacheInfo().Key)
+	})
 }
 
 func TestServiceDumpRequest_CacheInfoKey(t *testing.T) {
@@ -2081,6 +2210,16 @@ func TestSpecificServiceRequest_CacheInfo(t *testing.T) {
 				req.Ingress = true
 			},
 		},
+		{
+			name: "view options should be considered",
+			req: ServiceSpecificRequest{
+				Datacenter:  "dc1",
+				ServiceName: "my-service",
+			},
+			mutate: func(req *ServiceSpecificRequest) {
+				req.ViewOptions.SortByHealth = true
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,42 @@
+package submatview
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// EventDecoder decodes the payload of an event received on a topic into the
+// value which is applied to a View. It returns an error if the event does not
+// have the payload expected for the topic.
+type EventDecoder func(event *pbsubscribe.Event) (interface{}, error)
+
+var eventDecoders = struct {
+	lock    sync.RWMutex
+	byTopic map[pbsubscribe.Topic]EventDecoder
+}{byTopic: make(map[pbsubscribe.Topic]EventDecoder)}
+
+// RegisterEventDecoder registers the decoder used by DecodeEvent for the
+// events of topic, so that each package which materializes views for a topic
+// provides the decoding for its own events. It is expected to be called from
+// the init function of the package. A decoder registered for a topic replaces
+// the previous decoder for the same topic.
+func RegisterEventDecoder(topic pbsubscribe.Topic, decoder EventDecoder) {
+	eventDecoders.lock.Lock()
+	defer eventDecoders.lock.Unlock()
+	eventDecoders.byTopic[topic] = decoder
+}
+
+// DecodeEvent decodes an event received on topic using the decoder registered
+// for the topic. An error is returned if no decoder is registered for the
+// topic.
+func DecodeEvent(topic pbsubscribe.Topic, event *pbsubscribe.Event) (interface{}, error) {
+	eventDecoders.lock.RLock()
+	decoder, ok := eventDecoders.byTopic[topic]
+	eventDecoders.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no event decoder registered for topic %v", topic)
+	}
+	return decoder(event)
+}
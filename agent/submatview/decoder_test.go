@@ -0,0 +1,50 @@
+package submatview
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	// The topic is not defined by pbsubscribe, so that the decoder does not
+	// replace one registered by another package.
+	topic := pbsubscribe.Topic(1000)
+
+	_, err := DecodeEvent(topic, newEndOfSnapshotEvent(1))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no event decoder registered for topic 1000")
+
+	var decoded []*pbsubscribe.Event
+	RegisterEventDecoder(topic, func(event *pbsubscribe.Event) (interface{}, error) {
+		decoded = append(decoded, event)
+		if event.GetEndOfSnapshot() {
+			return nil, errors.New("unexpected end of snapshot")
+		}
+		return event.Index, nil
+	})
+	t.Cleanup(func() {
+		eventDecoders.lock.Lock()
+		delete(eventDecoders.byTopic, topic)
+		eventDecoders.lock.Unlock()
+	})
+
+	event := newEventServiceHealthRegister(5, 1, "web")
+	value, err := DecodeEvent(topic, event)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), value)
+
+	_, err = DecodeEvent(topic, newEndOfSnapshotEvent(6))
+	require.EqualError(t, err, "unexpected end of snapshot")
+
+	require.Len(t, decoded, 2)
+	require.Same(t, event, decoded[0])
+
+	// Other topics do not use the decoder.
+	_, err = DecodeEvent(pbsubscribe.Topic(1001), event)
+	require.Error(t, err)
+	require.Len(t, decoded, 2)
+}
@@ -2,18 +2,52 @@ package submatview
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"sync"
 	"time"
 
+	"github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/prometheus"
 	"github.com/hashicorp/go-hclog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"github.com/hashicorp/consul/lib"
 	"github.com/hashicorp/consul/lib/retry"
 	"github.com/hashicorp/consul/proto/pbsubscribe"
 )
 
+var Counters = []prometheus.CounterDefinition{
+	{
+		Name: []string{"submatview", "buffer", "overflow"},
+		Help: "Counts the number of times a materializer reset its view because the event buffer overflowed.",
+	},
+	{
+		Name: []string{"submatview", "event", "out_of_order"},
+		Help: "Counts the number of times a materializer reset its view because it received an event with an index lower than the index of the view.",
+	},
+	{
+		Name: []string{"submatview", "store", "expired"},
+		Help: "Counts the number of materialized views which were stopped and removed from the store because they had no requests for longer than the idle TTL.",
+	},
+	{
+		Name: []string{"submatview", "consumer", "lagging"},
+		Help: "Counts the number of times a consumer of Store.Notify did not receive an update within the consumer lag threshold.",
+	},
+}
+
+var Gauges = []prometheus.GaugeDefinition{
+	{
+		Name: []string{"submatview", "index_lag"},
+		Help: "Measures the difference between the index of the latest event received by a materializer and the index of its view.",
+	},
+}
+
 // View receives events from, and return results to, Materializer. A view is
 // responsible for converting the pbsubscribe.Event.Payload into the local
 // type, and storing it so that it can be returned by Result().
@@ -38,6 +72,39 @@ type View interface {
 	Reset()
 }
 
+// HashedView is a View that can compute a hash of the content of its result.
+// The hash may be used by callers to detect that a result with a new index has
+// the same content as the previous result.
+type HashedView interface {
+	View
+
+	// ResultHash returns a hash of the current state of the view. Two views with
+	// the same content must return the same hash, regardless of the order the
+	// events were received in.
+	ResultHash() uint64
+}
+
+// DeltaView is a View which can return only the changes to its result since
+// an earlier index, instead of the whole result.
+type DeltaView interface {
+	View
+
+	// ResultSince returns the result for index, which may contain only the
+	// changes since the since index. since is the minimum index of the request,
+	// and is 0 for the first request.
+	ResultSince(index, since uint64) interface{}
+}
+
+// DebugView is a View which can describe its state for debugging.
+type DebugView interface {
+	View
+
+	// DebugState returns a description of the current state of the view, which
+	// must be safe to encode as JSON. The returned value must not share memory
+	// with the view, because it is encoded after the view is unlocked.
+	DebugState() interface{}
+}
+
 // Materializer consumes the event stream, handling any framing events, and
 // sends the events to View as they are received.
 //
@@ -48,6 +115,7 @@ type Materializer struct {
 	deps        Deps
 	retryWaiter *retry.Waiter
 	handler     eventHandler
+	lag         indexLag
 
 	// lock protects the mutable state - all fields below it must only be accessed
 	// while holding lock.
@@ -55,7 +123,48 @@ type Materializer struct {
 	index    uint64
 	view     View
 	updateCh chan struct{}
-	err      error
+	// indexCh is closed whenever the index of the view changes, or when
+	// updateCh is closed, so that waitForIndex is woken even when the result
+	// did not change.
+	indexCh chan struct{}
+	err     error
+	// disconnectedAt is the time the subscription failed. It is the zero value
+	// while the subscription is active.
+	disconnectedAt time.Time
+	// connectedAt is the time the active subscription first updated the view.
+	// It is the zero value until then. It is only set when
+	// Deps.BackoffResetPeriod is set.
+	connectedAt time.Time
+	// cancelSubscription stops the active subscription. It is nil when there is
+	// no active subscription.
+	cancelSubscription context.CancelFunc
+	// resubscribe is true when the active subscription was stopped by
+	// Resubscribe.
+	resubscribe bool
+	// token is the ACL token used to subscribe. It is set from the first
+	// request returned by Deps.Request, and replaced by UpdateToken.
+	token string
+	// serverID is the ID of the server which serves the subscription, as sent
+	// in the header metadata of the stream. It is empty if the server did not
+	// send its ID.
+	serverID string
+	// resultHash is the hash of the result of the view after the last update,
+	// when the view is a HashedView.
+	resultHash uint64
+	// counts records the events applied to the view, for debugging.
+	counts EventCounts
+	// eventsApplied is the number of events applied to the view since it was
+	// last reset, including the events of the snapshot.
+	eventsApplied uint64
+	// updatedAt is the time events were last applied to the view. It is the
+	// zero value until the first snapshot is applied.
+	updatedAt time.Time
+	// closed is true once Close has been called.
+	closed bool
+	// stopRun cancels the context of Run, and runDone is closed when Run
+	// returns. Both are nil until Run is called.
+	stopRun context.CancelFunc
+	runDone chan struct{}
 }
 
 type Deps struct {
@@ -64,6 +173,122 @@ type Deps struct {
 	Logger  hclog.Logger
 	Waiter  *retry.Waiter
 	Request func(index uint64) *pbsubscribe.SubscribeRequest
+
+	// EventBufferSize is the maximum number of events that may be received
+	// from the stream while the View is still applying earlier events. When
+	// the buffer overflows the View is reset and the subscription is restarted
+	// from a new snapshot. If EventBufferSize is 0, events are not buffered and
+	// are only received as fast as the View can apply them.
+	EventBufferSize int
+
+	// SnapshotTimeout is the maximum amount of time a request waits for the
+	// initial snapshot to complete. It should be shorter than the timeout of
+	// blocking requests, so that a server which never completes the snapshot
+	// can be distinguished from a slow one. If SnapshotTimeout is 0, requests
+	// wait for the snapshot until their own timeout, unless
+	// SnapshotTimeoutFraction is set.
+	SnapshotTimeout time.Duration
+
+	// SnapshotTimeoutFraction sets the snapshot timeout of each request to a
+	// fraction of the time remaining before the deadline of the request, so
+	// that it scales with the timeout of blocking requests. It is only used
+	// when SnapshotTimeout is 0 and the request has a deadline. Once the
+	// snapshot is complete, requests block until their own deadline.
+	SnapshotTimeoutFraction float64
+
+	// CallOptions are passed to Client.Subscribe, and override the default call
+	// options of the connection (ex: grpc.MaxCallRecvMsgSize).
+	CallOptions []grpc.CallOption
+
+	// MaxSubscriptionLifetime is the maximum amount of time a subscription is
+	// kept open. When it expires the subscription is replaced by a new one
+	// from the index of the view, the same as Resubscribe, so that the servers
+	// authorize the subscription again without sending a new snapshot. Up to
+	// 10% of random jitter is added so that materializers started at the same
+	// time do not all resubscribe at once. If MaxSubscriptionLifetime is 0,
+	// subscriptions are kept open until they fail.
+	MaxSubscriptionLifetime time.Duration
+
+	// BackoffResetPeriod is how long a subscription must remain connected
+	// before the retry backoff is reset to its minimum. Until then, a
+	// subscription which fails after updating the view retries with the next,
+	// longer, delay, so that a server which accepts subscriptions and then
+	// fails them does not cause many agents to reconnect in lock step. If
+	// BackoffResetPeriod is 0, the backoff is reset by every update to the
+	// view.
+	BackoffResetPeriod time.Duration
+
+	// StatusActions sets the action taken when the subscription fails with one
+	// of the gRPC status codes in the map. Codes which are not in the map use
+	// the default action: codes.Aborted, which is sent by the servers to reset
+	// the subscription, uses StatusActionResnapshot, and all other codes use
+	// StatusActionSurface.
+	StatusActions map[codes.Code]StatusAction
+
+	// RequireLeader requires the subscription to be served by the leader, so
+	// that the view is not materialized from a stale follower. A follower
+	// forwards the subscription to the leader, and the server which serves it
+	// reports whether it is the leader. A subscription which is not served by
+	// the leader, for example while leadership changes, or by a server which
+	// does not report it, fails with a temporary error and is retried after the
+	// retry backoff. The retry is forwarded to the new leader. RequireLeader
+	// is sent as outgoing metadata, so a SharedStreamClient never shares the
+	// stream of the subscription.
+	RequireLeader bool
+
+	// ConsumerLagThreshold is how long Store.Notify waits for a consumer to
+	// receive an update from its channel before the consumer is reported as
+	// lagging. The view continues to be updated while the consumer is
+	// lagging, and the consumer receives the latest result once it has
+	// received the pending update. A lagging consumer is reported by
+	// incrementing the submatview.consumer.lagging counter, and by calling
+	// OnConsumerLag. If ConsumerLagThreshold is 0, consumers are not reported.
+	ConsumerLagThreshold time.Duration
+
+	// OnConsumerLag is called once each time a consumer of Store.Notify is
+	// reported as lagging. It is called from the goroutine which sends the
+	// updates to the consumer, so it must not block.
+	OnConsumerLag func(lag ConsumerLag)
+}
+
+// ConsumerLag describes a consumer of Store.Notify which did not receive an
+// update within Deps.ConsumerLagThreshold.
+type ConsumerLag struct {
+	// CorrelationID of the Notify call.
+	CorrelationID string
+	// Index of the update which is waiting to be received by the consumer.
+	Index uint64
+	// ViewIndex is the index of the view when the lag was reported. It is
+	// higher than Index if the view was updated while the consumer was
+	// lagging.
+	ViewIndex uint64
+	// Wait is how long the update has been waiting to be received.
+	Wait time.Duration
+}
+
+// StatusAction is the action taken by a Materializer when its subscription
+// fails with a gRPC status code.
+type StatusAction int
+
+const (
+	// StatusActionSurface returns the error to the requests waiting for the
+	// view, and then subscribes again from the index of the view after the
+	// retry backoff.
+	StatusActionSurface StatusAction = iota
+	// StatusActionResnapshot resets the view and subscribes again immediately
+	// to receive a new snapshot. The error is only returned to requests if
+	// the new subscription fails as well.
+	StatusActionResnapshot
+	// StatusActionReconnect subscribes again from the index of the view,
+	// without resetting it. The error is only returned to requests if the new
+	// subscription fails as well.
+	StatusActionReconnect
+)
+
+// defaultStatusActions are the actions for the codes which are not in
+// Deps.StatusActions.
+var defaultStatusActions = map[codes.Code]StatusAction{
+	codes.Aborted: StatusActionResnapshot,
 }
 
 // StreamClient provides a subscription to state change events.
@@ -78,6 +303,7 @@ func NewMaterializer(deps Deps) *Materializer {
 		view:        deps.View,
 		retryWaiter: deps.Waiter,
 		updateCh:    make(chan struct{}),
+		indexCh:     make(chan struct{}),
 	}
 	if v.retryWaiter == nil {
 		v.retryWaiter = &retry.Waiter{
@@ -97,17 +323,46 @@ func NewMaterializer(deps Deps) *Materializer {
 // Run receives events from the StreamClient and sends them to the View. It runs
 // until ctx is cancelled, so it is expected to be run in a goroutine.
 func (m *Materializer) Run(ctx context.Context) {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	m.stopRun = cancel
+	m.runDone = make(chan struct{})
+	defer close(m.runDone)
+	m.lock.Unlock()
+
 	for {
-		req := m.deps.Request(m.index)
+		req := m.subscribeRequest()
 		err := m.runSubscription(ctx, req)
 		if ctx.Err() != nil {
 			return
 		}
 
+		m.lock.Lock()
+		if m.resubscribe {
+			m.resubscribe = false
+			m.lock.Unlock()
+			m.deps.Logger.Debug("resubscribing",
+				"topic", req.Topic,
+				"key", req.Key)
+			continue
+		}
+		if m.disconnectedAt.IsZero() {
+			m.disconnectedAt = time.Now()
+		}
+		if m.connectedLongerThanLocked(m.deps.BackoffResetPeriod) {
+			m.retryWaiter.Reset()
+		}
+		m.lock.Unlock()
+
 		failures := m.retryWaiter.Failures()
 		if isNonTemporaryOrConsecutiveFailure(err, failures) {
 			m.lock.Lock()
-			m.notifyUpdateLocked(err)
+			m.notifyUpdateLocked(classifySubscriptionError(err))
 			m.lock.Unlock()
 		}
 
@@ -123,6 +378,86 @@ func (m *Materializer) Run(ctx context.Context) {
 	}
 }
 
+// Resubscribe stops the active subscription and starts a new one from the
+// index of the view, so that the servers authorize the subscription again. The
+// view is not reset, so requests continue to be served from the view while the
+// new subscription is started. Resubscribe does nothing if there is no active
+// subscription, because the next subscription will be a new one.
+func (m *Materializer) Resubscribe() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.cancelSubscription == nil {
+		return
+	}
+	m.resubscribe = true
+	m.cancelSubscription()
+}
+
+// UpdateToken replaces the ACL token used to subscribe. The active
+// subscription is replaced by a new one from the index of the view with the
+// new token, the same as Resubscribe, so the view is not reset and the servers
+// do not send a new snapshot when they can resume from that index. Events which
+// the previous token was allowed to read are not removed from the view, so the
+// new token must grant access to the same events, for example when a token is
+// rotated. UpdateToken does nothing if token is already used to subscribe.
+func (m *Materializer) UpdateToken(token string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if token == m.token {
+		return
+	}
+	m.token = token
+	if m.cancelSubscription == nil {
+		return
+	}
+	m.resubscribe = true
+	m.cancelSubscription()
+}
+
+// subscribeRequest returns the request used to subscribe from the index of the
+// view, with the token set by UpdateToken.
+func (m *Materializer) subscribeRequest() *pbsubscribe.SubscribeRequest {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	req := m.deps.Request(m.index)
+	if m.token != "" {
+		req.Token = m.token
+	} else {
+		m.token = req.Token
+	}
+	return req
+}
+
+// Close stops Run and the subscription, and waits for Run to return. Any
+// requests waiting for the view to update, and any later requests, return
+// ErrMaterializerClosed. Close is safe to call more than once.
+func (m *Materializer) Close() error {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.notifyUpdateLocked(ErrMaterializerClosed)
+	done := m.runDone
+	if m.stopRun != nil {
+		m.stopRun()
+	}
+	m.lock.Unlock()
+
+	if done != nil {
+		<-done
+	}
+	return nil
+}
+
+// isClosed returns true once Close has been called.
+func (m *Materializer) isClosed() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.closed
+}
+
 // isNonTemporaryOrConsecutiveFailure returns true if the error is not a
 // temporary error or if failures > 0.
 func isNonTemporaryOrConsecutiveFailure(err error, failures int) bool {
@@ -140,36 +475,354 @@ func (m *Materializer) runSubscription(ctx context.Context, req *pbsubscribe.Sub
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	m.lock.Lock()
+	m.cancelSubscription = cancel
+	m.connectedAt = time.Time{}
+	m.lock.Unlock()
+	defer func() {
+		m.lock.Lock()
+		m.cancelSubscription = nil
+		m.lock.Unlock()
+	}()
+
 	m.handler = initialHandler(req.Index)
 
-	s, err := m.deps.Client.Subscribe(ctx, req)
+	if m.deps.RequireLeader {
+		ctx = metadata.AppendToOutgoingContext(ctx, pbsubscribe.RequireLeaderMetadataKey, "true")
+	}
+	s, err := m.deps.Client.Subscribe(ctx, req, m.deps.CallOptions...)
 	if err != nil {
-		return err
+		return m.applyStatusAction(err)
+	}
+
+	m.lock.Lock()
+	m.disconnectedAt = time.Time{}
+	m.lock.Unlock()
+
+	if lifetime := m.deps.MaxSubscriptionLifetime; lifetime > 0 {
+		lifetime += lib.RandomStagger(lifetime / 10)
+		timer := time.AfterFunc(lifetime, func() {
+			m.deps.Logger.Debug("subscription reached its maximum lifetime",
+				"topic", req.Topic,
+				"key", req.Key)
+			m.Resubscribe()
+		})
+		defer timer.Stop()
 	}
 
+	m.lag.setLabels([]metrics.Label{
+		{Name: "topic", Value: req.Topic.String()},
+		{Name: "key", Value: req.Key},
+	})
+	var stream eventReceiver = indexRecordingStream{eventReceiver: s, lag: &m.lag}
+	if m.deps.EventBufferSize > 0 {
+		stream = newBufferedStream(ctx, stream, m.deps.EventBufferSize)
+	}
+
+	receivedEvent := false
 	for {
-		event, err := s.Recv()
+		event, err := stream.Recv()
 		switch {
-		case isGrpcStatus(err, codes.Aborted):
+		case errors.Is(err, errBufferOverflow):
+			metrics.IncrCounter([]string{"submatview", "buffer", "overflow"}, 1)
 			m.reset()
-			return resetErr("stream reset requested")
+			return resetErr("event buffer overflow")
+		case errors.Is(err, io.EOF):
+			// The server closed the stream without an error, for example because
+			// it is shutting down. The view is still valid, so resubscribe from
+			// its index without notifying requests.
+			return errStreamClosed
 		case err != nil:
-			return err
+			return m.applyStatusAction(err)
+		}
+
+		// The header of the stream is always available once an event has been
+		// received, so reading it does not block.
+		if !receivedEvent {
+			receivedEvent = true
+			if m.deps.RequireLeader && !leaderFromStream(s) {
+				return errNotLeader
+			}
+			m.lock.Lock()
+			m.serverID = serverIDFromStream(s)
+			m.lock.Unlock()
 		}
 
 		m.handler, err = m.handler(m, event)
-		if err != nil {
+		switch {
+		case errors.Is(err, errOutOfOrderEvent):
+			metrics.IncrCounter([]string{"submatview", "event", "out_of_order"}, 1)
+			m.deps.Logger.Warn("resetting view after receiving an event out of order",
+				"topic", req.Topic,
+				"key", req.Key,
+				"error", err)
+			m.reset()
+			return resetErr(err.Error())
+		case err != nil:
 			m.reset()
 			return err
 		}
 	}
 }
 
+// serverIDFromStream returns the ID of the server sent in the header metadata of
+// the stream, or an empty string if the server did not send its ID.
+func serverIDFromStream(s grpc.ClientStream) string {
+	md, err := s.Header()
+	if err != nil {
+		return ""
+	}
+	if ids := md.Get(pbsubscribe.ServerIDMetadataKey); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// leaderFromStream returns true if the server reported in the header metadata of
+// the stream that it is the leader.
+func leaderFromStream(s grpc.ClientStream) bool {
+	md, err := s.Header()
+	if err != nil {
+		return false
+	}
+	values := md.Get(pbsubscribe.LeaderMetadataKey)
+	return len(values) > 0 && values[0] == "true"
+}
+
+// eventReceiver is the part of the subscription stream used by runSubscription.
+type eventReceiver interface {
+	Recv() (*pbsubscribe.Event, error)
+}
+
+// indexRecordingStream records the index of each event received from the
+// subscription, before the event is buffered or applied to the view.
+type indexRecordingStream struct {
+	eventReceiver
+	lag *indexLag
+}
+
+func (s indexRecordingStream) Recv() (*pbsubscribe.Event, error) {
+	event, err := s.eventReceiver.Recv()
+	if err == nil {
+		s.lag.received(event.Index)
+	}
+	return event, err
+}
+
+// indexLag tracks how far the index of the view is behind the index of the
+// latest event received from the subscription, and reports the difference as
+// the index_lag gauge. It has its own lock so that receiving events is not
+// blocked while the view is being updated.
+type indexLag struct {
+	lock    sync.Mutex
+	latest  uint64
+	applied uint64
+	labels  []metrics.Label
+}
+
+func (l *indexLag) setLabels(labels []metrics.Label) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.labels = labels
+}
+
+// received records the index of an event received from the subscription.
+func (l *indexLag) received(index uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if index > l.latest {
+		l.latest = index
+	}
+	l.emitLocked()
+}
+
+// apply records the index of the view after events were applied to it.
+func (l *indexLag) apply(index uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.applied = index
+	if index > l.latest {
+		l.latest = index
+	}
+	l.emitLocked()
+}
+
+func (l *indexLag) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.latest = 0
+	l.applied = 0
+}
+
+// emitLocked sets the index_lag gauge. The gauge is not set until the view has
+// applied the initial snapshot, because the events of the snapshot are all
+// received before any of them are applied.
+func (l *indexLag) emitLocked() {
+	if l.applied == 0 {
+		return
+	}
+	metrics.SetGaugeWithLabels([]string{"submatview", "index_lag"}, float32(l.latest-l.applied), l.labels)
+}
+
+var errBufferOverflow = errors.New("event buffer overflow")
+
+// errOutOfOrderEvent is returned by updateView when an event has an index lower
+// than the index of the view. Applying the event could leave the view in an
+// inconsistent state, so the view is reset and a new snapshot is requested.
+var errOutOfOrderEvent = errors.New("event index lower than the index of the view")
+
+// bufferedStream receives events from the subscription in a separate goroutine,
+// and queues them until they are read by Recv. If the queue is full when
+// another event is received, the stream stops receiving and Recv returns
+// errBufferOverflow.
+type bufferedStream struct {
+	ctx      context.Context
+	events   chan streamEvent
+	overflow chan struct{}
+}
+
+type streamEvent struct {
+	event *pbsubscribe.Event
+	err   error
+}
+
+// newBufferedStream starts receiving events from s. The goroutine exits when
+// ctx is cancelled, when s returns an error, or when the buffer overflows.
+func newBufferedStream(ctx context.Context, s eventReceiver, size int) *bufferedStream {
+	b := &bufferedStream{
+		ctx:      ctx,
+		events:   make(chan streamEvent, size),
+		overflow: make(chan struct{}),
+	}
+	go b.run(ctx, s)
+	return b
+}
+
+func (b *bufferedStream) run(ctx context.Context, s eventReceiver) {
+	for {
+		event, err := s.Recv()
+		select {
+		case b.events <- streamEvent{event: event, err: err}:
+		case <-ctx.Done():
+			return
+		default:
+			close(b.overflow)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Recv returns the next buffered event, or errBufferOverflow once the buffer
+// has overflowed. Any events still in the buffer after an overflow are
+// discarded, because the view will be reset. Recv returns the error of the
+// context once it is cancelled, because the goroutine may exit without
+// queuing the error of the subscription.
+func (b *bufferedStream) Recv() (*pbsubscribe.Event, error) {
+	select {
+	case <-b.overflow:
+		return nil, errBufferOverflow
+	default:
+	}
+
+	select {
+	case <-b.overflow:
+		return nil, errBufferOverflow
+	case e := <-b.events:
+		return e.event, e.err
+	case <-b.ctx.Done():
+		return nil, b.ctx.Err()
+	}
+}
+
+// applyStatusAction applies the StatusAction for the gRPC status code of err,
+// and returns the error to be returned by runSubscription. Errors without a
+// gRPC status are returned unmodified.
+func (m *Materializer) applyStatusAction(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	action, ok := m.deps.StatusActions[s.Code()]
+	if !ok {
+		action = defaultStatusActions[s.Code()]
+	}
+
+	switch action {
+	case StatusActionResnapshot:
+		m.reset()
+		return resetErr("stream reset requested")
+	case StatusActionReconnect:
+		return reconnectErr{err: err}
+	default:
+		return err
+	}
+}
+
 func isGrpcStatus(err error, code codes.Code) bool {
 	s, ok := status.FromError(err)
 	return ok && s.Code() == code
 }
 
+var (
+	// ErrSubscriptionACLDenied is returned when the servers reject the
+	// subscription because the ACL token does not have permission to read the
+	// requested data.
+	ErrSubscriptionACLDenied = errors.New("subscription denied by ACLs")
+
+	// ErrSubscriptionReset is returned when the subscription was reset and a
+	// new subscription failed to replace it.
+	ErrSubscriptionReset = errors.New("subscription reset")
+
+	// ErrSnapshotTimeout is returned when the initial snapshot did not complete
+	// within Deps.SnapshotTimeout.
+	ErrSnapshotTimeout = errors.New("timed out waiting for the initial snapshot")
+
+	// ErrMaterializerClosed is returned by requests to a Materializer after
+	// Close was called.
+	ErrMaterializerClosed = errors.New("materializer is closed")
+)
+
+// classifySubscriptionError wraps err so that errors.Is will match it against
+// one of the ErrSubscription errors. The message and gRPC status of err are
+// preserved, so err is returned unmodified if it does not match any of them.
+func classifySubscriptionError(err error) error {
+	var kind error
+	switch {
+	case isGrpcStatus(err, codes.PermissionDenied):
+		kind = ErrSubscriptionACLDenied
+	case isGrpcStatus(err, codes.Aborted), errors.As(err, new(resetErr)):
+		kind = ErrSubscriptionReset
+	default:
+		return err
+	}
+	return subscriptionError{kind: kind, err: err}
+}
+
+type subscriptionError struct {
+	kind error
+	err  error
+}
+
+func (e subscriptionError) Error() string {
+	return e.err.Error()
+}
+
+func (e subscriptionError) Unwrap() error {
+	return e.err
+}
+
+func (e subscriptionError) Is(target error) bool {
+	return target == e.kind
+}
+
+// GRPCStatus allows status.FromError to return the status of the wrapped error.
+func (e subscriptionError) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
 // resetErr represents a server request to reset the subscription, it's typed so
 // we can mark it as temporary and so attempt to retry first time without
 // notifying clients.
@@ -185,6 +838,66 @@ func (e resetErr) Error() string {
 	return string(e)
 }
 
+// reconnectErr is returned by runSubscription for an error with a status code
+// which uses StatusActionReconnect. It is temporary, so that the first attempt
+// to resubscribe is made without notifying requests.
+type reconnectErr struct {
+	err error
+}
+
+// Temporary Implements the internal Temporary interface
+func (reconnectErr) Temporary() bool {
+	return true
+}
+
+// Error implements error
+func (e reconnectErr) Error() string {
+	return e.err.Error()
+}
+
+func (e reconnectErr) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus allows status.FromError to return the status of the wrapped error.
+func (e reconnectErr) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
+// notLeaderErr is returned by runSubscription when Deps.RequireLeader is set,
+// and the subscription is not served by the leader. It is temporary, so that
+// the first attempt to subscribe again is made without notifying requests.
+type notLeaderErr struct{}
+
+var errNotLeader = notLeaderErr{}
+
+// Temporary Implements the internal Temporary interface
+func (notLeaderErr) Temporary() bool {
+	return true
+}
+
+// Error implements error
+func (notLeaderErr) Error() string {
+	return "subscription is not served by the leader"
+}
+
+// streamClosedErr is returned by runSubscription when the server closed the
+// stream cleanly. It is temporary, so that the first attempt to resubscribe is
+// made without notifying requests.
+type streamClosedErr struct{}
+
+var errStreamClosed = streamClosedErr{}
+
+// Temporary Implements the internal Temporary interface
+func (streamClosedErr) Temporary() bool {
+	return true
+}
+
+// Error implements error
+func (streamClosedErr) Error() string {
+	return "subscription stream closed by the server"
+}
+
 // reset clears the state ready to start a new stream from scratch.
 func (m *Materializer) reset() {
 	m.lock.Lock()
@@ -192,27 +905,166 @@ func (m *Materializer) reset() {
 
 	m.view.Reset()
 	m.index = 0
+	m.eventsApplied = 0
+	m.lag.reset()
+	m.counts.Resets++
 }
 
 func (m *Materializer) updateView(events []*pbsubscribe.Event, index uint64) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	if index < m.index {
+		return fmt.Errorf("%w: received %d, view is at %d", errOutOfOrderEvent, index, m.index)
+	}
+	for _, event := range events {
+		if event.Index != 0 && event.Index < m.index {
+			return fmt.Errorf("%w: received %d, view is at %d", errOutOfOrderEvent, event.Index, m.index)
+		}
+	}
+
 	if err := m.view.Update(events); err != nil {
 		return err
 	}
+	changed := m.resultChangedLocked()
 	m.index = index
-	m.notifyUpdateLocked(nil)
-	m.retryWaiter.Reset()
+	m.lag.apply(index)
+	m.counts.Updates++
+	m.counts.Events += uint64(len(events))
+	m.eventsApplied += uint64(len(events))
+	m.updatedAt = time.Now()
+	if changed {
+		m.notifyUpdateLocked(nil)
+	} else {
+		m.err = nil
+		m.notifyIndexLocked()
+	}
+	switch {
+	case m.deps.BackoffResetPeriod == 0:
+		m.retryWaiter.Reset()
+	case m.connectedAt.IsZero():
+		m.connectedAt = time.Now()
+	}
 	return nil
 }
 
+// connectedLongerThanLocked returns true if period is greater than 0, and the
+// last subscription updated the view more than period ago. It must be called
+// while holding m.lock.
+func (m *Materializer) connectedLongerThanLocked(period time.Duration) bool {
+	return period > 0 && !m.connectedAt.IsZero() && time.Since(m.connectedAt) >= period
+}
+
+// resultChangedLocked returns false if the view is a HashedView and the hash
+// of its result is the same as it was after the previous update. Requests
+// which are waiting for an update are not woken up when the result has not
+// changed, because they would receive the same result with a new index. The
+// first update after a reset is always a change. It must be called while
+// holding m.lock.
+func (m *Materializer) resultChangedLocked() bool {
+	hv, ok := m.view.(HashedView)
+	if !ok {
+		return true
+	}
+	prev := m.resultHash
+	m.resultHash = hv.ResultHash()
+	return m.index == 0 || m.resultHash != prev
+}
+
+// staleDuration returns how long the subscription has been disconnected from
+// the servers, or 0 if the subscription is active.
+func (m *Materializer) staleDuration() time.Duration {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.disconnectedAt.IsZero() {
+		return 0
+	}
+	return time.Since(m.disconnectedAt)
+}
+
+// subscriptionInfo returns a description of the subscription used by the
+// Materializer.
+func (m *Materializer) subscriptionInfo() SubscriptionInfo {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	req := m.deps.Request(m.index)
+	return SubscriptionInfo{
+		Topic:          req.Topic.String(),
+		Key:            req.Key,
+		Index:          m.index,
+		Connected:      m.index > 0 && m.disconnectedAt.IsZero(),
+		DisconnectedAt: m.disconnectedAt,
+	}
+}
+
+// EventCounts are the number of events applied to the view of a Materializer
+// since it was created.
+type EventCounts struct {
+	// Updates is the number of times events were applied to the view. The
+	// events of a snapshot are applied in a single update.
+	Updates uint64
+	// Events is the total number of events applied to the view.
+	Events uint64
+	// Resets is the number of times the view was reset.
+	Resets uint64
+}
+
+// DebugState describes the internal state of a Materializer.
+type DebugState struct {
+	Topic string
+	Key   string
+	// Index is the last index applied to the view.
+	Index uint64
+	// Connected is true when the subscription has received a snapshot and is
+	// currently receiving events.
+	Connected bool
+	// DisconnectedAt is the time the subscription failed, or the zero value if
+	// the subscription has not failed.
+	DisconnectedAt time.Time
+	// ServerID is the ID of the server which serves the subscription.
+	ServerID string `json:",omitempty"`
+	Events   EventCounts
+	// View is the state of the view, when the view is a DebugView.
+	View interface{} `json:",omitempty"`
+}
+
+// DebugJSON returns the DebugState of the Materializer encoded as JSON, so that
+// it can be included in debug bundles. It is safe to call while the view is
+// being updated.
+func (m *Materializer) DebugJSON() ([]byte, error) {
+	m.lock.Lock()
+	req := m.deps.Request(m.index)
+	state := DebugState{
+		Topic:          req.Topic.String(),
+		Key:            req.Key,
+		Index:          m.index,
+		Connected:      m.index > 0 && m.disconnectedAt.IsZero(),
+		DisconnectedAt: m.disconnectedAt,
+		ServerID:       m.serverID,
+		Events:         m.counts,
+	}
+	if dv, ok := m.view.(DebugView); ok {
+		state.View = dv.DebugState()
+	}
+	m.lock.Unlock()
+
+	return json.Marshal(state)
+}
+
 // notifyUpdateLocked closes the current update channel and recreates a new
 // one. It must be called while holding the s.lock lock.
 func (m *Materializer) notifyUpdateLocked(err error) {
 	m.err = err
 	close(m.updateCh)
 	m.updateCh = make(chan struct{})
+	m.notifyIndexLocked()
+}
+
+// notifyIndexLocked closes the current index channel and recreates a new one.
+// It must be called while holding the s.lock lock.
+func (m *Materializer) notifyIndexLocked() {
+	close(m.indexCh)
+	m.indexCh = make(chan struct{})
 }
 
 // Result returned from the View.
@@ -222,6 +1074,20 @@ type Result struct {
 	// Cached is true if the requested value was already available locally. If
 	// the value is false, it indicates that getFromView had to wait for an update,
 	Cached bool
+	// Hash of the content of Value. Only set when the View implements
+	// HashedView.
+	Hash uint64
+	// ServerID is the ID of the server which served the subscription that
+	// produced Value. It is empty when the server did not send its ID.
+	ServerID string
+	// EventsApplied is the number of events applied to the View to produce
+	// Value, since the View was last reset.
+	EventsApplied uint64
+	// Age is the time since events were last applied to the View. It grows
+	// while no events are received, either because the data has not changed
+	// or because the subscription failed, so callers may use it to decide
+	// whether Value is too stale to be used.
+	Age time.Duration
 }
 
 // getFromView blocks until the index of the View is greater than opts.MinIndex,
@@ -229,10 +1095,12 @@ type Result struct {
 func (m *Materializer) getFromView(ctx context.Context, minIndex uint64) (Result, error) {
 	m.lock.Lock()
 
-	result := Result{
-		Index: m.index,
-		Value: m.view.Result(m.index),
+	result := Result{Index: m.index}
+	if m.closed {
+		m.lock.Unlock()
+		return result, ErrMaterializerClosed
 	}
+	m.setResultLocked(&result, minIndex)
 
 	updateCh := m.updateCh
 	m.lock.Unlock()
@@ -245,14 +1113,35 @@ func (m *Materializer) getFromView(ctx context.Context, minIndex uint64) (Result
 		return result, nil
 	}
 
+	var snapshotTimeout <-chan time.Time
+	timeout := m.snapshotTimeout(ctx)
+	if result.Index == 0 && timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		snapshotTimeout = timer.C
+	}
+
 	for {
 		select {
+		case <-snapshotTimeout:
+			m.lock.Lock()
+			index := m.index
+			m.lock.Unlock()
+			if index == 0 {
+				return result, fmt.Errorf("%w after %v", ErrSnapshotTimeout, timeout)
+			}
+			// The snapshot has completed, the update will be received from updateCh.
+			snapshotTimeout = nil
+
 		case <-updateCh:
 			// View updated, return the new result
 			m.lock.Lock()
 			result.Index = m.index
 
 			switch {
+			case m.closed:
+				m.lock.Unlock()
+				return result, ErrMaterializerClosed
 			case m.err != nil:
 				err := m.err
 				m.lock.Unlock()
@@ -264,17 +1153,97 @@ func (m *Materializer) getFromView(ctx context.Context, minIndex uint64) (Result
 				continue
 			}
 
-			result.Value = m.view.Result(m.index)
+			m.setResultLocked(&result, minIndex)
 			m.lock.Unlock()
 			return result, nil
 
 		case <-ctx.Done():
 			// Update the result value to the latest because callers may still
-			// use the value when the error is context.DeadlineExceeded
+			// use the value when the error is context.DeadlineExceeded. The index
+			// may have changed without waking up this request when the result did
+			// not change.
 			m.lock.Lock()
-			result.Value = m.view.Result(m.index)
+			result.Index = m.index
+			m.setResultLocked(&result, minIndex)
 			m.lock.Unlock()
 			return result, ctx.Err()
 		}
 	}
 }
+
+// peek returns the current result of the view without blocking. It returns
+// false if the view has not received its initial snapshot, or if the
+// Materializer is closed.
+func (m *Materializer) peek() (Result, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed || m.index == 0 {
+		return Result{}, false
+	}
+	result := Result{Index: m.index, Cached: true}
+	m.setResultLocked(&result, 0)
+	return result, true
+}
+
+// waitForIndex blocks until the index of the view is at least floor, or the
+// context is cancelled. Unlike getFromView, it is woken by every update to the
+// index, including updates which did not change the result.
+func (m *Materializer) waitForIndex(ctx context.Context, floor uint64) error {
+	m.lock.Lock()
+	for {
+		switch {
+		case m.closed:
+			m.lock.Unlock()
+			return ErrMaterializerClosed
+		case m.index >= floor:
+			m.lock.Unlock()
+			return nil
+		}
+		indexCh := m.indexCh
+		m.lock.Unlock()
+
+		select {
+		case <-indexCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		m.lock.Lock()
+		if m.err != nil && m.index < floor {
+			err := m.err
+			m.lock.Unlock()
+			return err
+		}
+	}
+}
+
+// snapshotTimeout returns how long a request with ctx waits for the initial
+// snapshot, or 0 if it waits until ctx is done.
+func (m *Materializer) snapshotTimeout(ctx context.Context) time.Duration {
+	if m.deps.SnapshotTimeout > 0 {
+		return m.deps.SnapshotTimeout
+	}
+	deadline, ok := ctx.Deadline()
+	if m.deps.SnapshotTimeoutFraction <= 0 || !ok {
+		return 0
+	}
+	return time.Duration(float64(time.Until(deadline)) * m.deps.SnapshotTimeoutFraction)
+}
+
+// setResultLocked sets the Value and Hash of result from the View. since is
+// passed to DeltaView.ResultSince. It must be called while holding m.lock.
+func (m *Materializer) setResultLocked(result *Result, since uint64) {
+	if dv, ok := m.view.(DeltaView); ok {
+		result.Value = dv.ResultSince(m.index, since)
+	} else {
+		result.Value = m.view.Result(m.index)
+	}
+	if hv, ok := m.view.(HashedView); ok {
+		result.Hash = hv.ResultHash()
+	}
+	result.ServerID = m.serverID
+	result.EventsApplied = m.eventsApplied
+	if !m.updatedAt.IsZero() {
+		result.Age = time.Since(m.updatedAt)
+	}
+}
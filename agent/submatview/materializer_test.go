@@ -0,0 +1,1073 @@
+package submatview
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	libretry "github.com/hashicorp/consul/lib/retry"
+	"github.com/hashicorp/consul/proto/pbcommon"
+	"github.com/hashicorp/consul/proto/pbservice"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+)
+
+func TestMaterializer_EventBufferOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(newEndOfSnapshotEvent(1))
+
+	view := &slowView{
+		fakeView: fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		unblock:  make(chan struct{}),
+	}
+	m := NewMaterializer(Deps{
+		View:            view,
+		Client:          client,
+		Logger:          hclog.New(nil),
+		Request:         newFakeSubscribeRequest,
+		EventBufferSize: 4,
+	})
+	go m.Run(ctx)
+
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), result.Index)
+
+	// The first event blocks the view, so the rest of the burst overflows the
+	// buffer.
+	for i := 1; i <= 10; i++ {
+		client.QueueEvents(newEventServiceHealthRegister(uint64(i+1), i, "srv1"))
+	}
+	time.Sleep(50 * time.Millisecond)
+	// The new subscription receives every event again. Pace them, the same as
+	// the events of a snapshot sent by a server, so that the view keeps up with
+	// the new subscription.
+	client.SetEventDelay(time.Millisecond)
+	close(view.unblock)
+
+	ctx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	result, err = m.getFromView(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, uint64(11), result.Index)
+	require.Len(t, result.Value.(fakeResult).srvs, 10)
+
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	require.Len(t, client.subClients, 2, "expected a new subscription after the overflow")
+}
+
+func TestMaterializer_Run_ExitsWhenContextCancelled(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(newEndOfSnapshotEvent(1))
+
+	for _, size := range []int{0, 4} {
+		m := NewMaterializer(Deps{
+			View:            &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+			Client:          client,
+			Logger:          hclog.New(nil),
+			Request:         newFakeSubscribeRequest,
+			EventBufferSize: size,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			m.Run(ctx)
+			close(done)
+		}()
+
+		_, err := m.getFromView(ctx, 0)
+		require.NoError(t, err)
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("expected Run to exit when the context is cancelled")
+		}
+	}
+
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	require.Len(t, client.subClients, 2)
+	for _, sub := range client.subClients {
+		require.Error(t, sub.ctx.Err(), "expected the stream to be closed")
+	}
+}
+
+func TestMaterializer_ClassifiesSubscriptionErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewMaterializer(Deps{
+		View:    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:  errStreamClient{err: status.Error(codes.PermissionDenied, "Permission denied")},
+		Logger:  hclog.New(nil),
+		Request: newFakeSubscribeRequest,
+	})
+	go m.Run(ctx)
+
+	_, err := m.getFromView(ctx, 0)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrSubscriptionACLDenied))
+	require.False(t, errors.Is(err, ErrSubscriptionReset))
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Equal(t, "rpc error: code = PermissionDenied desc = Permission denied", err.Error())
+}
+
+func TestClassifySubscriptionError(t *testing.T) {
+	err := classifySubscriptionError(resetErr("stream reset requested"))
+	require.True(t, errors.Is(err, ErrSubscriptionReset))
+
+	err = classifySubscriptionError(status.Error(codes.Aborted, "reset"))
+	require.True(t, errors.Is(err, ErrSubscriptionReset))
+
+	orig := status.Error(codes.Internal, "oops")
+	require.Equal(t, orig, classifySubscriptionError(orig))
+}
+
+type errStreamClient struct {
+	err error
+}
+
+func (c errStreamClient) Subscribe(context.Context, *pbsubscribe.SubscribeRequest, ...grpc.CallOption) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	return nil, c.err
+}
+
+func TestMaterializer_SnapshotTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(
+		newEventServiceHealthRegister(1, 1, "srv1"),
+		newEventServiceHealthRegister(1, 2, "srv1"))
+
+	m := NewMaterializer(Deps{
+		View:            &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:          client,
+		Logger:          hclog.New(nil),
+		Request:         newFakeSubscribeRequest,
+		SnapshotTimeout: 50 * time.Millisecond,
+	})
+	go m.Run(ctx)
+
+	start := time.Now()
+	_, err := m.getFromView(ctx, 0)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrSnapshotTimeout))
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+
+	// Once the snapshot completes the timeout no longer applies.
+	client.QueueEvents(newEndOfSnapshotEvent(1))
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), result.Index)
+}
+
+func TestMaterializer_SnapshotTimeoutFraction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(newEventServiceHealthRegister(1, 1, "srv1"))
+
+	m := NewMaterializer(Deps{
+		View:                    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:                  client,
+		Logger:                  hclog.New(nil),
+		Request:                 newFakeSubscribeRequest,
+		SnapshotTimeoutFraction: 0.1,
+	})
+	go m.Run(ctx)
+
+	// Without a deadline the request would wait for the snapshot until it is
+	// cancelled, so the fraction only applies to requests with a deadline.
+	require.Equal(t, time.Duration(0), m.snapshotTimeout(ctx))
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, time.Second)
+	defer reqCancel()
+	start := time.Now()
+	_, err := m.getFromView(reqCtx, 0)
+	require.True(t, errors.Is(err, ErrSnapshotTimeout), "unexpected error: %v", err)
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+
+	// Once the snapshot completes the request blocks until its own deadline.
+	client.QueueEvents(newEndOfSnapshotEvent(1))
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), result.Index)
+
+	reqCtx, reqCancel = context.WithTimeout(ctx, 300*time.Millisecond)
+	defer reqCancel()
+	start = time.Now()
+	result, err = m.getFromView(reqCtx, 1)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	require.Equal(t, uint64(1), result.Index)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(300*time.Millisecond))
+}
+
+func TestMaterializer_PacedEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	delay := 20 * time.Millisecond
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.SetEventDelay(delay)
+	client.QueueEvents(newEndOfSnapshotEvent(1))
+	for i := 1; i <= 5; i++ {
+		client.QueueEvents(newEventServiceHealthRegister(uint64(i+1), i, "srv1"))
+	}
+
+	m := NewMaterializer(Deps{
+		View:    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:  client,
+		Logger:  hclog.New(nil),
+		Request: newFakeSubscribeRequest,
+	})
+
+	start := time.Now()
+	go m.Run(ctx)
+
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), result.Index)
+
+	// Each of the 6 events is delivered after the delay, so the last event can
+	// not be applied until 6 delays have elapsed.
+	result, err = m.getFromView(ctx, 5)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), result.Index)
+	require.Len(t, result.Value.(fakeResult).srvs, 5)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(6*delay))
+}
+
+func TestMaterializer_IndexLagMetric(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("consul.submatview.test")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	metrics.NewGlobal(cfg, sink)
+	t.Cleanup(func() {
+		metrics.NewGlobal(cfg, &metrics.BlackholeSink{})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(newEndOfSnapshotEvent(1))
+
+	view := &slowView{
+		fakeView: fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		unblock:  make(chan struct{}),
+	}
+	m := NewMaterializer(Deps{
+		View:            view,
+		Client:          client,
+		Logger:          hclog.New(nil),
+		Request:         newFakeSubscribeRequest,
+		EventBufferSize: 10,
+	})
+	go m.Run(ctx)
+
+	_, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+
+	indexLag := func(r *retry.R) float32 {
+		key := "consul.submatview.test.submatview.index_lag;topic=ServiceHealth;key=key"
+		data := sink.Data()
+		require.Len(r, data, 1)
+		data[0].RLock()
+		defer data[0].RUnlock()
+		val, ok := data[0].Gauges[key]
+		require.True(r, ok, "missing gauge %v", key)
+		return val.Value
+	}
+
+	// The view blocks on the first event, so the rest of the events are
+	// received but not applied.
+	for i := 1; i <= 4; i++ {
+		client.QueueEvents(newEventServiceHealthRegister(uint64(i+1), i, "srv1"))
+	}
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, float32(4), indexLag(r))
+	})
+
+	close(view.unblock)
+	ctx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	result, err := m.getFromView(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, float32(0), indexLag(r))
+	})
+}
+
+func TestMaterializer_Close(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(newEndOfSnapshotEvent(1))
+
+	m := NewMaterializer(Deps{
+		View:    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:  client,
+		Logger:  hclog.New(nil),
+		Request: newFakeSubscribeRequest,
+	})
+	done := make(chan struct{})
+	go func() {
+		m.Run(context.Background())
+		close(done)
+	}()
+
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), result.Index)
+
+	// A request which is waiting for an update when the materializer is closed.
+	chErr := make(chan error, 1)
+	go func() {
+		_, err := m.getFromView(ctx, 1)
+		chErr <- err
+	}()
+
+	require.NoError(t, m.Close())
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatalf("expected Run to return when the materializer is closed")
+	}
+
+	select {
+	case err := <-chErr:
+		require.True(t, errors.Is(err, ErrMaterializerClosed), "unexpected error %v", err)
+	case <-ctx.Done():
+		t.Fatalf("expected the waiting request to return")
+	}
+
+	client.lock.RLock()
+	require.Len(t, client.subClients, 1)
+	require.Error(t, client.subClients[0].ctx.Err(), "expected the stream to be closed")
+	client.lock.RUnlock()
+
+	_, err = m.getFromView(ctx, 0)
+	require.True(t, errors.Is(err, ErrMaterializerClosed), "unexpected error %v", err)
+	require.NoError(t, m.Close())
+}
+
+// slowView is a fakeView that blocks updates after the initial snapshot until
+// unblock is closed.
+type slowView struct {
+	fakeView
+	unblock chan struct{}
+}
+
+func (v *slowView) Update(events []*pbsubscribe.Event) error {
+	if len(events) > 0 && events[0].Index > 1 {
+		<-v.unblock
+	}
+	return v.fakeView.Update(events)
+}
+
+func TestMaterializer_OutOfOrderEvent(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("consul.submatview.test")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	metrics.NewGlobal(cfg, sink)
+	t.Cleanup(func() {
+		metrics.NewGlobal(cfg, &metrics.BlackholeSink{})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4),
+		newEventServiceHealthRegister(5, 2, "srv1"))
+
+	var (
+		lock     sync.Mutex
+		requests []uint64
+	)
+	m := NewMaterializer(Deps{
+		View:   &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client: client,
+		Logger: hclog.New(nil),
+		Request: func(index uint64) *pbsubscribe.SubscribeRequest {
+			lock.Lock()
+			defer lock.Unlock()
+			requests = append(requests, index)
+			return newFakeSubscribeRequest(index)
+		},
+	})
+	go m.Run(ctx)
+
+	result, err := m.getFromView(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+
+	// Send an event with a lower index to the active subscription. The next
+	// subscription receives a new snapshot.
+	client.lock.Lock()
+	client.events = []eventOrErr{
+		{Event: newEventServiceHealthRegister(6, 1, "srv1")},
+		{Event: newEventServiceHealthRegister(6, 3, "srv1")},
+		{Event: newEndOfSnapshotEvent(6)},
+	}
+	client.subClients[0].events <- eventOrErr{Event: newEventServiceHealthRegister(3, 3, "srv1")}
+	client.lock.Unlock()
+
+	ctx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	result, err = m.getFromView(ctx, 5)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), result.Index)
+
+	// The event with the lower index was not applied, and the view was rebuilt
+	// from the new snapshot.
+	var nodes []string
+	for _, srv := range result.Value.(fakeResult).srvs {
+		nodes = append(nodes, srv.Node.Node)
+	}
+	require.ElementsMatch(t, []string{"node1", "node3"}, nodes)
+
+	client.lock.RLock()
+	require.Len(t, client.subClients, 2)
+	client.lock.RUnlock()
+	lock.Lock()
+	require.Equal(t, []uint64{0, 0}, requests)
+	lock.Unlock()
+
+	data := sink.Data()
+	require.Len(t, data, 1)
+	data[0].RLock()
+	defer data[0].RUnlock()
+	counter, ok := data[0].Counters["consul.submatview.test.submatview.event.out_of_order"]
+	require.True(t, ok, "missing out_of_order counter")
+	require.Equal(t, 1, counter.Count)
+}
+
+func TestMaterializer_MaxSubscriptionLifetime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	var (
+		lock     sync.Mutex
+		requests []uint64
+	)
+	m := NewMaterializer(Deps{
+		View:   &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client: client,
+		Logger: hclog.New(nil),
+		Request: func(index uint64) *pbsubscribe.SubscribeRequest {
+			lock.Lock()
+			defer lock.Unlock()
+			requests = append(requests, index)
+			return newFakeSubscribeRequest(index)
+		},
+		MaxSubscriptionLifetime: 200 * time.Millisecond,
+	})
+	go m.Run(ctx)
+
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	// The servers resume the new subscription from the index of the view, so
+	// the snapshot is not sent again.
+	client.lock.Lock()
+	client.events = nil
+	client.lock.Unlock()
+
+	retry.Run(t, func(r *retry.R) {
+		client.lock.RLock()
+		defer client.lock.RUnlock()
+		require.GreaterOrEqual(r, len(client.subClients), 2)
+		require.Error(r, client.subClients[0].ctx.Err(), "expected the first subscription to be stopped")
+	})
+
+	lock.Lock()
+	require.Equal(t, []uint64{0, 4}, requests[:2])
+	lock.Unlock()
+
+	client.QueueEvents(newEventServiceHealthRegister(5, 2, "srv1"))
+
+	ctx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	result, err = m.getFromView(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+	require.Len(t, result.Value.(fakeResult).srvs, 2, "expected the view to be preserved")
+}
+
+func TestMaterializer_StreamClosedByServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	var (
+		lock     sync.Mutex
+		requests []uint64
+	)
+	m := NewMaterializer(Deps{
+		View:   &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client: client,
+		Logger: hclog.New(nil),
+		Request: func(index uint64) *pbsubscribe.SubscribeRequest {
+			lock.Lock()
+			defer lock.Unlock()
+			requests = append(requests, index)
+			return newFakeSubscribeRequest(index)
+		},
+	})
+	go m.Run(ctx)
+
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	// Start a blocking query which waits for the next update.
+	blockingCtx, blockingCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer blockingCancel()
+	resultCh := make(chan Result, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		result, err := m.getFromView(blockingCtx, 4)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resultCh <- result
+	}()
+
+	// Close the first stream cleanly. The new subscription resumes from the
+	// index of the view, so the snapshot is not sent again.
+	client.lock.Lock()
+	client.events = nil
+	client.subClients[0].events <- eventOrErr{Err: io.EOF}
+	client.lock.Unlock()
+
+	retry.Run(t, func(r *retry.R) {
+		client.lock.RLock()
+		defer client.lock.RUnlock()
+		require.Len(r, client.subClients, 2)
+	})
+
+	lock.Lock()
+	require.Equal(t, []uint64{0, 4}, requests)
+	lock.Unlock()
+
+	client.QueueEvents(newEventServiceHealthRegister(5, 2, "srv1"))
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("expected the blocking query to continue, got error: %v", err)
+	case result := <-resultCh:
+		require.Equal(t, uint64(5), result.Index)
+		require.Len(t, result.Value.(fakeResult).srvs, 2, "expected the view to be preserved")
+	}
+}
+
+func TestMaterializer_StatusActions(t *testing.T) {
+	type testCase struct {
+		name          string
+		actions       map[codes.Code]StatusAction
+		code          codes.Code
+		expectedIndex []uint64
+		expectedErr   bool
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+		client.QueueEvents(
+			newEventServiceHealthRegister(4, 1, "srv1"),
+			newEndOfSnapshotEvent(4))
+
+		var (
+			lock     sync.Mutex
+			requests []uint64
+		)
+		m := NewMaterializer(Deps{
+			View:   &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+			Client: client,
+			Logger: hclog.New(nil),
+			Request: func(index uint64) *pbsubscribe.SubscribeRequest {
+				lock.Lock()
+				defer lock.Unlock()
+				requests = append(requests, index)
+				return newFakeSubscribeRequest(index)
+			},
+			StatusActions: tc.actions,
+		})
+		go m.Run(ctx)
+
+		result, err := m.getFromView(ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), result.Index)
+
+		blockingCtx, blockingCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer blockingCancel()
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := m.getFromView(blockingCtx, 4)
+			errCh <- err
+		}()
+		// Give the blocking query time to start waiting for an update, so that
+		// it is woken up by the error.
+		time.Sleep(20 * time.Millisecond)
+
+		// Fail the first stream. The events are not replayed to the next
+		// subscription, so that a new snapshot must be queued explicitly.
+		client.lock.Lock()
+		client.events = nil
+		client.subClients[0].events <- eventOrErr{Err: status.Error(tc.code, "failed")}
+		client.lock.Unlock()
+
+		if tc.expectedErr {
+			select {
+			case err := <-errCh:
+				require.Equal(t, tc.code, status.Code(err))
+			case <-time.After(time.Second):
+				t.Fatal("expected the error to be returned to the blocking query")
+			}
+		}
+
+		retry.Run(t, func(r *retry.R) {
+			lock.Lock()
+			defer lock.Unlock()
+			require.Equal(r, tc.expectedIndex, requests)
+		})
+
+		if !tc.expectedErr {
+			select {
+			case err := <-errCh:
+				t.Fatalf("expected the blocking query to continue, got error: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+
+	var testCases = []testCase{
+		{
+			name:          "default resnapshot for Aborted",
+			code:          codes.Aborted,
+			expectedIndex: []uint64{0, 0},
+		},
+		{
+			name:          "default surface for other codes",
+			code:          codes.Unavailable,
+			expectedIndex: []uint64{0, 4},
+			expectedErr:   true,
+		},
+		{
+			name:          "custom code resnapshot",
+			actions:       map[codes.Code]StatusAction{codes.Unavailable: StatusActionResnapshot},
+			code:          codes.Unavailable,
+			expectedIndex: []uint64{0, 0},
+		},
+		{
+			name:          "custom code reconnect",
+			actions:       map[codes.Code]StatusAction{codes.ResourceExhausted: StatusActionReconnect},
+			code:          codes.ResourceExhausted,
+			expectedIndex: []uint64{0, 4},
+		},
+		{
+			name:          "override the default for Aborted",
+			actions:       map[codes.Code]StatusAction{codes.Aborted: StatusActionReconnect},
+			code:          codes.Aborted,
+			expectedIndex: []uint64{0, 4},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestMaterializer_BackoffResetPeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	streams := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	streams.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+	client := &failingStreamClient{client: streams, failures: 4}
+
+	m := NewMaterializer(Deps{
+		View:    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:  client,
+		Logger:  hclog.New(nil),
+		Request: newFakeSubscribeRequest,
+		Waiter: &libretry.Waiter{
+			Factor:  20 * time.Millisecond,
+			MaxWait: 160 * time.Millisecond,
+			Jitter:  libretry.NewJitter(100),
+		},
+		BackoffResetPeriod: 300 * time.Millisecond,
+	})
+	go m.Run(ctx)
+
+	// Each failed attempt is returned to the requests waiting for the view,
+	// until the subscription succeeds.
+	var result Result
+	retry.Run(t, func(r *retry.R) {
+		var err error
+		result, err = m.getFromView(ctx, 0)
+		require.NoError(r, err)
+	})
+	require.Equal(t, uint64(4), result.Index)
+
+	// The delay between attempts doubles after each failure, and is at most
+	// twice as long with the jitter.
+	calls := client.subscribeCalls()
+	require.Len(t, calls, 5)
+	for i, base := range []time.Duration{20, 40, 80, 160} {
+		base *= time.Millisecond
+		delay := calls[i+1].Sub(calls[i])
+		require.GreaterOrEqual(t, int64(delay), int64(base), "attempt %d", i+1)
+		require.Less(t, int64(delay), int64(2*base+50*time.Millisecond), "attempt %d", i+1)
+	}
+
+	// The new subscription resumes from the index of the view. Once it has
+	// been connected for longer than the reset period, the backoff starts
+	// again from the minimum delay.
+	streams.lock.Lock()
+	streams.events = nil
+	streams.lock.Unlock()
+	time.Sleep(400 * time.Millisecond)
+
+	failedAt := time.Now()
+	streams.lock.Lock()
+	streams.subClients[0].events <- eventOrErr{Err: status.Error(codes.Unavailable, "server restarting")}
+	streams.lock.Unlock()
+
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, client.subscribeCalls(), 6)
+	})
+	delay := client.subscribeCalls()[5].Sub(failedAt)
+	require.Less(t, int64(delay), int64(160*time.Millisecond), "expected the backoff to be reset")
+}
+
+func TestMaterializer_DisconnectMidSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.DisconnectAfter(2)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEventServiceHealthRegister(4, 2, "srv1"),
+		newEventServiceHealthRegister(4, 3, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	var (
+		lock     sync.Mutex
+		requests []uint64
+	)
+	m := NewMaterializer(Deps{
+		View:   &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client: client,
+		Logger: hclog.New(nil),
+		Request: func(index uint64) *pbsubscribe.SubscribeRequest {
+			lock.Lock()
+			defer lock.Unlock()
+			requests = append(requests, index)
+			return newFakeSubscribeRequest(index)
+		},
+		Waiter: &libretry.Waiter{
+			Factor:  10 * time.Millisecond,
+			MaxWait: 20 * time.Millisecond,
+		},
+	})
+	go m.Run(ctx)
+
+	// The disconnect may be returned to the request if it is waiting for the
+	// snapshot when the first subscription is disconnected.
+	var result Result
+	retry.Run(t, func(r *retry.R) {
+		getCtx, getCancel := context.WithTimeout(ctx, time.Second)
+		defer getCancel()
+		var err error
+		result, err = m.getFromView(getCtx, 0)
+		require.NoError(r, err)
+	})
+
+	// The partial snapshot is discarded, and the view is materialized from the
+	// complete snapshot of the second subscription.
+	require.Equal(t, uint64(4), result.Index)
+	require.Len(t, result.Value.(fakeResult).srvs, 3)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, []uint64{0, 0}, requests)
+}
+
+func TestMaterializer_RequireLeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.SetLeader(false)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	m := NewMaterializer(Deps{
+		View:    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:  client,
+		Logger:  hclog.New(nil),
+		Request: newFakeSubscribeRequest,
+		Waiter: &libretry.Waiter{
+			Factor:  10 * time.Millisecond,
+			MaxWait: 20 * time.Millisecond,
+		},
+		RequireLeader: true,
+	})
+	go m.Run(ctx)
+
+	subscriptions := func() []*subscribeClient {
+		client.lock.RLock()
+		defer client.lock.RUnlock()
+		return append([]*subscribeClient(nil), client.subClients...)
+	}
+
+	// Subscriptions which are not served by the leader are retried, without
+	// applying their events to the view.
+	retry.Run(t, func(r *retry.R) {
+		require.GreaterOrEqual(r, len(subscriptions()), 3)
+	})
+	for _, sub := range subscriptions() {
+		require.True(t, requiresLeader(sub.ctx))
+	}
+	m.lock.Lock()
+	require.Equal(t, uint64(0), m.index)
+	m.lock.Unlock()
+
+	// A subscription which was started before the change may still fail, and
+	// return the error to the request.
+	client.SetLeader(true)
+	retry.Run(t, func(r *retry.R) {
+		getCtx, getCancel := context.WithTimeout(ctx, time.Second)
+		defer getCancel()
+		result, err := m.getFromView(getCtx, 0)
+		require.NoError(r, err)
+		require.Equal(r, uint64(4), result.Index)
+	})
+}
+
+func TestMaterializer_RequireLeader_SharedStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.SetLeader(true)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+	shared := NewSharedStreamClient(client, 0)
+
+	newMaterializer := func(requireLeader bool) *Materializer {
+		return NewMaterializer(Deps{
+			View:          &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+			Client:        shared,
+			Logger:        hclog.New(nil),
+			Request:       newFakeSubscribeRequest,
+			RequireLeader: requireLeader,
+		})
+	}
+
+	// The first materializer starts a shared stream without asking the server
+	// to report whether it is the leader.
+	first := newMaterializer(false)
+	go first.Run(ctx)
+	result, err := first.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	// A materializer which requires the leader must not join the shared stream,
+	// because the header of that stream does not report the leader status.
+	leader := newMaterializer(true)
+	go leader.Run(ctx)
+	getCtx, getCancel := context.WithTimeout(ctx, time.Second)
+	defer getCancel()
+	result, err = leader.getFromView(getCtx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	require.Len(t, client.subClients, 2)
+	require.False(t, requiresLeader(client.subClients[0].ctx))
+	require.True(t, requiresLeader(client.subClients[1].ctx))
+}
+
+func TestMaterializer_UpdateToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	streams := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	streams.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+	client := &recordingStreamClient{client: streams}
+
+	m := NewMaterializer(Deps{
+		View:    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client:  client,
+		Logger:  hclog.New(nil),
+		Request: newFakeSubscribeRequest,
+	})
+	go m.Run(ctx)
+
+	result, err := m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	// The token is not changed, so the subscription is not replaced.
+	m.UpdateToken("abcd")
+
+	// The servers resume the new subscription from the index of the view, so
+	// the snapshot is not sent again.
+	streams.lock.Lock()
+	streams.events = nil
+	streams.lock.Unlock()
+
+	m.UpdateToken("efgh")
+
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, client.subscribeRequests(), 2)
+	})
+	reqs := client.subscribeRequests()
+	require.Equal(t, "abcd", reqs[0].Token)
+	require.Equal(t, uint64(0), reqs[0].Index)
+	require.Equal(t, "efgh", reqs[1].Token)
+	require.Equal(t, uint64(4), reqs[1].Index)
+
+	streams.lock.RLock()
+	require.Error(t, streams.subClients[0].ctx.Err(), "expected the first subscription to be stopped")
+	streams.lock.RUnlock()
+
+	// Requests continue to be served from the view, which is not reset.
+	result, err = m.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+	require.Len(t, result.Value.(fakeResult).srvs, 1)
+
+	streams.QueueEvents(newEventServiceHealthRegister(5, 2, "srv1"))
+
+	ctx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	result, err = m.getFromView(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+	require.Len(t, result.Value.(fakeResult).srvs, 2, "expected the view to be preserved")
+	require.Len(t, client.subscribeRequests(), 2)
+}
+
+// recordingStreamClient records the requests passed to Subscribe.
+type recordingStreamClient struct {
+	client StreamClient
+
+	lock sync.Mutex
+	reqs []*pbsubscribe.SubscribeRequest
+}
+
+func (c *recordingStreamClient) Subscribe(
+	ctx context.Context,
+	req *pbsubscribe.SubscribeRequest,
+	opts ...grpc.CallOption,
+) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	c.lock.Lock()
+	c.reqs = append(c.reqs, req)
+	c.lock.Unlock()
+	return c.client.Subscribe(ctx, req, opts...)
+}
+
+func (c *recordingStreamClient) subscribeRequests() []*pbsubscribe.SubscribeRequest {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*pbsubscribe.SubscribeRequest(nil), c.reqs...)
+}
+
+// failingStreamClient fails the first failures calls to Subscribe, and records
+// the time of each call.
+type failingStreamClient struct {
+	client StreamClient
+
+	lock     sync.Mutex
+	failures int
+	calls    []time.Time
+}
+
+func (c *failingStreamClient) Subscribe(
+	ctx context.Context,
+	req *pbsubscribe.SubscribeRequest,
+	opts ...grpc.CallOption,
+) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	c.lock.Lock()
+	c.calls = append(c.calls, time.Now())
+	fail := len(c.calls) <= c.failures
+	c.lock.Unlock()
+
+	if fail {
+		return nil, status.Error(codes.Unavailable, "server unavailable")
+	}
+	return c.client.Subscribe(ctx, req, opts...)
+}
+
+func (c *failingStreamClient) subscribeCalls() []time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]time.Time(nil), c.calls...)
+}
+
+func newFakeSubscribeRequest(index uint64) *pbsubscribe.SubscribeRequest {
+	return &pbsubscribe.SubscribeRequest{
+		Topic:      pbsubscribe.Topic_ServiceHealth,
+		Key:        "key",
+		Token:      "abcd",
+		Datacenter: "dc1",
+		Index:      index,
+		Namespace:  pbcommon.DefaultEnterpriseMeta.Namespace,
+	}
+}
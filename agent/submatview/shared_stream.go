@@ -0,0 +1,359 @@
+package submatview
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// DefaultSharedStreamMaxReplay is the maxReplay used by NewSharedStreamClient
+// when the value is 0. While a stream accepts new subscribers it keeps every
+// event it received, so the value bounds the memory used by each stream.
+const DefaultSharedStreamMaxReplay = 256
+
+// SharedStreamClient is a StreamClient which shares a single subscription
+// stream between the materializers which subscribe to the same events, for
+// example the materializers of two cache types which watch the same service.
+// The events received from the stream are sent to every materializer, so
+// views must not modify the events they receive.
+//
+// Only new subscriptions (with an Index of 0) are shared. A materializer which
+// subscribes after the stream was started receives the events already
+// received by the stream, starting with the snapshot, so that it can build the
+// same view. Subscriptions which resume from an index, or which send outgoing
+// metadata (for example Deps.RequireLeader), use their own stream, because the
+// metadata of a shared stream is the metadata of its first subscription.
+//
+// The call options of the first subscription are used to start a shared
+// stream, so a SharedStreamClient must only be used by materializers which use
+// the same call options, like the materializers created from the same
+// health.MaterializerDeps.
+type SharedStreamClient struct {
+	client    StreamClient
+	maxReplay int
+
+	// lock protects streams. It is held while a new stream is started, so that
+	// concurrent subscriptions to the same events share the new stream.
+	lock    sync.Mutex
+	streams map[sharedStreamKey]*sharedStream
+}
+
+// sharedStreamKey identifies the subscriptions which may share a stream.
+type sharedStreamKey struct {
+	topic      pbsubscribe.Topic
+	key        string
+	token      string
+	datacenter string
+	namespace  string
+	partition  string
+}
+
+// NewSharedStreamClient returns a SharedStreamClient which starts streams with
+// client. maxReplay is the maximum number of events kept for materializers
+// which subscribe after a stream was started. Once a stream has received more
+// events, later subscriptions start a new stream. If maxReplay is 0
+// DefaultSharedStreamMaxReplay is used.
+func NewSharedStreamClient(client StreamClient, maxReplay int) *SharedStreamClient {
+	if maxReplay <= 0 {
+		maxReplay = DefaultSharedStreamMaxReplay
+	}
+	return &SharedStreamClient{
+		client:    client,
+		maxReplay: maxReplay,
+		streams:   make(map[sharedStreamKey]*sharedStream),
+	}
+}
+
+// Subscribe implements StreamClient. The call options of the first
+// subscription are used to start a shared stream.
+func (c *SharedStreamClient) Subscribe(
+	ctx context.Context,
+	req *pbsubscribe.SubscribeRequest,
+	opts ...grpc.CallOption,
+) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	if req.Index != 0 || hasOutgoingMetadata(ctx) {
+		return c.client.Subscribe(ctx, req, opts...)
+	}
+
+	key := sharedStreamKey{
+		topic:      req.Topic,
+		key:        req.Key,
+		token:      req.Token,
+		datacenter: req.Datacenter,
+		namespace:  req.Namespace,
+		partition:  req.Partition,
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if s, ok := c.streams[key]; ok {
+		if sub := s.subscribe(ctx); sub != nil {
+			return sub, nil
+		}
+		delete(c.streams, key)
+	}
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := c.client.Subscribe(streamCtx, req, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &sharedStream{
+		client:      c,
+		key:         key,
+		stream:      stream,
+		cancel:      cancel,
+		headerCh:    make(chan struct{}),
+		joinable:    true,
+		updateCh:    make(chan struct{}),
+		subscribers: make(map[*sharedSubscription]struct{}),
+	}
+	c.streams[key] = s
+	sub := s.subscribe(ctx)
+	go s.run()
+	return sub, nil
+}
+
+// hasOutgoingMetadata returns true if ctx has metadata to send to the servers.
+func hasOutgoingMetadata(ctx context.Context) bool {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	return ok && md.Len() > 0
+}
+
+// remove removes s from the streams available to new subscriptions.
+func (c *SharedStreamClient) remove(s *sharedStream) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.streams[s.key] == s {
+		delete(c.streams, s.key)
+	}
+}
+
+// sharedStream receives the events of a stream, and keeps them until they
+// have been received by every subscription.
+type sharedStream struct {
+	client *SharedStreamClient
+	key    sharedStreamKey
+	stream pbsubscribe.StateChangeSubscription_SubscribeClient
+	cancel context.CancelFunc
+
+	// headerCh is closed once header and headerErr have been read from the
+	// stream.
+	headerCh  chan struct{}
+	header    metadata.MD
+	headerErr error
+
+	// lock protects all the fields below it, and the pos and done of each
+	// subscription.
+	lock sync.Mutex
+	// log contains the events which have not been received by every
+	// subscription. base is the position in the stream of log[0].
+	log  []*pbsubscribe.Event
+	base int
+	// err is the error returned by the stream. Subscriptions return it once
+	// they have received all the events in log.
+	err error
+	// updateCh is closed when an event or error is received from the stream.
+	updateCh chan struct{}
+	// joinable is true while new subscriptions may use the stream. While it is
+	// true, log contains every event received by the stream.
+	joinable    bool
+	subscribers map[*sharedSubscription]struct{}
+}
+
+// subscribe returns a new subscription to the stream, or nil if the stream is
+// no longer joinable. The subscription is removed from the stream when ctx is
+// cancelled.
+func (s *sharedStream) subscribe(ctx context.Context) *sharedSubscription {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.joinable {
+		return nil
+	}
+
+	sub := &sharedSubscription{
+		stream: s,
+		ctx:    ctx,
+		pos:    s.base,
+	}
+	s.subscribers[sub] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		s.unsubscribe(sub)
+	}()
+	return sub
+}
+
+// unsubscribe removes sub from the stream. The stream is stopped once it has
+// no subscriptions.
+func (s *sharedStream) unsubscribe(sub *sharedSubscription) {
+	s.lock.Lock()
+	delete(s.subscribers, sub)
+	last := len(s.subscribers) == 0
+	if last {
+		s.joinable = false
+	}
+	s.trimLocked()
+	s.lock.Unlock()
+
+	if last {
+		s.client.remove(s)
+		s.cancel()
+	}
+}
+
+func (s *sharedStream) run() {
+	s.header, s.headerErr = s.stream.Header()
+	close(s.headerCh)
+
+	for {
+		event, err := s.stream.Recv()
+
+		s.lock.Lock()
+		if err != nil {
+			s.err = err
+		} else {
+			s.log = append(s.log, event)
+		}
+		close(s.updateCh)
+		s.updateCh = make(chan struct{})
+
+		stopJoining := s.joinable && (err != nil || len(s.log) > s.client.maxReplay)
+		if stopJoining {
+			s.joinable = false
+		}
+		s.trimLocked()
+		s.lock.Unlock()
+
+		if stopJoining {
+			s.client.remove(s)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// trimLocked removes the events which have been received by every
+// subscription from log, once the stream is no longer joinable. It must be
+// called while holding s.lock.
+func (s *sharedStream) trimLocked() {
+	if s.joinable {
+		return
+	}
+	min := s.base + len(s.log)
+	for sub := range s.subscribers {
+		if sub.pos < min {
+			min = sub.pos
+		}
+	}
+	n := min - s.base
+	for i := 0; i < n; i++ {
+		s.log[i] = nil
+	}
+	s.log = s.log[n:]
+	s.base = min
+}
+
+// sharedSubscription is the subscription returned by SharedStreamClient. It
+// receives the events of the shared stream from the position at which it
+// subscribed.
+type sharedSubscription struct {
+	stream *sharedStream
+	ctx    context.Context
+	// pos is the position in the stream of the next event to receive.
+	pos int
+	// done is true once Recv has returned the error of the stream.
+	done bool
+}
+
+var errSharedSubscriptionMsg = errors.New("SendMsg and RecvMsg are not supported by shared subscriptions")
+
+// Recv returns the next event of the shared stream. It blocks until the event
+// is received, or until the context of the subscription is cancelled.
+func (s *sharedSubscription) Recv() (*pbsubscribe.Event, error) {
+	st := s.stream
+	for {
+		st.lock.Lock()
+		if i := s.pos - st.base; i < len(st.log) {
+			event := st.log[i]
+			s.pos++
+			st.lock.Unlock()
+			return event, nil
+		}
+		if st.err != nil {
+			err := st.err
+			s.done = true
+			st.lock.Unlock()
+			return nil, err
+		}
+		updateCh := st.updateCh
+		st.lock.Unlock()
+
+		select {
+		case <-updateCh:
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		}
+	}
+}
+
+// Header returns a copy of the header metadata of the shared stream. Every
+// subscription to the stream is served by the same server, with the same
+// request, so they all receive the same header. Header blocks until the header
+// is received, or until the context of the subscription is cancelled.
+func (s *sharedSubscription) Header() (metadata.MD, error) {
+	select {
+	case <-s.stream.headerCh:
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+	if s.stream.headerErr != nil {
+		return nil, s.stream.headerErr
+	}
+	return s.stream.header.Copy(), nil
+}
+
+// Trailer returns a copy of the trailer metadata of the shared stream once Recv
+// has returned the error of the stream. Until then, or when the subscription
+// was stopped by its own context, it returns nil.
+func (s *sharedSubscription) Trailer() metadata.MD {
+	st := s.stream
+	st.lock.Lock()
+	done := s.done
+	st.lock.Unlock()
+	if !done {
+		return nil
+	}
+	return st.stream.Trailer().Copy()
+}
+
+// CloseSend does nothing, because the send direction of the stream is shared
+// with the other subscriptions. The subscription is stopped by cancelling its
+// context.
+func (s *sharedSubscription) CloseSend() error {
+	return nil
+}
+
+// Context returns the context of the subscription.
+func (s *sharedSubscription) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg is not supported, because the stream is shared.
+func (s *sharedSubscription) SendMsg(interface{}) error {
+	return errSharedSubscriptionMsg
+}
+
+// RecvMsg is not supported, because it would take the message from the other
+// subscriptions. Recv must be used instead.
+func (s *sharedSubscription) RecvMsg(interface{}) error {
+	return errSharedSubscriptionMsg
+}
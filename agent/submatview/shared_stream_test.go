@@ -0,0 +1,202 @@
+package submatview
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/hashicorp/consul/proto/pbcommon"
+	"github.com/hashicorp/consul/proto/pbservice"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+)
+
+func TestSharedStreamClient_MaterializersShareStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	shared := NewSharedStreamClient(client, 0)
+
+	newMaterializer := func() *Materializer {
+		return NewMaterializer(Deps{
+			View:    &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+			Client:  shared,
+			Logger:  hclog.New(nil),
+			Request: newFakeSubscribeRequest,
+		})
+	}
+
+	first := newMaterializer()
+	go first.Run(ctx)
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+	result, err := first.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	// The second materializer subscribes after the snapshot was received, and
+	// receives the snapshot from the shared stream.
+	second := newMaterializer()
+	go second.Run(ctx)
+
+	result, err = second.getFromView(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+	require.Len(t, result.Value.(fakeResult).srvs, 1)
+
+	client.QueueEvents(newEventServiceHealthRegister(5, 2, "srv1"))
+
+	for _, m := range []*Materializer{first, second} {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		result, err := m.getFromView(ctx, 4)
+		cancel()
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), result.Index)
+		require.Len(t, result.Value.(fakeResult).srvs, 2)
+	}
+
+	client.lock.RLock()
+	require.Len(t, client.subClients, 1, "expected a single stream")
+	client.lock.RUnlock()
+}
+
+func TestSharedStreamClient_StopsStreamWithoutSubscribers(t *testing.T) {
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	shared := NewSharedStreamClient(client, 0)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+
+	subA, err := shared.Subscribe(ctxA, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+	_, err = shared.Subscribe(ctxB, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+
+	client.lock.RLock()
+	require.Len(t, client.subClients, 1)
+	stream := client.subClients[0]
+	client.lock.RUnlock()
+
+	cancelA()
+	_, err = subA.Recv()
+	require.Equal(t, context.Canceled, err)
+	require.NoError(t, stream.ctx.Err(), "expected the stream to be used by the other subscription")
+
+	cancelB()
+	retry.Run(t, func(r *retry.R) {
+		require.Error(r, stream.ctx.Err(), "expected the stream to be stopped")
+	})
+
+	// A new subscription starts a new stream.
+	ctxC, cancelC := context.WithCancel(context.Background())
+	defer cancelC()
+	_, err = shared.Subscribe(ctxC, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+
+	client.lock.RLock()
+	require.Len(t, client.subClients, 2)
+	client.lock.RUnlock()
+}
+
+func TestSharedStreamClient_MaxReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	shared := NewSharedStreamClient(client, 2)
+
+	sub, err := shared.Subscribe(ctx, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEventServiceHealthRegister(4, 2, "srv1"),
+		newEndOfSnapshotEvent(4))
+	for i := 0; i < 3; i++ {
+		_, err := sub.Recv()
+		require.NoError(t, err)
+	}
+
+	// The stream has received more than maxReplay events, so a new
+	// subscription starts a new stream.
+	_, err = shared.Subscribe(ctx, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+
+	// Subscriptions which resume from an index are never shared.
+	_, err = shared.Subscribe(ctx, newFakeSubscribeRequest(4))
+	require.NoError(t, err)
+
+	client.lock.RLock()
+	require.Len(t, client.subClients, 3)
+	client.lock.RUnlock()
+}
+
+func TestSharedStreamClient_OutgoingMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	shared := NewSharedStreamClient(client, 0)
+
+	_, err := shared.Subscribe(ctx, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+
+	// Subscriptions with outgoing metadata never share a stream, because the
+	// stream would be started with the metadata of another subscription.
+	mdCtx := metadata.AppendToOutgoingContext(ctx, "x-test", "value")
+	_, err = shared.Subscribe(mdCtx, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+	_, err = shared.Subscribe(mdCtx, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	require.Len(t, client.subClients, 3)
+	require.Equal(t, mdCtx, client.subClients[1].ctx)
+	require.Equal(t, mdCtx, client.subClients[2].ctx)
+}
+
+func TestSharedStreamClient_HeaderAndTrailer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	client.SetServerID("server-one")
+	shared := NewSharedStreamClient(client, 0)
+
+	first, err := shared.Subscribe(ctx, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+	_, err = first.Recv()
+	require.NoError(t, err)
+
+	late, err := shared.Subscribe(ctx, newFakeSubscribeRequest(0))
+	require.NoError(t, err)
+
+	// Every subscription receives its own copy of the header of the stream.
+	md, err := late.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{"server-one"}, md.Get(pbsubscribe.ServerIDMetadataKey))
+	md.Set(pbsubscribe.ServerIDMetadataKey, "changed")
+
+	md, err = first.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{"server-one"}, md.Get(pbsubscribe.ServerIDMetadataKey))
+
+	// The trailer is only available once the stream has ended.
+	require.Nil(t, late.Trailer())
+
+	// Subscriptions must not read messages from the shared stream directly.
+	require.Error(t, late.RecvMsg(&pbsubscribe.Event{}))
+	require.Error(t, late.SendMsg(&pbsubscribe.SubscribeRequest{}))
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/hashicorp/consul/agent/cache"
@@ -85,6 +87,9 @@ func (s *Store) Run(ctx context.Context) {
 			if e.requests == 0 {
 				e.stop()
 				delete(s.byKey, he.Key())
+				// A new request for the same key will have to start a new
+				// subscription from a snapshot.
+				metrics.IncrCounter([]string{"submatview", "store", "expired"}, 1)
 			}
 
 			s.lock.Unlock()
@@ -92,9 +97,16 @@ func (s *Store) Run(ctx context.Context) {
 	}
 }
 
+// ErrViewStale is returned by Store.Get when the view has been disconnected
+// from the servers for longer than the cache.RequestInfo.MaxStaleDuration of
+// the request.
+var ErrViewStale = errors.New("materialized view is stale")
+
 // Request is used to request data from the Store.
 // Note that cache.Request is required, but some of the fields cache.RequestInfo
-// fields are ignored (ex: MaxAge, and MustRevalidate).
+// fields are ignored (ex: MaxAge, and MustRevalidate). MaxStaleDuration is used
+// to limit how long a view may continue to be served after its subscription
+// has failed.
 type Request interface {
 	cache.Request
 	// NewMaterializer will be called if there is no active materializer to fulfil
@@ -108,7 +120,11 @@ type Request interface {
 }
 
 // Get a value from the store, blocking if the store has not yet seen the
-// req.Index value.
+// req.Index value. If the request has an IndexFloor, Get also blocks until the
+// index of the view is at least IndexFloor. Concurrent requests with the same
+// key share a single materializer, so only the first request for a key starts
+// a subscription. Cancelling ctx stops a blocked Get promptly, and Get returns
+// the error from ctx; other requests for the same view are not affected.
 // See agent/cache.Cache.Get for complete documentation.
 func (s *Store) Get(ctx context.Context, req Request) (Result, error) {
 	info := req.CacheInfo()
@@ -124,13 +140,58 @@ func (s *Store) Get(ctx context.Context, req Request) (Result, error) {
 		defer cancel()
 	}
 
+	if info.IndexFloor > 0 {
+		err := materializer.waitForIndex(ctx, info.IndexFloor)
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return Result{}, err
+		}
+	}
+
 	result, err := materializer.getFromView(ctx, info.MinIndex)
 	// context.DeadlineExceeded is translated to nil to match the timeout
 	// behaviour of agent/cache.Cache.Get.
-	if err == nil || errors.Is(err, context.DeadlineExceeded) {
-		return result, nil
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return result, err
+	}
+
+	if stale := materializer.staleDuration(); info.MaxStaleDuration > 0 && stale > info.MaxStaleDuration {
+		return result, fmt.Errorf("%w: disconnected from the servers for %v", ErrViewStale, stale)
+	}
+	return result, nil
+}
+
+// Peek returns the current result of the view for req without blocking, even
+// if the view is stale. It returns false if there is no view for req in the
+// store, or if the view has not yet received its initial snapshot. Peek does
+// not start a new materializer, and does not extend the lifetime of the entry
+// in the store. MinIndex, Timeout, MaxAge, and MaxStaleDuration of the request
+// are ignored.
+func (s *Store) Peek(req Request) (Result, bool) {
+	key := makeEntryKey(req.Type(), req.CacheInfo())
+
+	s.lock.RLock()
+	e, ok := s.byKey[key]
+	s.lock.RUnlock()
+	if !ok {
+		return Result{}, false
+	}
+	return e.materializer.peek()
+}
+
+// Warm starts a materializer for each of the requests that does not already
+// have one in the store, so that the first Get for the request does not have
+// to wait for a new subscription to receive its snapshot. Warm does not block.
+// A warmed entry that is not requested is expired after the idle TTL, like any
+// other entry without active requests.
+func (s *Store) Warm(reqs []Request) error {
+	for _, req := range reqs {
+		key, _, err := s.readEntry(req)
+		if err != nil {
+			return fmt.Errorf("failed to warm %v: %w", makeEntryKey(req.Type(), req.CacheInfo()), err)
+		}
+		s.releaseEntry(key)
 	}
-	return result, err
+	return nil
 }
 
 // Notify the updateCh when there are updates to the entry identified by req.
@@ -159,6 +220,10 @@ func (s *Store) Notify(
 			switch {
 			case ctx.Err() != nil:
 				return
+			case errors.Is(err, ErrMaterializerClosed):
+				// The materializer will not be updated again. A new request
+				// for the same key starts a new materializer.
+				return
 			case err != nil:
 				s.logger.Warn("handling error in Store.Notify",
 					"error", err,
@@ -171,11 +236,16 @@ func (s *Store) Notify(
 			u := cache.UpdateEvent{
 				CorrelationID: correlationID,
 				Result:        result.Value,
-				Meta:          cache.ResultMeta{Index: result.Index, Hit: result.Cached},
+				Meta: cache.ResultMeta{
+					Index:         result.Index,
+					Hit:           result.Cached,
+					Hash:          result.Hash,
+					ServerID:      result.ServerID,
+					EventsApplied: result.EventsApplied,
+					Age:           result.Age,
+				},
 			}
-			select {
-			case updateCh <- u:
-			case <-ctx.Done():
+			if !s.sendUpdate(ctx, materializer, updateCh, u) {
 				return
 			}
 		}
@@ -183,6 +253,53 @@ func (s *Store) Notify(
 	return nil
 }
 
+// sendUpdate sends u to updateCh, and reports the consumer as lagging if it
+// does not receive u within the Deps.ConsumerLagThreshold of the
+// materializer. It returns false if ctx is cancelled before u is received.
+func (s *Store) sendUpdate(
+	ctx context.Context,
+	materializer *Materializer,
+	updateCh chan<- cache.UpdateEvent,
+	u cache.UpdateEvent,
+) bool {
+	if threshold := materializer.deps.ConsumerLagThreshold; threshold > 0 {
+		start := time.Now()
+		timer := time.NewTimer(threshold)
+		defer timer.Stop()
+		select {
+		case updateCh <- u:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+
+		materializer.lock.Lock()
+		lag := ConsumerLag{
+			CorrelationID: u.CorrelationID,
+			Index:         u.Meta.Index,
+			ViewIndex:     materializer.index,
+			Wait:          time.Since(start),
+		}
+		materializer.lock.Unlock()
+		metrics.IncrCounter([]string{"submatview", "consumer", "lagging"}, 1)
+		s.logger.Debug("consumer of Store.Notify is lagging",
+			"correlation-id", lag.CorrelationID,
+			"index", lag.Index,
+			"view-index", lag.ViewIndex)
+		if materializer.deps.OnConsumerLag != nil {
+			materializer.deps.OnConsumerLag(lag)
+		}
+	}
+
+	select {
+	case updateCh <- u:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 // readEntry from the store, and increment the requests counter. releaseEntry
 // must be called when the request is finished to decrement the counter.
 func (s *Store) readEntry(req Request) (string, *Materializer, error) {
@@ -192,7 +309,7 @@ func (s *Store) readEntry(req Request) (string, *Materializer, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	e, ok := s.byKey[key]
-	if ok {
+	if ok && !e.materializer.isClosed() {
 		e.requests++
 		s.byKey[key] = e
 		return key, e.materializer, nil
@@ -206,11 +323,15 @@ func (s *Store) readEntry(req Request) (string, *Materializer, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	go mat.Run(ctx)
 
-	e = entry{
-		materializer: mat,
-		stop:         cancel,
-		requests:     1,
+	if ok {
+		// The materializer of the entry was closed, so it is replaced. The
+		// entry keeps its request count, because requests which are still
+		// using the closed materializer release the same entry.
+		e.stop()
 	}
+	e.materializer = mat
+	e.stop = cancel
+	e.requests++
 	s.byKey[key] = e
 	return key, e.materializer, nil
 }
@@ -237,6 +358,58 @@ func (s *Store) releaseEntry(key string) {
 	s.expiryHeap.Update(e.expiry.Index(), s.idleTTL)
 }
 
+// SubscriptionInfo describes the subscription of an entry in the Store.
+type SubscriptionInfo struct {
+	// EntryKey is the key used to index the entry in the Store.
+	EntryKey string
+	// Topic and Key identify the stream of events the entry is subscribed to.
+	Topic string
+	Key   string
+	// Index is the last index applied to the view.
+	Index uint64
+	// Connected is true when the subscription has received a snapshot and is
+	// currently receiving events.
+	Connected bool
+	// DisconnectedAt is the time the subscription failed, or the zero value if
+	// the subscription has not failed.
+	DisconnectedAt time.Time
+	// Requests is the number of active requests using the entry.
+	Requests int
+}
+
+// Subscriptions returns a description of all the entries in the Store, sorted
+// by EntryKey. It is intended to be used for debugging.
+func (s *Store) Subscriptions() []SubscriptionInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := make([]SubscriptionInfo, 0, len(s.byKey))
+	for key, e := range s.byKey {
+		info := e.materializer.subscriptionInfo()
+		info.EntryKey = key
+		info.Requests = e.requests
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].EntryKey < result[j].EntryKey
+	})
+	return result
+}
+
+// ResubscribeAll stops the subscription of every entry in the Store and starts
+// a new one. It may be used after a change that should be applied to every
+// subscription, such as a change to ACL policies, because the servers only
+// authorize a subscription when it is started. Requests which are blocked on
+// an entry receive the results of the new subscription.
+func (s *Store) ResubscribeAll() {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, e := range s.byKey {
+		e.materializer.Resubscribe()
+	}
+}
+
 // makeEntryKey matches agent/cache.makeEntryKey, but may change in the future.
 func makeEntryKey(typ string, r cache.RequestInfo) string {
 	return fmt.Sprintf("%s/%s/%s/%s", typ, r.Datacenter, r.Token, r.Key)
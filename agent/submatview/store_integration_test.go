@@ -65,18 +65,27 @@ func TestStore_IntegrationWithBackend(t *testing.T) {
 	}
 
 	group, gctx := errgroup.WithContext(ctx)
+	var producing sync.WaitGroup
 	for i := range producers {
 		producer := producers[i]
+		producing.Add(1)
 		group.Go(func() error {
+			defer producing.Done()
 			producer.Produce(gctx, pub)
 			return nil
 		})
 	}
+	producersDone := make(chan struct{})
+	go func() {
+		producing.Wait()
+		close(producersDone)
+	}()
 
 	for i := range consumers {
 		consumer := consumers[i]
+		producer := producers[state.EventSubjectService{Key: consumer.srvName}.String()]
 		group.Go(func() error {
-			return consumer.Consume(gctx, maxIndex)
+			return consumer.Consume(gctx, maxIndex, producersDone, producer.finalNodes)
 		})
 	}
 
@@ -160,8 +169,10 @@ type eventProducer struct {
 	topic        stream.Topic
 	srvName      string
 	nodesByIndex map[uint64][]string
-	nodesLock    sync.Mutex
-	maxIndex     uint64
+	// lastIndex is the index of the last event published by the producer.
+	lastIndex uint64
+	nodesLock sync.Mutex
+	maxIndex  uint64
 }
 
 func newEventProducer(
@@ -259,6 +270,7 @@ func (e *eventProducer) Produce(ctx context.Context, pub *stream.EventPublisher)
 		e.nodesLock.Lock()
 		pub.Publish([]stream.Event{event})
 		e.nodesByIndex[idx] = copyNodeList(nodes)
+		e.lastIndex = idx
 		e.nodesLock.Unlock()
 
 		if idx > e.maxIndex {
@@ -270,6 +282,13 @@ func (e *eventProducer) Produce(ctx context.Context, pub *stream.EventPublisher)
 	}
 }
 
+// finalNodes returns the nodes after the last event published by the producer.
+func (e *eventProducer) finalNodes() []string {
+	e.nodesLock.Lock()
+	defer e.nodesLock.Unlock()
+	return e.nodesByIndex[e.lastIndex]
+}
+
 func nodeName(i int) string {
 	return fmt.Sprintf("node-%d", i)
 }
@@ -315,7 +334,16 @@ func newConsumer(t *testing.T, addr net.Addr, store *submatview.Store, srv strin
 	}
 }
 
-func (c *consumer) Consume(ctx context.Context, maxIndex uint64) error {
+// Consume records the nodes of each update until it receives an index greater
+// than or equal to maxIndex. Events which do not change the nodes of the
+// service do not send an update, so once producersDone is closed Consume also
+// returns when the nodes of the last update are the finalNodes of the producer.
+func (c *consumer) Consume(
+	ctx context.Context,
+	maxIndex uint64,
+	producersDone <-chan struct{},
+	finalNodes func() []string,
+) error {
 	req := structs.ServiceSpecificRequest{ServiceName: c.srvName}
 	updateCh := make(chan cache.UpdateEvent, 10)
 
@@ -325,6 +353,8 @@ func (c *consumer) Consume(ctx context.Context, maxIndex uint64) error {
 	})
 	group.Go(func() error {
 		var idx uint64
+		var nodes []string
+		var produced bool
 		for {
 			if idx >= maxIndex {
 				return nil
@@ -332,10 +362,17 @@ func (c *consumer) Consume(ctx context.Context, maxIndex uint64) error {
 			select {
 			case u := <-updateCh:
 				idx = u.Meta.Index
-				c.states[u.Meta.Index] = stateFromUpdates(u)
+				nodes = stateFromUpdates(u)
+				c.states[u.Meta.Index] = nodes
+			case <-producersDone:
+				produced = true
+				producersDone = nil
 			case <-cctx.Done():
 				return nil
 			}
+			if produced && idx > 0 && cmp.Equal(nodes, finalNodes(), cmpopts.EquateEmpty()) {
+				return nil
+			}
 		}
 	})
 	return group.Wait()
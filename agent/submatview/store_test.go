@@ -2,10 +2,13 @@ package submatview
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
 
@@ -194,6 +197,63 @@ func TestStore_Get(t *testing.T) {
 
 }
 
+func TestStore_Get_IndexFloor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req.client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	result, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	// The view is already past MinIndex, but not yet at the floor.
+	req.floor = 10
+	req.timeout = 5 * time.Second
+	chResult := make(chan resultOrError, 1)
+	go func() {
+		result, err := store.Get(ctx, req)
+		chResult <- resultOrError{Result: result, Err: err}
+	}()
+
+	assertBlocked := func() {
+		t.Helper()
+		select {
+		case r := <-chResult:
+			t.Fatalf("expected Get to block, got index %v, err %v", r.Result.Index, r.Err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	assertBlocked()
+
+	// An event below the floor does not unblock the request.
+	req.client.QueueEvents(newEventServiceHealthRegister(6, 2, "srv1"))
+	assertBlocked()
+
+	req.client.QueueEvents(newEventServiceHealthRegister(10, 3, "srv1"))
+	select {
+	case r := <-chResult:
+		require.NoError(t, r.Err)
+		require.Equal(t, uint64(10), r.Result.Index)
+		require.Len(t, r.Result.Value.(fakeResult).srvs, 3)
+	case <-time.After(time.Second):
+		t.Fatal("expected Get to return once the view reached the floor")
+	}
+
+	// A view at the floor satisfies the request immediately.
+	result, err = store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), result.Index)
+}
+
 type resultOrError struct {
 	Result Result
 	Err    error
@@ -201,9 +261,13 @@ type resultOrError struct {
 
 type fakeRequest struct {
 	index   uint64
+	floor   uint64
 	timeout time.Duration
 	key     string
 	client  *TestStreamingClient
+
+	lagThreshold time.Duration
+	onLag        func(ConsumerLag)
 }
 
 func (r *fakeRequest) CacheInfo() cache.RequestInfo {
@@ -217,6 +281,7 @@ func (r *fakeRequest) CacheInfo() cache.RequestInfo {
 		Datacenter: "dc1",
 		Timeout:    r.timeout,
 		MinIndex:   r.index,
+		IndexFloor: r.floor,
 	}
 }
 
@@ -228,7 +293,7 @@ func (r *fakeRequest) NewMaterializer() (*Materializer, error) {
 		Request: func(index uint64) *pbsubscribe.SubscribeRequest {
 			req := &pbsubscribe.SubscribeRequest{
 				Topic:      pbsubscribe.Topic_ServiceHealth,
-				Key:        "key",
+				Key:        r.CacheInfo().Key,
 				Token:      "abcd",
 				Datacenter: "dc1",
 				Index:      index,
@@ -236,6 +301,8 @@ func (r *fakeRequest) NewMaterializer() (*Materializer, error) {
 			}
 			return req
 		},
+		ConsumerLagThreshold: r.lagThreshold,
+		OnConsumerLag:        r.onLag,
 	}), nil
 }
 
@@ -284,6 +351,209 @@ func (f *fakeView) Reset() {
 	f.srvs = make(map[string]*pbservice.CheckServiceNode)
 }
 
+func TestStore_Get_ConcurrentRequestsShareMaterializer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	req := &countingRequest{fakeRequest: &fakeRequest{client: client}}
+
+	const count = 50
+	start := make(chan struct{})
+	chResult := make(chan resultOrError, count)
+	for i := 0; i < count; i++ {
+		go func() {
+			<-start
+			result, err := store.Get(ctx, req)
+			chResult <- resultOrError{Result: result, Err: err}
+		}()
+	}
+	close(start)
+
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	for i := 0; i < count; i++ {
+		select {
+		case r := <-chResult:
+			require.NoError(t, r.Err)
+			require.Equal(t, uint64(4), r.Result.Index)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for result %d", i)
+		}
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&req.materializers))
+	client.lock.RLock()
+	require.Len(t, client.subClients, 1)
+	client.lock.RUnlock()
+}
+
+// countingRequest counts the materializers created for the request.
+type countingRequest struct {
+	*fakeRequest
+	materializers int32
+}
+
+func (r *countingRequest) NewMaterializer() (*Materializer, error) {
+	atomic.AddInt32(&r.materializers, 1)
+	return r.fakeRequest.NewMaterializer()
+}
+
+func TestStore_Get_ContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req.client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	result, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	run := func(t *testing.T, req *fakeRequest) {
+		reqCtx, reqCancel := context.WithCancel(ctx)
+		defer reqCancel()
+
+		chResult := make(chan resultOrError, 1)
+		go func() {
+			result, err := store.Get(reqCtx, req)
+			chResult <- resultOrError{Result: result, Err: err}
+		}()
+
+		select {
+		case r := <-chResult:
+			t.Fatalf("expected Get to block, got index %v, err %v", r.Result.Index, r.Err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		reqCancel()
+		select {
+		case r := <-chResult:
+			require.True(t, errors.Is(r.Err, context.Canceled), "unexpected error: %v", r.Err)
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("expected Get to return promptly when the context is cancelled")
+		}
+		assertRequestCount(t, store, req, 0)
+	}
+
+	t.Run("waiting for MinIndex", func(t *testing.T) {
+		run(t, &fakeRequest{client: req.client, index: 4, timeout: 10 * time.Second})
+	})
+
+	t.Run("waiting for IndexFloor", func(t *testing.T) {
+		run(t, &fakeRequest{client: req.client, floor: 10, timeout: 10 * time.Second})
+	})
+}
+
+func TestStore_Peek(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+
+	_, ok := store.Peek(req)
+	require.False(t, ok, "expected no result before the materializer is started")
+
+	// Start the materializer, and wait for the request to time out before the
+	// snapshot is received.
+	req.timeout = 10 * time.Millisecond
+	_, err := store.Get(ctx, req)
+	require.NoError(t, err)
+
+	_, ok = store.Peek(req)
+	require.False(t, ok, "expected no result before the snapshot")
+
+	req.client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+	retry.Run(t, func(r *retry.R) {
+		result, ok := store.Peek(req)
+		require.True(r, ok)
+		require.Equal(r, uint64(4), result.Index)
+		require.Len(r, result.Value.(fakeResult).srvs, 1)
+	})
+
+	// Peek returns the current result immediately, even when a request with
+	// the same MinIndex would block.
+	req.index = 4
+	chResult := make(chan resultOrError, 1)
+	go func() {
+		result, ok := store.Peek(req)
+		if !ok {
+			chResult <- resultOrError{Err: fmt.Errorf("expected a result")}
+			return
+		}
+		chResult <- resultOrError{Result: result}
+	}()
+	select {
+	case r := <-chResult:
+		require.NoError(t, r.Err)
+		require.Equal(t, uint64(4), r.Result.Index)
+	case <-time.After(time.Second):
+		t.Fatal("expected Peek to return without blocking")
+	}
+}
+
+func TestStore_Warm(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	client := NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)
+	req := &countingRequest{fakeRequest: &fakeRequest{client: client}}
+	client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	require.NoError(t, store.Warm([]Request{req}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&req.materializers))
+	// The warmed entry has no active requests, so it expires if it is not used.
+	assertRequestCount(t, store, req, 0)
+
+	retry.Run(t, func(r *retry.R) {
+		_, ok := store.Peek(req)
+		require.True(r, ok, "expected the snapshot to be received")
+	})
+
+	// Warming the same request again does not start another materializer.
+	require.NoError(t, store.Warm([]Request{req}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&req.materializers))
+
+	chResult := make(chan resultOrError, 1)
+	go func() {
+		result, err := store.Get(ctx, req)
+		chResult <- resultOrError{Result: result, Err: err}
+	}()
+	select {
+	case r := <-chResult:
+		require.NoError(t, r.Err)
+		require.Equal(t, uint64(4), r.Result.Index)
+		require.Len(t, r.Result.Value.(fakeResult).srvs, 1)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("expected the first Get of a warmed entry to return immediately")
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&req.materializers))
+}
+
 func TestStore_Notify(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -353,6 +623,240 @@ func TestStore_Notify(t *testing.T) {
 	})
 }
 
+func TestStore_Notify_MaterializerClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &countingRequest{fakeRequest: &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}}
+	req.client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	ch := make(chan cache.UpdateEvent)
+	require.NoError(t, store.Notify(ctx, req, "cid", ch))
+
+	select {
+	case update := <-ch:
+		require.Equal(t, uint64(4), update.Meta.Index)
+	case <-time.After(time.Second):
+		t.Fatalf("expected an update from the snapshot")
+	}
+
+	runStep(t, "closing the materializer stops the notify", func(t *testing.T) {
+		store.lock.RLock()
+		mat := store.byKey[makeEntryKey(req.Type(), req.CacheInfo())].materializer
+		store.lock.RUnlock()
+		require.NoError(t, mat.Close())
+
+		retry.Run(t, func(r *retry.R) {
+			assertRequestCount(r, store, req, 0)
+		})
+		select {
+		case update := <-ch:
+			t.Fatalf("unexpected update after the materializer was closed: %v", update)
+		default:
+		}
+	})
+
+	runStep(t, "a new request replaces the closed materializer", func(t *testing.T) {
+		getCtx, getCancel := context.WithTimeout(ctx, time.Second)
+		defer getCancel()
+		result, err := store.Get(getCtx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), result.Index)
+
+		require.Equal(t, int32(2), atomic.LoadInt32(&req.materializers))
+		assertRequestCount(t, store, req, 0)
+	})
+}
+
+func TestStore_Notify_ServerID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req.client.SetServerID("server-1")
+	req.client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	ch := make(chan cache.UpdateEvent)
+	err := store.Notify(ctx, req, "correlate", ch)
+	require.NoError(t, err)
+
+	select {
+	case update := <-ch:
+		require.NoError(t, update.Err)
+		require.Equal(t, uint64(4), update.Meta.Index)
+		require.Equal(t, "server-1", update.Meta.ServerID)
+	case <-time.After(time.Second):
+		t.Fatal("expected an update with the snapshot")
+	}
+
+	result, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, "server-1", result.ServerID)
+}
+
+func TestStore_Notify_ConsumerLag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	lagCh := make(chan ConsumerLag, 1)
+	req := &fakeRequest{
+		client:       NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+		lagThreshold: 200 * time.Millisecond,
+		onLag: func(lag ConsumerLag) {
+			lagCh <- lag
+		},
+	}
+	req.client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	// The consumer does not receive from ch until the lag is reported.
+	ch := make(chan cache.UpdateEvent)
+	err := store.Notify(ctx, req, "correlate", ch)
+	require.NoError(t, err)
+
+	result, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), result.Index)
+
+	select {
+	case lag := <-lagCh:
+		require.Equal(t, "correlate", lag.CorrelationID)
+		require.Equal(t, uint64(4), lag.Index)
+		require.GreaterOrEqual(t, lag.ViewIndex, lag.Index)
+		require.GreaterOrEqual(t, int64(lag.Wait), int64(200*time.Millisecond))
+	case <-time.After(time.Second):
+		t.Fatal("expected the lag to be reported")
+	}
+
+	// The view continues to be updated while the consumer is lagging.
+	req.client.QueueEvents(newEventServiceHealthRegister(5, 2, "srv1"))
+	req.index = 4
+	result, err = store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+
+	// Once the consumer catches up it receives the pending update, and then
+	// the latest result.
+	update := <-ch
+	require.Equal(t, uint64(4), update.Meta.Index)
+	select {
+	case update := <-ch:
+		require.Equal(t, uint64(5), update.Meta.Index)
+	case <-time.After(time.Second):
+		t.Fatal("expected an update with the latest result")
+	}
+
+	// A consumer which keeps up is not reported.
+	select {
+	case lag := <-lagCh:
+		t.Fatalf("unexpected lag reported: %+v", lag)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestStore_EventsApplied(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req.client.QueueEvents(newEndOfSnapshotEvent(2))
+
+	result, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), result.Index)
+	require.Equal(t, uint64(0), result.EventsApplied)
+
+	ch := make(chan cache.UpdateEvent)
+	err = store.Notify(ctx, req, "correlate", ch)
+	require.NoError(t, err)
+	select {
+	case update := <-ch:
+		require.Equal(t, uint64(2), update.Meta.Index)
+	case <-time.After(time.Second):
+		t.Fatal("expected an update with the snapshot")
+	}
+
+	req.client.QueueEvents(newEventBatchWithEvents(
+		newEventServiceHealthRegister(5, 1, "srv1"),
+		newEventServiceHealthRegister(5, 2, "srv1"),
+		newEventServiceHealthRegister(5, 3, "srv1")))
+
+	select {
+	case update := <-ch:
+		require.NoError(t, update.Err)
+		require.Equal(t, uint64(5), update.Meta.Index)
+		require.Equal(t, uint64(3), update.Meta.EventsApplied)
+	case <-time.After(time.Second):
+		t.Fatal("expected an update with the batch of events")
+	}
+
+	req.index = 2
+	result, err = store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), result.Index)
+	require.Equal(t, uint64(3), result.EventsApplied)
+}
+
+func TestStore_Get_Age(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req.client.QueueEvents(
+		newEventServiceHealthRegister(4, 1, "srv1"),
+		newEndOfSnapshotEvent(4))
+
+	first, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), first.Index)
+
+	// The age increases while no events are received.
+	time.Sleep(20 * time.Millisecond)
+	second, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), second.Index)
+	require.True(t, second.Age >= first.Age+20*time.Millisecond,
+		"expected age to increase, got %v then %v", first.Age, second.Age)
+
+	// The age is reset when an event is applied to the view.
+	req.client.QueueEvents(newEventServiceHealthRegister(5, 2, "srv1"))
+	req.index = 4
+	req.timeout = time.Second
+	third, err := store.Get(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), third.Index)
+	require.True(t, third.Age < second.Age,
+		"expected age to be reset, got %v after %v", third.Age, second.Age)
+}
+
 func TestStore_Notify_ManyRequests(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -445,6 +949,119 @@ func TestStore_Notify_ManyRequests(t *testing.T) {
 	})
 }
 
+func TestStore_Subscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req1 := &fakeRequest{
+		key:    "web",
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req1.client.QueueEvents(newEndOfSnapshotEvent(4))
+
+	req2 := &fakeRequest{
+		key:    "api",
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req2.client.QueueEvents(
+		newEndOfSnapshotEvent(2),
+		newEventServiceHealthRegister(9, 1, "api"))
+
+	require.Len(t, store.Subscriptions(), 0)
+
+	for _, req := range []*fakeRequest{req1, req2} {
+		req := req
+		retry.Run(t, func(r *retry.R) {
+			_, err := store.Get(ctx, req)
+			require.NoError(r, err)
+		})
+	}
+	// Wait for the event which follows the snapshot of req2.
+	req2.index = 2
+	_, err := store.Get(ctx, req2)
+	require.NoError(t, err)
+
+	subs := store.Subscriptions()
+	require.Len(t, subs, 2)
+
+	require.Equal(t, makeEntryKey(req2.Type(), req2.CacheInfo()), subs[0].EntryKey)
+	require.Equal(t, pbsubscribe.Topic_ServiceHealth.String(), subs[0].Topic)
+	require.Equal(t, "api", subs[0].Key)
+	require.Equal(t, uint64(9), subs[0].Index)
+	require.True(t, subs[0].Connected)
+	require.Equal(t, 0, subs[0].Requests)
+
+	require.Equal(t, makeEntryKey(req1.Type(), req1.CacheInfo()), subs[1].EntryKey)
+	require.Equal(t, "web", subs[1].Key)
+	require.Equal(t, uint64(4), subs[1].Index)
+	require.True(t, subs[1].Connected)
+}
+
+func TestStore_ResubscribeAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	reqs := []*fakeRequest{
+		{key: "web", client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)},
+		{key: "api", client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace)},
+	}
+	for _, req := range reqs {
+		req.client.QueueEvents(newEndOfSnapshotEvent(1))
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), result.Index)
+
+		// The servers resume the new subscription from the index of the view, so
+		// the snapshot is not sent again.
+		req.client.lock.Lock()
+		req.client.events = nil
+		req.client.lock.Unlock()
+	}
+
+	// Start a blocking query, which should receive the result of the new
+	// subscription.
+	blocking := &fakeRequest{key: "web", index: 1, timeout: time.Second, client: reqs[0].client}
+	chResult := make(chan resultOrError, 1)
+	go func() {
+		result, err := store.Get(ctx, blocking)
+		chResult <- resultOrError{Result: result, Err: err}
+	}()
+
+	store.ResubscribeAll()
+
+	for _, req := range reqs {
+		req := req
+		retry.Run(t, func(r *retry.R) {
+			req.client.lock.RLock()
+			defer req.client.lock.RUnlock()
+			require.Len(r, req.client.subClients, 2)
+			require.Error(r, req.client.subClients[0].ctx.Err(), "expected the first subscription to be stopped")
+			require.NoError(r, req.client.subClients[1].ctx.Err())
+		})
+	}
+
+	reqs[0].client.QueueEvents(newEventServiceHealthRegister(2, 1, "web"))
+
+	select {
+	case res := <-chResult:
+		require.NoError(t, res.Err)
+		require.Equal(t, uint64(2), res.Result.Index)
+		require.Len(t, res.Result.Value.(fakeResult).srvs, 1)
+	case <-time.After(time.Second):
+		t.Fatalf("expected the blocking query to return the new result")
+	}
+
+	for _, sub := range store.Subscriptions() {
+		require.True(t, sub.Connected)
+	}
+}
+
 type testingT interface {
 	Helper()
 	Fatalf(string, ...interface{})
@@ -505,6 +1122,57 @@ func TestStore_Run_ExpiresEntries(t *testing.T) {
 	require.Equal(t, ttlcache.NotIndexed, e.expiry.Index())
 }
 
+func TestStore_Run_ExpiredEntriesMetric(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	cfg := metrics.DefaultConfig("consul.submatview.test")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	metrics.NewGlobal(cfg, sink)
+	t.Cleanup(func() {
+		metrics.NewGlobal(cfg, &metrics.BlackholeSink{})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	store.idleTTL = 10 * time.Millisecond
+	go store.Run(ctx)
+
+	// expired returns the count of expired entries in every interval of the
+	// sink, because the entries may expire in different intervals.
+	expired := func(r *retry.R) int {
+		var count int
+		for _, interval := range sink.Data() {
+			interval.RLock()
+			if counter, ok := interval.Counters["consul.submatview.test.submatview.store.expired"]; ok {
+				count += counter.Count
+			}
+			interval.RUnlock()
+		}
+		return count
+	}
+
+	for i, key := range []string{"web", "api"} {
+		req := &fakeRequest{
+			key:    key,
+			client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+		}
+		req.client.QueueEvents(newEndOfSnapshotEvent(2))
+
+		_, err := store.Get(ctx, req)
+		require.NoError(t, err)
+
+		expected := i + 1
+		retry.Run(t, func(r *retry.R) {
+			require.Equal(r, expected, expired(r))
+		})
+		store.lock.Lock()
+		require.Len(t, store.byKey, 0)
+		store.lock.Unlock()
+	}
+}
+
 func runStep(t *testing.T, name string, fn func(t *testing.T)) {
 	t.Helper()
 	if !t.Run(name, fn) {
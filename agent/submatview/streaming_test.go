@@ -4,9 +4,14 @@ import (
 	"context"
 	"fmt"
 	"github.com/hashicorp/consul/proto/pbcommon"
+	"strconv"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/hashicorp/consul/proto/pbservice"
 	"github.com/hashicorp/consul/proto/pbsubscribe"
@@ -20,6 +25,12 @@ type TestStreamingClient struct {
 	subClients        []*subscribeClient
 	lock              sync.RWMutex
 	events            []eventOrErr
+	delay             time.Duration
+	serverID          string
+	leader            *bool
+	// disconnectAfter is the number of events received by the next
+	// subscription before it is disconnected, or -1 if it is not disconnected.
+	disconnectAfter int
 }
 
 type eventOrErr struct {
@@ -28,7 +39,7 @@ type eventOrErr struct {
 }
 
 func NewTestStreamingClient(ns string) *TestStreamingClient {
-	return &TestStreamingClient{expectedNamespace: ns}
+	return &TestStreamingClient{expectedNamespace: ns, disconnectAfter: -1}
 }
 
 func (s *TestStreamingClient) Subscribe(
@@ -40,11 +51,17 @@ func (s *TestStreamingClient) Subscribe(
 		return nil, fmt.Errorf("wrong SubscribeRequest.Namespace %v, expected %v",
 			req.Namespace, s.expectedNamespace)
 	}
+	s.lock.Lock()
 	c := &subscribeClient{
-		events: make(chan eventOrErr, 32),
-		ctx:    ctx,
+		events:   make(chan eventOrErr, 32),
+		ctx:      ctx,
+		delay:    s.delay,
+		serverID: s.serverID,
+		leader:   s.leader,
+
+		disconnectAfter: s.disconnectAfter,
 	}
-	s.lock.Lock()
+	s.disconnectAfter = -1
 	s.subClients = append(s.subClients, c)
 	for _, event := range s.events {
 		c.events <- event
@@ -55,8 +72,57 @@ func (s *TestStreamingClient) Subscribe(
 
 type subscribeClient struct {
 	grpc.ClientStream
-	events chan eventOrErr
-	ctx    context.Context
+	events   chan eventOrErr
+	ctx      context.Context
+	delay    time.Duration
+	serverID string
+	leader   *bool
+
+	// disconnectAfter and received are only used by the goroutine which calls
+	// Recv.
+	disconnectAfter int
+	received        int
+}
+
+// errDisconnected is returned by the Recv of a subscription disconnected by
+// TestStreamingClient.DisconnectAfter.
+var errDisconnected = status.Error(codes.Unavailable, "transport is closing")
+
+// SetEventDelay paces the delivery of events to subscriptions created after the
+// call, so that each call to Recv waits for delay before it returns the next
+// event. Pacing the events allows tests to control how quickly a subscriber
+// receives events, for example to fill a buffer in a deterministic way.
+func (s *TestStreamingClient) SetEventDelay(delay time.Duration) {
+	s.lock.Lock()
+	s.delay = delay
+	s.lock.Unlock()
+}
+
+// SetServerID sets the server ID sent in the header metadata of subscriptions
+// created after the call.
+func (s *TestStreamingClient) SetServerID(id string) {
+	s.lock.Lock()
+	s.serverID = id
+	s.lock.Unlock()
+}
+
+// DisconnectAfter disconnects the next subscription after it has received the
+// given number of events, so that tests can simulate a disconnect at a precise
+// point, for example in the middle of a snapshot. The Recv of the subscription
+// returns a codes.Unavailable error in place of the next event. Subscriptions
+// created after the disconnected one are not disconnected.
+func (s *TestStreamingClient) DisconnectAfter(events int) {
+	s.lock.Lock()
+	s.disconnectAfter = events
+	s.lock.Unlock()
+}
+
+// SetLeader sets the leader status sent in the header metadata of subscriptions
+// created after the call, when the subscriber requires the leader.
+func (s *TestStreamingClient) SetLeader(leader bool) {
+	s.lock.Lock()
+	s.leader = &leader
+	s.lock.Unlock()
 }
 
 func (s *TestStreamingClient) QueueEvents(events ...*pbsubscribe.Event) {
@@ -80,17 +146,49 @@ func (s *TestStreamingClient) QueueErr(err error) {
 }
 
 func (c *subscribeClient) Recv() (*pbsubscribe.Event, error) {
+	if c.delay > 0 {
+		select {
+		case <-time.After(c.delay):
+		case <-c.ctx.Done():
+			return nil, c.ctx.Err()
+		}
+	}
+
+	if c.disconnectAfter >= 0 && c.received >= c.disconnectAfter {
+		return nil, errDisconnected
+	}
+
 	select {
 	case eoe := <-c.events:
 		if eoe.Err != nil {
 			return nil, eoe.Err
 		}
+		c.received++
 		return eoe.Event, nil
 	case <-c.ctx.Done():
 		return nil, c.ctx.Err()
 	}
 }
 
+func (c *subscribeClient) Header() (metadata.MD, error) {
+	md := metadata.MD{}
+	if c.serverID != "" {
+		md.Set(pbsubscribe.ServerIDMetadataKey, c.serverID)
+	}
+	if c.leader != nil && requiresLeader(c.ctx) {
+		md.Set(pbsubscribe.LeaderMetadataKey, strconv.FormatBool(*c.leader))
+	}
+	return md, nil
+}
+
+// requiresLeader returns true if the subscriber set
+// pbsubscribe.RequireLeaderMetadataKey in the outgoing metadata of ctx.
+func requiresLeader(ctx context.Context) bool {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	values := md.Get(pbsubscribe.RequireLeaderMetadataKey)
+	return len(values) > 0 && values[0] == "true"
+}
+
 func newEndOfSnapshotEvent(index uint64) *pbsubscribe.Event {
 	return &pbsubscribe.Event{
 		Index:   index,
@@ -0,0 +1,100 @@
+package pbsubscribe
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-net-rpc/go-msgpack/codec"
+
+	"github.com/hashicorp/consul/agent/structs"
+	"github.com/hashicorp/consul/proto/pbcommon"
+)
+
+// NewGatewayServiceFromStructs converts a structs.GatewayService to the
+// GatewayService sent in the events of the GatewayServices topic.
+func NewGatewayServiceFromStructs(t *structs.GatewayService) *GatewayService {
+	if t == nil {
+		return nil
+	}
+	s := &GatewayService{
+		Gateway:               t.Gateway.Name,
+		GatewayEnterpriseMeta: pbcommon.NewEnterpriseMetaFromStructs(t.Gateway.EnterpriseMeta),
+		Service:               t.Service.Name,
+		ServiceEnterpriseMeta: pbcommon.NewEnterpriseMetaFromStructs(t.Service.EnterpriseMeta),
+		GatewayKind:           string(t.GatewayKind),
+		Port:                  int32(t.Port),
+		Protocol:              t.Protocol,
+		Hosts:                 t.Hosts,
+		CAFile:                t.CAFile,
+		CertFile:              t.CertFile,
+		KeyFile:               t.KeyFile,
+		SNI:                   t.SNI,
+		FromWildcard:          t.FromWildcard,
+		RaftIndex:             &pbcommon.RaftIndex{},
+	}
+	pbcommon.RaftIndexFromStructs(&t.RaftIndex, s.RaftIndex)
+	return s
+}
+
+// GatewayServiceToStructs converts a GatewayService received in the events of
+// the GatewayServices topic to a structs.GatewayService.
+func GatewayServiceToStructs(s *GatewayService) *structs.GatewayService {
+	if s == nil {
+		return nil
+	}
+	t := &structs.GatewayService{
+		Gateway:      structs.ServiceName{Name: s.Gateway},
+		Service:      structs.ServiceName{Name: s.Service},
+		GatewayKind:  structs.ServiceKind(s.GatewayKind),
+		Port:         int(s.Port),
+		Protocol:     s.Protocol,
+		Hosts:        s.Hosts,
+		CAFile:       s.CAFile,
+		CertFile:     s.CertFile,
+		KeyFile:      s.KeyFile,
+		SNI:          s.SNI,
+		FromWildcard: s.FromWildcard,
+	}
+	pbcommon.EnterpriseMetaToStructs(s.GatewayEnterpriseMeta, &t.Gateway.EnterpriseMeta)
+	pbcommon.EnterpriseMetaToStructs(s.ServiceEnterpriseMeta, &t.Service.EnterpriseMeta)
+	pbcommon.RaftIndexToStructs(s.RaftIndex, &t.RaftIndex)
+	return t
+}
+
+// ConfigEntryKey returns the SubscribeRequest.Key of the ConfigEntries topic
+// for the config entry of kind with name.
+func ConfigEntryKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// NewConfigEntryFromStructs converts a structs.ConfigEntry to the ConfigEntry
+// sent in the events of the ConfigEntries topic.
+func NewConfigEntryFromStructs(entry structs.ConfigEntry) (*ConfigEntry, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	var value []byte
+	if err := codec.NewEncoderBytes(&value, structs.MsgpackHandle).Encode(entry); err != nil {
+		return nil, fmt.Errorf("failed to encode config entry %s/%s: %w", entry.GetKind(), entry.GetName(), err)
+	}
+	return &ConfigEntry{
+		Kind:  entry.GetKind(),
+		Name:  entry.GetName(),
+		Value: value,
+	}, nil
+}
+
+// ConfigEntryToStructs converts a ConfigEntry received in the events of the
+// ConfigEntries topic to a structs.ConfigEntry.
+func ConfigEntryToStructs(e *ConfigEntry) (structs.ConfigEntry, error) {
+	if e == nil {
+		return nil, nil
+	}
+	entry, err := structs.MakeConfigEntry(e.Kind, e.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := codec.NewDecoderBytes(e.Value, structs.MsgpackHandle).Decode(entry); err != nil {
+		return nil, fmt.Errorf("failed to decode config entry %s/%s: %w", e.Kind, e.Name, err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,80 @@
+package pbsubscribe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/agent/structs"
+)
+
+func TestNewGatewayServiceFromStructs_RoundTrip(t *testing.T) {
+	gs := &structs.GatewayService{
+		Gateway:      structs.NewServiceName("gateway", nil),
+		Service:      structs.NewServiceName("web", nil),
+		GatewayKind:  structs.ServiceKindIngressGateway,
+		Port:         8080,
+		Protocol:     "http",
+		Hosts:        []string{"web.example.com"},
+		CAFile:       "ca.pem",
+		CertFile:     "cert.pem",
+		KeyFile:      "key.pem",
+		SNI:          "web.example.com",
+		FromWildcard: true,
+		RaftIndex:    structs.RaftIndex{CreateIndex: 3, ModifyIndex: 7},
+	}
+
+	require.Equal(t, gs, GatewayServiceToStructs(NewGatewayServiceFromStructs(gs)))
+	require.Nil(t, NewGatewayServiceFromStructs(nil))
+	require.Nil(t, GatewayServiceToStructs(nil))
+}
+
+func TestNewConfigEntryFromStructs_RoundTrip(t *testing.T) {
+	entries := []structs.ConfigEntry{
+		&structs.ServiceConfigEntry{
+			Kind:     structs.ServiceDefaults,
+			Name:     "web",
+			Protocol: "http",
+			Meta:     map[string]string{"owner": "team"},
+			RaftIndex: structs.RaftIndex{
+				CreateIndex: 3,
+				ModifyIndex: 7,
+			},
+		},
+		&structs.ProxyConfigEntry{
+			Kind: structs.ProxyDefaults,
+			Name: structs.ProxyConfigGlobal,
+			Config: map[string]interface{}{
+				"protocol": "http",
+				"nested":   map[string]interface{}{"timeout_ms": int64(100)},
+			},
+			RaftIndex: structs.RaftIndex{
+				CreateIndex: 4,
+				ModifyIndex: 8,
+			},
+		},
+	}
+
+	for _, entry := range entries {
+		t.Run(entry.GetKind(), func(t *testing.T) {
+			e, err := NewConfigEntryFromStructs(entry)
+			require.NoError(t, err)
+			require.Equal(t, entry.GetKind(), e.Kind)
+			require.Equal(t, entry.GetName(), e.Name)
+
+			actual, err := ConfigEntryToStructs(e)
+			require.NoError(t, err)
+			require.Equal(t, entry, actual)
+		})
+	}
+
+	e, err := NewConfigEntryFromStructs(nil)
+	require.NoError(t, err)
+	require.Nil(t, e)
+	actual, err := ConfigEntryToStructs(nil)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+
+	_, err = ConfigEntryToStructs(&ConfigEntry{Kind: "unknown", Name: "web"})
+	require.Error(t, err)
+}
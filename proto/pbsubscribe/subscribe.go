@@ -2,6 +2,21 @@ package pbsubscribe
 
 import "time"
 
+// ServerIDMetadataKey is the gRPC header metadata key used by the servers to
+// send the ID of the server which serves a subscription. When a subscription is
+// forwarded to another datacenter, the ID of the remote server is sent.
+const ServerIDMetadataKey = "x-consul-server-id"
+
+// RequireLeaderMetadataKey is the gRPC request metadata key used by
+// subscribers to request that the server which serves the subscription reports
+// whether it is the leader, using LeaderMetadataKey. The value must be "true".
+const RequireLeaderMetadataKey = "x-consul-require-leader"
+
+// LeaderMetadataKey is the gRPC header metadata key used by the servers to
+// report whether they were the leader when the subscription started, as "true"
+// or "false". It is only sent when the subscriber sets RequireLeaderMetadataKey.
+const LeaderMetadataKey = "x-consul-leader"
+
 // RequestDatacenter implements structs.RPCInfo
 func (req *SubscribeRequest) RequestDatacenter() string {
 	return req.Datacenter
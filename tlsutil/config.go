@@ -1,9 +1,11 @@
 package tlsutil
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -32,6 +34,11 @@ type ALPNWrapper func(dc, nodeName, alpnProto string, conn net.Conn) (net.Conn,
 // a datacenter as an argument.
 type DCWrapper func(dc string, conn net.Conn) (net.Conn, error)
 
+// DCServerNameWrapper is a variant of DCWrapper which also takes the server
+// name used to verify the certificate of the server, for deployments where the
+// name of the servers can not be derived from the datacenter and the domain.
+type DCServerNameWrapper func(dc, serverName string, conn net.Conn) (net.Conn, error)
+
 // Wrapper is a variant of DCWrapper, where the DC is provided as
 // a constant value. This is usually done by currying DCWrapper.
 type Wrapper func(conn net.Conn) (net.Conn, error)
@@ -200,11 +207,25 @@ type Configurator struct {
 		verifyServerHostname bool
 	}
 
+	// internalRPCSessions are shared by the tls.Configs of internal RPC
+	// connections, so that a client reconnecting to a server can resume its
+	// TLS session instead of doing a full handshake. They are replaced
+	// whenever the certificates or CAs change, so that sessions established
+	// with the previous configuration are not resumed.
+	internalRPCSessions struct {
+		cache     tls.ClientSessionCache
+		ticketKey [32]byte
+	}
+
 	// logger is not protected by a lock. It must never be changed after
 	// Configurator is created.
 	logger hclog.Logger
 }
 
+// clientSessionCacheSize is the number of TLS sessions kept for resumption,
+// one for each server.
+const clientSessionCacheSize = 256
+
 // NewConfigurator creates a new Configurator and sets the provided
 // configuration.
 func NewConfigurator(config Config, logger hclog.Logger) (*Configurator, error) {
@@ -260,11 +281,26 @@ func (c *Configurator) Update(config Config) error {
 	c.https = *https
 	c.internalRPC = *internalRPC
 
+	if err := c.resetSessionsLocked(); err != nil {
+		return err
+	}
 	atomic.AddUint64(&c.version, 1)
 	c.log("Update")
 	return nil
 }
 
+// resetSessionsLocked replaces the TLS session cache and the session ticket key
+// used by internal RPC connections. It must be called while holding c.lock.
+func (c *Configurator) resetSessionsLocked() error {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return fmt.Errorf("failed to generate TLS session ticket key: %w", err)
+	}
+	c.internalRPCSessions.cache = tls.NewLRUClientSessionCache(clientSessionCacheSize)
+	c.internalRPCSessions.ticketKey = key
+	return nil
+}
+
 // loadProtocolConfig loads the certificates etc. for a given ProtocolConfig
 // and performs validation.
 func (c *Configurator) loadProtocolConfig(base Config, pc ProtocolConfig) (*protocolConfig, error) {
@@ -351,6 +387,9 @@ func (c *Configurator) UpdateAutoTLSCA(connectCAPems []string) error {
 	c.grpc.combinedCAPool = grpcPool
 	c.https.combinedCAPool = httpsPool
 
+	if err := c.resetSessionsLocked(); err != nil {
+		return err
+	}
 	atomic.AddUint64(&c.version, 1)
 	c.log("UpdateAutoTLSCA")
 	return nil
@@ -367,6 +406,9 @@ func (c *Configurator) UpdateAutoTLSCert(pub, priv string) error {
 	defer c.lock.Unlock()
 
 	c.autoTLS.cert = &cert
+	if err := c.resetSessionsLocked(); err != nil {
+		return err
+	}
 	atomic.AddUint64(&c.version, 1)
 	c.log("UpdateAutoTLSCert")
 	return nil
@@ -410,6 +452,9 @@ func (c *Configurator) UpdateAutoTLS(manualCAPems, connectCAPems []string, pub,
 	c.grpc.combinedCAPool = grpcPool
 	c.https.combinedCAPool = httpsPool
 
+	if err := c.resetSessionsLocked(); err != nil {
+		return err
+	}
 	atomic.AddUint64(&c.version, 1)
 	c.log("UpdateAutoTLS")
 	return nil
@@ -536,6 +581,12 @@ func (c *Configurator) internalRPCTLSConfig(verifyIncoming bool) *tls.Config {
 	)
 	config.InsecureSkipVerify = !c.base.InternalRPC.VerifyServerHostname
 
+	// Clients store the sessions in the shared cache, and servers encrypt
+	// session tickets with the shared key, because a new tls.Config is
+	// created for every connection.
+	config.ClientSessionCache = c.internalRPCSessions.cache
+	config.SetSessionTicketKeys([][32]byte{c.internalRPCSessions.ticketKey})
+
 	return config
 }
 
@@ -825,6 +876,9 @@ func (c *Configurator) OutgoingTLSConfigForCheck(skipVerify bool, serverName str
 	config := c.internalRPCTLSConfig(false)
 	config.InsecureSkipVerify = skipVerify
 	config.ServerName = serverName
+	// Checks connect to arbitrary endpoints, so they do not share the TLS
+	// sessions of internal RPC connections.
+	config.ClientSessionCache = nil
 
 	return config
 }
@@ -858,6 +912,24 @@ func (c *Configurator) outgoingALPNRPCConfig() *tls.Config {
 	return config
 }
 
+// outgoingRPCConfigForDC returns the OutgoingRPCConfig with the ServerName
+// set to the name of the servers in dc when server hostnames are verified.
+// Connections to different datacenters must use different configs, so this
+// returns a new config for every call.
+func (c *Configurator) outgoingRPCConfigForDC(dc string) *tls.Config {
+	return c.outgoingRPCConfigForServerName(c.ServerSNI(dc, ""))
+}
+
+// outgoingRPCConfigForServerName returns the OutgoingRPCConfig with the
+// ServerName set to serverName when server hostnames are verified.
+func (c *Configurator) outgoingRPCConfigForServerName(serverName string) *tls.Config {
+	config := c.OutgoingRPCConfig()
+	if config != nil && c.VerifyServerHostname() {
+		config.ServerName = serverName
+	}
+	return config
+}
+
 // OutgoingRPCWrapper wraps the result of OutgoingRPCConfig in a DCWrapper. It
 // decides if verify server hostname should be used.
 func (c *Configurator) OutgoingRPCWrapper() DCWrapper {
@@ -872,6 +944,20 @@ func (c *Configurator) OutgoingRPCWrapper() DCWrapper {
 	}
 }
 
+// OutgoingRPCServerNameWrapper is a variant of OutgoingRPCWrapper which
+// verifies the hostname of the servers using the server name passed to the
+// wrapper, instead of the name derived from the datacenter and the domain.
+func (c *Configurator) OutgoingRPCServerNameWrapper() DCServerNameWrapper {
+	c.log("OutgoingRPCServerNameWrapper")
+
+	return func(dc, serverName string, conn net.Conn) (net.Conn, error) {
+		if c.UseTLS(dc) {
+			return c.wrapTLSClientWithConfig(c.outgoingRPCConfigForServerName(serverName), conn)
+		}
+		return conn, nil
+	}
+}
+
 // UseTLS returns true if the outgoing RPC requests have been explicitly configured
 // to use TLS (via VerifyOutgoing or AutoTLS, and the target DC supports TLS.
 func (c *Configurator) UseTLS(dc string) bool {
@@ -921,16 +1007,11 @@ func (c *Configurator) log(name string) {
 // no longer supports this mode of operation, we have to do it
 // manually.
 func (c *Configurator) wrapTLSClient(dc string, conn net.Conn) (net.Conn, error) {
-	config := c.OutgoingRPCConfig()
-	verifyServerHostname := c.VerifyServerHostname()
-	verifyOutgoing := c.verifyOutgoing()
-	domain := c.domain()
+	return c.wrapTLSClientWithConfig(c.outgoingRPCConfigForDC(dc), conn)
+}
 
-	if verifyServerHostname {
-		// Strip the trailing '.' from the domain if any
-		domain = strings.TrimSuffix(domain, ".")
-		config.ServerName = "server." + dc + "." + domain
-	}
+func (c *Configurator) wrapTLSClientWithConfig(config *tls.Config, conn net.Conn) (net.Conn, error) {
+	verifyOutgoing := c.verifyOutgoing()
 	tlsConn := tls.Client(conn, config)
 
 	// If crypto/tls is doing verification, there's no need to do
@@ -717,6 +717,32 @@ func TestConfigurator_OutgoingInternalRPCWrapper(t *testing.T) {
 	})
 }
 
+func TestConfigurator_outgoingRPCConfigForDC(t *testing.T) {
+	c := makeConfigurator(t, Config{
+		InternalRPC: ProtocolConfig{
+			VerifyOutgoing:       true,
+			VerifyServerHostname: true,
+			CAFile:               "../test/client_certs/rootca.crt",
+		},
+		Domain: "consul.",
+	})
+
+	dc1 := c.outgoingRPCConfigForDC("dc1")
+	dc2 := c.outgoingRPCConfigForDC("dc2")
+	require.Equal(t, "server.dc1.consul", dc1.ServerName)
+	require.Equal(t, "server.dc2.consul", dc2.ServerName)
+	require.NotSame(t, dc1, dc2)
+
+	c = makeConfigurator(t, Config{
+		InternalRPC: ProtocolConfig{
+			VerifyOutgoing: true,
+			CAFile:         "../test/client_certs/rootca.crt",
+		},
+		Domain: "consul",
+	})
+	require.Equal(t, "", c.outgoingRPCConfigForDC("dc1").ServerName)
+}
+
 func TestConfigurator_outgoingWrapperALPN_serverHasNoNodeNameInSAN(t *testing.T) {
 	// if this test is failing because of expired certificates
 	// use the procedure in test/CA-GENERATION.md
@@ -1136,6 +1162,49 @@ func TestConfigurator_UpdateAutoTLSCA_DoesNotPanic(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestConfigurator_InternalRPCSessionResumption(t *testing.T) {
+	config := Config{
+		InternalRPC: ProtocolConfig{
+			CAFile:         "../test/hostname/CertAuth.crt",
+			CertFile:       "../test/hostname/Alice.crt",
+			KeyFile:        "../test/hostname/Alice.key",
+			VerifyOutgoing: true,
+		},
+		Domain: "consul",
+	}
+	c := makeConfigurator(t, config)
+
+	handshake := func(t *testing.T) tls.ConnectionState {
+		t.Helper()
+
+		client, errc := startRPCTLSServer(t, c)
+		if client == nil {
+			t.Fatalf("startTLSServer err: %v", <-errc)
+		}
+
+		// With TLS 1.3 the session ticket is only sent after the handshake,
+		// and the test server never writes to the connection.
+		clientConfig := c.OutgoingRPCConfig()
+		clientConfig.MaxVersion = tls.VersionTLS12
+
+		tlsClient := tls.Client(client, clientConfig)
+		require.NoError(t, tlsClient.Handshake())
+		require.NoError(t, <-errc)
+
+		state := tlsClient.ConnectionState()
+		require.NoError(t, tlsClient.Close())
+		return state
+	}
+
+	require.False(t, handshake(t).DidResume, "first connection must do a full handshake")
+	require.True(t, handshake(t).DidResume, "second connection must resume the session")
+
+	// Sessions must not be resumed once the configuration changed.
+	require.NoError(t, c.Update(config))
+	require.False(t, handshake(t).DidResume, "session resumed after update")
+	require.True(t, handshake(t).DidResume)
+}
+
 func TestConfigurator_VerifyIncomingRPC(t *testing.T) {
 	c := Configurator{base: &Config{}}
 	c.base.InternalRPC.VerifyIncoming = true
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+package version
+
+import "runtime/debug"
+
+// readBuildInfo is a variable so that tests can replace the build info.
+var readBuildInfo = debug.ReadBuildInfo
+
+// buildMetadata returns the short VCS revision recorded in the binary by the Go
+// toolchain, with a ".dirty" suffix when the working tree had local changes. It
+// returns an empty string when the binary was built without VCS information.
+func buildMetadata() string {
+	info, ok := readBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return ""
+	}
+
+	if len(revision) > 7 {
+		revision = revision[:7]
+	}
+	if modified {
+		revision += ".dirty"
+	}
+	return revision
+}
@@ -0,0 +1,10 @@
+//go:build !go1.18
+// +build !go1.18
+
+package version
+
+// buildMetadata returns an empty string, because VCS information is only
+// recorded in binaries built with Go 1.18 or later.
+func buildMetadata() string {
+	return ""
+}
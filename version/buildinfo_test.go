@@ -0,0 +1,58 @@
+//go:build go1.18
+// +build go1.18
+
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestGetHumanVersion_BuildInfoFallback(t *testing.T) {
+	origVersion, origPrerelease, origMetadata := Version, VersionPrerelease, VersionMetadata
+	origReadBuildInfo := readBuildInfo
+	t.Cleanup(func() {
+		Version, VersionPrerelease, VersionMetadata = origVersion, origPrerelease, origMetadata
+		readBuildInfo = origReadBuildInfo
+	})
+
+	settings := []debug.BuildSetting{
+		{Key: "vcs", Value: "git"},
+		{Key: "vcs.revision", Value: "0123456789abcdef0123456789abcdef01234567"},
+		{Key: "vcs.modified", Value: "false"},
+	}
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{Settings: settings}, true
+	}
+	Version, VersionPrerelease, VersionMetadata = "1.12.5", "dev", ""
+
+	if actual := GetHumanVersion(); actual != "1.12.5-dev+0123456" {
+		t.Fatalf("expected metadata from the build info, got %q", actual)
+	}
+
+	settings[2].Value = "true"
+	if actual := GetHumanVersion(); actual != "1.12.5-dev+0123456.dirty" {
+		t.Fatalf("expected metadata for a modified tree, got %q", actual)
+	}
+
+	VersionMetadata = "ent"
+	if actual := GetHumanVersion(); actual != "1.12.5-dev+ent" {
+		t.Fatalf("expected VersionMetadata to override the build info, got %q", actual)
+	}
+
+	VersionMetadata = ""
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return nil, false
+	}
+	if actual := GetHumanVersion(); actual != "1.12.5-dev" {
+		t.Fatalf("expected no metadata without build info, got %q", actual)
+	}
+
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{Settings: settings}, true
+	}
+	VersionPrerelease = ""
+	if actual := GetHumanVersion(); actual != "1.12.5" {
+		t.Fatalf("expected no metadata from the build info for a release, got %q", actual)
+	}
+}
@@ -2,6 +2,7 @@ package version
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -17,6 +18,10 @@ var (
 	Version = "1.12.5"
 
 	// https://semver.org/#spec-item-10
+	//
+	// If VersionMetadata is not set on a pre-release, GetHumanVersion uses the
+	// VCS revision recorded in the binary by the Go toolchain, when it is
+	// available. Final releases are never given metadata from the build.
 	VersionMetadata = ""
 
 	// A pre-release marker for the version. If this is "" (empty string)
@@ -31,6 +36,9 @@ func GetHumanVersion() string {
 	version := Version
 	release := VersionPrerelease
 	metadata := VersionMetadata
+	if metadata == "" && release != "" {
+		metadata = buildMetadata()
+	}
 
 	if release != "" {
 		version += fmt.Sprintf("-%s", release)
@@ -43,3 +51,128 @@ func GetHumanVersion() string {
 	// Strip off any single quotes added by the git information.
 	return strings.ReplaceAll(version, "'", "")
 }
+
+// SemVer is a version split into its semantic version components.
+type SemVer struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Metadata   string
+}
+
+// ParseVersion parses a version in the format returned by GetHumanVersion into
+// its components. A leading "v" is ignored, and the patch version defaults to 0
+// when it is missing.
+func ParseVersion(s string) (SemVer, error) {
+	var v SemVer
+	raw := strings.TrimPrefix(s, "v")
+
+	if idx := strings.Index(raw, "+"); idx != -1 {
+		v.Metadata = raw[idx+1:]
+		raw = raw[:idx]
+		if v.Metadata == "" {
+			return SemVer{}, fmt.Errorf("invalid version %q: empty metadata", s)
+		}
+	}
+	if idx := strings.Index(raw, "-"); idx != -1 {
+		v.Prerelease = raw[idx+1:]
+		raw = raw[:idx]
+		if v.Prerelease == "" {
+			return SemVer{}, fmt.Errorf("invalid version %q: empty prerelease", s)
+		}
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return SemVer{}, fmt.Errorf("invalid version %q: expected major.minor[.patch]", s)
+	}
+
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return SemVer{}, fmt.Errorf("invalid version %q: %q is not a valid number", s, part)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// String returns the version in the format returned by GetHumanVersion.
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Metadata != "" {
+		s += "+" + v.Metadata
+	}
+	return s
+}
+
+// Compare returns -1, 0, or +1 when v has a lower, equal, or higher precedence
+// than other, following https://semver.org/#spec-item-11. A prerelease has a
+// lower precedence than the release with the same version, and Metadata is
+// ignored.
+func (v SemVer) Compare(other SemVer) int {
+	if c := compareInts(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInts(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	left := strings.Split(v.Prerelease, ".")
+	right := strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(left) && i < len(right); i++ {
+		if c := comparePrereleaseIdentifiers(left[i], right[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(left), len(right))
+}
+
+// LessThan returns true if v has a lower precedence than other.
+func (v SemVer) LessThan(other SemVer) bool {
+	return v.Compare(other) < 0
+}
+
+// comparePrereleaseIdentifiers compares two dot separated identifiers of a
+// prerelease. Numeric identifiers are compared numerically, and have a lower
+// precedence than alphanumeric identifiers, which are compared in ASCII order.
+func comparePrereleaseIdentifiers(a, b string) int {
+	aNum, aErr := strconv.Atoi(a)
+	bNum, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(aNum, bNum)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
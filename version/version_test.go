@@ -1,9 +1,200 @@
 package version
 
 import (
+	"strings"
 	"testing"
 )
 
+func TestGetHumanVersion(t *testing.T) {
+	type testCase struct {
+		version    string
+		prerelease string
+		metadata   string
+		expected   string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		origVersion, origPrerelease, origMetadata := Version, VersionPrerelease, VersionMetadata
+		t.Cleanup(func() {
+			Version, VersionPrerelease, VersionMetadata = origVersion, origPrerelease, origMetadata
+		})
+		Version, VersionPrerelease, VersionMetadata = tc.version, tc.prerelease, tc.metadata
+
+		if actual := GetHumanVersion(); actual != tc.expected {
+			t.Fatalf("expected %q, got %q", tc.expected, actual)
+		}
+	}
+
+	testCases := map[string]testCase{
+		"release": {
+			version:  "1.12.5",
+			expected: "1.12.5",
+		},
+		"prerelease": {
+			version:    "1.12.5",
+			prerelease: "dev",
+			expected:   "1.12.5-dev",
+		},
+		"metadata": {
+			version:  "1.12.5",
+			metadata: "ent",
+			expected: "1.12.5+ent",
+		},
+		"prerelease and metadata": {
+			version:    "1.12.5",
+			prerelease: "beta1",
+			metadata:   "ent",
+			expected:   "1.12.5-beta1+ent",
+		},
+		"quotes from git are removed": {
+			version:    "'1.12.5'",
+			prerelease: "'dev'",
+			expected:   "1.12.5-dev",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	type testCase struct {
+		input       string
+		expected    SemVer
+		expectedErr string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		actual, err := ParseVersion(tc.input)
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if actual != tc.expected {
+			t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+		}
+	}
+
+	testCases := map[string]testCase{
+		"release": {
+			input:    "1.12.5",
+			expected: SemVer{Major: 1, Minor: 12, Patch: 5},
+		},
+		"leading v": {
+			input:    "v1.12.5",
+			expected: SemVer{Major: 1, Minor: 12, Patch: 5},
+		},
+		"missing patch": {
+			input:    "1.12",
+			expected: SemVer{Major: 1, Minor: 12},
+		},
+		"prerelease and metadata": {
+			input:    "1.12.5-beta1+ent",
+			expected: SemVer{Major: 1, Minor: 12, Patch: 5, Prerelease: "beta1", Metadata: "ent"},
+		},
+		"metadata with a dash": {
+			input:    "1.12.5+ent-fips",
+			expected: SemVer{Major: 1, Minor: 12, Patch: 5, Metadata: "ent-fips"},
+		},
+		"empty": {
+			input:       "",
+			expectedErr: "expected major.minor[.patch]",
+		},
+		"major only": {
+			input:       "1",
+			expectedErr: "expected major.minor[.patch]",
+		},
+		"too many parts": {
+			input:       "1.2.3.4",
+			expectedErr: "expected major.minor[.patch]",
+		},
+		"not a number": {
+			input:       "1.x.3",
+			expectedErr: `"x" is not a valid number`,
+		},
+		"missing minor": {
+			input:       "1..3",
+			expectedErr: `"" is not a valid number`,
+		},
+		"empty prerelease": {
+			input:       "1.2.3-",
+			expectedErr: "empty prerelease",
+		},
+		"empty metadata": {
+			input:       "1.2.3+",
+			expectedErr: "empty metadata",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestSemVer_String(t *testing.T) {
+	v := SemVer{Major: 1, Minor: 12, Patch: 5, Prerelease: "dev", Metadata: "ent"}
+	if actual := v.String(); actual != "1.12.5-dev+ent" {
+		t.Fatalf("unexpected version string %q", actual)
+	}
+}
+
+func TestSemVer_Compare(t *testing.T) {
+	type testCase struct {
+		left, right string
+		expected    int
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		left, err := ParseVersion(tc.left)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		right, err := ParseVersion(tc.right)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if actual := left.Compare(right); actual != tc.expected {
+			t.Fatalf("expected %d, got %d", tc.expected, actual)
+		}
+		if actual := right.Compare(left); actual != -tc.expected {
+			t.Fatalf("expected reverse comparison %d, got %d", -tc.expected, actual)
+		}
+		if actual := left.LessThan(right); actual != (tc.expected < 0) {
+			t.Fatalf("expected LessThan to be %v", tc.expected < 0)
+		}
+	}
+
+	var testCases = []testCase{
+		{left: "1.10.0", right: "1.10.0", expected: 0},
+		{left: "1.9.0", right: "1.10.0", expected: -1},
+		{left: "2.0.0", right: "1.10.3", expected: 1},
+		{left: "1.10.2", right: "1.10.10", expected: -1},
+		{left: "1.10.0-dev", right: "1.10.0", expected: -1},
+		{left: "1.10.0-alpha", right: "1.10.0-beta", expected: -1},
+		{left: "1.10.0-alpha.2", right: "1.10.0-alpha.10", expected: -1},
+		{left: "1.10.0-alpha.1", right: "1.10.0-alpha.beta", expected: -1},
+		{left: "1.10.0-alpha", right: "1.10.0-alpha.1", expected: -1},
+		{left: "1.10.0+ent", right: "1.10.0", expected: 0},
+		{left: "1.10.0-rc1+ent", right: "1.10.0-rc1+oss", expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.left+" "+tc.right, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
 func BenchmarkGetHumanVersion(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		GetHumanVersion()